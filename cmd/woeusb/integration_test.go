@@ -0,0 +1,153 @@
+//go:build integration
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mathisen/woeusb-go/internal/mount"
+	"github.com/mathisen/woeusb-go/internal/partition"
+)
+
+// Integration tests run the real CLI against a loop-backed image file.
+// They need root and the external tools used by woeusb-go, so they are
+// only built with: sudo go test -tags integration ./cmd/woeusb/
+
+// fakeWindowsFiles is a minimal Windows installer layout
+var fakeWindowsFiles = map[string]string{
+	"bootmgr":                    "bootmgr",
+	"setup.exe":                  "setup",
+	filepath.Join("boot", "bcd"): "bcd",
+	filepath.Join("efi", "boot", "bootx64.efi"): "efi",
+	filepath.Join("sources", "boot.wim"):        "boot wim",
+	filepath.Join("sources", "install.wim"):     "install wim",
+}
+
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available: %v", tool, err)
+		}
+	}
+}
+
+func run(t *testing.T, name string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %s failed: %v\n%s", name, strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// buildISO packs dir into an ISO image using xorriso or genisoimage
+func buildISO(t *testing.T, dir, isoPath string) {
+	t.Helper()
+	if _, err := exec.LookPath("xorriso"); err == nil {
+		run(t, "xorriso", "-as", "mkisofs", "-J", "-R", "-o", isoPath, dir)
+		return
+	}
+	if _, err := exec.LookPath("genisoimage"); err == nil {
+		run(t, "genisoimage", "-J", "-R", "-o", isoPath, dir)
+		return
+	}
+	t.Skip("neither xorriso nor genisoimage is available")
+}
+
+func TestIntegrationPartitionMode(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("integration test requires root")
+	}
+	requireTools(t, "losetup", "parted", "mkdosfs", "wimlib-imagex", "7z")
+
+	work := t.TempDir()
+
+	// Fake Windows layout packed into an ISO
+	layout := filepath.Join(work, "layout")
+	for rel, content := range fakeWindowsFiles {
+		path := filepath.Join(layout, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	isoPath := filepath.Join(work, "windows.iso")
+	buildISO(t, layout, isoPath)
+
+	// Partitioned image file attached to a loop device
+	imagePath := filepath.Join(work, "usb.img")
+	image, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	if err := image.Truncate(256 * 1024 * 1024); err != nil {
+		t.Fatalf("Failed to size image: %v", err)
+	}
+	_ = image.Close()
+	run(t, "parted", "-s", imagePath, "mklabel", "msdos", "mkpart", "primary", "fat32", "1MiB", "100%")
+
+	loopDev := run(t, "losetup", "--find", "--show", "-P", imagePath)
+	defer func() { _ = exec.Command("losetup", "-d", loopDev).Run() }()
+
+	targetPartition := partition.GetPartitionPath(loopDev)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(targetPartition); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("partition %s did not appear", targetPartition)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Build and run the CLI
+	binary := filepath.Join(work, "woeusb-go")
+	run(t, "go", "build", "-o", binary, ".")
+	run(t, binary, "--partition", "--no-color", "--label", "TESTUSB", isoPath, targetPartition)
+
+	// Assert the resulting layout
+	layoutInfo, err := partition.DescribeDevice(loopDev)
+	if err != nil {
+		t.Fatalf("DescribeDevice failed: %v", err)
+	}
+	if layoutInfo.TableType != "msdos" {
+		t.Errorf("Expected msdos partition table, got %s", layoutInfo.TableType)
+	}
+	if len(layoutInfo.Partitions) != 1 {
+		t.Fatalf("Expected 1 partition, got %d", len(layoutInfo.Partitions))
+	}
+	part := layoutInfo.Partitions[0]
+	if part.Filesystem != "vfat" {
+		t.Errorf("Expected vfat filesystem, got %s", part.Filesystem)
+	}
+	if !strings.EqualFold(part.Label, "TESTUSB") {
+		t.Errorf("Expected label TESTUSB, got %q", part.Label)
+	}
+
+	// Assert the copied files
+	dstMount, err := mount.MountDevice(targetPartition, "vfat")
+	if err != nil {
+		t.Fatalf("Failed to mount result: %v", err)
+	}
+	defer func() { _ = mount.CleanupMountpoint(dstMount) }()
+
+	for rel, content := range fakeWindowsFiles {
+		data, err := os.ReadFile(filepath.Join(dstMount, rel))
+		if err != nil {
+			t.Errorf("Expected %s on target: %v", rel, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Content mismatch for %s", rel)
+		}
+	}
+}
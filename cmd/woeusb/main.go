@@ -1,20 +1,34 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/mathisen/woeusb-go/internal/actions"
 	"github.com/mathisen/woeusb-go/internal/bootloader"
 	filecopy "github.com/mathisen/woeusb-go/internal/copy"
 	"github.com/mathisen/woeusb-go/internal/deps"
+	"github.com/mathisen/woeusb-go/internal/distro"
+	"github.com/mathisen/woeusb-go/internal/download"
 	"github.com/mathisen/woeusb-go/internal/filesystem"
+	"github.com/mathisen/woeusb-go/internal/fingerprint"
 	"github.com/mathisen/woeusb-go/internal/gui"
+	"github.com/mathisen/woeusb-go/internal/gui/components"
 	"github.com/mathisen/woeusb-go/internal/mount"
 	"github.com/mathisen/woeusb-go/internal/output"
 	"github.com/mathisen/woeusb-go/internal/partition"
+	"github.com/mathisen/woeusb-go/internal/report"
 	"github.com/mathisen/woeusb-go/internal/session"
 	"github.com/mathisen/woeusb-go/internal/validation"
 )
@@ -22,17 +36,116 @@ import (
 const version = "1.0.2"
 
 type config struct {
-	device       bool
-	partition    bool
-	filesystem   string
-	label        string
-	biosBootFlag bool
-	skipGrub     bool
-	verbose      bool
-	noColor      bool
-	guiMode      bool
-	source       string
-	target       string
+	device         bool
+	partition      bool
+	clone          bool
+	filesystem     string
+	partitionTable string
+	uefiSource     string
+	uefiNTFSImage  string
+	partitionStart string
+	trailingGap    string
+	layout         partition.PartitionLayout
+	uefiOnly       bool
+	mbrBoot        bool
+	label          string
+	fatVolumeID    string
+	biosBootFlag   bool
+	skipGrub       bool
+	grubFallback   bool
+	grubTheme      bool
+	grubEFI        bool
+	stamp          bool
+	sourceFSType   string
+	nonRemovable   bool
+	allowLarge     bool
+	maxDeviceSize  string
+	splitSize      int
+	maxRate        string
+	maxBytesPerSec int64
+	autoFS         bool
+	noSplit        bool
+	splitStrategy  string
+	slim           string
+	exclude        []string
+	stagingDir     string
+	stagingParent  string
+	ignoreReadErr  bool
+	fullFormat     bool
+	noReformat     bool
+	resume         bool
+	strictLabel    bool
+	testMedia      bool
+	verbose        bool
+	noColor        bool
+	logFile        string
+	logLevel       string
+	progressStyle  string
+	progressJSON   bool
+	dryRun         bool
+	stopOnStdinEOF bool
+	mountTuning    string
+	tuning         mount.MountTuning
+	guiMode        bool
+	source         string
+	target         string
+	reportPath     string
+	fingerprint    bool
+	sourceSHA256   string
+	keepDownload   bool
+	ntfs3gOptions  string
+	verify         bool
+	knownHashes    string
+	onSuccess      string
+	onFailure      string
+}
+
+// runReport collects the JSON run report when --report is given; nil otherwise
+var runReport *report.Report
+
+// Exit codes, documented in the README for scripts
+const (
+	exitFailure      = 1 // any failure without a more specific code
+	exitValidation   = 2 // missing root, invalid arguments, source or target, or a failed pre-write check
+	exitDependencies = 3 // a required external tool is missing
+	exitDeviceBusy   = 4 // the target is mounted, held or locked by another run
+	exitCopyFailed   = 5 // copying files to the target failed
+	exitVerifyFailed = 6 // the written target does not match the source
+	exitInterrupted  = 130
+)
+
+// exitError tags an error with the exit code the process ends with
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode tags err with code, keeping any code it already carries
+func withExitCode(code int, err error) error {
+	var tagged *exitError
+	if err == nil || errors.As(err, &tagged) {
+		return err
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for err, or fallback if nothing more specific applies
+func exitCode(err error, fallback int) int {
+	var tagged *exitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &tagged):
+		return tagged.code
+	case errors.Is(err, session.ErrDeviceLocked):
+		return exitDeviceBusy
+	}
+	return fallback
 }
 
 func main() {
@@ -41,9 +154,36 @@ func main() {
 		return
 	}
 
+	mount.NTFS3GOptions = splitOptions(cfg.ntfs3gOptions)
+
 	// Setup output options
 	output.SetNoColor(cfg.noColor)
 	output.SetVerbose(cfg.verbose)
+	if cfg.logLevel != "" {
+		level, err := output.ParseLevel(cfg.logLevel)
+		if err != nil {
+			output.Error("Invalid --log-level: %v", err)
+			os.Exit(exitValidation)
+		}
+		output.SetLevel(level)
+	}
+	if cfg.logFile != "" {
+		if err := output.SetLogFile(cfg.logFile); err != nil {
+			output.Error("Invalid --log-file: %v", err)
+			os.Exit(exitValidation)
+		}
+		defer func() { _ = output.SetLogFile("") }()
+	}
+	if err := output.SetProgressStyle(cfg.progressStyle); err != nil {
+		output.Error("Invalid --progress-style: %v", err)
+		os.Exit(exitValidation)
+	}
+	if cfg.progressJSON {
+		output.SetProgressJSON(os.Stdout)
+	}
+	if cfg.stopOnStdinEOF {
+		go stopOnStdinClose()
+	}
 
 	// Setup session for cleanup
 	sess := &session.Session{
@@ -56,9 +196,12 @@ func main() {
 		SetBootFlag: cfg.biosBootFlag,
 		Verbose:     cfg.verbose,
 		NoColor:     cfg.noColor,
+		StartTime:   time.Now(),
+		DryRun:      cfg.dryRun,
 	}
 
-	// Setup signal handler for cleanup
+	// Setup signal handler for cleanup; it takes over from the one installed in init
+	signal.Stop(interruptCh)
 	sess.SetupSignalHandler()
 	defer func() { _ = sess.Cleanup() }()
 
@@ -68,58 +211,186 @@ func main() {
 	output.Verbose("Target: %s", cfg.target)
 	output.Verbose("Filesystem: %s, Label: %s", cfg.filesystem, cfg.label)
 
+	if cfg.reportPath != "" {
+		runReport = report.New(version, getMode(cfg), cfg.source, cfg.target)
+		output.SetStepHook(runReport.BeginPhase)
+		output.SetWarningHook(runReport.AddWarning)
+	}
+
+	// Fail early instead of letting wipefs/parted fail mid-run
+	if err := validation.CheckPrivileges(); err != nil {
+		output.Error("%v", err)
+		output.Info("Re-run with: %s", validation.SuggestSudoCommand(os.Args))
+		writeReport(cfg, err)
+		runAction(cfg, err)
+		os.Exit(exitValidation)
+	}
+
 	// Check dependencies
 	output.Step("Checking dependencies...")
 	if err := checkDependencies(); err != nil {
 		output.Error("Dependency check failed: %v", err)
-		os.Exit(1)
+		writeReport(cfg, err)
+		runAction(cfg, err)
+		os.Exit(exitDependencies)
 	}
 	output.Info("All dependencies found")
 
+	if !cfg.clone && download.IsURL(cfg.source) {
+		if err := fetchSource(cfg, sess); err != nil {
+			output.Error("Download failed: %v", err)
+			writeReport(cfg, err)
+			_ = sess.Cleanup()
+			runAction(cfg, err)
+			os.Exit(exitFailure)
+		}
+	}
+
 	// Validate source and target
 	output.Step("Validating source and target...")
 	if err := validateInputs(cfg); err != nil {
 		output.Error("Validation failed: %v", err)
-		os.Exit(1)
+		writeReport(cfg, err)
+		runAction(cfg, err)
+		os.Exit(exitCode(err, exitValidation))
 	}
 	output.Info("Validation passed")
 
+	// Held until cleanup so a second run against the same stick fails right away
+	if err := sess.LockTarget(cfg.target); err != nil {
+		output.Error("%v", err)
+		writeReport(cfg, err)
+		_ = sess.Cleanup()
+		runAction(cfg, err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+
+	if cfg.fingerprint || cfg.knownHashes != "" {
+		fingerprintSource(cfg)
+	}
+
 	// Execute the appropriate mode
 	var err error
-	if cfg.device {
+	switch {
+	case cfg.device:
 		err = executeDeviceMode(cfg, sess)
-	} else {
+	case cfg.clone:
+		err = executeCloneMode(cfg, sess)
+	default:
 		err = executePartitionMode(cfg, sess)
 	}
 
+	if errors.Is(err, context.Canceled) {
+		// Interrupted while scanning the source, before anything was written
+		output.Warning("Interrupted, the target was not modified")
+		writeReport(cfg, err)
+		_ = sess.Cleanup()
+		os.Exit(exitInterrupted)
+	}
 	if err != nil {
 		output.Error("%v", err)
-		os.Exit(1)
+		writeReport(cfg, err)
+		// os.Exit skips the deferred cleanup, so release mounts (incl. the source loop device) here
+		_ = sess.Cleanup()
+		runAction(cfg, err)
+		os.Exit(exitCode(err, exitFailure))
+	}
+
+	if cfg.dryRun {
+		writeReport(cfg, nil)
+		output.Success("Dry run complete, nothing was written to %s", cfg.target)
+		return
+	}
+
+	if cfg.device {
+		printFinalLayout(cfg.target, cfg.filesystem)
 	}
+	writeReport(cfg, nil)
 
+	output.SetPhase(output.PhaseDone)
 	output.Success("WoeUSB operation completed successfully!")
+	output.Success("%s", filecopy.CompletionSummary(time.Since(sess.StartTime), sess.BytesCopied))
 	output.Info("You may now safely remove the USB device")
+
+	// Unmount everything first so an eject action finds the device idle
+	_ = sess.Cleanup()
+	runAction(cfg, nil)
 }
 
 func parseArgs() *config {
 	var cfg config
 	var showVersion bool
 	var checkDepsOnly bool
+	var secureBootTools bool
+	var installDeps bool
+	var analyzeSource string
+	var verifyOnly bool
 
 	flag.BoolVar(&cfg.device, "device", false, "Wipe entire device and create bootable USB")
 	flag.BoolVar(&cfg.device, "d", false, "Wipe entire device (shorthand)")
 	flag.BoolVar(&cfg.partition, "partition", false, "Use existing partition")
 	flag.BoolVar(&cfg.partition, "p", false, "Use existing partition (shorthand)")
+	flag.BoolVar(&cfg.clone, "clone", false, "Clone an existing bootable USB device to another device")
 	flag.BoolVar(&checkDepsOnly, "check-deps", false, "Check if all required dependencies are installed and exit")
+	flag.BoolVar(&secureBootTools, "secure-boot-tools", false, "With --check-deps, also check Secure Boot tools (sbsign, mokutil)")
+	flag.BoolVar(&installDeps, "install-deps", false, "Install missing required dependencies with the distribution's package manager, after confirmation, and exit")
 	flag.BoolVar(&cfg.guiMode, "gui", false, "Launch graphical user interface")
-	flag.StringVar(&cfg.filesystem, "target-filesystem", "FAT", "Target filesystem: FAT or NTFS")
+	flag.BoolVar(&verifyOnly, "verify-only", false, "Verify an existing stick against a source without writing anything: --verify-only <source> <target>")
+	flag.StringVar(&analyzeSource, "analyze", "", "Analyze an ISO or device and recommend a target filesystem, without writing anything")
+	flag.StringVar(&cfg.filesystem, "target-filesystem", "auto", "Target filesystem: auto, FAT, NTFS or EXFAT (auto picks based on the source)")
+	flag.StringVar(&cfg.partitionTable, "partition-table", "msdos", "Partition table for device mode: msdos or gpt (gpt requires NTFS)")
+	flag.StringVar(&cfg.uefiSource, "ntfs-uefi-source", partition.UEFISourceDownload, "Where the gpt layout's ESP gets its boot files: download (UEFI:NTFS) or iso (the ISO's efi directory)")
+	flag.StringVar(&cfg.uefiNTFSImage, "uefi-ntfs-image", "", "Use this local uefi-ntfs.img instead of downloading it")
+	flag.StringVar(&cfg.partitionStart, "partition-start", "", "Device mode: start offset of the Windows partition, e.g. 4MiB (default 1MiB)")
+	flag.StringVar(&cfg.trailingGap, "trailing-gap", "", "Device mode: leave this much unallocated at the end of the device, e.g. 2GiB")
+	flag.BoolVar(&cfg.uefiOnly, "uefi-only", false, "Device mode: create a GPT table with a single FAT32 EFI System Partition (UEFI boot only, no GRUB)")
+	flag.BoolVar(&cfg.mbrBoot, "mbr-boot", false, "Device mode: boot legacy BIOS with Windows MBR and NTFS boot code (ms-sys) instead of GRUB")
+	flag.IntVar(&cfg.splitSize, "split-size", filecopy.SplitWIMMaxSize, "Maximum size of split WIM parts in MB")
+	flag.StringVar(&cfg.maxRate, "max-rate", "", "Limit the copy to this many bytes per second (e.g. 10M) to keep slow USB controllers responsive; unlimited by default")
+	flag.StringVar(&cfg.slim, "slim", "", "Leave optional files off the target: comma-separated presets sxs, support, recovery or all")
+	flag.StringVar(&cfg.splitStrategy, "split-strategy", filecopy.SplitDirect, "How large WIMs are split: direct (onto the target) or temp-staging (on local disk, then copied)")
+	flag.StringVar(&cfg.stagingParent, "staging-dir", "", "Directory to stage split WIM parts in with --split-strategy temp-staging (default: $TMPDIR or /tmp)")
+	flag.BoolVar(&cfg.ignoreReadErr, "ignore-read-errors", false, "DVD source: zero-fill sectors that stay unreadable after retries instead of failing the file")
+	flag.BoolVar(&cfg.noSplit, "no-split", false, "Never split WIM files; fail before writing if one does not fit on FAT32")
+	flag.BoolVar(&cfg.autoFS, "auto-filesystem", false, "Switch from FAT to NTFS automatically if a file cannot fit on FAT32")
+	flag.StringVar(&cfg.ntfs3gOptions, "ntfs-3g-options", strings.Join(mount.NTFS3GOptions, ","), "Extra mount options when NTFS falls back to the ntfs-3g FUSE driver (comma-separated, empty for none)")
+	flag.BoolVar(&cfg.noReformat, "no-reformat", false, "Partition mode: keep the existing filesystem if it matches, delete its files and relabel it instead of formatting")
+	flag.BoolVar(&cfg.resume, "resume", false, "Partition mode: continue an interrupted write, keeping the filesystem and skipping files already copied (same size and modification time)")
+	flag.BoolVar(&cfg.fullFormat, "full-format", false, "Do a full NTFS format that zeroes the partition (slow, shows progress)")
 	flag.StringVar(&cfg.label, "label", "Windows USB", "Filesystem label")
+	flag.StringVar(&cfg.fatVolumeID, "fat-volume-id", "", "FAT volume serial as 8 hex digits (e.g. 1234ABCD) for reproducible images; random by default")
+	flag.BoolVar(&cfg.strictLabel, "strict-label", false, "Fail if the partition label cannot be set instead of warning")
 	flag.StringVar(&cfg.label, "l", "Windows USB", "Filesystem label (shorthand)")
 	flag.BoolVar(&cfg.biosBootFlag, "workaround-bios-boot-flag", false, "Set boot flag for buggy BIOSes")
 	flag.BoolVar(&cfg.skipGrub, "workaround-skip-grub", false, "Skip GRUB installation")
+	flag.BoolVar(&cfg.grubFallback, "grub-fallback", false, "Add a fallback menu entry that searches for bootmgr to the generated grub.cfg")
+	flag.BoolVar(&cfg.grubTheme, "grub-theme", false, "Install a graphical GRUB theme and show a boot menu")
+	flag.StringVar(&deps.SevenZipPath, "7z-path", "", "Use this 7-Zip binary instead of looking for 7z, 7zz or 7za in PATH")
+	flag.StringVar(&cfg.sourceFSType, "source-fstype", "", "Mount the source as this filesystem type (e.g. vfat) instead of detecting udf/iso9660")
+	flag.BoolVar(&cfg.stamp, "stamp", false, "Write "+filecopy.StampFileName+" with the source name, SHA-256, editions, date and tool version to the stick")
+	flag.BoolVar(&cfg.grubEFI, "grub-efi", false, "Also boot GRUB on UEFI (x86_64-efi), chainloading Windows' loader; FAT only, needs Secure Boot off")
 	flag.BoolVar(&cfg.verbose, "verbose", false, "Verbose output")
 	flag.BoolVar(&cfg.verbose, "v", false, "Verbose output (shorthand)")
+	flag.StringVar(&cfg.sourceSHA256, "source-sha256", "", "Expected SHA-256 of a source given as an http(s) URL; the download is rejected on mismatch")
+	flag.BoolVar(&cfg.keepDownload, "keep-download", false, "Keep a source downloaded from a URL in the cache directory for later runs")
+	flag.BoolVar(&cfg.fingerprint, "fingerprint", false, "Compute the source ISO's SHA-256 and size and include them in the log and report")
+	flag.StringVar(&cfg.knownHashes, "known-hashes", "", "Compare the source fingerprint against a sha256sum-style file of known-good ISOs (implies --fingerprint)")
+	flag.BoolVar(&cfg.verify, "verify", false, "After copying, hash every file on the target against the source and fail on the first difference (slow). Boot-critical files are always checked")
+	flag.BoolVar(&cfg.testMedia, "test-media", false, "Write and verify a test pattern over the whole device before writing, to detect fake-capacity sticks (slow)")
+	flag.BoolVar(&cfg.nonRemovable, "include-non-removable", false, "Allow USB devices that report themselves as non-removable (e.g. USB SSDs)")
+	flag.BoolVar(&cfg.allowLarge, "allow-large-device", false, "Allow device mode targets larger than --max-device-size")
+	flag.StringVar(&cfg.maxDeviceSize, "max-device-size", fmt.Sprintf("%dG", validation.DefaultMaxDeviceSize>>30), "Largest target device mode accepts without --allow-large-device (e.g. 64G, 256G)")
 	flag.BoolVar(&cfg.noColor, "no-color", false, "Disable colored output")
+	flag.StringVar(&cfg.logFile, "log-file", "", "Also append all messages to this file, with timestamps and levels and without colors")
+	flag.StringVar(&cfg.logLevel, "log-level", "", "Hide messages below this level: debug, info, warn or error (default info, debug with --verbose)")
+	flag.StringVar(&cfg.mountTuning, "mount-tuning", string(mount.MountTuningFast), "Target mount options: safe (write out data early, slower) or fast (cached writes, long final sync)")
+	flag.StringVar(&cfg.progressStyle, "progress-style", output.StyleAuto, "Progress display: auto, bar, percent (new lines, for logs), dots or none")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "Check the source and target and print the planned steps without writing anything")
+	flag.BoolVar(&cfg.stopOnStdinEOF, "stop-on-stdin-close", false, "Stop and clean up, as on SIGTERM, when stdin is closed (for frontends that cannot signal the process)")
+	flag.BoolVar(&cfg.progressJSON, "progress-json", false, "Also write progress to stdout as one JSON object per line, for frontends")
+	flag.StringVar(&cfg.onSuccess, "on-success", "", "Action after a successful write: beep, notify, eject or a shell command")
+	flag.StringVar(&cfg.onFailure, "on-failure", "", "Action after a failed run: beep, notify, eject or a shell command")
+	flag.StringVar(&cfg.reportPath, "report", "", "Write a JSON report of the run to this path, also on failure")
 	flag.BoolVar(&showVersion, "version", false, "Print version")
 	flag.BoolVar(&showVersion, "V", false, "Print version (shorthand)")
 
@@ -133,7 +404,36 @@ func parseArgs() *config {
 
 	// Handle --check-deps flag
 	if checkDepsOnly {
-		runDependencyCheck()
+		runDependencyCheck(deps.CheckOptions{SecureBoot: secureBootTools})
+		return nil
+	}
+
+	if installDeps {
+		runInstallDeps()
+		return nil
+	}
+
+	if cfg.sourceFSType != "" {
+		if err := mount.ValidateFSType(cfg.sourceFSType); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --source-fstype: %v\n", err)
+			os.Exit(exitValidation)
+		}
+	}
+
+	// Handle --analyze flag
+	if analyzeSource != "" {
+		runAnalyze(analyzeSource, cfg.sourceFSType)
+		return nil
+	}
+
+	// Handle --verify-only flag
+	if verifyOnly {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "Error: --verify-only requires a source and a target")
+			usage()
+			os.Exit(exitValidation)
+		}
+		runVerifyOnly(flag.Arg(0), flag.Arg(1), cfg.sourceFSType)
 		return nil
 	}
 
@@ -143,23 +443,30 @@ func parseArgs() *config {
 		return nil
 	}
 
-	if !cfg.device && !cfg.partition {
-		fmt.Fprintln(os.Stderr, "Error: You must specify --device or --partition")
+	modes := 0
+	for _, set := range []bool{cfg.device, cfg.partition, cfg.clone} {
+		if set {
+			modes++
+		}
+	}
+
+	if modes == 0 {
+		fmt.Fprintln(os.Stderr, "Error: You must specify --device, --partition or --clone")
 		usage()
-		os.Exit(1)
+		os.Exit(exitValidation)
 	}
 
-	if cfg.device && cfg.partition {
-		fmt.Fprintln(os.Stderr, "Error: --device and --partition are mutually exclusive")
+	if modes > 1 {
+		fmt.Fprintln(os.Stderr, "Error: --device, --partition and --clone are mutually exclusive")
 		usage()
-		os.Exit(1)
+		os.Exit(exitValidation)
 	}
 
 	args := flag.Args()
 	if len(args) != 2 {
 		fmt.Fprintln(os.Stderr, "Error: source and target are required")
 		usage()
-		os.Exit(1)
+		os.Exit(exitValidation)
 	}
 
 	cfg.source = args[0]
@@ -173,16 +480,179 @@ func runGUI() {
 	app := gui.NewApp()
 	if err := app.Run(); err != nil {
 		output.Error("GUI error: %v", err)
-		os.Exit(1)
+		os.Exit(exitFailure)
+	}
+	os.Exit(0)
+}
+
+// runAnalyze mounts the source read-only and reports which filesystem it needs
+func runAnalyze(source, sourceFSType string) {
+	output.Step("Analyzing %s...", source)
+
+	if err := validation.CheckPrivileges(); err != nil {
+		output.Error("%v", err)
+		output.Info("Re-run with: %s", validation.SuggestSudoCommand(os.Args))
+		os.Exit(exitValidation)
+	}
+
+	if err := validation.ValidateSource(source); err != nil {
+		output.Error("Source validation failed: %v", err)
+		os.Exit(exitValidation)
+	}
+
+	srcMount, err := mountSource(source, sourceFSType)
+	if err != nil {
+		output.Error("Failed to mount source: %v", err)
+		os.Exit(exitFailure)
+	}
+
+	err = analyzeSourceMount(srcMount)
+	if cleanupErr := mount.CleanupMountpoint(srcMount); cleanupErr != nil {
+		output.Warning("Failed to unmount source: %v", cleanupErr)
+	}
+	if err != nil {
+		output.Error("Analysis failed: %v", err)
+		os.Exit(exitFailure)
+	}
+	os.Exit(0)
+}
+
+// runVerifyOnly mounts the source and an existing stick read-only and checks the stick
+// against the source without writing anything. A whole device is checked through its
+// first partition.
+func runVerifyOnly(source, target, sourceFSType string) {
+	output.Step("Verifying %s against %s...", target, source)
+
+	if err := validation.CheckPrivileges(); err != nil {
+		output.Error("%v", err)
+		output.Info("Re-run with: %s", validation.SuggestSudoCommand(os.Args))
+		os.Exit(exitValidation)
 	}
+	if err := validation.ValidateSource(source); err != nil {
+		output.Error("Source validation failed: %v", err)
+		os.Exit(exitValidation)
+	}
+
+	targetPartition := target
+	if validation.ValidateTarget(target, "device") == nil {
+		targetPartition = partition.GetPartitionPath(target)
+	}
+
+	srcMount, err := mountSource(source, sourceFSType)
+	if err != nil {
+		output.Error("Failed to mount source: %v", err)
+		os.Exit(exitFailure)
+	}
+	dstMount, err := mount.MountDeviceReadOnly(targetPartition, "auto")
+	if err != nil {
+		_ = mount.CleanupMountpoint(srcMount)
+		output.Error("Failed to mount %s: %v", targetPartition, err)
+		os.Exit(exitFailure)
+	}
+
+	failed := verifyMounts(srcMount, dstMount)
+
+	for _, mp := range []string{dstMount, srcMount} {
+		if err := mount.CleanupMountpoint(mp); err != nil {
+			output.Warning("Failed to unmount %s: %v", mp, err)
+		}
+	}
+
+	if failed > 0 {
+		output.Error("Verification failed: %d check(s) did not pass", failed)
+		os.Exit(exitVerifyFailed)
+	}
+	output.Success("Verification passed: %s matches %s", target, source)
 	os.Exit(0)
 }
 
+// verifyMounts runs every verification of dstMount against srcMount, printing each
+// result, and returns the number of failed checks
+func verifyMounts(srcMount, dstMount string) int {
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"Essential setup files present", func() error { return filecopy.CheckWindowsMediaComplete(dstMount) }},
+		{"Boot-critical files match", func() error { return filecopy.VerifyCriticalFiles(srcMount, dstMount) }},
+		{"UEFI bootloader present", func() error { return bootloader.CheckUEFIBootloader(dstMount) }},
+		{"All files match", func() error { return filecopy.VerifyAllFiles(srcMount, dstMount) }},
+	}
+
+	failed := 0
+	for _, check := range checks {
+		output.Step("%s...", check.name)
+		if err := check.run(); err != nil {
+			output.Error("%s: %v", check.name, err)
+			failed++
+			continue
+		}
+		output.Info("%s", check.name)
+	}
+	return failed
+}
+
+// analyzeSourceMount prints the filesystem recommendation for a mounted source
+func analyzeSourceMount(srcMount string) error {
+	suggested, reason, err := filesystem.SuggestFilesystem(srcMount)
+	if err != nil {
+		return err
+	}
+
+	maxSize, maxFile, err := filesystem.GetLargestFileSize(srcMount)
+	if err != nil {
+		return err
+	}
+
+	_, largeFiles, err := filecopy.ScanSource(srcMount)
+	if err != nil {
+		return err
+	}
+
+	if archs, err := bootloader.CheckUEFIBootloaderArch(srcMount); err == nil {
+		output.Info("UEFI bootloader: %v", archs)
+		if bootloader.IsIA32Only(archs) {
+			output.Warning("Only a 32-bit UEFI bootloader is present: 64-bit UEFI PCs need legacy BIOS/CSM boot")
+		}
+	} else {
+		output.Info("UEFI bootloader: none")
+	}
+
+	output.Info("Recommended filesystem: %s", suggested)
+	output.Info("Reason: %s", reason)
+	if maxFile != "" {
+		output.Info("Largest file: %s (%s)", maxFile, filesystem.FormatSizeHuman(maxSize))
+	}
+
+	if len(largeFiles) == 0 {
+		output.Info("WIM splitting needed on FAT: no")
+		return nil
+	}
+
+	splittable := true
+	for _, lf := range largeFiles {
+		if filecopy.IsSplittableImage(lf.RelPath) {
+			output.Info("WIM splitting needed on FAT: %s (%s)", lf.RelPath, filesystem.FormatSizeHuman(lf.Size))
+		} else {
+			splittable = false
+			output.Warning("Cannot be stored on FAT: %s (%s)", lf.RelPath, filesystem.FormatSizeHuman(lf.Size))
+		}
+	}
+
+	if splittable {
+		output.Info("FAT can still be used: all oversized files are WIM images that will be split")
+	} else {
+		output.Warning("FAT cannot be used: use --target-filesystem NTFS")
+	}
+
+	return nil
+}
+
 // runDependencyCheck checks all dependencies and prints detailed status
-func runDependencyCheck() {
+func runDependencyCheck(opts deps.CheckOptions) {
 	output.Step("Checking system dependencies...")
 
-	result := deps.CheckDependenciesWithDistro()
+	result := deps.CheckDependenciesWithOptions(opts)
 
 	// Show distro info if detected
 	if result.DistroInfo != nil {
@@ -202,6 +672,9 @@ func runDependencyCheck() {
 	if result.Deps.Blockdev != "" {
 		output.Info("blockdev: found at %s", result.Deps.Blockdev)
 	}
+	if result.Deps.Sfdisk != "" {
+		output.Info("sfdisk: found at %s", result.Deps.Sfdisk)
+	}
 	if result.Deps.Mount != "" {
 		output.Info("mount: found at %s", result.Deps.Mount)
 	}
@@ -223,6 +696,16 @@ func runDependencyCheck() {
 	if result.Deps.GrubCmd != "" {
 		output.Info("grub-install: found at %s", result.Deps.GrubCmd)
 	}
+	if result.Deps.Sbsign != "" {
+		output.Info("sbsign: found at %s", result.Deps.Sbsign)
+	}
+	if result.Deps.Mokutil != "" {
+		output.Info("mokutil: found at %s", result.Deps.Mokutil)
+	}
+
+	for _, w := range deps.CheckVersions() {
+		output.Warning("%s", w)
+	}
 
 	// Report missing dependencies
 	requiredMissing := deps.GetRequiredMissing(result.Missing)
@@ -235,13 +718,8 @@ func runDependencyCheck() {
 	if len(optionalMissing) > 0 {
 		output.Step("Checking optional dependencies...")
 		for _, m := range optionalMissing {
-			var purpose string
-			switch m.Binary {
-			case "grub-install":
-				purpose = "legacy BIOS boot"
-			case "mkntfs":
-				purpose = "NTFS filesystem support"
-			default:
+			purpose := m.Purpose
+			if purpose == "" {
 				purpose = "additional features"
 			}
 			output.Warning("%s: not found (needed for %s, install: %s)", m.Binary, purpose, m.PackageName)
@@ -260,26 +738,81 @@ func runDependencyCheck() {
 		if installCmd := deps.GetInstallCommand(requiredMissing, result.DistroInfo); installCmd != "" {
 			output.Info("Install with: %s", installCmd)
 		}
-		os.Exit(1)
+		os.Exit(exitDependencies)
+	}
+}
+
+// runInstallDeps installs the missing required dependencies after asking the user
+func runInstallDeps() {
+	output.Step("Checking system dependencies...")
+
+	result := deps.CheckDependenciesWithDistro()
+	requiredMissing := deps.GetRequiredMissing(result.Missing)
+	if len(requiredMissing) == 0 {
+		output.Success("All required dependencies are installed!")
+		os.Exit(0)
 	}
+	for _, m := range requiredMissing {
+		output.Error("%s: NOT FOUND (install package: %s)", m.Binary, m.PackageName)
+	}
+
+	installCmd := deps.GetInstallCommand(requiredMissing, result.DistroInfo)
+	if !distro.CanInstall(result.DistroInfo) {
+		output.Error("Cannot install dependencies automatically on this distribution")
+		output.Info("Install them manually: %s", installCmd)
+		os.Exit(exitDependencies)
+	}
+	isRoot := os.Geteuid() == 0
+	installCmd = distro.CommandForUser(installCmd, isRoot)
+	if strings.HasPrefix(installCmd, "sudo ") && !deps.BinaryExists("sudo") {
+		output.Error("sudo is not installed; run as root or install manually: %s", installCmd)
+		os.Exit(exitDependencies)
+	}
+
+	output.Info("Install command: %s", installCmd)
+	if !confirm("Run it? Type 'yes' to continue: ") {
+		output.Error("Installation not confirmed")
+		os.Exit(exitDependencies)
+	}
+
+	cmd := exec.Command("sh", "-c", installCmd)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		output.Error("Install command failed: %v", err)
+		os.Exit(exitDependencies)
+	}
+
+	if _, err := deps.CheckDependencies(); err != nil {
+		output.Error("Still missing after installation: %v", err)
+		os.Exit(exitDependencies)
+	}
+	output.Success("All required dependencies are installed!")
+	os.Exit(0)
 }
 
 func getMode(cfg *config) string {
 	if cfg.device {
 		return "device"
 	}
+	if cfg.clone {
+		return "clone"
+	}
 	return "partition"
 }
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: woeusb-go [--device | --partition] [options] <source> <target>\n")
+	fmt.Fprintf(os.Stderr, "       woeusb-go --clone [options] <source_device> <target_device>\n")
 	fmt.Fprintf(os.Stderr, "       woeusb-go --gui\n\n")
 	fmt.Fprintf(os.Stderr, "Create a bootable Windows USB drive from an ISO or DVD.\n\n")
 	fmt.Fprintf(os.Stderr, "Examples:\n")
 	fmt.Fprintf(os.Stderr, "  woeusb-go --device /path/to/windows.iso /dev/sdX\n")
 	fmt.Fprintf(os.Stderr, "  woeusb-go --partition /path/to/windows.iso /dev/sdX1\n")
+	fmt.Fprintf(os.Stderr, "  woeusb-go --clone /dev/sdX /dev/sdY\n")
 	fmt.Fprintf(os.Stderr, "  woeusb-go --gui\n")
-	fmt.Fprintf(os.Stderr, "  woeusb-go --check-deps\n\n")
+	fmt.Fprintf(os.Stderr, "  woeusb-go --check-deps\n")
+	fmt.Fprintf(os.Stderr, "  woeusb-go --analyze /path/to/windows.iso\n")
+	fmt.Fprintf(os.Stderr, "  woeusb-go --verify-only /path/to/windows.iso /dev/sdX\n\n")
 	fmt.Fprintf(os.Stderr, "Options:\n")
 	flag.PrintDefaults()
 }
@@ -290,6 +823,10 @@ func checkDependencies() error {
 }
 
 func validateInputs(cfg *config) error {
+	if cfg.clone {
+		return validateCloneInputs(cfg)
+	}
+
 	if err := validation.ValidateSource(cfg.source); err != nil {
 		return fmt.Errorf("source validation failed: %v", err)
 	}
@@ -298,172 +835,1560 @@ func validateInputs(cfg *config) error {
 		return fmt.Errorf("target validation failed: %v", err)
 	}
 
-	if err := mount.CheckNotBusy(cfg.target); err != nil {
-		return fmt.Errorf("target busy check failed: %v", err)
+	if err := validateFilesystem(cfg); err != nil {
+		return fmt.Errorf("invalid --target-filesystem: %v", err)
 	}
 
-	return nil
-}
-
-func executeDeviceMode(cfg *config, sess *session.Session) error {
-	output.Step("Mounting source ISO...")
-	srcMount, err := mountSource(cfg.source)
-	if err != nil {
-		return fmt.Errorf("failed to mount source: %v", err)
+	if err := validateUEFIOnly(cfg); err != nil {
+		return fmt.Errorf("invalid --uefi-only: %v", err)
 	}
-	sess.SourceMount = srcMount
-	output.Info("Source mounted at %s", srcMount)
 
-	// Default to FAT if not specified
-	if cfg.filesystem == "" {
-		cfg.filesystem = "FAT"
+	if err := validateMBRBoot(cfg); err != nil {
+		return fmt.Errorf("invalid --mbr-boot: %v", err)
 	}
 
-	output.Step("Wiping device %s...", cfg.target)
-	output.Notice("This will destroy ALL data on the device!")
-	if err := partition.CreateBootablePartition(cfg.target, cfg.filesystem); err != nil {
-		return fmt.Errorf("failed to create bootable partition: %v", err)
+	if err := filecopy.ValidateSplitSize(cfg.splitSize, cfg.filesystem); err != nil {
+		return fmt.Errorf("invalid --split-size: %v", err)
 	}
-	output.Info("Partition table created")
-
-	mainPartition := partition.GetPartitionPath(cfg.target)
-	output.Verbose("Main partition: %s", mainPartition)
 
-	output.Step("Formatting partition as %s...", cfg.filesystem)
-	if err := filesystem.FormatPartition(mainPartition, cfg.filesystem, cfg.label); err != nil {
-		return fmt.Errorf("failed to format partition: %v", err)
+	if cfg.maxRate != "" {
+		rate, err := partition.ParseSize(strings.TrimSuffix(cfg.maxRate, "/s"))
+		if err != nil {
+			return fmt.Errorf("invalid --max-rate: %v", err)
+		}
+		cfg.maxBytesPerSec = rate
 	}
-	output.Info("Partition formatted with label '%s'", cfg.label)
 
-	output.Step("Mounting target partition...")
-	fsType := "vfat"
-	if cfg.filesystem == "NTFS" {
-		fsType = "ntfs-3g"
+	if cfg.fatVolumeID != "" {
+		if cfg.filesystem == "NTFS" || cfg.filesystem == "EXFAT" {
+			return fmt.Errorf("--fat-volume-id requires a FAT target filesystem")
+		}
+		if _, err := filesystem.NormalizeFATVolumeID(cfg.fatVolumeID); err != nil {
+			return fmt.Errorf("invalid --fat-volume-id: %v", err)
+		}
 	}
-	dstMount, err := mount.MountDevice(mainPartition, fsType)
+
+	tuning, err := mount.ParseMountTuning(cfg.mountTuning)
 	if err != nil {
-		return fmt.Errorf("failed to mount target partition: %v", err)
+		return fmt.Errorf("invalid --mount-tuning: %v", err)
 	}
-	sess.TargetMount = dstMount
-	output.Info("Target mounted at %s", dstMount)
+	cfg.tuning = tuning
 
-	output.Step("Copying Windows files...")
-	output.Notice("This may take a while depending on USB speed. Do not interrupt!")
-	if err := filecopy.CopyWindowsISOWithWIMSplit(srcMount, dstMount, filecopy.PrintProgress); err != nil {
-		return fmt.Errorf("failed to copy files: %v", err)
+	if err := filecopy.ValidateSplitStrategy(cfg.splitStrategy); err != nil {
+		return fmt.Errorf("invalid --split-strategy: %v", err)
 	}
-	output.Info("All files copied successfully")
 
-	if cfg.biosBootFlag {
-		output.Step("Setting boot flag for BIOS compatibility...")
-		if err := partition.SetBootFlag(cfg.target, 1); err != nil {
-			return fmt.Errorf("failed to set boot flag: %v", err)
+	if cfg.stagingParent != "" {
+		if cfg.splitStrategy != filecopy.SplitTempStaging {
+			return fmt.Errorf("--staging-dir requires --split-strategy %s", filecopy.SplitTempStaging)
+		}
+		if info, err := os.Stat(cfg.stagingParent); err != nil || !info.IsDir() {
+			return fmt.Errorf("--staging-dir %s is not an existing directory", cfg.stagingParent)
 		}
-		output.Info("Boot flag set")
 	}
 
-	if !cfg.skipGrub {
-		output.Step("Installing GRUB bootloader for legacy BIOS support...")
-		dependencies, _ := deps.CheckDependencies()
-		if dependencies.GrubCmd != "" {
-			if err := bootloader.InstallGRUBWithConfig(dstMount, cfg.target, dependencies.GrubCmd); err != nil {
-				output.Warning("GRUB installation failed (UEFI boot will still work): %v", err)
-			} else {
-				output.Info("GRUB installed successfully")
-			}
-		} else {
-			output.Warning("GRUB not found, skipping legacy BIOS boot support")
+	if cfg.slim != "" {
+		if err := validateSlim(cfg); err != nil {
+			return fmt.Errorf("invalid --slim: %v", err)
 		}
-	} else {
-		output.Verbose("Skipping GRUB installation as requested")
 	}
 
-	output.Step("Cleaning up...")
-	if err := mount.CleanupMountpoint(dstMount); err != nil {
-		output.Warning("Failed to unmount target: %v", err)
+	if cfg.ignoreReadErr {
+		if info, err := os.Stat(cfg.source); err == nil && info.Mode().IsRegular() {
+			return fmt.Errorf("--ignore-read-errors requires a physical disc source (e.g. /dev/sr0), not an image file")
+		}
 	}
-	if err := mount.CleanupMountpoint(srcMount); err != nil {
-		output.Warning("Failed to unmount source: %v", err)
+
+	if err := validatePartitionTable(cfg); err != nil {
+		return fmt.Errorf("invalid --partition-table: %v", err)
 	}
-	sess.SourceMount = ""
-	sess.TargetMount = ""
-	output.Info("Cleanup complete")
 
-	return nil
-}
+	if err := validateUEFISource(cfg); err != nil {
+		return fmt.Errorf("invalid --ntfs-uefi-source: %v", err)
+	}
 
-func executePartitionMode(cfg *config, sess *session.Session) error {
-	output.Step("Mounting source ISO...")
-	srcMount, err := mountSource(cfg.source)
-	if err != nil {
-		return fmt.Errorf("failed to mount source: %v", err)
+	if err := validateUEFINTFSImage(cfg); err != nil {
+		return fmt.Errorf("invalid --uefi-ntfs-image: %v", err)
 	}
-	sess.SourceMount = srcMount
-	output.Info("Source mounted at %s", srcMount)
 
-	// Default to FAT if not specified
-	if cfg.filesystem == "" {
-		cfg.filesystem = "FAT"
+	// Fail before wiping rather than leaving a GPT stick without its UEFI:NTFS loader
+	if needsUEFINTFS(cfg) {
+		if err := partition.CheckUEFINTFSSource(); err != nil {
+			return fmt.Errorf("the gpt layout cannot install UEFI:NTFS: %v", err)
+		}
 	}
 
-	output.Step("Formatting partition %s as %s...", cfg.target, cfg.filesystem)
-	output.Notice("This will destroy all data on the partition!")
-	if err := filesystem.FormatPartition(cfg.target, cfg.filesystem, cfg.label); err != nil {
-		return fmt.Errorf("failed to format partition: %v", err)
+	if err := validateResume(cfg); err != nil {
+		return fmt.Errorf("invalid --resume: %v", err)
 	}
-	output.Info("Partition formatted with label '%s'", cfg.label)
 
-	output.Step("Mounting target partition...")
-	fsType := "vfat"
-	if cfg.filesystem == "NTFS" {
-		fsType = "ntfs-3g"
+	if err := validateNoReformat(cfg); err != nil {
+		return fmt.Errorf("invalid --no-reformat: %v", err)
 	}
-	dstMount, err := mount.MountDevice(cfg.target, fsType)
-	if err != nil {
-		return fmt.Errorf("failed to mount target partition: %v", err)
+
+	if err := validateGRUBEFI(cfg); err != nil {
+		return fmt.Errorf("invalid --grub-efi: %v", err)
 	}
-	sess.TargetMount = dstMount
-	output.Info("Target mounted at %s", dstMount)
 
-	output.Step("Copying Windows files...")
-	output.Notice("This may take a while depending on USB speed. Do not interrupt!")
-	if err := filecopy.CopyWindowsISOWithWIMSplit(srcMount, dstMount, filecopy.PrintProgress); err != nil {
-		return fmt.Errorf("failed to copy files: %v", err)
+	if err := validatePartitionLayout(cfg); err != nil {
+		return fmt.Errorf("invalid partition layout: %v", err)
 	}
-	output.Info("All files copied successfully")
 
-	output.Step("Cleaning up...")
-	if err := mount.CleanupMountpoint(dstMount); err != nil {
-		output.Warning("Failed to unmount target: %v", err)
+	if cfg.testMedia && !cfg.device {
+		return fmt.Errorf("--test-media is only available in --device mode")
 	}
-	if err := mount.CleanupMountpoint(srcMount); err != nil {
-		output.Warning("Failed to unmount source: %v", err)
+	if cfg.testMedia && cfg.stopOnStdinEOF {
+		return fmt.Errorf("--test-media asks for confirmation on stdin and cannot be combined with --stop-on-stdin-close")
+	}
+
+	if cfg.device {
+		if err := checkNonRemovable(cfg); err != nil {
+			return fmt.Errorf("target validation failed: %v", err)
+		}
+		if err := checkDeviceSize(cfg); err != nil {
+			return fmt.Errorf("target validation failed: %v", err)
+		}
+	}
+
+	if cfg.dryRun {
+		reportMounted(cfg.target)
+	} else if err := mount.CheckNotBusy(cfg.target); err != nil {
+		return withExitCode(exitDeviceBusy, fmt.Errorf("target busy check failed: %v", err))
 	}
-	sess.SourceMount = ""
-	sess.TargetMount = ""
-	output.Info("Cleanup complete")
 
 	return nil
 }
 
-func mountSource(source string) (string, error) {
-	info, err := os.Stat(source)
-	if err != nil {
-		return "", err
+// validateCloneInputs checks that both clone devices are whole devices, distinct and idle,
+// and that the destination is a USB stick rather than a system disk
+func validateCloneInputs(cfg *config) error {
+	if err := validation.ValidateTarget(cfg.source, "device"); err != nil {
+		return fmt.Errorf("clone source validation failed: %v", err)
+	}
+	if err := validation.ValidateTarget(cfg.target, "device"); err != nil {
+		return fmt.Errorf("clone target validation failed: %v", err)
 	}
 
-	if info.Mode().IsRegular() {
-		return mount.MountISO(source)
+	src, _ := filepath.EvalSymlinks(cfg.source)
+	dst, _ := filepath.EvalSymlinks(cfg.target)
+	if src == dst {
+		return fmt.Errorf("source and target are the same device: %s", dst)
 	}
-	return mount.MountDevice(source, "auto")
-}
+
+	devices, err := components.GetUSBDevicesWithOptions(cfg.nonRemovable)
+	if err != nil {
+		return fmt.Errorf("failed to list USB devices: %v", err)
+	}
+	isUSB := false
+	for _, dev := range devices {
+		if dev.Path == cfg.target || dev.Path == dst {
+			isUSB = true
+			break
+		}
+	}
+	if !isUSB {
+		return fmt.Errorf("%s is not a removable USB device; refusing to overwrite it", cfg.target)
+	}
+
+	for _, dev := range []string{cfg.source, cfg.target} {
+		if cfg.dryRun {
+			reportMounted(dev)
+		} else if err := mount.CheckNotBusy(dev); err != nil {
+			return withExitCode(exitDeviceBusy, fmt.Errorf("busy check failed for %s: %v", dev, err))
+		}
+	}
+
+	return nil
+}
+
+// validateNoReformat checks the flags --no-reformat cannot be combined with. Whether the
+// existing filesystem matches is checked in checkWritePlan, once the filesystem is known.
+func validateNoReformat(cfg *config) error {
+	if !cfg.noReformat {
+		return nil
+	}
+
+	if !cfg.partition {
+		return fmt.Errorf("only available in --partition mode")
+	}
+	if cfg.fullFormat || cfg.fatVolumeID != "" {
+		return fmt.Errorf("cannot be combined with --full-format or --fat-volume-id, which need a format")
+	}
+	if cfg.autoFS {
+		return fmt.Errorf("cannot be combined with --auto-filesystem, which may reformat the partition")
+	}
+	return nil
+}
+
+// validateResume checks that --resume is used in partition mode. Resuming needs the
+// files already on the target, so it implies --no-reformat without deleting them.
+func validateResume(cfg *config) error {
+	if !cfg.resume {
+		return nil
+	}
+
+	if !cfg.partition {
+		return fmt.Errorf("only available in --partition mode")
+	}
+	cfg.noReformat = true
+	return nil
+}
+
+// validateGRUBEFI checks that --grub-efi is used where GRUB is installed on a FAT target
+func validateGRUBEFI(cfg *config) error {
+	if !cfg.grubEFI {
+		return nil
+	}
+
+	if !cfg.device {
+		return fmt.Errorf("only available in --device mode")
+	}
+	if cfg.skipGrub || cfg.mbrBoot {
+		return fmt.Errorf("requires GRUB, which --workaround-skip-grub and --mbr-boot disable")
+	}
+	if strings.EqualFold(cfg.partitionTable, "gpt") || cfg.uefiOnly {
+		return fmt.Errorf("requires the msdos partition table")
+	}
+	if cfg.filesystem == "NTFS" || cfg.filesystem == "EXFAT" {
+		return fmt.Errorf("UEFI firmware cannot read GRUB from %s; use --target-filesystem FAT", cfg.filesystem)
+	}
+	return nil
+}
+
+// validatePartitionLayout parses --partition-start and --trailing-gap into cfg.layout.
+// Alignment and fit are checked against the device when the partition is created.
+func validatePartitionLayout(cfg *config) error {
+	if cfg.partitionStart == "" && cfg.trailingGap == "" {
+		return nil
+	}
+
+	if !cfg.device {
+		return fmt.Errorf("--partition-start and --trailing-gap are only available in --device mode")
+	}
+	if strings.EqualFold(cfg.partitionTable, "gpt") || cfg.uefiOnly {
+		return fmt.Errorf("--partition-start and --trailing-gap only apply to the msdos layout")
+	}
+
+	if cfg.partitionStart != "" {
+		start, err := partition.ParseSize(cfg.partitionStart)
+		if err != nil {
+			return fmt.Errorf("--partition-start: %v", err)
+		}
+		if start == 0 {
+			return fmt.Errorf("--partition-start must be greater than zero")
+		}
+		cfg.layout.StartOffset = start
+	}
+	if cfg.trailingGap != "" {
+		gap, err := partition.ParseSize(cfg.trailingGap)
+		if err != nil {
+			return fmt.Errorf("--trailing-gap: %v", err)
+		}
+		cfg.layout.TrailingGap = gap
+	}
+	return nil
+}
+
+// validateUEFIOnly checks that --uefi-only fits the other options. The ESP is always
+// FAT32, so an auto filesystem choice is resolved to FAT here.
+func validateUEFIOnly(cfg *config) error {
+	if !cfg.uefiOnly {
+		return nil
+	}
+
+	if !cfg.device {
+		return fmt.Errorf("only available in --device mode")
+	}
+	if strings.EqualFold(cfg.partitionTable, "gpt") {
+		return fmt.Errorf("--uefi-only creates its own GPT layout and cannot be combined with --partition-table gpt")
+	}
+	if cfg.filesystem == "NTFS" || cfg.filesystem == "EXFAT" {
+		return fmt.Errorf("the EFI System Partition must be FAT32, not %s", cfg.filesystem)
+	}
+	if cfg.autoFS {
+		return fmt.Errorf("cannot be combined with --auto-filesystem (the ESP cannot be NTFS)")
+	}
+	if cfg.biosBootFlag {
+		return fmt.Errorf("cannot be combined with --workaround-bios-boot-flag")
+	}
+
+	cfg.filesystem = "FAT"
+	return nil
+}
+
+// validateMBRBoot checks that --mbr-boot can be used. The Windows boot code loads
+// bootmgr from an NTFS boot record, so it forces NTFS on the msdos layout.
+func validateMBRBoot(cfg *config) error {
+	if !cfg.mbrBoot {
+		return nil
+	}
+
+	if !cfg.device {
+		return fmt.Errorf("only available in --device mode")
+	}
+	if strings.EqualFold(cfg.partitionTable, "gpt") || cfg.uefiOnly {
+		return fmt.Errorf("requires the msdos partition table")
+	}
+	if cfg.filesystem == "FAT" || cfg.filesystem == "EXFAT" {
+		return fmt.Errorf("requires --target-filesystem NTFS")
+	}
+	if cfg.skipGrub {
+		return fmt.Errorf("cannot be combined with --workaround-skip-grub (GRUB is already skipped)")
+	}
+	if _, err := exec.LookPath("ms-sys"); err != nil {
+		return fmt.Errorf("ms-sys not found (install package %s)", distro.GetPackageNameWithFallback("ms-sys", nil))
+	}
+
+	cfg.filesystem = "NTFS"
+	return nil
+}
+
+// validateSlim resolves the --slim presets into cfg.exclude and warns about what the
+// stick loses without the files
+func validateSlim(cfg *config) error {
+	presets, err := filecopy.ParseSlimPresets(cfg.slim)
+	if err != nil {
+		return err
+	}
+
+	exclude := filecopy.SlimExcludes(presets)
+	if err := filecopy.ValidateExcludes(exclude); err != nil {
+		return err
+	}
+	for _, preset := range presets {
+		output.Warning("--slim %s: %s", preset.Name, preset.Warning)
+	}
+	cfg.exclude = exclude
+	return nil
+}
+
+// validatePartitionTable checks that the requested partition table fits the other options
+func validatePartitionTable(cfg *config) error {
+	switch strings.ToLower(cfg.partitionTable) {
+	case "", "msdos", "mbr":
+		cfg.partitionTable = "msdos"
+		return nil
+	case "gpt":
+		cfg.partitionTable = "gpt"
+	default:
+		return fmt.Errorf("unsupported partition table: %s (use msdos or gpt)", cfg.partitionTable)
+	}
+
+	if !cfg.device {
+		return fmt.Errorf("gpt is only available in --device mode")
+	}
+	// The GPT layout always uses NTFS, so there is nothing for auto to decide
+	if cfg.filesystem == "auto" {
+		cfg.filesystem = "NTFS"
+	}
+	if cfg.filesystem != "NTFS" {
+		return fmt.Errorf("gpt requires --target-filesystem NTFS")
+	}
+	if cfg.biosBootFlag {
+		return fmt.Errorf("gpt cannot be combined with --workaround-bios-boot-flag")
+	}
+
+	return nil
+}
+
+// validateUEFISource checks --ntfs-uefi-source and normalizes it to download or iso
+func validateUEFISource(cfg *config) error {
+	switch strings.ToLower(cfg.uefiSource) {
+	case "", partition.UEFISourceDownload:
+		cfg.uefiSource = partition.UEFISourceDownload
+		return nil
+	case partition.UEFISourceISO:
+		cfg.uefiSource = partition.UEFISourceISO
+	default:
+		return fmt.Errorf("unsupported source: %s (use download or iso)", cfg.uefiSource)
+	}
+
+	if cfg.partitionTable != "gpt" || cfg.uefiOnly {
+		return fmt.Errorf("iso is only used by the --partition-table gpt layout")
+	}
+	return nil
+}
+
+// validateUEFINTFSImage checks --uefi-ntfs-image and hands it to the partition package
+func validateUEFINTFSImage(cfg *config) error {
+	if cfg.uefiNTFSImage == "" {
+		return nil
+	}
+	if cfg.uefiSource == partition.UEFISourceISO {
+		return fmt.Errorf("cannot be combined with --ntfs-uefi-source iso")
+	}
+	if err := partition.CheckUEFINTFSImage(cfg.uefiNTFSImage); err != nil {
+		return err
+	}
+	partition.UEFINTFSImage = cfg.uefiNTFSImage
+	return nil
+}
+
+// checkNonRemovable refuses USB devices that report RM=0 unless --include-non-removable is given
+func checkNonRemovable(cfg *config) error {
+	devices, err := components.GetUSBDevicesWithOptions(true)
+	if err != nil {
+		return nil // lsblk unavailable, nothing to check against
+	}
+
+	target := validation.ResolveDevicePath(cfg.target)
+	for _, dev := range devices {
+		if dev.Path != target || dev.Removable {
+			continue
+		}
+		if !cfg.nonRemovable {
+			return fmt.Errorf("%s is a USB device that reports itself as non-removable; "+
+				"pass --include-non-removable if it is really the drive you want to overwrite", cfg.target)
+		}
+		output.Warning("%s reports itself as non-removable, continuing as requested", cfg.target)
+	}
+
+	return nil
+}
+
+// checkDeviceSize refuses targets larger than --max-device-size unless --allow-large-device is given
+func checkDeviceSize(cfg *config) error {
+	maxSize, err := partition.ParseSize(cfg.maxDeviceSize)
+	if err != nil || maxSize <= 0 {
+		return fmt.Errorf("invalid --max-device-size %q", cfg.maxDeviceSize)
+	}
+
+	err = validation.CheckDeviceSize(cfg.target, maxSize)
+	if err == nil || !cfg.allowLarge {
+		return err
+	}
+	output.Warning("%s is larger than %s, continuing as requested", cfg.target, cfg.maxDeviceSize)
+	return nil
+}
+
+// executeDeviceMode wipes cfg.target and writes the source to it. It does not run
+// through pkg/woeusb yet: Writer has no options for the gpt and UEFI:NTFS layouts,
+// --mbr-boot, --resume, WIM staging or reports, and it reports partially failed copies
+// through FailedFiles where the CLI exits with exitCopyFailed.
+func executeDeviceMode(cfg *config, sess *session.Session) error {
+	output.SetPhase(output.PhaseMount)
+	output.Step("Mounting source ISO...")
+	srcMount, err := sess.MountSource(sourceMounter(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to mount source: %v", err)
+	}
+	output.Info("Source mounted at %s", srcMount)
+	if err := validation.ValidateWindowsISO(srcMount); err != nil {
+		return withExitCode(exitValidation, err)
+	}
+
+	// All checks that can fail without touching the target (source, capacity, filesystem,
+	// tools, layout) run in scanSource, so a recoverable error never leaves a wiped stick
+	if err := scanSource(cfg, sess, srcMount); err != nil {
+		return err
+	}
+
+	if sess.DryRun {
+		return printPlan(cfg, srcMount)
+	}
+
+	if cfg.testMedia {
+		if err := runMediaTest(cfg); err != nil {
+			return err
+		}
+	}
+
+	output.SetPhase(output.PhasePartition)
+	output.Step("Wiping device %s...", cfg.target)
+	output.Notice("This will destroy ALL data on the device!")
+	if err := sess.WipeTarget(func(device string) error {
+		return partitionDevice(cfg, device, srcMount)
+	}, false); err != nil {
+		return err
+	}
+
+	if needsUEFINTFS(cfg) {
+		output.Step("Installing UEFI:NTFS...")
+		if err := sess.InstallUEFINTFS(partition.InstallUEFINTFSToExisting); err != nil {
+			return fmt.Errorf("failed to install UEFI:NTFS: %v", err)
+		}
+		output.Info("UEFI:NTFS installed")
+	}
+
+	mainPartition := partition.GetPartitionPath(cfg.target)
+	output.Verbose("Main partition: %s", mainPartition)
+
+	output.SetPhase(output.PhaseFormat)
+	output.Step("Formatting partition as %s...", cfg.filesystem)
+	if err := formatTarget(cfg, mainPartition); err != nil {
+		return fmt.Errorf("failed to format partition: %v", err)
+	}
+	output.Info("Partition formatted with label '%s'", cfg.label)
+
+	output.Step("Mounting target partition...")
+	dstMount, err := mount.MountDeviceWithTuning(mainPartition, mountFSType(cfg.filesystem), cfg.tuning)
+	if err != nil {
+		return fmt.Errorf("failed to mount target partition: %v", err)
+	}
+	sess.TargetMount = dstMount
+	output.Info("Target mounted at %s", dstMount)
+
+	output.SetPhase(output.PhaseCopy)
+	output.Step("Copying Windows files...")
+	output.Notice("This may take a while depending on USB speed. Do not interrupt!")
+	dstMount, err = copyWindowsFiles(cfg, sess, mainPartition, srcMount, dstMount)
+	if err != nil {
+		reportFailedFiles(err)
+		return withExitCode(exitCopyFailed, fmt.Errorf("failed to copy files: %v", err))
+	}
+	output.Info("All files copied successfully")
+
+	if err := verifyTarget(cfg, srcMount, dstMount); err != nil {
+		return err
+	}
+	writeStamp(cfg, srcMount, dstMount)
+
+	output.SetPhase(output.PhaseBootloader)
+	// The Windows MBR code boots the active partition, so --mbr-boot needs the flag too
+	if cfg.biosBootFlag || cfg.mbrBoot {
+		output.Step("Setting boot flag for BIOS compatibility...")
+		if err := partition.SetBootFlag(cfg.target, 1); err != nil {
+			return fmt.Errorf("failed to set boot flag: %v", err)
+		}
+		output.Info("Boot flag set")
+	}
+
+	if cfg.mbrBoot {
+		output.Verbose("Skipping GRUB installation: --mbr-boot writes Windows boot code instead")
+	} else if cfg.partitionTable == "gpt" || cfg.uefiOnly {
+		output.Verbose("Skipping GRUB installation: legacy BIOS boot is not supported on the GPT layout")
+	} else if !cfg.skipGrub {
+		output.Step("Installing GRUB bootloader for legacy BIOS support...")
+		dependencies, _ := deps.CheckDependencies()
+		if dependencies.GrubCmd != "" {
+			grubOpts := bootloader.GRUBConfigOptionsForFilesystem(cfg.filesystem)
+			grubOpts.IncludeFallback = cfg.grubFallback
+			grubOpts.Theme = cfg.grubTheme
+			grubOpts.EFI = grubEFIEnabled(cfg)
+			if err := bootloader.InstallGRUBWithOptions(dstMount, cfg.target, dependencies.GrubCmd, grubOpts); err != nil {
+				output.Warning("GRUB installation failed (UEFI boot will still work): %v", err)
+			} else {
+				output.Info("GRUB installed successfully")
+				if cfg.grubTheme {
+					if err := bootloader.InstallGRUBTheme(dstMount); err != nil {
+						output.Warning("GRUB theme installation failed (text menu will be used): %v", err)
+					}
+				}
+			}
+		} else {
+			output.Warning("GRUB not found, skipping legacy BIOS boot support")
+		}
+	} else {
+		output.Verbose("Skipping GRUB installation as requested")
+	}
+
+	output.SetPhase(output.PhaseCleanup)
+	flushTarget(cfg, dstMount)
+
+	output.Step("Cleaning up...")
+	if err := mount.CleanupMountpoint(dstMount); err != nil {
+		output.Warning("Failed to unmount target: %v", err)
+	}
+	if err := sess.ReleaseSource(); err != nil {
+		output.Warning("Failed to unmount source: %v", err)
+	}
+	sess.TargetMount = ""
+	output.Info("Cleanup complete")
+
+	// The boot record is written to the unmounted partition
+	if cfg.mbrBoot {
+		if err := writeWindowsBootCode(cfg.target, mainPartition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func executePartitionMode(cfg *config, sess *session.Session) error {
+	output.SetPhase(output.PhaseMount)
+	output.Step("Mounting source ISO...")
+	srcMount, err := sess.MountSource(sourceMounter(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to mount source: %v", err)
+	}
+	output.Info("Source mounted at %s", srcMount)
+	if err := validation.ValidateWindowsISO(srcMount); err != nil {
+		return withExitCode(exitValidation, err)
+	}
+
+	if err := scanSource(cfg, sess, srcMount); err != nil {
+		return err
+	}
+
+	if sess.DryRun {
+		return printPlan(cfg, srcMount)
+	}
+
+	output.SetPhase(output.PhaseFormat)
+	if cfg.noReformat {
+		output.Step("Relabeling existing %s partition %s...", cfg.filesystem, cfg.target)
+		if err := relabelTarget(cfg, cfg.target); err != nil {
+			return fmt.Errorf("failed to relabel partition: %v", err)
+		}
+	} else {
+		output.Step("Formatting partition %s as %s...", cfg.target, cfg.filesystem)
+		output.Notice("This will destroy all data on the partition!")
+		if err := formatTarget(cfg, cfg.target); err != nil {
+			return fmt.Errorf("failed to format partition: %v", err)
+		}
+		output.Info("Partition formatted with label '%s'", cfg.label)
+	}
+
+	output.Step("Mounting target partition...")
+	dstMount, err := mount.MountDeviceWithTuning(cfg.target, mountFSType(cfg.filesystem), cfg.tuning)
+	if err != nil {
+		return fmt.Errorf("failed to mount target partition: %v", err)
+	}
+	sess.TargetMount = dstMount
+	output.Info("Target mounted at %s", dstMount)
+
+	if cfg.resume {
+		output.Info("Resuming: files already on the target are skipped")
+	} else if cfg.noReformat {
+		output.Step("Deleting existing files...")
+		output.Notice("This will delete all files on the partition!")
+		if err := filecopy.ClearDirectory(dstMount); err != nil {
+			return fmt.Errorf("failed to delete existing files: %v", err)
+		}
+		output.Info("Existing files deleted")
+	}
+
+	output.SetPhase(output.PhaseCopy)
+	output.Step("Copying Windows files...")
+	output.Notice("This may take a while depending on USB speed. Do not interrupt!")
+	dstMount, err = copyWindowsFiles(cfg, sess, cfg.target, srcMount, dstMount)
+	if err != nil {
+		reportFailedFiles(err)
+		return withExitCode(exitCopyFailed, fmt.Errorf("failed to copy files: %v", err))
+	}
+	output.Info("All files copied successfully")
+
+	if err := verifyTarget(cfg, srcMount, dstMount); err != nil {
+		return err
+	}
+	writeStamp(cfg, srcMount, dstMount)
+
+	output.SetPhase(output.PhaseCleanup)
+	flushTarget(cfg, dstMount)
+
+	output.Step("Cleaning up...")
+	if err := mount.CleanupMountpoint(dstMount); err != nil {
+		output.Warning("Failed to unmount target: %v", err)
+	}
+	if err := sess.ReleaseSource(); err != nil {
+		output.Warning("Failed to unmount source: %v", err)
+	}
+	sess.TargetMount = ""
+	output.Info("Cleanup complete")
+
+	return nil
+}
+
+// reportMounted lists the mounts of device a real run would unmount, for --dry-run
+func reportMounted(device string) {
+	paths, err := mount.MountedPaths(device)
+	if err != nil {
+		output.Warning("%v", err)
+		return
+	}
+	for _, path := range paths {
+		output.Notice("%s is mounted at %s and would be unmounted", device, path)
+	}
+}
+
+// printPlan prints the steps a run would perform after scanning the source, for --dry-run
+func printPlan(cfg *config, srcMount string) error {
+	stats, largeFiles, err := filecopy.ScanSource(srcMount)
+	if err != nil {
+		return fmt.Errorf("failed to scan source: %v", err)
+	}
+
+	output.Step("Dry run: planned actions")
+	switch {
+	case cfg.device:
+		if cfg.testMedia {
+			output.Info("Write and verify a test pattern over all of %s", cfg.target)
+		}
+		output.Info("Wipe %s and create %s", cfg.target, planLayout(cfg))
+		output.Info("Format %s as %s with label '%s'", partition.GetPartitionPath(cfg.target), cfg.filesystem, cfg.label)
+	case cfg.noReformat:
+		output.Info("Keep the %s filesystem on %s and set its label to '%s'", cfg.filesystem, cfg.target, cfg.label)
+		if !cfg.resume {
+			output.Info("Delete all files on %s", cfg.target)
+		}
+	default:
+		output.Info("Format %s as %s with label '%s'", cfg.target, cfg.filesystem, cfg.label)
+	}
+
+	output.Info("Copy %d files (%s) from the source", stats.TotalFiles, filesystem.FormatSizeHuman(stats.TotalBytes))
+	if cfg.resume {
+		output.Info("Skip files already on the target")
+	}
+	if cfg.filesystem == "FAT" {
+		for _, lf := range largeFiles {
+			if filecopy.IsSplittableImage(lf.RelPath) {
+				output.Info("Split %s (%s) into parts of at most %d MB", lf.RelPath, filesystem.FormatSizeHuman(lf.Size), cfg.splitSize)
+			}
+		}
+	}
+	if cfg.verify {
+		output.Info("Verify every file against the source with SHA-256")
+	}
+
+	if !cfg.device {
+		return nil
+	}
+	if cfg.biosBootFlag || cfg.mbrBoot {
+		output.Info("Set the boot flag on the main partition")
+	}
+	switch {
+	case cfg.mbrBoot:
+		output.Info("Write Windows boot code to the MBR of %s", cfg.target)
+	case cfg.partitionTable == "gpt" || cfg.uefiOnly || cfg.skipGrub:
+	case cfg.grubEFI && cfg.filesystem == "FAT":
+		output.Info("Install GRUB for legacy BIOS and UEFI boot")
+	default:
+		output.Info("Install GRUB for legacy BIOS boot")
+	}
+	return nil
+}
+
+// planLayout describes the partition layout partitionDevice would create
+func planLayout(cfg *config) string {
+	switch {
+	case cfg.uefiOnly:
+		return "a GPT partition table with a single EFI System Partition"
+	case cfg.partitionTable == "gpt" && cfg.uefiSource == partition.UEFISourceISO:
+		return "a GPT partition table with an ESP holding the source's UEFI boot files"
+	case cfg.partitionTable == "gpt" && cfg.uefiNTFSImage != "":
+		return fmt.Sprintf("a GPT partition table with a UEFI:NTFS ESP from %s", cfg.uefiNTFSImage)
+	case cfg.partitionTable == "gpt":
+		return "a GPT partition table with a downloaded UEFI:NTFS ESP"
+	}
+	return "an MBR partition table with one partition"
+}
+
+// runMediaTest asks for confirmation, then writes and verifies the whole device
+func runMediaTest(cfg *config) error {
+	output.Step("Testing media integrity of %s...", cfg.target)
+	output.Notice("This writes over the ENTIRE device and can take a long time")
+	if !confirm(fmt.Sprintf("Overwrite all of %s to test it? Type 'yes' to continue: ", cfg.target)) {
+		return fmt.Errorf("media test not confirmed")
+	}
+
+	err := partition.TestMediaIntegrity(cfg.target, func(done, total int64, phase string) {
+		percent := float64(done) * 100 / float64(total)
+		output.ProgressPercent(percent, "%s: %.1f%%", phase, percent)
+	})
+	output.ProgressDone()
+	if err != nil {
+		return fmt.Errorf("media test failed: %v", err)
+	}
+	output.Info("Media test passed: every byte of %s read back correctly", cfg.target)
+
+	return nil
+}
+
+// confirm prints prompt and reports whether the user typed "yes"
+func confirm(prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(line)) == "yes"
+}
+
+// executeCloneMode copies an existing bootable stick to another device
+func executeCloneMode(cfg *config, sess *session.Session) error {
+	if sess.DryRun {
+		output.Step("Dry run: planned actions")
+		output.Info("Copy %s sector by sector onto %s, replacing everything on it", cfg.source, cfg.target)
+		return nil
+	}
+
+	output.SetPhase(output.PhaseCopy)
+	output.Step("Cloning %s to %s...", cfg.source, cfg.target)
+	output.Notice("This will destroy ALL data on %s!", cfg.target)
+
+	err := partition.CloneDevice(cfg.source, cfg.target, func(copied, total int64, _ string) {
+		runReport.SetBytesCopied(copied)
+		sess.BytesCopied = copied
+		output.ProgressPercent(float64(copied)*100/float64(total), "%s / %s",
+			filesystem.FormatSizeHuman(copied), filesystem.FormatSizeHuman(total))
+	})
+	output.ProgressDone()
+	if err != nil {
+		return withExitCode(exitCopyFailed, fmt.Errorf("failed to clone device: %v", err))
+	}
+	output.Info("Clone complete")
+
+	if layout, err := partition.DescribeDevice(cfg.target); err == nil {
+		output.Step("Cloned device layout:")
+		for _, line := range layout.Summary() {
+			output.Info("%s", line)
+		}
+	}
+
+	return nil
+}
+
+// printFinalLayout reports the partition layout of the written device
+func printFinalLayout(device, fstype string) {
+	layout, err := partition.DescribeDevice(device)
+	if err != nil {
+		output.Warning("Could not read final device layout: %v", err)
+		return
+	}
+
+	output.Step("Final device layout:")
+	for _, line := range layout.Summary() {
+		output.Info("%s", line)
+	}
+
+	if err := layout.VerifyPartitionType(1, fstype); err != nil {
+		output.Warning("Partition type check: %v", err)
+	}
+}
+
+// writeReport finalizes the run report with runErr and writes it, if --report was given
+func writeReport(cfg *config, runErr error) {
+	if runReport == nil {
+		return
+	}
+
+	runReport.Filesystem = cfg.filesystem
+	runReport.Label = cfg.label
+	if cfg.device {
+		if layout, err := partition.DescribeDevice(cfg.target); err == nil {
+			runReport.Layout = layout
+		}
+	}
+	runReport.Finish(runErr)
+
+	if err := runReport.WriteFile(cfg.reportPath); err != nil {
+		output.Warning("%v", err)
+		return
+	}
+	output.Info("Report written to %s", cfg.reportPath)
+}
+
+// runAction runs --on-success or --on-failure for the outcome of the run.
+// A failing action is only reported, it does not change the result.
+func runAction(cfg *config, runErr error) {
+	action := cfg.onSuccess
+	if runErr != nil {
+		action = cfg.onFailure
+	}
+	if action == "" {
+		return
+	}
+
+	result := actions.Result{Source: cfg.source, Target: cfg.target, Err: runErr}
+	if err := actions.Run(action, result); err != nil {
+		output.Warning("Action %q failed: %v", action, err)
+	}
+}
+
+// fetchSource downloads a source given as a URL into the cache directory, verifies
+// --source-sha256 if set, and points cfg.source at the local copy
+func fetchSource(cfg *config, sess *session.Session) error {
+	output.Step("Downloading source...")
+	dest, err := download.CachePath(cfg.source)
+	if err != nil {
+		return err
+	}
+	if !cfg.keepDownload {
+		sess.DownloadPath = dest
+	}
+
+	err = download.File(cfg.source, dest, func(downloaded, total int64) {
+		if total < 0 {
+			output.Progress("%s", filesystem.FormatSizeHuman(downloaded))
+			return
+		}
+		output.ProgressPercent(float64(downloaded)*100/float64(total), "%s / %s",
+			filesystem.FormatSizeHuman(downloaded), filesystem.FormatSizeHuman(total))
+	})
+	output.ProgressDone()
+	if err != nil {
+		return err
+	}
+	output.Info("Downloaded to %s", dest)
+
+	if cfg.sourceSHA256 != "" {
+		output.Step("Verifying download...")
+		if err := download.VerifySHA256(dest, cfg.sourceSHA256); err != nil {
+			// Never keep a bad image around for later runs
+			_ = download.Remove(dest)
+			return err
+		}
+		output.Info("SHA-256 matches")
+	}
+
+	cfg.source = dest
+	sess.Source = dest
+	return nil
+}
+
+// fingerprintSource logs the SHA-256 and size of an ISO source, records them in the
+// report and checks them against --known-hashes. Failures are only warnings.
+func fingerprintSource(cfg *config) {
+	if cfg.clone {
+		return
+	}
+	if info, err := os.Stat(cfg.source); err != nil || !info.Mode().IsRegular() {
+		output.Verbose("Skipping fingerprint: source is not an image file")
+		return
+	}
+
+	output.Step("Fingerprinting source image...")
+	fp, err := fingerprint.Compute(cfg.source)
+	if err != nil {
+		output.Warning("Could not fingerprint source: %v", err)
+		return
+	}
+	if fp.Cached {
+		output.Verbose("Fingerprint taken from cache")
+	}
+	output.Info("Source SHA-256: %s", fp.SHA256)
+	output.Info("Source size: %s (%d bytes)", filesystem.FormatSizeHuman(fp.Size), fp.Size)
+	runReport.SetSourceFingerprint(fp.Size, fp.SHA256)
+
+	if cfg.knownHashes == "" {
+		return
+	}
+	known, err := fingerprint.LoadKnown(cfg.knownHashes)
+	if err != nil {
+		output.Warning("Could not read known hashes: %v", err)
+		return
+	}
+	if name, ok := fp.Match(known); ok {
+		output.Info("Source matches known image: %s", name)
+	} else {
+		output.Warning("Source SHA-256 is not listed in %s", cfg.knownHashes)
+	}
+}
+
+// copyProgress prints copy progress with rate and ETA and records it in the run report
+var copyProgress = filecopy.DetailedProgress(func(p filecopy.Progress) {
+	runReport.SetBytesCopied(p.BytesCopied)
+	if p.TotalBytes <= 0 {
+		return
+	}
+	percent := float64(p.BytesCopied) * 100 / float64(p.TotalBytes)
+	output.ProgressPercentFile(percent, p.CurrentFile, "%s - %s", filecopy.FormatProgress(p), p.CurrentFile)
+})
+
+// recordSplitFiles records the WIM parts written to dstMount in the run report
+func recordSplitFiles(dstMount string) {
+	if runReport == nil {
+		return
+	}
+
+	parts, _ := filepath.Glob(filepath.Join(dstMount, "sources", "*.swm"))
+	for i, part := range parts {
+		parts[i], _ = filepath.Rel(dstMount, part)
+	}
+	runReport.SetSplitFiles(parts)
+}
+
+// writeStamp writes the provenance file with --stamp. A failure only warns, the stick
+// works without it.
+func writeStamp(cfg *config, srcMount, dstMount string) {
+	if !cfg.stamp {
+		return
+	}
+
+	output.Step("Writing %s...", filecopy.StampFileName)
+	info := filecopy.NewStampInfo(cfg.source, srcMount, version, cfg.filesystem)
+	if err := filecopy.WriteInfoStamp(dstMount, info); err != nil {
+		output.Warning("%v", err)
+		return
+	}
+	output.Info("Stamped with source %s", info.Source)
+}
+
+// flushTarget writes out the data cached for the target before it is unmounted
+func flushTarget(cfg *config, dstMount string) {
+	output.Step("Flushing data to the target...")
+	if cfg.tuning == mount.MountTuningFast {
+		output.Notice("Cached writes are being written out; this can take several minutes. Do not remove the device!")
+	}
+	if err := mount.SyncFilesystem(dstMount); err != nil {
+		output.Warning("Failed to flush target: %v", err)
+	}
+}
+
+// verifyTarget always checks the boot-critical files, and every file with --verify
+func verifyTarget(cfg *config, srcMount, dstMount string) error {
+	output.SetPhase(output.PhaseVerify)
+	output.Step("Verifying boot-critical files...")
+	if err := filecopy.VerifyCriticalFiles(srcMount, dstMount); err != nil {
+		return withExitCode(exitVerifyFailed, fmt.Errorf("verification failed: %v", err))
+	}
+	if err := filecopy.CheckWindowsMediaComplete(dstMount); err != nil {
+		return withExitCode(exitVerifyFailed, fmt.Errorf("verification failed: %v", err))
+	}
+	output.Info("Boot-critical files match the source")
+
+	if !cfg.verify {
+		return nil
+	}
+	output.Step("Verifying all files...")
+	output.Notice("This reads the whole source and target again")
+	shown := false
+	err := filecopy.ValidateCopyChecksumExcluding(srcMount, dstMount, cfg.exclude, func(read, total int64, _ string) {
+		if total > 0 {
+			shown = true
+			percent := float64(read) * 100 / float64(total)
+			output.ProgressPercent(percent, "Verifying: %.1f%%", percent)
+		}
+	})
+	if shown {
+		output.ProgressDone()
+	}
+	if err != nil {
+		return withExitCode(exitVerifyFailed, fmt.Errorf("verification failed: %v", err))
+	}
+	output.Info("All files match the source")
+	return nil
+}
+
+// logWIMImages lists the Windows editions in the source install image on one line,
+// with per-image sizes in verbose mode
+func logWIMImages(srcMount string) {
+	wimPath, ok := filecopy.FindInstallImage(srcMount)
+	if !ok {
+		return
+	}
+
+	images, err := filecopy.ListWIMImages(wimPath)
+	if err != nil {
+		output.Verbose("Could not list images: %v", err)
+		return
+	}
+	if len(images) == 0 {
+		return
+	}
+
+	output.Info("%s contains: %s", filepath.Base(wimPath), filecopy.FormatWIMImages(images))
+	for _, img := range images {
+		output.Verbose("  Image %d: %s (%s)", img.Index, img.Name, filesystem.FormatSizeHuman(img.Size))
+	}
+}
+
+// grubEFIEnabled reports whether UEFI GRUB should be installed alongside BIOS GRUB.
+// It is skipped with a warning if its modules are missing or the target became NTFS.
+func grubEFIEnabled(cfg *config) bool {
+	if !cfg.grubEFI {
+		return false
+	}
+	if cfg.filesystem != "FAT" {
+		output.Warning("Skipping UEFI GRUB: the target is now %s, which UEFI firmware cannot read", cfg.filesystem)
+		return false
+	}
+	if !bootloader.GRUBTargetAvailable(bootloader.GRUBTargetEFI) {
+		output.Warning("Skipping UEFI GRUB: %s modules are not installed "+
+			"(grub-efi-amd64-bin on Debian/Ubuntu, grub2-efi-x64-modules on Fedora)", bootloader.GRUBTargetEFI)
+		return false
+	}
+	return true
+}
+
+// writeWindowsBootCode writes the Windows MBR boot code and NTFS boot record for --mbr-boot
+func writeWindowsBootCode(device, part string) error {
+	output.Step("Writing Windows boot code for legacy BIOS...")
+	if err := bootloader.WriteNTFSBootRecord(part); err != nil {
+		return fmt.Errorf("failed to write NTFS boot record: %v", err)
+	}
+	if err := bootloader.WriteMBRBootCode(device); err != nil {
+		return fmt.Errorf("failed to write MBR boot code: %v", err)
+	}
+	output.Info("Windows boot code written; BIOS boots without GRUB")
+	return nil
+}
+
+// reportFailedFiles lists every file that could not be copied, if err carries them
+func reportFailedFiles(err error) {
+	var copyFailed *filecopy.CopyFailedError
+	if !errors.As(err, &copyFailed) {
+		return
+	}
+	output.Error("%d file(s) could not be copied:", len(copyFailed.Failed))
+	for _, f := range copyFailed.Failed {
+		output.Error("  %s: %v", f.Path, f.Err)
+	}
+}
+
+// copyWindowsFiles copies the Windows files to dstMount. If --auto-filesystem is set and
+// the source holds a file FAT32 cannot store, the target partition is reformatted as
+// NTFS and the copy is retried once. Returns the (possibly new) target mountpoint.
+func copyWindowsFiles(cfg *config, sess *session.Session, targetPartition, srcMount, dstMount string) (string, error) {
+	logWIMImages(srcMount)
+
+	err := filecopy.CopyWindowsISOWithOptions(srcMount, dstMount, copyOptions(cfg), copyProgress)
+	if err == nil {
+		recordSplitFiles(dstMount)
+		recordBytesCopied(sess, srcMount)
+	}
+
+	var oversized *filecopy.OversizedFileError
+	if err == nil || !cfg.autoFS || cfg.filesystem == "NTFS" || !errors.As(err, &oversized) {
+		return dstMount, err
+	}
+
+	output.Warning("%v", err)
+	output.Step("Switching target filesystem to NTFS...")
+
+	if err := mount.CleanupMountpoint(dstMount); err != nil {
+		return dstMount, fmt.Errorf("failed to unmount target before reformatting: %v", err)
+	}
+	sess.TargetMount = ""
+
+	cfg.filesystem = "NTFS"
+	sess.Filesystem = cfg.filesystem
+	if err := formatTarget(cfg, targetPartition); err != nil {
+		return "", fmt.Errorf("failed to reformat partition as NTFS: %v", err)
+	}
+	output.Info("Partition reformatted as NTFS with label '%s'", cfg.label)
+
+	if cfg.device {
+		if err := partition.SetPartitionType(cfg.target, 1, cfg.filesystem); err != nil {
+			output.Warning("Failed to update partition type: %v", err)
+		}
+	}
+
+	dstMount, err = mount.MountDeviceWithTuning(targetPartition, mountFSType(cfg.filesystem), cfg.tuning)
+	if err != nil {
+		return "", fmt.Errorf("failed to mount target partition: %v", err)
+	}
+	sess.TargetMount = dstMount
+
+	// Single retry: NTFS has no 4GB limit, so this cannot fail the same way again
+	err = filecopy.CopyWindowsISOWithOptions(srcMount, dstMount, copyOptions(cfg), copyProgress)
+	if err == nil {
+		recordBytesCopied(sess, srcMount)
+	}
+	return dstMount, err
+}
+
+// recordBytesCopied records the size of the copied source for the completion summary.
+// Split WIMs are written in full, so the scanned source size is what reached the target.
+func recordBytesCopied(sess *session.Session, srcMount string) {
+	if stats, _, err := filecopy.ScanSource(srcMount); err == nil {
+		sess.BytesCopied = stats.TotalBytes
+	}
+}
+
+// needsUEFINTFS reports whether the chosen layout installs the UEFI:NTFS image
+func needsUEFINTFS(cfg *config) bool {
+	return cfg.device && cfg.partitionTable == "gpt" && !cfg.uefiOnly && cfg.uefiSource != partition.UEFISourceISO
+}
+
+// partitionDevice wipes device and creates the partition layout selected by cfg.
+// srcMount is the mounted source, used for --ntfs-uefi-source iso.
+func partitionDevice(cfg *config, device, srcMount string) error {
+	if cfg.uefiOnly {
+		if err := partition.CreateESPOnlyGPT(device); err != nil {
+			return fmt.Errorf("failed to create EFI System Partition: %v", err)
+		}
+		output.Info("GPT partition table created with a single EFI System Partition")
+	} else if cfg.partitionTable == "gpt" && cfg.uefiSource == partition.UEFISourceISO {
+		_, esp, err := partition.CreateNTFSWithUEFIGPTLayout(device)
+		if err != nil {
+			return fmt.Errorf("failed to create GPT layout: %v", err)
+		}
+		if err := partition.InstallISOBootToESP(esp, srcMount); err != nil {
+			return fmt.Errorf("failed to populate ESP from the source: %v", err)
+		}
+		output.Info("GPT partition table created with the source's UEFI boot files on ESP %s", esp)
+	} else if cfg.partitionTable == "gpt" {
+		// UEFI:NTFS is installed after the wipe, so a failed download can be retried
+		_, esp, err := partition.CreateNTFSWithUEFIGPTLayout(device)
+		if err != nil {
+			return fmt.Errorf("failed to create GPT layout: %v", err)
+		}
+		output.Info("GPT partition table created with ESP %s", esp)
+	} else {
+		if err := partition.CreateBootablePartitionWithLayout(device, cfg.filesystem, cfg.layout); err != nil {
+			return fmt.Errorf("failed to create bootable partition: %v", err)
+		}
+		output.Info("Partition table created")
+	}
+	return nil
+}
+
+// formatTarget formats a target partition, showing mkntfs progress for full NTFS formats
+func formatTarget(cfg *config, part string) error {
+	if !strings.EqualFold(cfg.filesystem, "NTFS") {
+		err := filesystem.FormatPartitionWithFATOptions(part, cfg.filesystem, cfg.label,
+			filesystem.FATOptions{VolumeID: cfg.fatVolumeID})
+
+		// The stick boots fine without the label, so only fail on it with --strict-label
+		var labelErr *filesystem.LabelError
+		if errors.As(err, &labelErr) && !cfg.strictLabel {
+			output.Warning("%v", err)
+			return nil
+		}
+		return err
+	}
+
+	shown := false
+	err := filesystem.FormatNTFSWithProgress(part, cfg.label, !cfg.fullFormat, func(percent int) {
+		shown = true
+		output.ProgressPercent(float64(percent), "Formatting: %d%%", percent)
+	})
+	if shown {
+		output.ProgressDone()
+	}
+	return err
+}
+
+// relabelTarget sets the label of a partition that keeps its filesystem. Like a
+// format, a label failure only warns unless --strict-label is set.
+func relabelTarget(cfg *config, part string) error {
+	err := filesystem.SetLabel(part, cfg.filesystem, cfg.label)
+	var labelErr *filesystem.LabelError
+	if errors.As(err, &labelErr) && !cfg.strictLabel {
+		output.Warning("%v", err)
+		return nil
+	}
+	if err == nil {
+		output.Info("Partition relabeled '%s'", cfg.label)
+	}
+	return err
+}
+
+// validateFilesystem checks --target-filesystem and normalizes it to auto, FAT, NTFS or EXFAT
+func validateFilesystem(cfg *config) error {
+	switch strings.ToUpper(cfg.filesystem) {
+	case "", "AUTO":
+		cfg.filesystem = "auto"
+	case "FAT", "FAT32", "VFAT":
+		cfg.filesystem = "FAT"
+	case "NTFS":
+		cfg.filesystem = "NTFS"
+	case "EXFAT":
+		cfg.filesystem = "EXFAT"
+	default:
+		return fmt.Errorf("unsupported filesystem: %s (use auto, FAT, NTFS or EXFAT)", cfg.filesystem)
+	}
+	return nil
+}
+
+// scanSource runs the checks that walk the source before anything is written to the
+// target. An interrupt during the scan aborts it with context.Canceled.
+func scanSource(cfg *config, sess *session.Session, srcMount string) error {
+	ctx, done := sess.ScanContext()
+	defer done()
+
+	err := func() error {
+		if err := resolveFilesystem(ctx, cfg, sess, srcMount); err != nil {
+			return err
+		}
+		if err := checkWritePlan(cfg); err != nil {
+			return err
+		}
+		if err := checkCapacity(ctx, cfg, srcMount); err != nil {
+			return err
+		}
+		if cfg.noSplit {
+			if err := filecopy.ValidateNoSplit(srcMount, cfg.filesystem); err != nil {
+				return fmt.Errorf("--no-split: %v", err)
+			}
+		}
+		if err := prepareStaging(ctx, cfg, sess, srcMount); err != nil {
+			return err
+		}
+		if cfg.uefiOnly {
+			opts := copyOptions(cfg)
+			opts.Context = ctx
+			if err := filecopy.CheckFATFit(srcMount, opts); err != nil {
+				return fmt.Errorf("source does not fit on the FAT32 EFI System Partition: %v", err)
+			}
+		}
+		if cfg.partitionTable == "gpt" && cfg.uefiSource == partition.UEFISourceISO {
+			archs, err := bootloader.CheckUEFIBootloaderArch(srcMount)
+			if err != nil || bootloader.IsIA32Only(archs) {
+				return fmt.Errorf("--ntfs-uefi-source iso needs efi/boot/bootx64.efi on the source; use --ntfs-uefi-source download")
+			}
+		}
+		return nil
+	}()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		// Nothing has been written yet, so a failed scan is a validation failure
+		return withExitCode(exitValidation, err)
+	}
+
+	checkUEFIArch(srcMount)
+	return nil
+}
+
+// checkWritePlan checks that the tools and partition layout the chosen filesystem and
+// mode need are available before the target is wiped or formatted
+func checkWritePlan(cfg *config) error {
+	_, mkntfsErr := exec.LookPath("mkntfs")
+	if cfg.filesystem == "NTFS" {
+		if mkntfsErr != nil {
+			return withExitCode(exitDependencies, fmt.Errorf("mkntfs not found, needed to format NTFS (install package %s)", ntfsPackage()))
+		}
+		if _, err := exec.LookPath("ntfs-3g"); err != nil {
+			return withExitCode(exitDependencies, fmt.Errorf("ntfs-3g not found, needed to mount NTFS (install package %s)", ntfsPackage()))
+		}
+	} else if cfg.filesystem == "EXFAT" {
+		if _, err := filesystem.FindExFATFormatter(); err != nil {
+			return withExitCode(exitDependencies, fmt.Errorf("%v (install package %s)", err, exfatPackage()))
+		}
+	} else if cfg.autoFS && mkntfsErr != nil {
+		output.Warning("mkntfs not found: --auto-filesystem cannot fall back to NTFS if a file does not fit on FAT32")
+	}
+
+	if cfg.device && !strings.EqualFold(cfg.partitionTable, "gpt") && !cfg.uefiOnly {
+		size, err := partition.PlannedPartitionSize(cfg.target, cfg.filesystem, cfg.layout)
+		if err != nil {
+			return err
+		}
+		if err := filesystem.ValidatePartitionSize(cfg.filesystem, size); err != nil {
+			var sizeWarning *filesystem.PartitionSizeWarning
+			if !errors.As(err, &sizeWarning) {
+				return err
+			}
+			output.Warning("%v", err)
+		}
+	}
+
+	if cfg.noReformat {
+		existing, err := filesystem.DetectFilesystem(cfg.target)
+		if err != nil {
+			return fmt.Errorf("--no-reformat: %v", err)
+		}
+		if existing != cfg.filesystem {
+			return fmt.Errorf("--no-reformat: %s holds %s, not %s; drop --no-reformat to format it", cfg.target, existing, cfg.filesystem)
+		}
+	}
+	return nil
+}
+
+// ntfsPackage returns the package providing the NTFS tools on this distro
+func ntfsPackage() string {
+	info, _ := distro.Detect()
+	return distro.GetPackageNameWithFallback("mkntfs", info)
+}
+
+// exfatPackage returns the package providing the exFAT tools on this distro
+func exfatPackage() string {
+	info, _ := distro.Detect()
+	return distro.GetPackageNameWithFallback("mkfs.exfat", info)
+}
+
+// prepareStaging creates the local directory WIMs are split into with the temp-staging
+// strategy and checks it has room for the largest one. Only FAT targets split WIMs.
+// When the default temp directory is a tmpfs or too small, it falls back to splitting
+// directly onto the target instead of failing.
+func prepareStaging(ctx context.Context, cfg *config, sess *session.Session, srcMount string) error {
+	if cfg.splitStrategy != filecopy.SplitTempStaging || cfg.filesystem != "FAT" || cfg.noSplit {
+		return nil
+	}
+
+	parent := cfg.stagingParent
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	if tmpfs, err := filecopy.IsTmpfs(parent); err == nil && tmpfs {
+		// Staged parts would live in RAM; an explicit --staging-dir is trusted if it fits
+		if cfg.stagingParent == "" {
+			fallBackToDirectSplit(cfg, fmt.Sprintf("%s is a RAM-backed tmpfs", parent))
+			return nil
+		}
+		output.Warning("--staging-dir %s is a RAM-backed tmpfs; staging large WIMs will use memory", parent)
+	}
+
+	stagingDir, err := os.MkdirTemp(cfg.stagingParent, "woeusb-staging-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	sess.StagingDir = stagingDir
+	cfg.stagingDir = stagingDir
+
+	opts := copyOptions(cfg)
+	opts.Context = ctx
+	if err := filecopy.CheckStagingSpace(srcMount, opts); err != nil {
+		if !errors.Is(err, filecopy.ErrStagingSpace) {
+			return fmt.Errorf("--split-strategy %s: %v", filecopy.SplitTempStaging, err)
+		}
+		_ = os.RemoveAll(stagingDir)
+		sess.StagingDir = ""
+		cfg.stagingDir = ""
+		fallBackToDirectSplit(cfg, err.Error())
+		return nil
+	}
+	output.Verbose("Staging split WIM parts in %s", stagingDir)
+	return nil
+}
+
+// fallBackToDirectSplit switches cfg to splitting WIMs straight onto the target
+func fallBackToDirectSplit(cfg *config, reason string) {
+	output.Warning("Cannot use --split-strategy %s (%s); splitting directly onto the target instead. Use --staging-dir to pick a larger disk-backed directory.",
+		filecopy.SplitTempStaging, reason)
+	cfg.splitStrategy = filecopy.SplitDirect
+}
+
+// resolveFilesystem replaces an "auto" filesystem choice with the one suggested for
+// the mounted source. Explicit choices are left untouched.
+func resolveFilesystem(ctx context.Context, cfg *config, sess *session.Session, srcMount string) error {
+	if cfg.filesystem != "auto" {
+		return nil
+	}
+
+	suggested, reason, err := filesystem.SuggestFilesystemContext(ctx, srcMount)
+	if err != nil {
+		return fmt.Errorf("failed to choose a filesystem: %v", err)
+	}
+	if suggested == "FAT32" {
+		suggested = "FAT"
+	}
+
+	cfg.filesystem = suggested
+	sess.Filesystem = suggested
+	output.Info("Auto-selected filesystem: %s (%s)", suggested, reason)
+
+	// --split-size was only checked against "auto" so far
+	if err := filecopy.ValidateSplitSize(cfg.splitSize, cfg.filesystem); err != nil {
+		return fmt.Errorf("invalid --split-size: %v", err)
+	}
+	return nil
+}
+
+// splitOptions splits a comma-separated option list, dropping empty entries
+func splitOptions(list string) []string {
+	var opts []string
+	for _, opt := range strings.Split(list, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// mountFSType returns the mount filesystem type for a target filesystem choice
+func mountFSType(fs string) string {
+	switch fs {
+	case "NTFS":
+		return "ntfs-3g"
+	case "EXFAT":
+		return "exfat"
+	}
+	return "vfat"
+}
+
+// checkCapacity verifies the target can hold the source, including WIM split overhead
+func checkCapacity(ctx context.Context, cfg *config, srcMount string) error {
+	capacity, err := partition.GetDeviceSize(cfg.target)
+	if err != nil {
+		output.Warning("Could not determine target size, skipping capacity check: %v", err)
+		return nil
+	}
+
+	opts := copyOptions(cfg)
+	opts.Context = ctx
+	if err := filecopy.CheckTargetCapacity(srcMount, cfg.filesystem, opts, capacity); err != nil {
+		return fmt.Errorf("capacity check failed: %v", err)
+	}
+	output.Verbose("Target capacity check passed")
+
+	return nil
+}
+
+// checkUEFIArch warns when the source only ships a 32-bit UEFI bootloader
+func checkUEFIArch(srcMount string) {
+	archs, err := bootloader.CheckUEFIBootloaderArch(srcMount)
+	if err != nil {
+		output.Verbose("No UEFI bootloader on source: %v", err)
+		return
+	}
+
+	output.Verbose("Source UEFI bootloader architectures: %v", archs)
+	if bootloader.IsIA32Only(archs) {
+		output.Warning("Source only provides a 32-bit UEFI bootloader (bootia32.efi)")
+		output.Warning("Most 64-bit UEFI PCs will not boot it; use legacy BIOS/CSM boot instead")
+	}
+}
+
+// copyOptions builds the copy options from the command line configuration
+func copyOptions(cfg *config) filecopy.CopyOptions {
+	opts := filecopy.DefaultCopyOptions()
+	opts.SplitSizeMB = cfg.splitSize
+	opts.NoSplit = cfg.noSplit
+	opts.SplitStrategy = cfg.splitStrategy
+	opts.StagingDir = cfg.stagingDir
+	opts.IgnoreReadErrors = cfg.ignoreReadErr
+	opts.OnReadError = reportReadError
+	opts.Exclude = cfg.exclude
+	opts.Filesystem = cfg.filesystem
+	opts.ResumeMode = cfg.resume
+	opts.MaxBytesPerSec = cfg.maxBytesPerSec
+	return opts
+}
+
+// reportReadError warns about a file that hit source read errors while copying
+func reportReadError(r filecopy.ReadErrorFile) {
+	if r.Recovered() {
+		output.Warning("Read errors in %s recovered after %d retries", r.Path, r.Retries)
+		return
+	}
+	output.Warning("!!! %s: %d bytes could not be read and were zero-filled; this file is DAMAGED", r.Path, r.ZeroFilled)
+}
+
+// mountSource mounts an image file or device source read-only. An empty fstype detects
+// the filesystem; otherwise it is mounted as fstype (--source-fstype).
+func mountSource(source, fstype string) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode().IsRegular() {
+		return mount.MountISOWithFSType(source, fstype)
+	}
+	if fstype == "" {
+		// A Windows DVD needs the udf/iso9660 handling of an ISO, which "auto" may not pick
+		if mount.IsOpticalDevice(source) {
+			return mount.MountOptical(source)
+		}
+		fstype = "auto"
+	}
+	return mount.MountDeviceReadOnly(source, fstype)
+}
+
+// sourceMounter returns the mount function for sess.MountSource, applying --source-fstype
+func sourceMounter(cfg *config) func(string) (string, error) {
+	return func(source string) (string, error) {
+		return mountSource(source, cfg.sourceFSType)
+	}
+}
+
+// stopOnStdinClose sends SIGTERM to the process once stdin is closed, so a frontend
+// that runs it as another user (e.g. through pkexec) can still cancel it
+func stopOnStdinClose() {
+	_, _ = io.Copy(io.Discard, os.Stdin)
+	_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+}
+
+// interruptCh receives the signals handled in init until a session takes over
+var interruptCh = make(chan os.Signal, 1)
 
 func init() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(interruptCh, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
+		<-interruptCh
 		output.Warning("Received interrupt signal, cleaning up...")
-		os.Exit(1)
+		os.Exit(exitInterrupted)
 	}()
 }
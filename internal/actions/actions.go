@@ -0,0 +1,100 @@
+package actions
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Built-in actions; anything else is run as a shell command
+const (
+	Beep   = "beep"
+	Notify = "notify"
+	Eject  = "eject"
+)
+
+// Result describes the finished run an action is triggered by
+type Result struct {
+	Source string
+	Target string
+	Err    error // nil on success
+}
+
+// Success reports whether the run succeeded
+func (r Result) Success() bool {
+	return r.Err == nil
+}
+
+// message returns a one-line summary of the result for notifications
+func (r Result) message() string {
+	if r.Success() {
+		return fmt.Sprintf("Finished writing %s to %s", r.Source, r.Target)
+	}
+	return fmt.Sprintf("Writing %s to %s failed: %v", r.Source, r.Target, r.Err)
+}
+
+// bell is where the beep action writes the terminal bell; tests replace it
+var bell io.Writer = os.Stdout
+
+// commandFn builds the commands actions run; tests replace it
+var commandFn = exec.Command
+
+// Run performs action for result. An empty action does nothing.
+func Run(action string, result Result) error {
+	switch action {
+	case "":
+		return nil
+	case Beep:
+		_, err := fmt.Fprint(bell, "\a")
+		return err
+	case Notify:
+		urgency := "normal"
+		if !result.Success() {
+			urgency = "critical"
+		}
+		return run(commandFn("notify-send", "--urgency="+urgency, "WoeUSB-go", result.message()))
+	case Eject:
+		return run(commandFn("eject", result.Target))
+	default:
+		cmd := commandFn("sh", "-c", action)
+		cmd.Env = append(os.Environ(), environment(result)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return run(cmd)
+	}
+}
+
+// environment returns the WOEUSB_* variables passed to command actions
+func environment(result Result) []string {
+	status, errMsg := "success", ""
+	if !result.Success() {
+		status, errMsg = "failure", result.Err.Error()
+	}
+	return []string{
+		"WOEUSB_RESULT=" + status,
+		"WOEUSB_SOURCE=" + result.Source,
+		"WOEUSB_TARGET=" + result.Target,
+		"WOEUSB_ERROR=" + errMsg,
+	}
+}
+
+// run runs cmd, including its output in the error if it fails
+func run(cmd *exec.Cmd) error {
+	if cmd.Stdout != nil {
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v", cmd.Args[0], err)
+		}
+		return nil
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s failed: %v: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s failed: %v", cmd.Args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package actions
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunBuiltins(t *testing.T) {
+	var buf bytes.Buffer
+	oldBell, oldCommand := bell, commandFn
+	defer func() { bell, commandFn = oldBell, oldCommand }()
+	bell = &buf
+
+	var calls [][]string
+	commandFn = func(name string, args ...string) *exec.Cmd {
+		calls = append(calls, append([]string{name}, args...))
+		return exec.Command("true")
+	}
+
+	ok := Result{Source: "win.iso", Target: "/dev/sdb"}
+	failed := Result{Source: "win.iso", Target: "/dev/sdb", Err: errors.New("boom")}
+
+	if err := Run("", ok); err != nil {
+		t.Errorf("Run(\"\") = %v", err)
+	}
+	if err := Run(Beep, ok); err != nil || buf.String() != "\a" {
+		t.Errorf("Run(beep) = %v, wrote %q", err, buf.String())
+	}
+	if err := Run(Notify, failed); err != nil {
+		t.Errorf("Run(notify) = %v", err)
+	}
+	if err := Run(Eject, ok); err != nil {
+		t.Errorf("Run(eject) = %v", err)
+	}
+
+	want := [][]string{
+		{"notify-send", "--urgency=critical", "WoeUSB-go", "Writing win.iso to /dev/sdb failed: boom"},
+		{"eject", "/dev/sdb"},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("commands = %q, want %q", calls, want)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "actions_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	outFile := filepath.Join(tmpDir, "out.txt")
+	action := `echo "$WOEUSB_RESULT $WOEUSB_TARGET $WOEUSB_ERROR" > ` + outFile
+
+	if err := Run(action, Result{Source: "win.iso", Target: "/dev/sdb", Err: errors.New("no space")}); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "failure /dev/sdb no space" {
+		t.Errorf("command saw %q", got)
+	}
+
+	if err := Run("exit 3", Result{}); err == nil {
+		t.Error("Run() should report a failing command")
+	}
+}
@@ -2,13 +2,25 @@ package bootloader
 
 import (
 	"bufio"
+	"bytes"
+	"embed"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/mathisen/woeusb-go/internal/deps"
 )
 
+// GRUBThemeName is the directory name of the embedded theme under boot/grub/themes
+const GRUBThemeName = "woeusb"
+
+//go:embed theme
+var themeFS embed.FS
+
 // IsWindows7 checks if the source contains Windows 7 by examining cversion.ini
 func IsWindows7(srcMount string) (bool, error) {
 	cversionPath := filepath.Join(srcMount, "sources", "cversion.ini")
@@ -68,9 +80,14 @@ func ExtractBootloader(srcMount, dstMount string) error {
 	// Extract bootmgfw.efi using 7z
 	bootloaderPath := filepath.Join(efiBootDir, "bootx64.efi")
 
+	sevenZip, err := deps.FindSevenZip()
+	if err != nil {
+		return err
+	}
+
 	// Use 7z to extract bootmgfw.efi from the install file
 	// The path in the WIM/ESD is typically: 1/Windows/Boot/EFI/bootmgfw.efi
-	cmd := exec.Command("7z", "e", "-so", installFile, "1/Windows/Boot/EFI/bootmgfw.efi")
+	cmd := exec.Command(sevenZip, "e", "-so", installFile, "1/Windows/Boot/EFI/bootmgfw.efi")
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -105,46 +122,338 @@ func ApplyWindows7UEFIWorkaround(srcMount, dstMount string) error {
 	return nil
 }
 
+// UEFIArch identifies the CPU architecture of a UEFI fallback bootloader
+type UEFIArch string
+
+const (
+	UEFIArchX64  UEFIArch = "x64"  // efi/boot/bootx64.efi
+	UEFIArchIA32 UEFIArch = "ia32" // efi/boot/bootia32.efi
+)
+
+// uefiBootloaderFiles maps each architecture to its removable-media bootloader name
+var uefiBootloaderFiles = []struct {
+	arch UEFIArch
+	name string
+}{
+	{UEFIArchX64, "bootx64.efi"},
+	{UEFIArchIA32, "bootia32.efi"},
+}
+
 // CheckUEFIBootloader verifies that the UEFI bootloader is properly installed
 func CheckUEFIBootloader(dstMount string) error {
-	bootloaderPath := filepath.Join(dstMount, "efi", "boot", "bootx64.efi")
+	_, err := CheckUEFIBootloaderArch(dstMount)
+	return err
+}
 
-	info, err := os.Stat(bootloaderPath)
+// CheckUEFIBootloaderArch verifies that a UEFI bootloader is present and returns
+// the architectures it was found for. File names are matched case-insensitively
+// since ISO9660 media often use upper-case names.
+func CheckUEFIBootloaderArch(dstMount string) ([]UEFIArch, error) {
+	bootDir := filepath.Join(dstMount, "efi", "boot")
+
+	entries, err := readDirFold(dstMount, "efi", "boot")
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("UEFI bootloader not found at %s", bootloaderPath)
+			return nil, fmt.Errorf("UEFI bootloader not found in %s", bootDir)
+		}
+		return nil, fmt.Errorf("failed to check UEFI bootloader: %v", err)
+	}
+
+	var archs []UEFIArch
+	for _, bl := range uefiBootloaderFiles {
+		for _, entry := range entries {
+			if !strings.EqualFold(entry.Name(), bl.name) || entry.IsDir() {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to check UEFI bootloader: %v", err)
+			}
+
+			// Check that the file is not empty
+			if info.Size() == 0 {
+				return nil, fmt.Errorf("UEFI bootloader file is empty: %s", filepath.Join(bootDir, entry.Name()))
+			}
+
+			archs = append(archs, bl.arch)
+			break
 		}
-		return fmt.Errorf("failed to check UEFI bootloader: %v", err)
 	}
 
-	// Check that the file is not empty
-	if info.Size() == 0 {
-		return fmt.Errorf("UEFI bootloader file is empty: %s", bootloaderPath)
+	if len(archs) == 0 {
+		return nil, fmt.Errorf("UEFI bootloader not found in %s", bootDir)
 	}
 
-	return nil
+	return archs, nil
+}
+
+// IsIA32Only reports whether archs contains a 32-bit bootloader but no 64-bit one,
+// which standard 64-bit UEFI firmware will refuse to boot
+func IsIA32Only(archs []UEFIArch) bool {
+	hasIA32 := false
+	for _, arch := range archs {
+		switch arch {
+		case UEFIArchX64:
+			return false
+		case UEFIArchIA32:
+			hasIA32 = true
+		}
+	}
+	return hasIA32
 }
 
+// readDirFold reads the directory root/elems..., matching each path element case-insensitively
+func readDirFold(root string, elems ...string) ([]os.DirEntry, error) {
+	dir, err := resolveDirFold(root, elems...)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(dir)
+}
+
+// resolveDirFold returns the path of root/elems..., matching each path element
+// case-insensitively
+func resolveDirFold(root string, elems ...string) (string, error) {
+	dir := root
+	for _, elem := range elems {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+
+		next := ""
+		for _, entry := range entries {
+			if entry.IsDir() && strings.EqualFold(entry.Name(), elem) {
+				next = filepath.Join(dir, entry.Name())
+				break
+			}
+		}
+		if next == "" {
+			return "", os.ErrNotExist
+		}
+		dir = next
+	}
+
+	return dir, nil
+}
+
+// GRUB targets accepted by InstallGRUBTarget
+const (
+	GRUBTargetBIOS = "i386-pc"
+	GRUBTargetEFI  = "x86_64-efi"
+)
+
+// WindowsEFILoaderName is what Windows' fallback loader in EFI/Boot is renamed to when
+// UEFI GRUB takes over bootx64.efi; grub.cfg chainloads it
+const WindowsEFILoaderName = "bootx64_windows.efi"
+
+// grubLibDirs are the directories grub-install looks for platform modules in
+var grubLibDirs = []string{"/usr/lib/grub", "/usr/lib/grub2", "/usr/share/grub2"}
+
 // InstallGRUB installs GRUB bootloader to the specified device
 func InstallGRUB(mountpoint, device, grubCmd string) error {
-	// Prepare grub-install arguments
-	args := []string{
-		"--target=i386-pc",
-		"--boot-directory=" + filepath.Join(mountpoint, "boot"),
-		"--force",
-		device,
+	return InstallGRUBTarget(mountpoint, device, grubCmd, GRUBTargetBIOS)
+}
+
+// InstallGRUBTarget installs GRUB for target (GRUBTargetBIOS or GRUBTargetEFI). BIOS
+// GRUB goes to the device's MBR. UEFI GRUB is installed as the removable-media loader
+// EFI/Boot/bootx64.efi, the only path firmware boots without a boot entry, after moving
+// Windows' loader aside to WindowsEFILoaderName so grub.cfg can chainload it.
+func InstallGRUBTarget(mountpoint, device, grubCmd, target string) error {
+	args := grubInstallArgs(mountpoint, device, target)
+	if args == nil {
+		return fmt.Errorf("unsupported GRUB target: %s", target)
+	}
+
+	var restore func()
+	if target == GRUBTargetEFI {
+		var err error
+		if restore, err = preserveWindowsEFILoader(mountpoint); err != nil {
+			return err
+		}
 	}
 
 	cmd := exec.Command(grubCmd, args...)
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to install GRUB with %s: %v", grubCmd, err)
+		if restore != nil {
+			restore()
+		}
+		return fmt.Errorf("failed to install GRUB (%s) with %s: %v", target, grubCmd, err)
+	}
+
+	return nil
+}
+
+// preserveWindowsEFILoader renames EFI/Boot/bootx64.efi under mountpoint to
+// WindowsEFILoaderName and returns a function that moves it back. If the loader was
+// already preserved by an earlier run, bootx64.efi is that run's GRUB and is left alone.
+func preserveWindowsEFILoader(mountpoint string) (func(), error) {
+	bootDir, err := resolveDirFold(mountpoint, "efi", "boot")
+	if err != nil {
+		return nil, fmt.Errorf("no EFI/Boot directory on %s for UEFI GRUB to chainload: %v", mountpoint, err)
+	}
+	entries, err := os.ReadDir(bootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var loader, preserved string
+	for _, entry := range entries {
+		switch {
+		case strings.EqualFold(entry.Name(), "bootx64.efi"):
+			loader = entry.Name()
+		case strings.EqualFold(entry.Name(), WindowsEFILoaderName):
+			preserved = entry.Name()
+		}
+	}
+	if preserved != "" {
+		return func() {}, nil
+	}
+	if loader == "" {
+		return nil, fmt.Errorf("no EFI/Boot/bootx64.efi on %s for UEFI GRUB to chainload", mountpoint)
+	}
+
+	src := filepath.Join(bootDir, loader)
+	dst := filepath.Join(bootDir, WindowsEFILoaderName)
+	if err := os.Rename(src, dst); err != nil {
+		return nil, fmt.Errorf("failed to preserve the Windows EFI loader: %v", err)
+	}
+	return func() { _ = os.Rename(dst, src) }, nil
+}
+
+// grubInstallArgs returns the grub-install arguments for target, or nil if the target
+// is not supported
+func grubInstallArgs(mountpoint, device, target string) []string {
+	bootDir := "--boot-directory=" + filepath.Join(mountpoint, "boot")
+	switch target {
+	case GRUBTargetBIOS:
+		return []string{"--target=" + target, bootDir, "--force", device}
+	case GRUBTargetEFI:
+		return []string{
+			"--target=" + target,
+			"--efi-directory=" + mountpoint,
+			"--removable",
+			bootDir,
+			"--no-nvram",
+			"--force",
+		}
+	}
+	return nil
+}
+
+// GRUBTargetAvailable reports whether the GRUB modules for target are installed, so
+// grub-install can install it
+func GRUBTargetAvailable(target string) bool {
+	for _, dir := range grubLibDirs {
+		if _, err := os.Stat(filepath.Join(dir, target, "normal.mod")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// mbrTableOffset is where the partition table starts in the MBR; the boot code and
+// disk signature come before it, the 0x55AA boot signature ends the sector
+const mbrTableOffset = 446
+
+// WriteMBRBootCode writes Windows 7 compatible boot code to the MBR of device with
+// ms-sys, as an alternative to GRUB for legacy BIOS boot. The code chainloads the
+// active partition, which needs the boot flag and a Windows boot record (see
+// WriteNTFSBootRecord). Fails if the partition table changed while writing.
+func WriteMBRBootCode(device string) error {
+	return writeMBRBootCode(device, func() error {
+		return runMSSys("--mbr7", device)
+	})
+}
+
+// writeMBRBootCode runs write and checks that it left the partition table of device alone
+func writeMBRBootCode(device string, write func() error) error {
+	before, err := readMBR(device)
+	if err != nil {
+		return err
+	}
+
+	if err := write(); err != nil {
+		return err
+	}
+
+	after, err := readMBR(device)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(before[mbrTableOffset:], after[mbrTableOffset:]) {
+		return fmt.Errorf("writing MBR boot code changed the partition table of %s", device)
+	}
+	return nil
+}
+
+// readMBR returns the first sector of device
+func readMBR(device string) ([]byte, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", device, err)
 	}
+	defer func() { _ = f.Close() }()
+
+	mbr := make([]byte, 512)
+	if _, err := io.ReadFull(f, mbr); err != nil {
+		return nil, fmt.Errorf("failed to read MBR of %s: %v", device, err)
+	}
+	return mbr, nil
+}
 
+// WriteNTFSBootRecord writes a Windows 7 NTFS partition boot record, which loads
+// bootmgr, to partition with ms-sys. The partition must not be mounted.
+func WriteNTFSBootRecord(partition string) error {
+	return runMSSys("--ntfs", partition)
+}
+
+// runMSSys runs ms-sys with args, including its output in the error
+func runMSSys(args ...string) error {
+	out, err := exec.Command("ms-sys", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("ms-sys %s failed: %v: %s", strings.Join(args, " "), err, msg)
+		}
+		return fmt.Errorf("ms-sys %s failed: %v", strings.Join(args, " "), err)
+	}
 	return nil
 }
 
+// GRUBConfigOptions controls the content of the generated grub.cfg
+type GRUBConfigOptions struct {
+	Modules         []string // GRUB modules to insmod before chainloading (e.g. "fat", "ntfs")
+	IncludeFallback bool     // Emit a second "Windows (fallback)" menu entry that searches for bootmgr
+	Theme           bool     // Switch to a graphical terminal and use the embedded theme (see InstallGRUBTheme)
+	EFI             bool     // Also install UEFI GRUB; its entries chainload Windows' EFI loader instead of ntldr
+}
+
+// GRUBConfigOptionsForFilesystem returns config options matching the target filesystem,
+// so the generated entries only load the module that can actually read the stick. The
+// fallback entry stays off, keeping the original menu-less config.
+func GRUBConfigOptionsForFilesystem(fstype string) GRUBConfigOptions {
+	var opts GRUBConfigOptions
+
+	switch strings.ToUpper(fstype) {
+	case "NTFS":
+		opts.Modules = []string{"part_msdos", "ntfs"}
+	case "FAT32", "FAT":
+		opts.Modules = []string{"part_msdos", "fat"}
+	case "EXFAT":
+		opts.Modules = []string{"part_msdos", "exfat"}
+	}
+
+	return opts
+}
+
 // WriteGRUBConfig writes a GRUB configuration file
 func WriteGRUBConfig(mountpoint, grubPrefix string) error {
+	return WriteGRUBConfigWithOptions(mountpoint, grubPrefix, GRUBConfigOptions{})
+}
+
+// WriteGRUBConfigWithOptions writes a GRUB configuration file using the given options
+func WriteGRUBConfigWithOptions(mountpoint, grubPrefix string, opts GRUBConfigOptions) error {
 	// Determine the correct boot directory based on grub prefix
 	var bootDir string
 	if strings.Contains(grubPrefix, "grub2") {
@@ -160,7 +469,7 @@ func WriteGRUBConfig(mountpoint, grubPrefix string) error {
 
 	// Write grub.cfg
 	grubCfgPath := filepath.Join(bootDir, "grub.cfg")
-	grubConfig := generateGRUBConfig(grubPrefix)
+	grubConfig := generateGRUBConfig(opts)
 
 	if err := os.WriteFile(grubCfgPath, []byte(grubConfig), 0644); err != nil {
 		return fmt.Errorf("failed to write GRUB config to %s: %v", grubCfgPath, err)
@@ -177,30 +486,131 @@ func DetectGRUBPrefix(grubCmd string) string {
 	return "grub"
 }
 
-// generateGRUBConfig generates a basic GRUB configuration for Windows USB
-// Uses ntldr to chainload Windows bootmgr, matching the original WoeUSB-ng behavior
-func generateGRUBConfig(_ string) string {
-	return `ntldr /bootmgr
-boot
-`
+// generateGRUBConfig generates a GRUB configuration for Windows USB
+// Uses ntldr to chainload Windows bootmgr, matching the original WoeUSB-ng behavior.
+// Without a fallback entry the commands run directly with no menu shown.
+func generateGRUBConfig(opts GRUBConfigOptions) string {
+	var sb strings.Builder
+
+	if !opts.IncludeFallback && !opts.Theme {
+		for _, mod := range opts.Modules {
+			sb.WriteString("insmod " + mod + "\n")
+		}
+		writeBootCommands(&sb, "", opts.EFI)
+		sb.WriteString("boot\n")
+		return sb.String()
+	}
+
+	sb.WriteString("set default=0\n")
+	if opts.IncludeFallback {
+		sb.WriteString("set fallback=1\n")
+	}
+
+	if opts.Theme {
+		// A themed menu is pointless if it is never shown
+		sb.WriteString("set timeout=5\n\n")
+		sb.WriteString("insmod all_video\n")
+		sb.WriteString("insmod gfxterm\n")
+		sb.WriteString("loadfont unicode\n")
+		sb.WriteString("set gfxmode=auto\n")
+		sb.WriteString("terminal_output gfxterm\n")
+		sb.WriteString("set theme=/boot/grub/themes/" + GRUBThemeName + "/theme.txt\n\n")
+	} else {
+		sb.WriteString("set timeout=0\n\n")
+	}
+
+	writeEntry := func(title string, search bool) {
+		sb.WriteString(fmt.Sprintf("menuentry \"%s\" {\n", title))
+		for _, mod := range opts.Modules {
+			sb.WriteString("\tinsmod " + mod + "\n")
+		}
+		if search {
+			sb.WriteString("\tsearch --no-floppy --file --set=root /bootmgr\n")
+		}
+		writeBootCommands(&sb, "\t", opts.EFI)
+		sb.WriteString("\tboot\n")
+		sb.WriteString("}\n")
+	}
+
+	writeEntry("Windows", false)
+	if opts.IncludeFallback {
+		sb.WriteString("\n")
+		writeEntry("Windows (fallback)", true)
+	}
+
+	return sb.String()
+}
+
+// writeBootCommands writes the command that loads Windows. With efi, UEFI GRUB
+// chainloads the Windows EFI loader, since ntldr only works on BIOS.
+func writeBootCommands(sb *strings.Builder, indent string, efi bool) {
+	if !efi {
+		sb.WriteString(indent + "ntldr /bootmgr\n")
+		return
+	}
+	sb.WriteString(indent + "if [ \"${grub_platform}\" = \"efi\" ]; then\n")
+	sb.WriteString(indent + "\tinsmod chain\n")
+	sb.WriteString(indent + "\tchainloader /efi/boot/" + WindowsEFILoaderName + "\n")
+	sb.WriteString(indent + "else\n")
+	sb.WriteString(indent + "\tntldr /bootmgr\n")
+	sb.WriteString(indent + "fi\n")
 }
 
 // InstallGRUBWithConfig installs GRUB and writes configuration in one step
 func InstallGRUBWithConfig(mountpoint, device, grubCmd string) error {
+	return InstallGRUBWithOptions(mountpoint, device, grubCmd, GRUBConfigOptions{})
+}
+
+// InstallGRUBWithOptions installs GRUB and writes a configuration built from opts
+func InstallGRUBWithOptions(mountpoint, device, grubCmd string, opts GRUBConfigOptions) error {
 	// Install GRUB
 	if err := InstallGRUB(mountpoint, device, grubCmd); err != nil {
 		return fmt.Errorf("GRUB installation failed: %v", err)
 	}
+	if opts.EFI {
+		if err := InstallGRUBTarget(mountpoint, device, grubCmd, GRUBTargetEFI); err != nil {
+			return fmt.Errorf("UEFI GRUB installation failed: %v", err)
+		}
+	}
 
 	// Detect prefix and write config
 	grubPrefix := DetectGRUBPrefix(grubCmd)
-	if err := WriteGRUBConfig(mountpoint, grubPrefix); err != nil {
+	if err := WriteGRUBConfigWithOptions(mountpoint, grubPrefix, opts); err != nil {
 		return fmt.Errorf("GRUB configuration failed: %v", err)
 	}
 
 	return nil
 }
 
+// InstallGRUBTheme copies the embedded GRUB theme to boot/grub/themes on the target
+func InstallGRUBTheme(mountpoint string) error {
+	themeDir := filepath.Join(mountpoint, "boot", "grub", "themes", GRUBThemeName)
+
+	err := fs.WalkDir(themeFS, "theme", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(path, "theme"), "/")
+		dst := filepath.Join(themeDir, filepath.FromSlash(rel))
+
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+
+		data, err := themeFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to install GRUB theme to %s: %v", themeDir, err)
+	}
+
+	return nil
+}
+
 // CheckGRUBInstallation verifies that GRUB was installed correctly
 func CheckGRUBInstallation(mountpoint, grubPrefix string) error {
 	// Check for boot directory
@@ -3,6 +3,7 @@ package bootloader
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -291,3 +292,297 @@ func TestApplyWindows7UEFIWorkaround(t *testing.T) {
 	// Note: Testing with actual Windows 7 would require creating proper
 	// cversion.ini and install.wim files, which is complex for unit tests
 }
+
+func TestGRUBConfigOptionsForFilesystem(t *testing.T) {
+	tests := []struct {
+		fstype   string
+		module   string
+		excluded string
+	}{
+		{"FAT", "fat", "ntfs"},
+		{"FAT32", "fat", "ntfs"},
+		{"NTFS", "ntfs", "fat"},
+		{"EXFAT", "exfat", "ntfs"},
+	}
+
+	for _, test := range tests {
+		opts := GRUBConfigOptionsForFilesystem(test.fstype)
+		if opts.IncludeFallback {
+			t.Errorf("GRUBConfigOptionsForFilesystem(%s) should not include the fallback by default", test.fstype)
+		}
+
+		config := generateGRUBConfig(opts)
+		if !strings.Contains(config, "insmod "+test.module) {
+			t.Errorf("config for %s does not load %s module:\n%s", test.fstype, test.module, config)
+		}
+		if strings.Contains(config, "insmod "+test.excluded) {
+			t.Errorf("config for %s should not load %s module:\n%s", test.fstype, test.excluded, config)
+		}
+	}
+}
+
+func TestGenerateGRUBConfigFallback(t *testing.T) {
+	opts := GRUBConfigOptionsForFilesystem("NTFS")
+
+	// The default keeps the original menu-less config, plus the filesystem's modules
+	if config := generateGRUBConfig(opts); config != "insmod part_msdos\ninsmod ntfs\nntldr /bootmgr\nboot\n" {
+		t.Errorf("unexpected default NTFS config: %q", config)
+	}
+
+	opts.IncludeFallback = true
+	config := generateGRUBConfig(opts)
+	if !strings.Contains(config, "Windows (fallback)") {
+		t.Error("config should contain fallback entry when IncludeFallback is set")
+	}
+
+	opts.IncludeFallback = false
+	config = generateGRUBConfig(opts)
+	if strings.Contains(config, "fallback") {
+		t.Errorf("config should not contain fallback entry:\n%s", config)
+	}
+	if !strings.Contains(config, "ntldr /bootmgr") {
+		t.Error("config does not contain expected ntldr command")
+	}
+
+	// Zero options keep the original minimal config
+	if config := generateGRUBConfig(GRUBConfigOptions{}); config != "ntldr /bootmgr\nboot\n" {
+		t.Errorf("unexpected default config: %q", config)
+	}
+}
+
+func TestGenerateGRUBConfigTheme(t *testing.T) {
+	opts := GRUBConfigOptionsForFilesystem("FAT")
+	opts.Theme = true
+
+	config := generateGRUBConfig(opts)
+	for _, want := range []string{"terminal_output gfxterm", "set gfxmode=auto", "set theme=/boot/grub/themes/" + GRUBThemeName + "/theme.txt", "set timeout=5"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("themed config missing %q:\n%s", want, config)
+		}
+	}
+
+	// Theme without fallback still needs a menu entry
+	opts.IncludeFallback = false
+	config = generateGRUBConfig(opts)
+	if !strings.Contains(config, "menuentry \"Windows\"") || strings.Contains(config, "fallback") {
+		t.Errorf("unexpected themed config without fallback:\n%s", config)
+	}
+}
+
+func TestInstallGRUBTheme(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "grub-theme-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tempDir) }()
+
+	if err := InstallGRUBTheme(tempDir); err != nil {
+		t.Fatalf("InstallGRUBTheme() error = %v", err)
+	}
+
+	themePath := filepath.Join(tempDir, "boot", "grub", "themes", GRUBThemeName, "theme.txt")
+	data, err := os.ReadFile(themePath)
+	if err != nil {
+		t.Fatalf("theme.txt not written: %v", err)
+	}
+	if !strings.Contains(string(data), "boot_menu") {
+		t.Error("theme.txt does not contain a boot_menu component")
+	}
+}
+
+func TestCheckUEFIBootloaderArch(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		want     []UEFIArch
+		ia32Only bool
+	}{
+		{"x64 only", []string{"efi/boot/bootx64.efi"}, []UEFIArch{UEFIArchX64}, false},
+		{"ia32 only", []string{"efi/boot/bootia32.efi"}, []UEFIArch{UEFIArchIA32}, true},
+		{"both", []string{"efi/boot/bootx64.efi", "efi/boot/bootia32.efi"}, []UEFIArch{UEFIArchX64, UEFIArchIA32}, false},
+		{"upper-case ISO names", []string{"EFI/BOOT/BOOTIA32.EFI"}, []UEFIArch{UEFIArchIA32}, true},
+	}
+
+	for _, test := range tests {
+		tmpDir, err := os.MkdirTemp("", "uefi_arch_test")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer func() { _ = os.RemoveAll(tmpDir) }()
+
+		for _, f := range test.files {
+			path := filepath.Join(tmpDir, filepath.FromSlash(f))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			if err := os.WriteFile(path, []byte("fake bootloader"), 0644); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+		}
+
+		archs, err := CheckUEFIBootloaderArch(tmpDir)
+		if err != nil {
+			t.Errorf("%s: CheckUEFIBootloaderArch() error = %v", test.name, err)
+			continue
+		}
+		if len(archs) != len(test.want) {
+			t.Errorf("%s: got %v, expected %v", test.name, archs, test.want)
+			continue
+		}
+		for i := range archs {
+			if archs[i] != test.want[i] {
+				t.Errorf("%s: got %v, expected %v", test.name, archs, test.want)
+			}
+		}
+		if IsIA32Only(archs) != test.ia32Only {
+			t.Errorf("%s: IsIA32Only() = %v, expected %v", test.name, IsIA32Only(archs), test.ia32Only)
+		}
+	}
+}
+
+func TestWriteMBRBootCodePreservesTable(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mbr_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	disk := filepath.Join(tmpDir, "disk.img")
+	sector := make([]byte, 1024)
+	for i := mbrTableOffset; i < 510; i++ {
+		sector[i] = byte(i)
+	}
+	sector[510], sector[511] = 0x55, 0xAA
+	if err := os.WriteFile(disk, sector, 0644); err != nil {
+		t.Fatalf("Failed to create disk image: %v", err)
+	}
+
+	writeAt := func(offset int64, data []byte) func() error {
+		return func() error {
+			f, err := os.OpenFile(disk, os.O_WRONLY, 0)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			_, err = f.WriteAt(data, offset)
+			return err
+		}
+	}
+
+	// Writing only the boot code area is fine
+	if err := writeMBRBootCode(disk, writeAt(0, []byte(strings.Repeat("\x90", 440)))); err != nil {
+		t.Errorf("writeMBRBootCode() with boot code only = %v", err)
+	}
+
+	// Touching the partition table is detected
+	if err := writeMBRBootCode(disk, writeAt(mbrTableOffset, []byte{0x80})); err == nil {
+		t.Error("writeMBRBootCode() should detect a changed partition table")
+	}
+
+	if err := writeMBRBootCode(filepath.Join(tmpDir, "missing"), func() error { return nil }); err == nil {
+		t.Error("writeMBRBootCode() should fail for a missing device")
+	}
+}
+
+func TestGRUBInstallArgs(t *testing.T) {
+	bios := grubInstallArgs("/mnt/usb", "/dev/sdb", GRUBTargetBIOS)
+	want := []string{"--target=i386-pc", "--boot-directory=/mnt/usb/boot", "--force", "/dev/sdb"}
+	if !reflect.DeepEqual(bios, want) {
+		t.Errorf("BIOS args = %q, want %q", bios, want)
+	}
+
+	// Without a boot entry firmware only boots the removable-media path EFI/Boot/bootx64.efi
+	efi := strings.Join(grubInstallArgs("/mnt/usb", "/dev/sdb", GRUBTargetEFI), " ")
+	for _, arg := range []string{"--target=x86_64-efi", "--efi-directory=/mnt/usb", "--removable", "--no-nvram"} {
+		if !strings.Contains(efi, arg) {
+			t.Errorf("EFI args %q lack %s", efi, arg)
+		}
+	}
+
+	if grubInstallArgs("/mnt/usb", "/dev/sdb", "arm64-efi") != nil {
+		t.Error("unsupported target should have no arguments")
+	}
+	if err := InstallGRUBTarget("/mnt/usb", "/dev/sdb", "grub-install", "arm64-efi"); err == nil {
+		t.Error("InstallGRUBTarget should reject an unsupported target")
+	}
+}
+
+func TestGRUBTargetAvailable(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "grub_lib_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	oldDirs := grubLibDirs
+	defer func() { grubLibDirs = oldDirs }()
+	grubLibDirs = []string{filepath.Join(tmpDir, "missing"), tmpDir}
+
+	biosDir := filepath.Join(tmpDir, GRUBTargetBIOS)
+	if err := os.MkdirAll(biosDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(biosDir, "normal.mod"), []byte("mod"), 0644); err != nil {
+		t.Fatalf("Failed to write module: %v", err)
+	}
+
+	if !GRUBTargetAvailable(GRUBTargetBIOS) {
+		t.Error("BIOS target should be available")
+	}
+	if GRUBTargetAvailable(GRUBTargetEFI) {
+		t.Error("EFI target should not be available without its modules")
+	}
+}
+
+func TestPreserveWindowsEFILoader(t *testing.T) {
+	tmpDir := t.TempDir()
+	bootDir := filepath.Join(tmpDir, "EFI", "Boot")
+	if err := os.MkdirAll(bootDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := preserveWindowsEFILoader(tmpDir); err == nil {
+		t.Error("preserveWindowsEFILoader() should fail without bootx64.efi")
+	}
+
+	loader := filepath.Join(bootDir, "BOOTX64.EFI")
+	preserved := filepath.Join(bootDir, WindowsEFILoaderName)
+	_ = os.WriteFile(loader, []byte("windows"), 0644)
+
+	restore, err := preserveWindowsEFILoader(tmpDir)
+	if err != nil {
+		t.Fatalf("preserveWindowsEFILoader() error = %v", err)
+	}
+	if data, err := os.ReadFile(preserved); err != nil || string(data) != "windows" {
+		t.Errorf("Windows loader not moved to %s: %v", preserved, err)
+	}
+	restore()
+	if _, err := os.Stat(loader); err != nil {
+		t.Errorf("restore did not move the Windows loader back: %v", err)
+	}
+
+	// A second run keeps the loader preserved by the first one
+	_ = os.Rename(loader, preserved)
+	_ = os.WriteFile(loader, []byte("grub"), 0644)
+	if _, err := preserveWindowsEFILoader(tmpDir); err != nil {
+		t.Fatalf("preserveWindowsEFILoader() on a second run error = %v", err)
+	}
+	if data, _ := os.ReadFile(preserved); string(data) != "windows" {
+		t.Errorf("preserved loader was overwritten: %q", data)
+	}
+}
+
+func TestGenerateGRUBConfigEFI(t *testing.T) {
+	opts := GRUBConfigOptionsForFilesystem("FAT")
+	opts.EFI = true
+
+	for _, fallback := range []bool{true, false} {
+		opts.IncludeFallback = fallback
+		config := generateGRUBConfig(opts)
+		for _, want := range []string{`if [ "${grub_platform}" = "efi" ]; then`, "chainloader /efi/boot/" + WindowsEFILoaderName, "ntldr /bootmgr"} {
+			if !strings.Contains(config, want) {
+				t.Errorf("config (fallback %v) lacks %q:\n%s", fallback, want, config)
+			}
+		}
+	}
+}
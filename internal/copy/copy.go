@@ -1,12 +1,24 @@
 package copy
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mathisen/woeusb-go/internal/fingerprint"
 )
 
 const (
@@ -19,6 +31,117 @@ const (
 // ProgressFunc is called during file copying to report progress
 type ProgressFunc func(bytesCopied, totalBytes int64, currentFile string)
 
+// ProgressInterval is the minimum time between two throttled progress callbacks
+const ProgressInterval = 100 * time.Millisecond
+
+// ThrottleProgress wraps fn so it is called at most every ProgressInterval and only
+// once progress has moved by at least 1% since the last call. The final call, with
+// bytesCopied == totalBytes, is always passed through. Returns nil if fn is nil.
+func ThrottleProgress(fn ProgressFunc) ProgressFunc {
+	return throttleProgress(fn, ProgressInterval, time.Now)
+}
+
+// throttleProgress implements ThrottleProgress with an injectable clock
+func throttleProgress(fn ProgressFunc, interval time.Duration, now func() time.Time) ProgressFunc {
+	if fn == nil {
+		return nil
+	}
+
+	var lastTime time.Time
+	var lastBytes int64
+	called := false
+
+	return func(bytesCopied, totalBytes int64, currentFile string) {
+		final := bytesCopied >= totalBytes
+		t := now()
+		if called && !final {
+			if t.Sub(lastTime) < interval {
+				return
+			}
+			if totalBytes > 0 && (bytesCopied-lastBytes)*100 < totalBytes {
+				return
+			}
+		}
+
+		called = true
+		lastTime = t
+		lastBytes = bytesCopied
+		fn(bytesCopied, totalBytes, currentFile)
+	}
+}
+
+// Progress is a progress report with the transfer rate and estimated time left
+type Progress struct {
+	BytesCopied int64
+	TotalBytes  int64
+	CurrentFile string
+
+	BytesPerSec        float64       // Average rate over the last RateWindow, 0 while unknown
+	EstimatedRemaining time.Duration // Time left at BytesPerSec, 0 while unknown
+}
+
+// DetailedProgressFunc is called with progress reports that include rate and ETA
+type DetailedProgressFunc func(p Progress)
+
+// RateWindow is how far back DetailedProgress averages the transfer rate
+const RateWindow = 5 * time.Second
+
+// DetailedProgress adapts fn to a ProgressFunc, adding the rolling transfer rate and
+// the estimated time left. Tracking restarts when progress goes backwards or the total
+// changes, e.g. when a copy is retried. Returns nil if fn is nil.
+func DetailedProgress(fn DetailedProgressFunc) ProgressFunc {
+	return detailedProgress(fn, time.Now)
+}
+
+// rateSample is the number of bytes copied at a point in time
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// detailedProgress implements DetailedProgress with an injectable clock
+func detailedProgress(fn DetailedProgressFunc, now func() time.Time) ProgressFunc {
+	if fn == nil {
+		return nil
+	}
+
+	var samples []rateSample
+	var total int64
+
+	return func(bytesCopied, totalBytes int64, currentFile string) {
+		t := now()
+		if totalBytes != total || (len(samples) > 0 && bytesCopied < samples[len(samples)-1].bytes) {
+			samples = samples[:0]
+			total = totalBytes
+		}
+		samples = append(samples, rateSample{at: t, bytes: bytesCopied})
+
+		// Keep the newest sample older than the window as the baseline
+		for len(samples) > 2 && t.Sub(samples[1].at) >= RateWindow {
+			samples = samples[1:]
+		}
+
+		p := Progress{BytesCopied: bytesCopied, TotalBytes: totalBytes, CurrentFile: currentFile}
+		first := samples[0]
+		if elapsed := t.Sub(first.at); elapsed > 0 {
+			p.BytesPerSec = float64(bytesCopied-first.bytes) / elapsed.Seconds()
+		}
+		if p.BytesPerSec > 0 && totalBytes > bytesCopied {
+			p.EstimatedRemaining = time.Duration(float64(totalBytes-bytesCopied) / p.BytesPerSec * float64(time.Second))
+		}
+		fn(p)
+	}
+}
+
+// FormatETA formats a remaining duration as hh:mm:ss
+func FormatETA(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	sec := (d % time.Minute) / time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", h, m, sec)
+}
+
 // CopyStats holds statistics about the copy operation
 type CopyStats struct {
 	TotalFiles  int
@@ -26,26 +149,218 @@ type CopyStats struct {
 	CopiedFiles int
 	CopiedBytes int64
 	CurrentFile string
-	Failed      []string
+	Failed      []FailedFile
+
+	// IgnoreReadErrors zero-fills source regions that stay unreadable after retries
+	IgnoreReadErrors bool
+	// ReadErrors lists the files that hit source read errors, whether recovered or not
+	ReadErrors []ReadErrorFile
+	// OnReadError, if set, is called for every entry added to ReadErrors
+	OnReadError func(ReadErrorFile)
+
+	// PreserveMetadata gives copies the source's modification time and permission bits
+	PreserveMetadata bool
+	// ResumeMode skips files whose copy on the target already has the source's size
+	// and modification time, counting them as copied (see alreadyCopied). It relies on
+	// PreserveMetadata having been set for the earlier run.
+	ResumeMode bool
+	// SkippedFiles counts the files ResumeMode left in place
+	SkippedFiles int
+
+	// ctx cancels the copy between files and chunks; nil means it cannot be cancelled
+	ctx context.Context
+	// limiter keeps writes under CopyOptions.MaxBytesPerSec; nil means no limit
+	limiter *rateLimiter
+}
+
+// rateLimiter keeps a copy under rate bytes per second by sleeping whenever the bytes
+// written get ahead of the time spent
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	bytes int64
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// newRateLimiter returns a rateLimiter for rate bytes per second, or nil if rate is
+// not positive
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, now: time.Now, sleep: time.Sleep}
+}
+
+// wait accounts for n bytes written and sleeps until they are within the rate
+func (l *rateLimiter) wait(n int64) {
+	if l == nil {
+		return
+	}
+
+	now := l.now()
+	if l.start.IsZero() {
+		l.start = now
+	}
+	l.bytes += n
+
+	due := l.start.Add(time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second)))
+	if d := due.Sub(now); d > 0 {
+		l.sleep(d)
+	} else if d < -time.Second {
+		// Time spent elsewhere (e.g. splitting a WIM) is not saved up for a burst
+		l.start, l.bytes = now, 0
+	}
+}
+
+// cancelled returns the context error once the copy has been cancelled, nil otherwise
+func (s *CopyStats) cancelled() error {
+	if s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Err()
+}
+
+// ReadErrorFile records a file whose source reads failed at least once
+type ReadErrorFile struct {
+	Path       string
+	Retries    int   // Failed reads that were retried
+	ZeroFilled int64 // Bytes replaced with zeros because they could not be read
+}
+
+// Recovered reports whether every failed read succeeded on a retry
+func (r ReadErrorFile) Recovered() bool {
+	return r.ZeroFilled == 0
+}
+
+// recordReadError adds r to s.ReadErrors if the file had any read errors
+func (s *CopyStats) recordReadError(r ReadErrorFile) {
+	if r.Retries == 0 && r.ZeroFilled == 0 {
+		return
+	}
+	s.ReadErrors = append(s.ReadErrors, r)
+	if s.OnReadError != nil {
+		s.OnReadError(r)
+	}
+}
+
+// FailedFile records a file that could not be copied and why
+type FailedFile struct {
+	Path string
+	Err  error
+}
+
+// ErrSourceUnavailable is returned when the whole source disappears during a copy,
+// e.g. an ejected disc or a dropped network mount
+var ErrSourceUnavailable = errors.New("source became unavailable")
+
+// sourceGone reports whether err means the device or network mount behind the source is gone
+func sourceGone(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENODEV, syscall.ENXIO, syscall.ESTALE, syscall.ENOMEDIUM} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceWatch tells a vanished source from single failing files by comparing the
+// source root with the filesystem it was on when the copy started
+type sourceWatch struct {
+	root string
+	dev  uint64
+	ok   bool // whether the root could be stat'ed at the start
+}
+
+// watchSource records the filesystem of root
+func watchSource(root string) *sourceWatch {
+	w := &sourceWatch{root: root}
+	var st syscall.Stat_t
+	if err := syscall.Stat(root, &st); err == nil {
+		w.dev = uint64(st.Dev)
+		w.ok = true
+	}
+	return w
+}
+
+// check returns an ErrSourceUnavailable error if failErr, a file failure, was caused by
+// the whole source going away: the error says so, or the root is gone or unmounted
+func (w *sourceWatch) check(failErr error) error {
+	if sourceGone(failErr) {
+		return fmt.Errorf("%w: %s: %v", ErrSourceUnavailable, w.root, failErr)
+	}
+	if !w.ok {
+		return nil
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(w.root, &st); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrSourceUnavailable, w.root, err)
+	}
+	if uint64(st.Dev) != w.dev {
+		return fmt.Errorf("%w: %s is no longer mounted", ErrSourceUnavailable, w.root)
+	}
+	return nil
+}
+
+// CopyFailedError is returned when one or more files could not be copied
+type CopyFailedError struct {
+	Failed []FailedFile
+}
+
+func (e *CopyFailedError) Error() string {
+	if len(e.Failed) == 1 {
+		return fmt.Sprintf("failed to copy %s: %v", e.Failed[0].Path, e.Failed[0].Err)
+	}
+	return fmt.Sprintf("failed to copy %d files (first: %s: %v)", len(e.Failed), e.Failed[0].Path, e.Failed[0].Err)
+}
+
+// failedError returns a CopyFailedError if any files failed, nil otherwise
+func (s *CopyStats) failedError() error {
+	if len(s.Failed) == 0 {
+		return nil
+	}
+	return &CopyFailedError{Failed: s.Failed}
 }
 
 // CopyWithProgress copies all files from srcMount to dstMount with progress reporting
 func CopyWithProgress(srcMount, dstMount string, progressFn ProgressFunc) error {
+	return CopyWithProgressWithOptions(srcMount, dstMount, DefaultCopyOptions(), progressFn)
+}
+
+// CopyWithProgressWithOptions is CopyWithProgress with the read error, resume and
+// cancellation settings of opts. WIM splitting options do not apply.
+func CopyWithProgressWithOptions(srcMount, dstMount string, opts CopyOptions, progressFn ProgressFunc) error {
 	// First pass: calculate total size and file count
-	stats, err := calculateTotalSize(srcMount)
+	ctx := opts.context()
+	stats, err := calculateTotalSize(ctx, srcMount)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return fmt.Errorf("failed to calculate total size: %v", err)
 	}
+	stats.IgnoreReadErrors = opts.IgnoreReadErrors
+	stats.OnReadError = opts.OnReadError
+	stats.PreserveMetadata = opts.PreserveMetadata
+	stats.ResumeMode = opts.ResumeMode
+	stats.ctx = ctx
+	stats.limiter = newRateLimiter(opts.MaxBytesPerSec)
 
 	// Second pass: copy files with progress
-	return copyFiles(srcMount, dstMount, stats, progressFn)
+	return copyFiles(srcMount, dstMount, stats, ThrottleProgress(progressFn))
 }
 
-// calculateTotalSize walks the source directory and calculates total bytes and file count
-func calculateTotalSize(srcMount string) (*CopyStats, error) {
+// calculateTotalSize walks the source directory and calculates total bytes and file count.
+// The walk stops with ctx.Err() once ctx is cancelled.
+func calculateTotalSize(ctx context.Context, srcMount string) (*CopyStats, error) {
 	stats := &CopyStats{}
 
 	err := filepath.Walk(srcMount, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return nil // Skip files we can't access
 		}
@@ -67,11 +382,18 @@ func calculateTotalSize(srcMount string) (*CopyStats, error) {
 
 // copyFiles performs the actual file copying with progress reporting
 func copyFiles(srcMount, dstMount string, stats *CopyStats, progressFn ProgressFunc) error {
-	return filepath.Walk(srcMount, func(srcPath string, info os.FileInfo, err error) error {
+	watch := watchSource(srcMount)
+	err := filepath.Walk(srcMount, func(srcPath string, info os.FileInfo, err error) error {
+		if ctxErr := stats.cancelled(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
-			// Log failed file but continue
+			if goneErr := watch.check(err); goneErr != nil {
+				return goneErr
+			}
+			// Record failed file but continue
 			relPath, _ := filepath.Rel(srcMount, srcPath)
-			stats.Failed = append(stats.Failed, relPath)
+			stats.Failed = append(stats.Failed, FailedFile{Path: relPath, Err: err})
 			return nil
 		}
 
@@ -95,7 +417,13 @@ func copyFiles(srcMount, dstMount string, stats *CopyStats, progressFn ProgressF
 			}
 
 			if err := copyFile(srcPath, dstPath, info.Size(), stats, progressFn); err != nil {
-				stats.Failed = append(stats.Failed, relPath)
+				if ctxErr := stats.cancelled(); ctxErr != nil {
+					return ctxErr
+				}
+				if goneErr := watch.check(err); goneErr != nil {
+					return goneErr
+				}
+				stats.Failed = append(stats.Failed, FailedFile{Path: relPath, Err: err})
 				return nil // Continue with other files
 			}
 
@@ -104,9 +432,20 @@ func copyFiles(srcMount, dstMount string, stats *CopyStats, progressFn ProgressF
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return stats.failedError()
 }
 
-// copyFile copies a single file with progress reporting for large files
+// copyFile copies a single file with progress reporting for large files. Source reads
+// that fail are retried (see readAtRetry); with stats.IgnoreReadErrors, regions that
+// stay unreadable are zero-filled instead of failing the file. Once stats is cancelled,
+// the partial destination file is removed and the context error returned.
+//
+// With stats.PreserveMetadata the copy gets the source's modification time, so
+// stats.ResumeMode can tell a finished copy from one cut short by a disconnect.
 func copyFile(srcPath, dstPath string, fileSize int64, stats *CopyStats, progressFn ProgressFunc) error {
 	srcFile, err := os.Open(srcPath)
 	if err != nil {
@@ -114,48 +453,72 @@ func copyFile(srcPath, dstPath string, fileSize int64, stats *CopyStats, progres
 	}
 	defer func() { _ = srcFile.Close() }()
 
-	dstFile, err := os.Create(dstPath)
+	srcInfo, err := srcFile.Stat()
 	if err != nil {
 		return err
 	}
-	defer func() { _ = dstFile.Close() }()
-
-	// For small files, copy directly
-	if fileSize < LargeFileThreshold {
-		_, err := io.Copy(dstFile, srcFile)
-		if err != nil {
-			return err
-		}
+	if stats.ResumeMode && alreadyCopied(dstPath, srcInfo) {
 		stats.CopiedBytes += fileSize
+		stats.SkippedFiles++
 		if progressFn != nil {
 			progressFn(stats.CopiedBytes, stats.TotalBytes, stats.CurrentFile)
 		}
 		return nil
 	}
 
-	// For large files, copy in chunks with progress updates
-	buffer := make([]byte, ChunkSize)
-	var totalCopied int64
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dstFile.Close() }()
+
+	// Small files are copied in one chunk without intermediate progress updates
+	bufSize := int64(ChunkSize)
+	if fileSize < LargeFileThreshold {
+		bufSize = max(fileSize, 1)
+	}
+	buffer := make([]byte, bufSize)
+	readErr := ReadErrorFile{Path: stats.CurrentFile}
+	var offset int64
 
 	for {
-		n, err := srcFile.Read(buffer)
-		if n == 0 {
-			break
+		if ctxErr := stats.cancelled(); ctxErr != nil {
+			_ = dstFile.Close()
+			_ = os.Remove(dstPath)
+			return ctxErr
 		}
+
+		n, retries, err := readAtRetry(srcFile, buffer, offset)
+		readErr.Retries += retries
 		if err != nil && err != io.EOF {
-			return err
+			// Zero-filling cannot help once the whole source is gone
+			if !stats.IgnoreReadErrors || sourceGone(err) {
+				stats.recordReadError(readErr)
+				return fmt.Errorf("read error at offset %d: %w", offset, err)
+			}
+			if offset >= fileSize {
+				break // Everything up to the scanned size has been salvaged
+			}
+			chunk := buffer[:min(int64(len(buffer)), fileSize-offset)]
+			var zeroed int64
+			n, zeroed = salvageChunk(srcFile, chunk, offset)
+			readErr.ZeroFilled += zeroed
+			err = nil
+		}
+		if n == 0 {
+			break
 		}
 
-		_, writeErr := dstFile.Write(buffer[:n])
-		if writeErr != nil {
+		if _, writeErr := dstFile.Write(buffer[:n]); writeErr != nil {
 			return writeErr
 		}
+		stats.limiter.wait(int64(n))
 
-		totalCopied += int64(n)
+		offset += int64(n)
 		stats.CopiedBytes += int64(n)
 
 		// Report progress for large files
-		if progressFn != nil {
+		if progressFn != nil && fileSize >= LargeFileThreshold {
 			progressFn(stats.CopiedBytes, stats.TotalBytes, stats.CurrentFile)
 		}
 
@@ -164,14 +527,124 @@ func copyFile(srcPath, dstPath string, fileSize int64, stats *CopyStats, progres
 		}
 	}
 
+	stats.recordReadError(readErr)
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	if stats.PreserveMetadata {
+		if err := preserveMetadata(dstPath, srcInfo); err != nil {
+			return err
+		}
+	}
+
+	if progressFn != nil && fileSize < LargeFileThreshold {
+		progressFn(stats.CopiedBytes, stats.TotalBytes, stats.CurrentFile)
+	}
+	return nil
+}
+
+// preserveMetadata gives dstPath the modification time and permission bits of srcInfo.
+// The owner keeps write access, since ISO files are read-only and a later --resume or
+// --no-reformat run must be able to replace them. Filesystems without permissions
+// (FAT, exFAT) refuse the chmod with EPERM or ENOTSUP, which is not an error.
+func preserveMetadata(dstPath string, srcInfo os.FileInfo) error {
+	if err := os.Chtimes(dstPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		return fmt.Errorf("failed to set modification time: %v", err)
+	}
+	err := os.Chmod(dstPath, srcInfo.Mode().Perm()|0200)
+	if err != nil && !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.ENOTSUP) {
+		return fmt.Errorf("failed to set permissions: %v", err)
+	}
 	return nil
 }
 
-// PrintProgress prints progress information to stderr
+// mtimeTolerance is how far modification times may differ and still match. FAT stores
+// them with a 2 second resolution.
+const mtimeTolerance = 2 * time.Second
+
+// alreadyCopied reports whether dstPath holds a finished copy of the file described by
+// srcInfo: same size and, within mtimeTolerance, the same modification time
+func alreadyCopied(dstPath string, srcInfo os.FileInfo) bool {
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil || !dstInfo.Mode().IsRegular() || dstInfo.Size() != srcInfo.Size() {
+		return false
+	}
+	diff := dstInfo.ModTime().Sub(srcInfo.ModTime())
+	return diff > -mtimeTolerance && diff < mtimeTolerance
+}
+
+// ReadRetries is how often a failed source read is retried before giving up
+const ReadRetries = 3
+
+// SectorSize is the granularity unreadable regions are zero-filled at, matching
+// optical media
+const SectorSize = 2048
+
+// readRetryDelay is the pause before retrying a failed read; tests shorten it
+var readRetryDelay = 500 * time.Millisecond
+
+// readAtRetry reads into buf at off, retrying failed reads up to ReadRetries times.
+// Every attempt reads at an explicit offset, so a retry re-seeks to where the failed
+// read started. Returns the bytes read, the number of retries and the last error;
+// io.EOF is returned as-is and not retried.
+func readAtRetry(f *os.File, buf []byte, off int64) (int, int, error) {
+	var n int
+	var err error
+	for attempt := 0; ; attempt++ {
+		n, err = f.ReadAt(buf, off)
+		if err == nil || err == io.EOF || attempt == ReadRetries {
+			return n, attempt, err
+		}
+		time.Sleep(readRetryDelay)
+	}
+}
+
+// salvageChunk fills chunk with the data at off, reading it SectorSize bytes at a
+// time and zero-filling sectors that stay unreadable. Returns len(chunk) and the
+// number of zero-filled bytes.
+func salvageChunk(f *os.File, chunk []byte, off int64) (int, int64) {
+	var zeroed int64
+	for pos := 0; pos < len(chunk); pos += SectorSize {
+		sector := chunk[pos:min(pos+SectorSize, len(chunk))]
+		n, _, err := readAtRetry(f, sector, off+int64(pos))
+		if err != nil && !(err == io.EOF && n == len(sector)) {
+			clear(sector[n:])
+			zeroed += int64(len(sector) - n)
+		}
+	}
+	return len(chunk), zeroed
+}
+
+// printProgress tracks the rate for PrintProgress
+var printProgress = DetailedProgress(PrintDetailedProgress)
+
+// PrintProgress prints progress information, including the transfer rate and time
+// left once they are known, to stderr
 func PrintProgress(bytesCopied, totalBytes int64, currentFile string) {
-	percentage := float64(bytesCopied) / float64(totalBytes) * 100
-	fmt.Fprintf(os.Stderr, "\rCopying: %.1f%% (%s) - %s",
-		percentage, formatBytes(bytesCopied), currentFile)
+	printProgress(bytesCopied, totalBytes, currentFile)
+}
+
+// PrintDetailedProgress prints p to stderr, e.g.
+// "Copying: 45.2% (1.2 GB) at 18.4 MB/s, ETA 00:03:12 - sources/install.wim"
+func PrintDetailedProgress(p Progress) {
+	fmt.Fprintf(os.Stderr, "\r%s - %s", FormatProgress(p), p.CurrentFile)
+}
+
+// FormatProgress describes p without the current file, e.g.
+// "Copying: 45.2% (1.2 GB) at 18.4 MB/s, ETA 00:03:12"
+func FormatProgress(p Progress) string {
+	var percentage float64
+	if p.TotalBytes > 0 {
+		percentage = float64(p.BytesCopied) / float64(p.TotalBytes) * 100
+	}
+	msg := fmt.Sprintf("Copying: %.1f%% (%s)", percentage, formatBytes(p.BytesCopied))
+	if p.BytesPerSec > 0 {
+		msg += fmt.Sprintf(" at %s/s", formatBytes(int64(p.BytesPerSec)))
+		if p.EstimatedRemaining > 0 {
+			msg += ", ETA " + FormatETA(p.EstimatedRemaining)
+		}
+	}
+	return msg
 }
 
 // formatBytes formats byte count into human-readable format
@@ -191,6 +664,87 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %s", float64(bytes)/float64(div), units[exp])
 }
 
+// CompletionSummary describes a finished write, e.g.
+// "Completed in 8m42s, average 19.3 MB/s (4.8 GB copied)"
+func CompletionSummary(elapsed time.Duration, copied int64) string {
+	elapsed = elapsed.Round(time.Second)
+	if elapsed < time.Second {
+		return fmt.Sprintf("Completed in %s (%s copied)", elapsed, formatBytes(copied))
+	}
+	speed := int64(float64(copied) / elapsed.Seconds())
+	return fmt.Sprintf("Completed in %s, average %s/s (%s copied)", elapsed, formatBytes(speed), formatBytes(copied))
+}
+
+// StampFileName is the provenance file WriteInfoStamp writes to the root of the target
+const StampFileName = "woeusb-go.json"
+
+// StampInfo describes where a stick came from, for asset tracking
+type StampInfo struct {
+	Source       string    `json:"source"`
+	SourceSHA256 string    `json:"source_sha256,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Version      string    `json:"tool_version"`
+	Filesystem   string    `json:"filesystem"`
+	Editions     []string  `json:"editions,omitempty"` // Image names in the install WIM/ESD
+}
+
+// NewStampInfo fills in the StampInfo fields that can be read from the source: the
+// image name, its SHA-256 (for image files) and the Windows editions it contains.
+// Details that cannot be determined are left empty.
+func NewStampInfo(source, srcMount, version, filesystem string) StampInfo {
+	info := StampInfo{
+		Source:     filepath.Base(source),
+		CreatedAt:  time.Now().UTC(),
+		Version:    version,
+		Filesystem: filesystem,
+	}
+
+	if st, err := os.Stat(source); err == nil && st.Mode().IsRegular() {
+		if fp, err := fingerprint.Compute(source); err == nil {
+			info.SourceSHA256 = fp.SHA256
+		}
+	}
+
+	if wimPath, ok := FindInstallImage(srcMount); ok {
+		if images, err := ListWIMImages(wimPath); err == nil {
+			for _, img := range images {
+				info.Editions = append(info.Editions, img.Name)
+			}
+		}
+	}
+
+	return info
+}
+
+// WriteInfoStamp writes info as StampFileName to the root of dstMount
+func WriteInfoStamp(dstMount string, info StampInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode stamp: %v", err)
+	}
+
+	path := filepath.Join(dstMount, StampFileName)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// ClearDirectory removes everything inside dir but keeps dir itself, so a mounted
+// filesystem can be refilled without reformatting it
+func ClearDirectory(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CopyDirectory is a convenience function that copies a directory with default progress printing
 func CopyDirectory(srcDir, dstDir string) error {
 	return CopyWithProgress(srcDir, dstDir, PrintProgress)
@@ -203,12 +757,12 @@ func CopyDirectoryQuiet(srcDir, dstDir string) error {
 
 // ValidateCopy verifies that the copy operation was successful
 func ValidateCopy(srcMount, dstMount string) error {
-	srcStats, err := calculateTotalSize(srcMount)
+	srcStats, err := calculateTotalSize(context.Background(), srcMount)
 	if err != nil {
 		return fmt.Errorf("failed to calculate source size: %v", err)
 	}
 
-	dstStats, err := calculateTotalSize(dstMount)
+	dstStats, err := calculateTotalSize(context.Background(), dstMount)
 	if err != nil {
 		return fmt.Errorf("failed to calculate destination size: %v", err)
 	}
@@ -226,57 +780,985 @@ func ValidateCopy(srcMount, dstMount string) error {
 	return nil
 }
 
-// FAT32 max file size (4GB - 1 byte)
-const FAT32MaxFileSize = 4*1024*1024*1024 - 1
+// criticalFiles are the files a stick cannot boot or start setup without. They are
+// looked up case-insensitively since ISOs differ in how they capitalize them.
+var criticalFiles = []string{
+	"bootmgr",
+	"bootmgr.efi",
+	filepath.Join("efi", "boot", "bootx64.efi"),
+	filepath.Join("efi", "boot", "bootia32.efi"),
+	filepath.Join("sources", "boot.wim"),
+}
 
-// SplitWIMMaxSize is the max size for split WIM parts (3.8GB to be safe)
-const SplitWIMMaxSize = 3800
+// VerifyCriticalFiles hashes the boot-critical files on srcMount and dstMount and checks
+// that any split install*.swm set on dstMount is complete. It is cheap enough to run
+// after every write; VerifyAllFiles checks everything.
+func VerifyCriticalFiles(srcMount, dstMount string) error {
+	for _, rel := range criticalFiles {
+		srcRel, ok := findFold(srcMount, rel)
+		if !ok {
+			continue
+		}
+		if err := verifySameHash(srcMount, dstMount, srcRel); err != nil {
+			return err
+		}
+	}
 
-// LargeFile represents a file that exceeds FAT32 limits
-type LargeFile struct {
-	RelPath string
-	Size    int64
+	sourcesDir, ok := findFold(dstMount, "sources")
+	if !ok {
+		return nil
+	}
+	return verifySWMSet(filepath.Join(dstMount, sourcesDir))
 }
 
-// FindLargeFiles finds all files > 4GB in the source directory
-func FindLargeFiles(srcMount string) ([]LargeFile, error) {
-	var largeFiles []LargeFile
+// VerifyAllFiles hashes every file on srcMount against its copy on dstMount. WIM files
+// that were split into SWM parts are checked for a complete part set instead.
+func VerifyAllFiles(srcMount, dstMount string) error {
+	return VerifyAllFilesExcluding(srcMount, dstMount, nil)
+}
 
-	err := filepath.Walk(srcMount, func(path string, info os.FileInfo, err error) error {
+// VerifyAllFilesExcluding is VerifyAllFiles for a copy made with CopyOptions.Exclude:
+// source files matching exclude are not expected on the target
+func VerifyAllFilesExcluding(srcMount, dstMount string, exclude []string) error {
+	var mismatched []string
+
+	err := filepath.Walk(srcMount, func(srcPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
-		if info.Mode().IsRegular() && info.Size() > FAT32MaxFileSize {
-			relPath, _ := filepath.Rel(srcMount, path)
-			largeFiles = append(largeFiles, LargeFile{
-				RelPath: relPath,
-				Size:    info.Size(),
-			})
+
+		relPath, err := filepath.Rel(srcMount, srcPath)
+		if err != nil {
+			return err
+		}
+		if isExcluded(relPath, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if _, err := os.Stat(filepath.Join(dstMount, relPath)); os.IsNotExist(err) && IsSplittableImage(relPath) {
+			if err := verifySWMSet(filepath.Join(dstMount, filepath.Dir(relPath))); err != nil {
+				mismatched = append(mismatched, fmt.Sprintf("%s: %v", relPath, err))
+			}
+			return nil
+		}
+
+		if err := verifySameHash(srcMount, dstMount, relPath); err != nil {
+			mismatched = append(mismatched, err.Error())
 		}
 		return nil
 	})
+	if err != nil {
+		return fmt.Errorf("failed to walk %s: %v", srcMount, err)
+	}
 
-	return largeFiles, err
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%d file(s) failed verification: %s", len(mismatched), strings.Join(mismatched, "; "))
+	}
+	return nil
 }
 
-// IsWIMFile checks if a file is a WIM file
-func IsWIMFile(path string) bool {
-	lower := strings.ToLower(path)
-	return strings.HasSuffix(lower, ".wim")
+// ChecksumMismatchError is returned by ValidateCopyChecksum for the first file whose
+// copy does not match the source
+type ChecksumMismatchError struct {
+	RelPath string
+	Reason  string
 }
 
-// SplitWIM splits a WIM file into smaller SWM files using wimlib-imagex
-func SplitWIM(wimPath, outputDir string, maxSizeMB int) error {
-	// Output will be install.swm, install2.swm, etc.
-	baseName := strings.TrimSuffix(filepath.Base(wimPath), filepath.Ext(wimPath))
-	outputPattern := filepath.Join(outputDir, baseName+".swm")
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s: %s", e.RelPath, e.Reason)
+}
 
-	cmd := exec.Command("wimlib-imagex", "split", wimPath, outputPattern, fmt.Sprintf("%d", maxSizeMB))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// ValidateCopyChecksum hashes every regular file on srcMount and its copy on dstMount
+// with SHA-256 and returns a *ChecksumMismatchError for the first file that is missing
+// or differs. Unlike ValidateCopy it catches corruption that keeps sizes intact.
+//
+// A WIM that was split has no single file to hash on the target: wimlib re-packs the
+// resources into the parts, so only the install*.swm set is checked for gaps and
+// empty parts. Files only present on the target, such as the SWM parts or the
+// stamp, are not reported. progressFn, if set, receives the bytes read so far out of
+// both trees.
+func ValidateCopyChecksum(srcMount, dstMount string, progressFn ProgressFunc) error {
+	return ValidateCopyChecksumExcluding(srcMount, dstMount, nil, progressFn)
+}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to split WIM file: %v", err)
+// ValidateCopyChecksumExcluding is ValidateCopyChecksum for a copy made with
+// CopyOptions.Exclude: source files matching exclude are not expected on the target
+func ValidateCopyChecksumExcluding(srcMount, dstMount string, exclude []string, progressFn ProgressFunc) error {
+	stats, err := calculateTotalSizeExcluding(context.Background(), srcMount, exclude)
+	if err != nil {
+		return fmt.Errorf("failed to calculate source size: %v", err)
+	}
+	// Each byte is read twice, once from each tree
+	total := 2 * stats.TotalBytes
+	progress := ThrottleProgress(progressFn)
+	var done int64
+
+	return filepath.Walk(srcMount, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk %s: %v", srcMount, err)
+		}
+
+		relPath, err := filepath.Rel(srcMount, srcPath)
+		if err != nil {
+			return err
+		}
+		if isExcluded(relPath, exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		report := func(n int64) {
+			done += n
+			if progress != nil {
+				progress(done, total, relPath)
+			}
+		}
+
+		dstPath := filepath.Join(dstMount, relPath)
+		if _, err := os.Stat(dstPath); os.IsNotExist(err) && IsSplittableImage(relPath) {
+			dir := filepath.Dir(dstPath)
+			if matches, _ := filepath.Glob(filepath.Join(dir, "install*.swm")); len(matches) == 0 {
+				return &ChecksumMismatchError{RelPath: relPath, Reason: "missing on the target and not split into SWM parts"}
+			}
+			if err := verifySWMSet(dir); err != nil {
+				return &ChecksumMismatchError{RelPath: relPath, Reason: err.Error()}
+			}
+			report(2 * info.Size())
+			return nil
+		}
+
+		srcSum, err := hashFile(srcPath, report)
+		if err != nil {
+			return fmt.Errorf("failed to hash source %s: %v", relPath, err)
+		}
+		dstSum, err := hashFile(dstPath, report)
+		if err != nil {
+			return &ChecksumMismatchError{RelPath: relPath, Reason: fmt.Sprintf("missing or unreadable on the target: %v", err)}
+		}
+		if srcSum != dstSum {
+			return &ChecksumMismatchError{RelPath: relPath,
+				Reason: fmt.Sprintf("differs from the source (SHA-256 %s, expected %s)", dstSum, srcSum)}
+		}
+		return nil
+	})
+}
+
+// hashFile returns the hex SHA-256 of the file at path, calling onRead with the size
+// of every chunk read
+func hashFile(path string, onRead func(n int64)) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			onRead(int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// IncompleteMediaError lists the essential Windows setup files that are missing or
+// empty on a target
+type IncompleteMediaError struct {
+	Missing []string
+}
+
+func (e *IncompleteMediaError) Error() string {
+	return fmt.Sprintf("Windows media is incomplete, missing or empty: %s", strings.Join(e.Missing, ", "))
+}
+
+// mediaEssentials are the files a Windows stick needs to boot and start setup. Each
+// entry is satisfied by any one of its alternatives.
+var mediaEssentials = [][]string{
+	{"bootmgr"},
+	{"bootmgr.efi"},
+	{filepath.Join("efi", "boot", "bootx64.efi"), filepath.Join("efi", "boot", "bootia32.efi")},
+	{filepath.Join("sources", "boot.wim")},
+	{filepath.Join("sources", "install.wim"), filepath.Join("sources", "install.swm"), filepath.Join("sources", "install.esd")},
+}
+
+// CheckWindowsMediaComplete checks that the essential Windows setup files exist and are
+// non-empty on dstMount, returning an *IncompleteMediaError listing the missing ones
+func CheckWindowsMediaComplete(dstMount string) error {
+	var missing []string
+	for _, alternatives := range mediaEssentials {
+		if !anyNonEmpty(dstMount, alternatives) {
+			missing = append(missing, strings.Join(alternatives, " or "))
+		}
+	}
+
+	if sourcesDir, ok := findFold(dstMount, "sources"); ok {
+		if err := verifySWMSet(filepath.Join(dstMount, sourcesDir)); err != nil {
+			missing = append(missing, err.Error())
+		}
+	}
+
+	if len(missing) > 0 {
+		return &IncompleteMediaError{Missing: missing}
+	}
+	return nil
+}
+
+// anyNonEmpty reports whether one of rels exists under root as a non-empty file
+func anyNonEmpty(root string, rels []string) bool {
+	for _, rel := range rels {
+		found, ok := findFold(root, rel)
+		if !ok {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(root, found)); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySameHash compares the SHA-256 of relPath under srcMount and dstMount
+func verifySameHash(srcMount, dstMount, relPath string) error {
+	src, err := fingerprint.ComputeWithCache(filepath.Join(srcMount, relPath), "")
+	if err != nil {
+		return fmt.Errorf("failed to hash source %s: %v", relPath, err)
+	}
+	dst, err := fingerprint.ComputeWithCache(filepath.Join(dstMount, relPath), "")
+	if err != nil {
+		return fmt.Errorf("%s is missing or unreadable on the target: %v", relPath, err)
+	}
+	if src.SHA256 != dst.SHA256 {
+		return fmt.Errorf("%s differs from the source (SHA-256 %s, expected %s)", relPath, dst.SHA256, src.SHA256)
+	}
+	return nil
+}
+
+// verifySWMSet checks that install*.swm parts in dir, if any, are numbered without gaps
+// and non-empty
+func verifySWMSet(dir string) error {
+	matches, _ := filepath.Glob(filepath.Join(dir, "install*.swm"))
+	if len(matches) == 0 {
+		return nil
+	}
+
+	for n := 1; n <= len(matches); n++ {
+		name := swmPartName("install", n)
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("split part %s is missing", name)
+		}
+		if info.Size() == 0 {
+			return fmt.Errorf("split part %s is empty", name)
+		}
+	}
+	return nil
+}
+
+// findFold looks up rel under root matching each path component case-insensitively
+// and returns the path with its on-disk capitalization
+func findFold(root, rel string) (string, bool) {
+	current := root
+	var found []string
+
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		entries, err := os.ReadDir(current)
+		if err != nil {
+			return "", false
+		}
+
+		match := ""
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), part) {
+				match = entry.Name()
+				break
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+
+		found = append(found, match)
+		current = filepath.Join(current, match)
+	}
+
+	return filepath.Join(found...), true
+}
+
+// FAT32 max file size (4GB - 1 byte)
+const FAT32MaxFileSize = 4*1024*1024*1024 - 1
+
+// SplitWIMMaxSize is the max size for split WIM parts (3.8GB to be safe)
+const SplitWIMMaxSize = 3800
+
+// FAT32MaxSplitSizeMB is the first split size (in MB) that no longer fits on FAT32
+const FAT32MaxSplitSizeMB = 4096
+
+// CopyOptions configures CopyWindowsISOWithOptions
+type CopyOptions struct {
+	SplitSizeMB int             // Maximum size of each SWM part in MB (0 means SplitWIMMaxSize)
+	NoSplit     bool            // Copy large WIM files whole instead of splitting them (see ValidateNoSplit)
+	Context     context.Context // Cancels source scans and the copy; nil means they cannot be cancelled
+
+	SplitStrategy string // SplitDirect (the default when empty) or SplitTempStaging
+	StagingDir    string // Directory SWM parts are staged in with SplitTempStaging ("" means os.TempDir)
+
+	IgnoreReadErrors bool                // Zero-fill source regions that stay unreadable after retries
+	OnReadError      func(ReadErrorFile) // Called for every file that hit source read errors
+
+	Exclude []string // Source paths left off the target, see isExcluded and SlimPresets
+
+	ResumeMode bool // Skip files already copied to the target by an earlier, interrupted run
+
+	MaxBytesPerSec int64 // Limit file writes to this rate (0 means unlimited); WIM splits are not limited
+
+	PreserveMetadata bool // Copy modification times and permission bits (on by default, see preserveMetadata)
+
+	// Filesystem is the target filesystem. On EXFAT, which has no 4GB file limit, large
+	// files are copied whole; anything else is treated as FAT32.
+	Filesystem string
+}
+
+// Split strategies for CopyOptions.SplitStrategy
+const (
+	// SplitDirect splits large WIMs straight onto the target
+	SplitDirect = "direct"
+	// SplitTempStaging splits large WIMs on local disk first and then copies the
+	// parts, which is faster when the target is much slower than the local disk
+	SplitTempStaging = "temp-staging"
+)
+
+// ValidateSplitStrategy checks that strategy is a known split strategy
+func ValidateSplitStrategy(strategy string) error {
+	switch strategy {
+	case "", SplitDirect, SplitTempStaging:
+		return nil
+	}
+	return fmt.Errorf("unknown split strategy %q (use %s or %s)", strategy, SplitDirect, SplitTempStaging)
+}
+
+// context returns opts.Context, or context.Background() if it is unset
+func (o CopyOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// scanError wraps a failed source scan, passing a cancellation through unchanged
+// so callers can recognise it with errors.Is
+func scanError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return fmt.Errorf("failed to scan source: %v", err)
+}
+
+// DefaultCopyOptions returns the options used by CopyWindowsISOWithWIMSplit
+func DefaultCopyOptions() CopyOptions {
+	return CopyOptions{SplitSizeMB: SplitWIMMaxSize, PreserveMetadata: true}
+}
+
+// ValidateSplitSize checks that a WIM split size is usable on the target filesystem
+func ValidateSplitSize(sizeMB int, fstype string) error {
+	if sizeMB <= 0 {
+		return fmt.Errorf("split size must be positive, got %d MB", sizeMB)
+	}
+
+	switch strings.ToUpper(fstype) {
+	case "FAT", "FAT32":
+		if sizeMB >= FAT32MaxSplitSizeMB {
+			return fmt.Errorf("split size %d MB does not fit the FAT32 4GB file limit (must be below %d MB)",
+				sizeMB, FAT32MaxSplitSizeMB)
+		}
+	}
+
+	return nil
+}
+
+// SWMPartOverhead is the estimated number of extra bytes each SWM part adds over the
+// original WIM (header, lookup table and XML data are repeated per part)
+const SWMPartOverhead = 8 * 1024 * 1024
+
+// EstimateCopyFootprint estimates how many bytes copying srcMount to the given
+// filesystem will need, including the overhead of splitting large WIM files on FAT
+func EstimateCopyFootprint(srcMount, filesystem string) (int64, error) {
+	return EstimateCopyFootprintWithOptions(srcMount, filesystem, DefaultCopyOptions())
+}
+
+// EstimateCopyFootprintWithOptions is EstimateCopyFootprint using the split size from opts
+func EstimateCopyFootprintWithOptions(srcMount, filesystem string, opts CopyOptions) (int64, error) {
+	ctx := opts.context()
+	stats, largeFiles, err := ScanSourceContext(ctx, srcMount)
+	if err != nil {
+		return 0, scanError(ctx, err)
+	}
+
+	footprint := stats.TotalBytes
+	if len(opts.Exclude) > 0 {
+		kept, err := calculateTotalSizeExcluding(ctx, srcMount, opts.Exclude)
+		if err != nil {
+			return 0, scanError(ctx, err)
+		}
+		footprint = kept.TotalBytes
+		largeFiles = keptLargeFiles(largeFiles, opts.Exclude)
+	}
+
+	switch strings.ToUpper(filesystem) {
+	case "FAT", "FAT32":
+		if !opts.NoSplit {
+			footprint += splitOverhead(largeFiles, opts.SplitSizeMB)
+		}
+	}
+
+	return footprint, nil
+}
+
+// splitOverhead returns the extra bytes produced by splitting the given WIM files
+func splitOverhead(largeFiles []LargeFile, splitSizeMB int) int64 {
+	if splitSizeMB <= 0 {
+		splitSizeMB = SplitWIMMaxSize
+	}
+	partSize := int64(splitSizeMB) * 1024 * 1024
+
+	var overhead int64
+	for _, lf := range largeFiles {
+		if !IsSplittableImage(lf.RelPath) {
+			continue
+		}
+		parts := (lf.Size + partSize - 1) / partSize
+		overhead += parts * SWMPartOverhead
+	}
+	return overhead
+}
+
+// stagingFootprint returns the staging space needed to split largeFiles one at a time:
+// the largest WIM plus its per-part overhead, as each set is removed once copied
+func stagingFootprint(largeFiles []LargeFile, splitSizeMB int) int64 {
+	var need int64
+	for _, lf := range largeFiles {
+		if !IsSplittableImage(lf.RelPath) {
+			continue
+		}
+		need = max(need, lf.Size+splitOverhead([]LargeFile{lf}, splitSizeMB))
+	}
+	return need
+}
+
+// FreeSpace returns the number of bytes available to unprivileged users in dir
+func FreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem of %s: %v", dir, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// tmpfsMagic is the statfs f_type of tmpfs (TMPFS_MAGIC)
+const tmpfsMagic = 0x01021994
+
+// IsTmpfs reports whether dir is on a tmpfs, whose pages live in RAM and swap
+func IsTmpfs(dir string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, fmt.Errorf("failed to stat filesystem of %s: %v", dir, err)
+	}
+	return int64(st.Type) == tmpfsMagic, nil
+}
+
+// ErrStagingSpace is wrapped by CheckStagingSpace when the staging directory is too small
+var ErrStagingSpace = errors.New("not enough staging space")
+
+// CheckStagingSpace returns an error if the staging directory in opts cannot hold the
+// split parts of the largest WIM in srcMount. It is a no-op for SplitDirect.
+func CheckStagingSpace(srcMount string, opts CopyOptions) error {
+	if opts.SplitStrategy != SplitTempStaging || opts.NoSplit {
+		return nil
+	}
+
+	ctx := opts.context()
+	_, largeFiles, err := ScanSourceContext(ctx, srcMount)
+	if err != nil {
+		return scanError(ctx, err)
+	}
+
+	need := stagingFootprint(largeFiles, opts.SplitSizeMB)
+	if need == 0 {
+		return nil
+	}
+
+	dir := opts.StagingDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	free, err := FreeSpace(dir)
+	if err != nil {
+		return err
+	}
+	if need > free {
+		return fmt.Errorf("%w in %s to split WIM parts: need about %s, but only %s available",
+			ErrStagingSpace, dir, formatBytes(need), formatBytes(free))
+	}
+	return nil
+}
+
+// MinCapacityHeadroom is the least space CapacityHeadroom reserves on a target
+const MinCapacityHeadroom = 64 * 1024 * 1024
+
+// CapacityHeadroom returns the part of a capacity-byte target that is not available for
+// files: partition alignment, boot partitions, filesystem metadata and cluster slack.
+// It is 2% of the target, but at least MinCapacityHeadroom.
+func CapacityHeadroom(capacity int64) int64 {
+	return max(capacity/50, MinCapacityHeadroom)
+}
+
+// CheckTargetCapacity returns an error if the estimated footprint of srcMount plus
+// CapacityHeadroom does not fit in capacity bytes
+func CheckTargetCapacity(srcMount, filesystem string, opts CopyOptions, capacity int64) error {
+	footprint, err := EstimateCopyFootprintWithOptions(srcMount, filesystem, opts)
+	if err != nil {
+		return err
+	}
+
+	if footprint+CapacityHeadroom(capacity) > capacity {
+		return fmt.Errorf("target is too small: need about %s plus %s headroom, but only %s available",
+			formatBytes(footprint), formatBytes(CapacityHeadroom(capacity)), formatBytes(capacity))
+	}
+
+	return nil
+}
+
+// CheckFATFit returns an *OversizedFileError for the first file in srcMount that cannot
+// be stored on FAT32, either because it is not a WIM or because opts disables splitting
+func CheckFATFit(srcMount string, opts CopyOptions) error {
+	ctx := opts.context()
+	_, largeFiles, err := ScanSourceContext(ctx, srcMount)
+	if err != nil {
+		return scanError(ctx, err)
+	}
+
+	for _, lf := range largeFiles {
+		if opts.NoSplit || !IsSplittableImage(lf.RelPath) {
+			return &OversizedFileError{RelPath: lf.RelPath, Size: lf.Size}
+		}
+	}
+
+	return nil
+}
+
+// ValidateNoSplit checks that srcMount can be copied to filesystem without splitting
+// any WIM, i.e. that no file exceeds the FAT32 limit when the target is FAT
+func ValidateNoSplit(srcMount, filesystem string) error {
+	switch strings.ToUpper(filesystem) {
+	case "FAT", "FAT32":
+	default:
+		return nil
+	}
+
+	_, largeFiles, err := ScanSource(srcMount)
+	if err != nil {
+		return fmt.Errorf("failed to scan source: %v", err)
+	}
+	if len(largeFiles) == 0 {
+		return nil
+	}
+
+	lf := largeFiles[0]
+	msg := fmt.Sprintf("'%s' (%.1f GB) exceeds the FAT32 4GB limit and splitting is disabled",
+		lf.RelPath, float64(lf.Size)/(1024*1024*1024))
+	if len(largeFiles) > 1 {
+		msg += fmt.Sprintf(" (and %d other files)", len(largeFiles)-1)
+	}
+	return fmt.Errorf("%s: use NTFS as the target filesystem", msg)
+}
+
+// OversizedFileError is returned when a file that cannot be split exceeds the FAT32 limit
+type OversizedFileError struct {
+	RelPath string
+	Size    int64
+}
+
+func (e *OversizedFileError) Error() string {
+	return fmt.Sprintf("file '%s' (%.1f GB) exceeds FAT32 4GB limit and is not a WIM file - cannot proceed with FAT32",
+		e.RelPath, float64(e.Size)/(1024*1024*1024))
+}
+
+// LargeFile represents a file that exceeds FAT32 limits
+type LargeFile struct {
+	RelPath string
+	Size    int64
+}
+
+// FindLargeFiles finds all files > 4GB in the source directory
+func FindLargeFiles(srcMount string) ([]LargeFile, error) {
+	return FindLargeFilesContext(context.Background(), srcMount)
+}
+
+// FindLargeFilesContext is FindLargeFiles, stopping with ctx.Err() once ctx is cancelled
+func FindLargeFilesContext(ctx context.Context, srcMount string) ([]LargeFile, error) {
+	var largeFiles []LargeFile
+
+	err := filepath.Walk(srcMount, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() && info.Size() > FAT32MaxFileSize {
+			relPath, _ := filepath.Rel(srcMount, path)
+			largeFiles = append(largeFiles, LargeFile{
+				RelPath: relPath,
+				Size:    info.Size(),
+			})
+		}
+		return nil
+	})
+
+	return largeFiles, err
+}
+
+// sourceScanCache remembers the last source scan so repeated size queries
+// (capacity checks, previews, the GUI) don't walk the whole tree again
+type sourceScanCache struct {
+	mu         sync.Mutex
+	srcMount   string
+	modTime    time.Time
+	stats      CopyStats
+	largeFiles []LargeFile
+	valid      bool
+}
+
+var sourceCache sourceScanCache
+
+// ScanSource returns the total size statistics and the files over 4GB in srcMount.
+// The result is cached keyed by srcMount and its modification time; scanning a
+// different path replaces the cached entry. Safe for concurrent use.
+func ScanSource(srcMount string) (*CopyStats, []LargeFile, error) {
+	return ScanSourceContext(context.Background(), srcMount)
+}
+
+// ScanSourceContext is ScanSource, aborting the walk with ctx.Err() once ctx is
+// cancelled. An aborted scan is not cached.
+func ScanSourceContext(ctx context.Context, srcMount string) (*CopyStats, []LargeFile, error) {
+	info, err := os.Stat(srcMount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat source %s: %v", srcMount, err)
+	}
+
+	sourceCache.mu.Lock()
+	defer sourceCache.mu.Unlock()
+
+	c := &sourceCache
+	if !c.valid || c.srcMount != srcMount || !c.modTime.Equal(info.ModTime()) {
+		stats, err := calculateTotalSize(ctx, srcMount)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, nil, ctxErr
+			}
+			return nil, nil, fmt.Errorf("failed to calculate total size: %v", err)
+		}
+		largeFiles, err := FindLargeFilesContext(ctx, srcMount)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, nil, ctxErr
+			}
+			return nil, nil, fmt.Errorf("failed to scan for large files: %v", err)
+		}
+
+		c.srcMount = srcMount
+		c.modTime = info.ModTime()
+		c.stats = *stats
+		c.largeFiles = largeFiles
+		c.valid = true
+	}
+
+	stats := c.stats
+	largeFiles := append([]LargeFile(nil), c.largeFiles...)
+	return &stats, largeFiles, nil
+}
+
+// InvalidateSourceCache drops the cached result of ScanSource
+func InvalidateSourceCache() {
+	sourceCache.mu.Lock()
+	defer sourceCache.mu.Unlock()
+
+	sourceCache.valid = false
+	sourceCache.largeFiles = nil
+}
+
+// IsWIMFile checks if a file is a WIM file
+func IsWIMFile(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".wim")
+}
+
+// IsSplittableImage reports whether a file can be split into SWM parts: a WIM, or an
+// ESD, which is a WIM with solid compression. wimlib-imagex splits both the same way.
+func IsSplittableImage(path string) bool {
+	return IsWIMFile(path) || strings.HasSuffix(strings.ToLower(path), ".esd")
+}
+
+// WIMTool performs operations on WIM files with one of the WIM tools on the host
+type WIMTool interface {
+	// Name returns the command the tool runs, for messages
+	Name() string
+	// Split splits wimPath into SWM parts of at most maxSizeMB, named after swmPath,
+	// stopping the tool once ctx is cancelled
+	Split(ctx context.Context, wimPath, swmPath string, maxSizeMB int) error
+	// Info lists the images stored in wimPath
+	Info(wimPath string) ([]WIMImage, error)
+	// Export copies image index of wimPath into a new WIM at destPath
+	Export(wimPath string, index int, destPath string) error
+	// Verify checks the integrity of wimPath
+	Verify(wimPath string) error
+}
+
+// wimlibTool is a WIMTool backed by wimlib, either through wimlib-imagex or through the
+// per-command frontends (wimsplit, wiminfo, ...) some packages install instead
+type wimlibTool struct {
+	perCommand bool
+}
+
+// command returns the command running the wimlib subcommand sub with args
+func (t wimlibTool) command(sub string, args ...string) *exec.Cmd {
+	return t.commandContext(context.Background(), sub, args...)
+}
+
+// commandContext is command, killing the process once ctx is cancelled
+func (t wimlibTool) commandContext(ctx context.Context, sub string, args ...string) *exec.Cmd {
+	if t.perCommand {
+		return exec.CommandContext(ctx, "wim"+sub, args...)
+	}
+	return exec.CommandContext(ctx, "wimlib-imagex", append([]string{sub}, args...)...)
+}
+
+func (t wimlibTool) Name() string {
+	if t.perCommand {
+		return "wimlib (wimsplit, wiminfo, ...)"
+	}
+	return "wimlib-imagex"
+}
+
+func (t wimlibTool) Split(ctx context.Context, wimPath, swmPath string, maxSizeMB int) error {
+	cmd := t.commandContext(ctx, "split", wimPath, swmPath, strconv.Itoa(maxSizeMB))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (t wimlibTool) Info(wimPath string) ([]WIMImage, error) {
+	out, err := t.command("info", wimPath).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseWIMInfo(string(out)), nil
+}
+
+func (t wimlibTool) Export(wimPath string, index int, destPath string) error {
+	return t.command("export", wimPath, strconv.Itoa(index), destPath).Run()
+}
+
+func (t wimlibTool) Verify(wimPath string) error {
+	return t.command("verify", wimPath).Run()
+}
+
+// wimLookPath finds WIM tool commands; tests replace it
+var wimLookPath = exec.LookPath
+
+// DetectWIMTool returns the WIM tool available on this host: wimlib-imagex, or else
+// wimlib's per-command frontends. It defaults to wimlib-imagex when neither is found,
+// so errors name the tool the dependency check asks for.
+func DetectWIMTool() WIMTool {
+	if _, err := wimLookPath("wimlib-imagex"); err == nil {
+		return wimlibTool{}
+	}
+	if _, err := wimLookPath("wimsplit"); err == nil {
+		if _, err := wimLookPath("wiminfo"); err == nil {
+			return wimlibTool{perCommand: true}
+		}
+	}
+	return wimlibTool{}
+}
+
+// DefaultWIMTool is the WIMTool used by SplitWIM, ListWIMImages, ExportWIMImage and
+// VerifyWIM. Tests replace it with a fake.
+var DefaultWIMTool = DetectWIMTool()
+
+// SplitWIM splits a WIM file into smaller SWM files using DefaultWIMTool
+func SplitWIM(wimPath, outputDir string, maxSizeMB int) error {
+	return SplitWIMContext(context.Background(), wimPath, outputDir, maxSizeMB)
+}
+
+// SplitWIMContext is SplitWIM, killing the WIM tool and returning ctx.Err() once ctx
+// is cancelled. Parts already written are left for the caller to remove.
+func SplitWIMContext(ctx context.Context, wimPath, outputDir string, maxSizeMB int) error {
+	// Output will be install.swm, install2.swm, etc.
+	baseName := swmBaseName(wimPath)
+	outputPattern := filepath.Join(outputDir, baseName+".swm")
+
+	if err := DefaultWIMTool.Split(ctx, wimPath, outputPattern, maxSizeMB); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to split WIM file: %v", err)
+	}
+
+	return nil
+}
+
+// ExportWIMImage writes image index of wimPath to a new WIM at destPath
+func ExportWIMImage(wimPath string, index int, destPath string) error {
+	if err := DefaultWIMTool.Export(wimPath, index, destPath); err != nil {
+		return fmt.Errorf("failed to export image %d of %s: %v", index, wimPath, err)
+	}
+	return nil
+}
+
+// VerifyWIM checks the integrity of wimPath
+func VerifyWIM(wimPath string) error {
+	if err := DefaultWIMTool.Verify(wimPath); err != nil {
+		return fmt.Errorf("WIM integrity check of %s failed: %v", wimPath, err)
+	}
+	return nil
+}
+
+// WIMImage describes one image (Windows edition) stored in a WIM or ESD file
+type WIMImage struct {
+	Index int
+	Name  string
+	Size  int64 // Total uncompressed bytes of the image
+}
+
+// ListWIMImages lists the images in wimPath using DefaultWIMTool
+func ListWIMImages(wimPath string) ([]WIMImage, error) {
+	images, err := DefaultWIMTool.Info(wimPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WIM info of %s: %v", wimPath, err)
+	}
+	return images, nil
+}
+
+// parseWIMInfo extracts the images from wimlib-imagex info output, where each image
+// is a block of "Key: value" lines starting with "Index:"
+func parseWIMInfo(info string) []WIMImage {
+	var images []WIMImage
+	for _, line := range strings.Split(info, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "Index":
+			index, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			images = append(images, WIMImage{Index: index})
+		case "Name":
+			if len(images) > 0 {
+				images[len(images)-1].Name = value
+			}
+		case "Total Bytes":
+			if len(images) > 0 {
+				images[len(images)-1].Size, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+	}
+	return images
+}
+
+// FormatWIMImages formats images as a one-line summary, e.g. "1=Windows 11 Home, 2=Windows 11 Pro"
+func FormatWIMImages(images []WIMImage) string {
+	parts := make([]string, len(images))
+	for i, img := range images {
+		parts[i] = fmt.Sprintf("%d=%s", img.Index, img.Name)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FindInstallImage returns the path of sources/install.wim or sources/install.esd in
+// srcMount, matching names case-insensitively
+func FindInstallImage(srcMount string) (string, bool) {
+	for _, name := range []string{"install.wim", "install.esd"} {
+		if rel, ok := findFold(srcMount, filepath.Join("sources", name)); ok {
+			return filepath.Join(srcMount, rel), true
+		}
+	}
+	return "", false
+}
+
+// swmBaseName returns the base name used for the SWM parts of a WIM file
+func swmBaseName(wimPath string) string {
+	return strings.TrimSuffix(filepath.Base(wimPath), filepath.Ext(wimPath))
+}
+
+// swmPartName returns the file name of SWM part n (1-based) as produced by wimlib-imagex:
+// base.swm, base2.swm, base3.swm, ...
+func swmPartName(baseName string, n int) string {
+	if n == 1 {
+		return baseName + ".swm"
+	}
+	return fmt.Sprintf("%s%d.swm", baseName, n)
+}
+
+// VerifySWMParts checks that the split parts of baseName exist in dir and renames
+// them to install*.swm, which is the only split set Windows setup looks for.
+// Any install.wim or install.esd left in dir is removed so setup uses the SWM set.
+func VerifySWMParts(dir, baseName string) error {
+	var parts []string
+	for n := 1; ; n++ {
+		name := swmPartName(baseName, n)
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			break
+		}
+		parts = append(parts, name)
+	}
+
+	if len(parts) == 0 {
+		return fmt.Errorf("expected split part %s not found in %s", swmPartName(baseName, 1), dir)
+	}
+
+	if !strings.EqualFold(baseName, "install") {
+		for i, name := range parts {
+			target := swmPartName("install", i+1)
+			if _, err := os.Stat(filepath.Join(dir, target)); err == nil {
+				return fmt.Errorf("cannot rename %s: %s already exists", name, target)
+			}
+			if err := os.Rename(filepath.Join(dir, name), filepath.Join(dir, target)); err != nil {
+				return fmt.Errorf("failed to rename %s to %s: %v", name, target, err)
+			}
+		}
+	}
+
+	for _, name := range []string{"install.wim", "install.esd"} {
+		image := filepath.Join(dir, name)
+		if _, err := os.Stat(image); err == nil {
+			if err := os.Remove(image); err != nil {
+				return fmt.Errorf("failed to remove %s: %v", image, err)
+			}
+		}
 	}
 
 	return nil
@@ -284,40 +1766,108 @@ func SplitWIM(wimPath, outputDir string, maxSizeMB int) error {
 
 // CopyWindowsISOWithWIMSplit copies Windows ISO contents to FAT32, splitting large WIM files
 func CopyWindowsISOWithWIMSplit(srcMount, dstMount string, progressFn ProgressFunc) error {
-	// Find large files
-	largeFiles, err := FindLargeFiles(srcMount)
-	if err != nil {
-		return fmt.Errorf("failed to scan for large files: %v", err)
+	return CopyWindowsISOWithOptions(srcMount, dstMount, DefaultCopyOptions(), progressFn)
+}
+
+// CopyWindowsISOWithWIMSplitContext is CopyWindowsISOWithWIMSplit, stopping with
+// ctx.Err() once ctx is cancelled
+func CopyWindowsISOWithWIMSplitContext(ctx context.Context, srcMount, dstMount string, progressFn ProgressFunc) error {
+	opts := DefaultCopyOptions()
+	opts.Context = ctx
+	return CopyWindowsISOWithOptions(srcMount, dstMount, opts, progressFn)
+}
+
+// CopyWindowsISOWithOptions copies Windows ISO contents, splitting large WIM files
+// according to opts. If opts.Context is cancelled, the file or SWM parts being written
+// are removed and the context error is returned unwrapped.
+func CopyWindowsISOWithOptions(srcMount, dstMount string, opts CopyOptions, progressFn ProgressFunc) error {
+	splitSize := opts.SplitSizeMB
+	if splitSize == 0 {
+		splitSize = SplitWIMMaxSize
+	}
+
+	ctx := opts.context()
+	watch := watchSource(srcMount)
+
+	// Find large files; exFAT takes them as they are, so there is nothing to split
+	var largeFiles []LargeFile
+	var err error
+	if !strings.EqualFold(opts.Filesystem, "EXFAT") {
+		largeFiles, err = FindLargeFilesContext(ctx, srcMount)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("failed to scan for large files: %v", err)
+		}
 	}
 
+	largeFiles = keptLargeFiles(largeFiles, opts.Exclude)
+
 	// Check if any large files are NOT WIM files (can't handle those on FAT32)
 	for _, lf := range largeFiles {
-		if !IsWIMFile(lf.RelPath) {
-			return fmt.Errorf("file '%s' (%.1f GB) exceeds FAT32 4GB limit and is not a WIM file - cannot proceed with FAT32",
-				lf.RelPath, float64(lf.Size)/(1024*1024*1024))
+		if !IsSplittableImage(lf.RelPath) {
+			return &OversizedFileError{RelPath: lf.RelPath, Size: lf.Size}
 		}
 	}
 
+	// Without splitting, large WIMs are copied whole like any other file
+	if opts.NoSplit {
+		largeFiles = nil
+	}
+
 	// Build exclusion list for large WIM files
-	var excludeFiles []string
+	excludeFiles := append([]string(nil), opts.Exclude...)
 	for _, lf := range largeFiles {
 		excludeFiles = append(excludeFiles, lf.RelPath)
 		fmt.Printf("Will split: %s (%.1f GB)\n", lf.RelPath, float64(lf.Size)/(1024*1024*1024))
 	}
 
 	// First pass: copy all files except large WIMs
-	stats, err := calculateTotalSizeExcluding(srcMount, excludeFiles)
+	stats, err := calculateTotalSizeExcluding(ctx, srcMount, excludeFiles)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return fmt.Errorf("failed to calculate total size: %v", err)
 	}
+	stats.IgnoreReadErrors = opts.IgnoreReadErrors
+	stats.OnReadError = opts.OnReadError
+	stats.PreserveMetadata = opts.PreserveMetadata
+	stats.ResumeMode = opts.ResumeMode
+	stats.ctx = ctx
+	stats.limiter = newRateLimiter(opts.MaxBytesPerSec)
+
+	// Progress covers both passes, each weighted by its share of the bytes, so the
+	// split of a multi-GB WIM does not sit at the end of a bar that already looks done
+	totalBytes := stats.TotalBytes
+	for _, lf := range largeFiles {
+		totalBytes += lf.Size
+	}
+	progress := ThrottleProgress(progressFn)
+	var copyProgress ProgressFunc
+	if progress != nil {
+		copyProgress = func(bytesCopied, _ int64, currentFile string) {
+			progress(bytesCopied, totalBytes, currentFile)
+		}
+	}
 
 	fmt.Println("Copying files (excluding large WIM files)...")
-	if err := copyFilesExcluding(srcMount, dstMount, excludeFiles, stats, progressFn); err != nil {
-		return fmt.Errorf("failed to copy files: %v", err)
+	// Files that fail individually are reported after the WIMs are split, so the
+	// caller sees the complete list in one go
+	var copyFailed *CopyFailedError
+	if err := copyFilesExcluding(srcMount, dstMount, excludeFiles, stats, copyProgress); err != nil {
+		if errors.Is(err, ErrSourceUnavailable) || ctx.Err() != nil {
+			return err
+		}
+		if !errors.As(err, &copyFailed) {
+			return fmt.Errorf("failed to copy files: %v", err)
+		}
 	}
 	fmt.Println()
 
 	// Second pass: split and copy large WIM files
+	done := stats.TotalBytes
 	for _, lf := range largeFiles {
 		fmt.Printf("Splitting %s...\n", lf.RelPath)
 
@@ -329,32 +1879,194 @@ func CopyWindowsISOWithWIMSplit(srcMount, dstMount string, progressFn ProgressFu
 			return fmt.Errorf("failed to create directory %s: %v", dstDir, err)
 		}
 
-		// Split WIM directly to destination
-		if err := SplitWIM(srcWIM, dstDir, SplitWIMMaxSize); err != nil {
+		meter := newSplitMeter(swmBaseName(srcWIM), lf.Size, dstDir)
+		stopMeter := func() {}
+		if progress != nil {
+			offset, relPath := done, lf.RelPath
+			stopMeter = meter.run(ProgressInterval, func(written int64) {
+				progress(offset+written, totalBytes, relPath)
+			})
+		}
+
+		if opts.SplitStrategy == SplitTempStaging {
+			err = splitWIMStaged(ctx, srcWIM, dstDir, opts.StagingDir, splitSize, meter)
+		} else {
+			// Split WIM directly to destination
+			err = SplitWIMContext(ctx, srcWIM, dstDir, splitSize)
+		}
+		stopMeter()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// Windows setup would pick up an incomplete set of parts
+			removeSWMParts(dstDir, swmBaseName(srcWIM))
+			return ctxErr
+		}
+		if err != nil {
+			if goneErr := watch.check(err); goneErr != nil {
+				return goneErr
+			}
 			return fmt.Errorf("failed to split %s: %v", lf.RelPath, err)
 		}
 
+		// Windows setup only picks up sources/install*.swm
+		if strings.EqualFold(filepath.Base(dstDir), "sources") {
+			if err := VerifySWMParts(dstDir, swmBaseName(srcWIM)); err != nil {
+				return fmt.Errorf("split parts of %s are not usable by Windows setup: %v", lf.RelPath, err)
+			}
+		}
+
+		done += lf.Size
+		if progress != nil {
+			progress(done, totalBytes, lf.RelPath)
+		}
 		fmt.Printf("✓ Split %s into SWM files\n", lf.RelPath)
 	}
 
+	if copyFailed != nil {
+		return copyFailed
+	}
 	return nil
 }
 
-// calculateTotalSizeExcluding calculates total size excluding specified files
-func calculateTotalSizeExcluding(srcMount string, excludeFiles []string) (*CopyStats, error) {
-	stats := &CopyStats{}
-	excludeMap := make(map[string]bool)
-	for _, f := range excludeFiles {
-		excludeMap[f] = true
+// splitWIMStaged splits wimPath into a temporary directory under stagingDir, copies the
+// parts to outputDir and removes the staged copies again. The staging directory is
+// added to meter, if set.
+func splitWIMStaged(ctx context.Context, wimPath, outputDir, stagingDir string, maxSizeMB int, meter *splitMeter) error {
+	stageDir, err := os.MkdirTemp(stagingDir, "split-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(stageDir) }()
+	if meter != nil {
+		meter.addDir(stageDir)
+	}
+
+	if err := SplitWIMContext(ctx, wimPath, stageDir, maxSizeMB); err != nil {
+		return err
+	}
+
+	parts, err := filepath.Glob(filepath.Join(stageDir, "*.swm"))
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		info, err := os.Stat(part)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(part)
+		fmt.Printf("Copying staged %s...\n", name)
+		if err := copyFile(part, filepath.Join(outputDir, name), info.Size(), &CopyStats{ctx: ctx}, nil); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return fmt.Errorf("failed to copy staged %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// removeSWMParts removes the SWM parts of baseName in dir, left behind by a split that
+// did not finish
+func removeSWMParts(dir, baseName string) {
+	parts, _ := filepath.Glob(filepath.Join(dir, baseName+"*.swm"))
+	for _, part := range parts {
+		_ = os.Remove(part)
+	}
+}
+
+// splitMeter estimates how much of a WIM split is done from the size of the SWM parts
+// written so far. With temp staging the parts are written twice, to the staging
+// directory and then to the target, so each directory counts for half.
+type splitMeter struct {
+	baseName string
+	wimSize  int64
+
+	mu   sync.Mutex
+	dirs []string
+}
+
+// newSplitMeter returns a splitMeter for the parts of baseName written to dirs
+func newSplitMeter(baseName string, wimSize int64, dirs ...string) *splitMeter {
+	return &splitMeter{baseName: baseName, wimSize: wimSize, dirs: dirs}
+}
+
+// addDir adds a directory the parts are written to
+func (m *splitMeter) addDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirs = append(m.dirs, dir)
+}
+
+// written returns the estimated number of bytes of the WIM split so far, at most wimSize
+func (m *splitMeter) written() int64 {
+	m.mu.Lock()
+	dirs := append([]string(nil), m.dirs...)
+	m.mu.Unlock()
+	if len(dirs) == 0 {
+		return 0
+	}
+
+	var total int64
+	for _, dir := range dirs {
+		parts, _ := filepath.Glob(filepath.Join(dir, m.baseName+"*.swm"))
+		for _, part := range parts {
+			if info, err := os.Stat(part); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+
+	written := total / int64(len(dirs))
+	if written > m.wimSize {
+		written = m.wimSize
+	}
+	return written
+}
+
+// run calls report with the bytes written every interval until the returned stop
+// function is called
+func (m *splitMeter) run(interval time.Duration, report func(written int64)) (stop func()) {
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				report(m.written())
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		wg.Wait()
 	}
+}
+
+// calculateTotalSizeExcluding calculates total size excluding specified files. The
+// walk stops with ctx.Err() once ctx is cancelled.
+func calculateTotalSizeExcluding(ctx context.Context, srcMount string, excludeFiles []string) (*CopyStats, error) {
+	stats := &CopyStats{}
 
 	err := filepath.Walk(srcMount, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return nil
 		}
 
 		relPath, _ := filepath.Rel(srcMount, path)
-		if excludeMap[relPath] {
+		if isExcluded(relPath, excludeFiles) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -371,15 +2083,17 @@ func calculateTotalSizeExcluding(srcMount string, excludeFiles []string) (*CopyS
 
 // copyFilesExcluding copies files excluding specified paths
 func copyFilesExcluding(srcMount, dstMount string, excludeFiles []string, stats *CopyStats, progressFn ProgressFunc) error {
-	excludeMap := make(map[string]bool)
-	for _, f := range excludeFiles {
-		excludeMap[f] = true
-	}
-
-	return filepath.Walk(srcMount, func(srcPath string, info os.FileInfo, err error) error {
+	watch := watchSource(srcMount)
+	err := filepath.Walk(srcMount, func(srcPath string, info os.FileInfo, err error) error {
+		if ctxErr := stats.cancelled(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
+			if goneErr := watch.check(err); goneErr != nil {
+				return goneErr
+			}
 			relPath, _ := filepath.Rel(srcMount, srcPath)
-			stats.Failed = append(stats.Failed, relPath)
+			stats.Failed = append(stats.Failed, FailedFile{Path: relPath, Err: err})
 			return nil
 		}
 
@@ -388,8 +2102,11 @@ func copyFilesExcluding(srcMount, dstMount string, excludeFiles []string, stats
 			return err
 		}
 
-		// Skip excluded files
-		if excludeMap[relPath] {
+		// Skip excluded files and directories
+		if isExcluded(relPath, excludeFiles) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -406,7 +2123,13 @@ func copyFilesExcluding(srcMount, dstMount string, excludeFiles []string, stats
 			}
 
 			if err := copyFile(srcPath, dstPath, info.Size(), stats, progressFn); err != nil {
-				stats.Failed = append(stats.Failed, relPath)
+				if ctxErr := stats.cancelled(); ctxErr != nil {
+					return ctxErr
+				}
+				if goneErr := watch.check(err); goneErr != nil {
+					return goneErr
+				}
+				stats.Failed = append(stats.Failed, FailedFile{Path: relPath, Err: err})
 				return nil
 			}
 
@@ -415,4 +2138,140 @@ func copyFilesExcluding(srcMount, dstMount string, excludeFiles []string, stats
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return stats.failedError()
+}
+
+// isExcluded reports whether relPath, or a directory above it, matches one of patterns.
+// Patterns are slash-separated path.Match globs relative to the source root and are
+// compared case-insensitively, so "sources/sxs" leaves out the whole SxS directory.
+func isExcluded(relPath string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	rel := strings.ToLower(filepath.ToSlash(relPath))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(filepath.ToSlash(pattern))
+		for candidate := rel; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+			if candidate == pattern {
+				return true
+			}
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keptLargeFiles returns the entries of largeFiles that are not excluded by patterns
+func keptLargeFiles(largeFiles []LargeFile, patterns []string) []LargeFile {
+	if len(patterns) == 0 {
+		return largeFiles
+	}
+
+	var kept []LargeFile
+	for _, lf := range largeFiles {
+		if !isExcluded(lf.RelPath, patterns) {
+			kept = append(kept, lf)
+		}
+	}
+	return kept
+}
+
+// SlimPreset is a named set of optional Windows media files that --slim leaves off the
+// target to save space
+type SlimPreset struct {
+	Name    string
+	Exclude []string // patterns for CopyOptions.Exclude
+	Warning string   // what the stick can no longer do without the files
+}
+
+// SlimPresets lists the presets accepted by ParseSlimPresets
+var SlimPresets = []SlimPreset{
+	{
+		Name:    "sxs",
+		Exclude: []string{"sources/sxs"},
+		Warning: ".NET Framework 3.5 can only be added from Windows Update after installing",
+	},
+	{
+		Name:    "support",
+		Exclude: []string{"support"},
+		Warning: "the deployment and upgrade tools in support\\ are not on the stick",
+	},
+	{
+		Name:    "recovery",
+		Exclude: []string{"sources/recovery"},
+		Warning: "the recovery tools of the install media will not be available",
+	},
+}
+
+// ParseSlimPresets parses a comma-separated list of SlimPresets names; "all" selects
+// every preset
+func ParseSlimPresets(value string) ([]SlimPreset, error) {
+	var presets []SlimPreset
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if name == "all" {
+			return append([]SlimPreset(nil), SlimPresets...), nil
+		}
+
+		found := false
+		for _, preset := range SlimPresets {
+			if preset.Name == name {
+				presets = append(presets, preset)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown preset %q (use %s or all)", name, slimPresetNames())
+		}
+	}
+
+	if len(presets) == 0 {
+		return nil, fmt.Errorf("no preset given (use %s or all)", slimPresetNames())
+	}
+	return presets, nil
+}
+
+// slimPresetNames returns the preset names for error messages
+func slimPresetNames() string {
+	names := make([]string, len(SlimPresets))
+	for i, preset := range SlimPresets {
+		names[i] = preset.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// SlimExcludes returns the exclude patterns of presets
+func SlimExcludes(presets []SlimPreset) []string {
+	var patterns []string
+	for _, preset := range presets {
+		patterns = append(patterns, preset.Exclude...)
+	}
+	return patterns
+}
+
+// ValidateExcludes returns an error if patterns would leave out a file the stick needs
+// to boot and start Windows setup
+func ValidateExcludes(patterns []string) error {
+	for _, alternatives := range mediaEssentials {
+		for _, rel := range alternatives {
+			if isExcluded(rel, patterns) {
+				return fmt.Errorf("excluding %s would leave the stick unable to install Windows", rel)
+			}
+		}
+	}
+	return nil
 }
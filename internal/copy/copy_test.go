@@ -1,9 +1,19 @@
 package copy
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestCalculateTotalSize(t *testing.T) {
@@ -26,7 +36,7 @@ func TestCalculateTotalSize(t *testing.T) {
 		t.Fatalf("Failed to create file2: %v", err)
 	}
 
-	stats, err := calculateTotalSize(tmpDir)
+	stats, err := calculateTotalSize(context.Background(), tmpDir)
 	if err != nil {
 		t.Fatalf("calculateTotalSize failed: %v", err)
 	}
@@ -322,7 +332,7 @@ func TestCalculateTotalSizeExcluding(t *testing.T) {
 
 	// Calculate size excluding file2 (use relative path)
 	excludeList := []string{"exclude.txt"}
-	stats, err := calculateTotalSizeExcluding(tmpDir, excludeList)
+	stats, err := calculateTotalSizeExcluding(context.Background(), tmpDir, excludeList)
 	if err != nil {
 		t.Fatalf("calculateTotalSizeExcluding failed: %v", err)
 	}
@@ -379,3 +389,1690 @@ func TestCopyFilesExcluding(t *testing.T) {
 		t.Error("Exclude file should not have been copied")
 	}
 }
+
+func TestValidateSplitSize(t *testing.T) {
+	tests := []struct {
+		sizeMB  int
+		fstype  string
+		wantErr bool
+	}{
+		{SplitWIMMaxSize, "FAT", false},
+		{4095, "FAT32", false},
+		{4096, "FAT", true},
+		{8000, "FAT", true},
+		{8000, "NTFS", false},
+		{0, "FAT", true},
+		{-1, "NTFS", true},
+	}
+
+	for _, test := range tests {
+		err := ValidateSplitSize(test.sizeMB, test.fstype)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ValidateSplitSize(%d, %s) error = %v, wantErr %v", test.sizeMB, test.fstype, err, test.wantErr)
+		}
+	}
+}
+
+func TestSWMPartName(t *testing.T) {
+	tests := []struct {
+		base     string
+		n        int
+		expected string
+	}{
+		{"install", 1, "install.swm"},
+		{"install", 2, "install2.swm"},
+		{"install", 10, "install10.swm"},
+		{"custom", 3, "custom3.swm"},
+	}
+
+	for _, test := range tests {
+		result := swmPartName(test.base, test.n)
+		if result != test.expected {
+			t.Errorf("swmPartName(%s, %d) = %s, expected %s", test.base, test.n, result, test.expected)
+		}
+	}
+}
+
+func TestVerifySWMParts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "swm_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// No parts at all should fail
+	if err := VerifySWMParts(tmpDir, "install"); err == nil {
+		t.Error("VerifySWMParts should fail when no parts exist")
+	}
+
+	// Parts with a non-standard base name plus a stale install.wim
+	for _, name := range []string{"custom.swm", "custom2.swm", "custom3.swm", "install.wim"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("part"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	if err := VerifySWMParts(tmpDir, "custom"); err != nil {
+		t.Fatalf("VerifySWMParts failed: %v", err)
+	}
+
+	for _, name := range []string{"install.swm", "install2.swm", "install3.swm"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("Expected %s to exist after rename", name)
+		}
+	}
+	for _, name := range []string{"custom.swm", "install.wim"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be gone", name)
+		}
+	}
+
+	// Already correctly named parts are left alone
+	if err := VerifySWMParts(tmpDir, "install"); err != nil {
+		t.Errorf("VerifySWMParts failed for install parts: %v", err)
+	}
+}
+
+func TestEstimateCopyFootprint(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "footprint_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	sourcesDir := filepath.Join(tmpDir, "sources")
+	if err := os.MkdirAll(sourcesDir, 0755); err != nil {
+		t.Fatalf("Failed to create sources dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "setup.exe"), []byte("setup"), 0644); err != nil {
+		t.Fatalf("Failed to create setup.exe: %v", err)
+	}
+
+	// Sparse file larger than the FAT32 limit
+	const wimSize = 5 * 1024 * 1024 * 1024
+	wim, err := os.Create(filepath.Join(sourcesDir, "install.wim"))
+	if err != nil {
+		t.Fatalf("Failed to create install.wim: %v", err)
+	}
+	if err := wim.Truncate(wimSize); err != nil {
+		_ = wim.Close()
+		t.Skipf("Sparse files not supported: %v", err)
+	}
+	_ = wim.Close()
+
+	rawSize := int64(wimSize + len("setup"))
+
+	ntfs, err := EstimateCopyFootprint(tmpDir, "NTFS")
+	if err != nil {
+		t.Fatalf("EstimateCopyFootprint failed for NTFS: %v", err)
+	}
+	if ntfs != rawSize {
+		t.Errorf("Expected NTFS footprint %d (no split), got %d", rawSize, ntfs)
+	}
+
+	fat, err := EstimateCopyFootprint(tmpDir, "FAT")
+	if err != nil {
+		t.Fatalf("EstimateCopyFootprint failed for FAT: %v", err)
+	}
+	// 5GB at 3800MB per part gives 2 parts
+	if expected := rawSize + 2*SWMPartOverhead; fat != expected {
+		t.Errorf("Expected FAT footprint %d, got %d", expected, fat)
+	}
+
+	if err := CheckTargetCapacity(tmpDir, "FAT", DefaultCopyOptions(), rawSize); err == nil {
+		t.Error("CheckTargetCapacity should fail when split overhead does not fit")
+	}
+	if err := CheckTargetCapacity(tmpDir, "NTFS", DefaultCopyOptions(), rawSize); err == nil {
+		t.Error("CheckTargetCapacity should fail for NTFS without room for headroom")
+	}
+	if err := CheckTargetCapacity(tmpDir, "NTFS", DefaultCopyOptions(), rawSize+rawSize/20); err != nil {
+		t.Errorf("CheckTargetCapacity failed for NTFS with headroom: %v", err)
+	}
+}
+
+func TestCapacityHeadroom(t *testing.T) {
+	testCases := []struct {
+		capacity int64
+		want     int64
+	}{
+		{1024 * 1024 * 1024, MinCapacityHeadroom},
+		{32 * 1000 * 1000 * 1000, 640 * 1000 * 1000},
+	}
+	for _, tc := range testCases {
+		if got := CapacityHeadroom(tc.capacity); got != tc.want {
+			t.Errorf("CapacityHeadroom(%d) = %d, want %d", tc.capacity, got, tc.want)
+		}
+	}
+}
+
+func TestCopyWindowsISOOversizedFileError(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "oversized_src")
+	if err != nil {
+		t.Fatalf("Failed to create source dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(srcDir) }()
+
+	dstDir, err := os.MkdirTemp("", "oversized_dst")
+	if err != nil {
+		t.Fatalf("Failed to create destination dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dstDir) }()
+
+	// Sparse non-image file larger than the FAT32 limit
+	big, err := os.Create(filepath.Join(srcDir, "huge.iso"))
+	if err != nil {
+		t.Fatalf("Failed to create huge.iso: %v", err)
+	}
+	if err := big.Truncate(FAT32MaxFileSize + 1); err != nil {
+		_ = big.Close()
+		t.Skipf("Sparse files not supported: %v", err)
+	}
+	_ = big.Close()
+
+	err = CopyWindowsISOWithWIMSplit(srcDir, dstDir, nil)
+
+	var oversized *OversizedFileError
+	if !errors.As(err, &oversized) {
+		t.Fatalf("Expected OversizedFileError, got %v", err)
+	}
+	if oversized.RelPath != "huge.iso" {
+		t.Errorf("Expected huge.iso, got %s", oversized.RelPath)
+	}
+}
+
+func TestScanSourceCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "scan_cache_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	stats, _, err := ScanSource(tmpDir)
+	if err != nil {
+		t.Fatalf("ScanSource failed: %v", err)
+	}
+	if stats.TotalFiles != 1 || stats.TotalBytes != 5 {
+		t.Errorf("Expected 1 file / 5 bytes, got %d / %d", stats.TotalFiles, stats.TotalBytes)
+	}
+
+	// Grow a file in place: the directory mtime is unchanged, so the cached result is served
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	stats, _, _ = ScanSource(tmpDir)
+	if stats.TotalBytes != 5 {
+		t.Errorf("Expected cached 5 bytes, got %d", stats.TotalBytes)
+	}
+
+	// Changing the source mtime invalidates the entry
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(tmpDir, future, future); err != nil {
+		t.Fatalf("Failed to touch dir: %v", err)
+	}
+	stats, _, _ = ScanSource(tmpDir)
+	if stats.TotalBytes != 11 {
+		t.Errorf("Expected rescanned 11 bytes, got %d", stats.TotalBytes)
+	}
+
+	// Explicit invalidation forces a rescan as well
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite file: %v", err)
+	}
+	InvalidateSourceCache()
+	stats, _, _ = ScanSource(tmpDir)
+	if stats.TotalBytes != 2 {
+		t.Errorf("Expected 2 bytes after invalidation, got %d", stats.TotalBytes)
+	}
+
+	// Concurrent access must be safe
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := ScanSource(tmpDir); err != nil {
+				t.Errorf("concurrent ScanSource failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, _, err := ScanSource(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("ScanSource should fail for a missing source")
+	}
+}
+
+func TestCopyWithProgressReportsFailedFiles(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "copy_src")
+	if err != nil {
+		t.Fatalf("Failed to create source dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(srcDir) }()
+
+	dstDir, err := os.MkdirTemp("", "copy_dst")
+	if err != nil {
+		t.Fatalf("Failed to create destination dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dstDir) }()
+
+	for _, name := range []string{"good.txt", "blocked.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	// A directory in the way makes creating the destination file fail
+	if err := os.MkdirAll(filepath.Join(dstDir, "blocked.txt"), 0755); err != nil {
+		t.Fatalf("Failed to create blocking dir: %v", err)
+	}
+
+	err = CopyWithProgress(srcDir, dstDir, nil)
+	var copyFailed *CopyFailedError
+	if !errors.As(err, &copyFailed) {
+		t.Fatalf("CopyWithProgress() error = %v, want *CopyFailedError", err)
+	}
+	if len(copyFailed.Failed) != 1 {
+		t.Fatalf("Failed = %v, want one entry", copyFailed.Failed)
+	}
+	if copyFailed.Failed[0].Path != "blocked.txt" || copyFailed.Failed[0].Err == nil {
+		t.Errorf("Failed[0] = %+v, want blocked.txt with an error", copyFailed.Failed[0])
+	}
+
+	if _, err := os.Stat(filepath.Join(dstDir, "good.txt")); err != nil {
+		t.Errorf("good.txt was not copied: %v", err)
+	}
+}
+
+func TestValidateNoSplit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nosplit_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	sourcesDir := filepath.Join(tmpDir, "sources")
+	if err := os.MkdirAll(sourcesDir, 0755); err != nil {
+		t.Fatalf("Failed to create sources dir: %v", err)
+	}
+
+	// Small sources are fine on any filesystem
+	if err := ValidateNoSplit(tmpDir, "FAT"); err != nil {
+		t.Errorf("ValidateNoSplit() on small source = %v", err)
+	}
+
+	wim, err := os.Create(filepath.Join(sourcesDir, "install.wim"))
+	if err != nil {
+		t.Fatalf("Failed to create install.wim: %v", err)
+	}
+	if err := wim.Truncate(FAT32MaxFileSize + 1); err != nil {
+		_ = wim.Close()
+		t.Skipf("Sparse files not supported: %v", err)
+	}
+	_ = wim.Close()
+	InvalidateSourceCache()
+
+	if err := ValidateNoSplit(tmpDir, "FAT"); err == nil {
+		t.Error("ValidateNoSplit() should fail for a WIM over 4GB on FAT")
+	}
+	if err := ValidateNoSplit(tmpDir, "NTFS"); err != nil {
+		t.Errorf("ValidateNoSplit() on NTFS = %v", err)
+	}
+
+	// The footprint no longer includes split overhead
+	footprint, err := EstimateCopyFootprintWithOptions(tmpDir, "FAT", CopyOptions{SplitSizeMB: SplitWIMMaxSize, NoSplit: true})
+	if err != nil {
+		t.Fatalf("EstimateCopyFootprintWithOptions failed: %v", err)
+	}
+	if footprint != FAT32MaxFileSize+1 {
+		t.Errorf("Expected footprint %d without split overhead, got %d", int64(FAT32MaxFileSize+1), footprint)
+	}
+}
+
+func TestCheckFATFit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "fatfit_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	wim, err := os.Create(filepath.Join(tmpDir, "install.wim"))
+	if err != nil {
+		t.Fatalf("Failed to create install.wim: %v", err)
+	}
+	if err := wim.Truncate(FAT32MaxFileSize + 1); err != nil {
+		_ = wim.Close()
+		t.Skipf("Sparse files not supported: %v", err)
+	}
+	_ = wim.Close()
+	InvalidateSourceCache()
+
+	// A large WIM fits once split
+	if err := CheckFATFit(tmpDir, DefaultCopyOptions()); err != nil {
+		t.Errorf("CheckFATFit() with splitting = %v", err)
+	}
+
+	var oversized *OversizedFileError
+	if err := CheckFATFit(tmpDir, CopyOptions{NoSplit: true}); !errors.As(err, &oversized) {
+		t.Errorf("CheckFATFit() without splitting = %v, want *OversizedFileError", err)
+	}
+
+	iso, err := os.Create(filepath.Join(tmpDir, "recovery.iso"))
+	if err != nil {
+		t.Fatalf("Failed to create recovery.iso: %v", err)
+	}
+	if err := iso.Truncate(FAT32MaxFileSize + 1); err != nil {
+		_ = iso.Close()
+		t.Skipf("Sparse files not supported: %v", err)
+	}
+	_ = iso.Close()
+	InvalidateSourceCache()
+
+	if err := CheckFATFit(tmpDir, DefaultCopyOptions()); !errors.As(err, &oversized) || oversized.RelPath != "recovery.iso" {
+		t.Errorf("CheckFATFit() = %v, want *OversizedFileError for recovery.iso", err)
+	}
+}
+
+func TestThrottleProgress(t *testing.T) {
+	if ThrottleProgress(nil) != nil {
+		t.Error("ThrottleProgress(nil) should return nil")
+	}
+
+	clock := time.Unix(0, 0)
+	now := func() time.Time { return clock }
+
+	var calls []int64
+	fn := throttleProgress(func(copied, total int64, file string) {
+		calls = append(calls, copied)
+	}, 100*time.Millisecond, now)
+
+	const total = 1000
+	fn(0, total, "a")  // first call always passes
+	fn(50, total, "b") // too soon
+	clock = clock.Add(200 * time.Millisecond)
+	fn(5, total, "c")     // late enough but under 1% since the last call
+	fn(100, total, "d")   // late enough and 10%
+	fn(500, total, "e")   // big jump but too soon
+	fn(total, total, "f") // final call always passes
+
+	want := []int64{0, 100, total}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("calls[%d] = %d, want %d", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestVerifyCriticalFiles(t *testing.T) {
+	srcDir, err := os.MkdirTemp("", "verify_src")
+	if err != nil {
+		t.Fatalf("Failed to create source dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(srcDir) }()
+
+	dstDir, err := os.MkdirTemp("", "verify_dst")
+	if err != nil {
+		t.Fatalf("Failed to create destination dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dstDir) }()
+
+	files := map[string]string{
+		"bootmgr":                  "bootmgr",
+		"EFI/BOOT/BOOTX64.EFI":     "efi loader",
+		"sources/boot.wim":         "boot image",
+		"sources/setup.exe":        "setup",
+		"support/readme.txt":       "readme",
+		"sources/lang.ini":         "lang",
+		"sources/ignored/file.txt": "x",
+	}
+	for rel, content := range files {
+		for _, root := range []string{srcDir, dstDir} {
+			path := filepath.Join(root, rel)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create dir for %s: %v", rel, err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to create %s: %v", rel, err)
+			}
+		}
+	}
+	for _, part := range []string{"install.swm", "install2.swm"} {
+		if err := os.WriteFile(filepath.Join(dstDir, "sources", part), []byte("part"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", part, err)
+		}
+	}
+
+	if err := VerifyCriticalFiles(srcDir, dstDir); err != nil {
+		t.Fatalf("VerifyCriticalFiles() on identical trees = %v", err)
+	}
+	if err := VerifyAllFiles(srcDir, dstDir); err != nil {
+		t.Fatalf("VerifyAllFiles() on identical trees = %v", err)
+	}
+
+	// Corrupting a non-critical file is only caught by the full verification
+	if err := os.WriteFile(filepath.Join(dstDir, "support", "readme.txt"), []byte("READMe"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt readme: %v", err)
+	}
+	if err := VerifyCriticalFiles(srcDir, dstDir); err != nil {
+		t.Errorf("VerifyCriticalFiles() should ignore non-critical files, got %v", err)
+	}
+	if err := VerifyAllFiles(srcDir, dstDir); err == nil {
+		t.Error("VerifyAllFiles() should detect the corrupted readme")
+	}
+
+	// A gap in the SWM set is caught
+	if err := os.Rename(filepath.Join(dstDir, "sources", "install2.swm"), filepath.Join(dstDir, "sources", "install3.swm")); err != nil {
+		t.Fatalf("Failed to rename part: %v", err)
+	}
+	if err := VerifyCriticalFiles(srcDir, dstDir); err == nil {
+		t.Error("VerifyCriticalFiles() should detect a missing SWM part")
+	}
+	if err := os.Rename(filepath.Join(dstDir, "sources", "install3.swm"), filepath.Join(dstDir, "sources", "install2.swm")); err != nil {
+		t.Fatalf("Failed to rename part back: %v", err)
+	}
+
+	// A corrupted EFI loader is caught despite the different capitalization lookup
+	if err := os.WriteFile(filepath.Join(dstDir, "EFI", "BOOT", "BOOTX64.EFI"), []byte("broken"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt loader: %v", err)
+	}
+	if err := VerifyCriticalFiles(srcDir, dstDir); err == nil {
+		t.Error("VerifyCriticalFiles() should detect a corrupted EFI loader")
+	}
+}
+
+func TestScanSourceContextCancelled(t *testing.T) {
+	InvalidateSourceCache()
+	defer InvalidateSourceCache()
+
+	tmpDir, err := os.MkdirTemp("", "scan_cancel_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := ScanSourceContext(ctx, tmpDir); !errors.Is(err, context.Canceled) {
+		t.Errorf("ScanSourceContext() error = %v, want context.Canceled", err)
+	}
+
+	opts := DefaultCopyOptions()
+	opts.Context = ctx
+	if _, err := EstimateCopyFootprintWithOptions(tmpDir, "FAT", opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("EstimateCopyFootprintWithOptions() error = %v, want context.Canceled", err)
+	}
+	if err := CheckFATFit(tmpDir, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckFATFit() error = %v, want context.Canceled", err)
+	}
+
+	// The aborted scan must not have been cached
+	stats, _, err := ScanSource(tmpDir)
+	if err != nil {
+		t.Fatalf("ScanSource() after cancellation failed: %v", err)
+	}
+	if stats.TotalFiles != 1 {
+		t.Errorf("TotalFiles = %d, want 1", stats.TotalFiles)
+	}
+}
+
+func TestSplitStrategy(t *testing.T) {
+	for _, strategy := range []string{"", SplitDirect, SplitTempStaging} {
+		if err := ValidateSplitStrategy(strategy); err != nil {
+			t.Errorf("ValidateSplitStrategy(%q) = %v, want nil", strategy, err)
+		}
+	}
+	if err := ValidateSplitStrategy("in-place"); err == nil {
+		t.Error("ValidateSplitStrategy() should reject unknown strategies")
+	}
+
+	largeFiles := []LargeFile{
+		{RelPath: "sources/install.wim", Size: 6 * 1024 * 1024 * 1024},
+		{RelPath: "sources/other.wim", Size: 5 * 1024 * 1024 * 1024},
+		{RelPath: "big.iso", Size: 9 * 1024 * 1024 * 1024},
+	}
+	want := int64(6*1024*1024*1024) + 2*SWMPartOverhead
+	if got := stagingFootprint(largeFiles, SplitWIMMaxSize); got != want {
+		t.Errorf("stagingFootprint() = %d, want %d", got, want)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "staging_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer InvalidateSourceCache()
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "small.txt"), []byte("small"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	opts := DefaultCopyOptions()
+	opts.SplitStrategy = SplitTempStaging
+	opts.StagingDir = filepath.Join(tmpDir, "missing")
+	if err := CheckStagingSpace(tmpDir, opts); err != nil {
+		t.Errorf("CheckStagingSpace() without large WIMs = %v, want nil", err)
+	}
+
+	if free, err := FreeSpace(tmpDir); err != nil || free <= 0 {
+		t.Errorf("FreeSpace() = %d, %v", free, err)
+	}
+	if _, err := FreeSpace(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("FreeSpace() should fail for a missing directory")
+	}
+}
+
+func TestParseWIMInfo(t *testing.T) {
+	info := `WIM Information:
+----------------
+Path:           /mnt/sources/install.wim
+Image Count:    2
+Compression:    LZX
+
+Available Images:
+-----------------
+Index:                  1
+Name:                   Windows 11 Home
+Description:            Windows 11 Home
+Total Bytes:            17459987036
+
+Index:                  2
+Name:                   Windows 11 Pro
+Description:            Windows 11 Pro
+Total Bytes:            17712345678
+`
+
+	images := parseWIMInfo(info)
+	want := []WIMImage{
+		{Index: 1, Name: "Windows 11 Home", Size: 17459987036},
+		{Index: 2, Name: "Windows 11 Pro", Size: 17712345678},
+	}
+	if len(images) != len(want) {
+		t.Fatalf("parseWIMInfo() returned %d images, want %d", len(images), len(want))
+	}
+	for i := range want {
+		if images[i] != want[i] {
+			t.Errorf("image %d = %+v, want %+v", i, images[i], want[i])
+		}
+	}
+
+	if got := FormatWIMImages(images); got != "1=Windows 11 Home, 2=Windows 11 Pro" {
+		t.Errorf("FormatWIMImages() = %q", got)
+	}
+
+	if images := parseWIMInfo("Path: x.wim\nImage Count: 0\n"); len(images) != 0 {
+		t.Errorf("parseWIMInfo() without images = %+v, want none", images)
+	}
+}
+
+func TestFindInstallImage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "install_image_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if _, ok := FindInstallImage(tmpDir); ok {
+		t.Error("FindInstallImage() should find nothing in an empty source")
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "SOURCES"), 0755); err != nil {
+		t.Fatalf("Failed to create sources: %v", err)
+	}
+	esd := filepath.Join(tmpDir, "SOURCES", "INSTALL.ESD")
+	if err := os.WriteFile(esd, []byte("esd"), 0644); err != nil {
+		t.Fatalf("Failed to create install.esd: %v", err)
+	}
+
+	if got, ok := FindInstallImage(tmpDir); !ok || got != esd {
+		t.Errorf("FindInstallImage() = %q, %v, want %q", got, ok, esd)
+	}
+}
+
+func TestCheckWindowsMediaComplete(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "media_complete_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	write := func(rel, content string) {
+		path := filepath.Join(tmpDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", rel, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", rel, err)
+		}
+	}
+
+	var incomplete *IncompleteMediaError
+	if err := CheckWindowsMediaComplete(tmpDir); !errors.As(err, &incomplete) || len(incomplete.Missing) != 5 {
+		t.Fatalf("CheckWindowsMediaComplete() on an empty target = %v, want 5 missing", err)
+	}
+
+	write("BOOTMGR", "bootmgr")
+	write("bootmgr.efi", "efi")
+	write("EFI/Boot/bootx64.efi", "loader")
+	write("sources/install.swm", "part1")
+	write("sources/install2.swm", "part2")
+	write("sources/boot.wim", "")
+
+	err = CheckWindowsMediaComplete(tmpDir)
+	if !errors.As(err, &incomplete) || len(incomplete.Missing) != 1 || incomplete.Missing[0] != filepath.Join("sources", "boot.wim") {
+		t.Fatalf("CheckWindowsMediaComplete() with an empty boot.wim = %v", err)
+	}
+
+	write("sources/boot.wim", "boot")
+	if err := CheckWindowsMediaComplete(tmpDir); err != nil {
+		t.Errorf("CheckWindowsMediaComplete() on complete media = %v", err)
+	}
+
+	// A gap in the split set counts as incomplete
+	if err := os.Rename(filepath.Join(tmpDir, "sources", "install2.swm"), filepath.Join(tmpDir, "sources", "install3.swm")); err != nil {
+		t.Fatalf("Failed to rename part: %v", err)
+	}
+	if err := CheckWindowsMediaComplete(tmpDir); err == nil {
+		t.Error("CheckWindowsMediaComplete() should detect a missing SWM part")
+	}
+}
+
+func TestCopyFileReadErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	oldDelay := readRetryDelay
+	defer func() { readRetryDelay = oldDelay }()
+	readRetryDelay = 0
+
+	// A directory opens fine but every read of it fails, like a bad disc sector
+	badSrc := filepath.Join(tmpDir, "bad")
+	if err := os.Mkdir(badSrc, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	dst := filepath.Join(tmpDir, "out")
+
+	stats := &CopyStats{CurrentFile: "bad"}
+	if err := copyFile(badSrc, dst, 5000, stats, nil); err == nil {
+		t.Fatal("copyFile() should fail on an unreadable source")
+	}
+	if len(stats.ReadErrors) != 1 || stats.ReadErrors[0].Retries != ReadRetries || !stats.ReadErrors[0].Recovered() {
+		t.Errorf("ReadErrors = %+v, want one entry with %d retries", stats.ReadErrors, ReadRetries)
+	}
+
+	var reported []ReadErrorFile
+	stats = &CopyStats{CurrentFile: "bad", IgnoreReadErrors: true, OnReadError: func(r ReadErrorFile) {
+		reported = append(reported, r)
+	}}
+	if err := copyFile(badSrc, dst, 5000, stats, nil); err != nil {
+		t.Fatalf("copyFile() with IgnoreReadErrors = %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if len(data) != 5000 || stats.CopiedBytes != 5000 {
+		t.Errorf("copied %d bytes (stats %d), want 5000 zero-filled bytes", len(data), stats.CopiedBytes)
+	}
+	if len(reported) != 1 || reported[0].ZeroFilled != 5000 || reported[0].Recovered() {
+		t.Errorf("reported = %+v, want 5000 zero-filled bytes", reported)
+	}
+
+	// A readable file records no read errors
+	goodSrc := filepath.Join(tmpDir, "good")
+	if err := os.WriteFile(goodSrc, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	stats = &CopyStats{CurrentFile: "good"}
+	if err := copyFile(goodSrc, dst, 5, stats, nil); err != nil || len(stats.ReadErrors) != 0 {
+		t.Errorf("copyFile() = %v, ReadErrors = %+v", err, stats.ReadErrors)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "hello" {
+		t.Errorf("copied %q, want %q", data, "hello")
+	}
+}
+
+func TestCompletionSummary(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		copied  int64
+		want    string
+	}{
+		{8*time.Minute + 42*time.Second, 100 * 1024 * 1024 * 522, "Completed in 8m42s, average 100.0 MB/s (51.0 GB copied)"},
+		{10 * time.Second, 20 * 1024 * 1024, "Completed in 10s, average 2.0 MB/s (20.0 MB copied)"},
+		{200 * time.Millisecond, 1024, "Completed in 0s (1.0 KB copied)"},
+	}
+
+	for _, tt := range tests {
+		if got := CompletionSummary(tt.elapsed, tt.copied); got != tt.want {
+			t.Errorf("CompletionSummary(%v, %d) = %q, want %q", tt.elapsed, tt.copied, got, tt.want)
+		}
+	}
+}
+
+func TestWriteInfoStamp(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+	iso := filepath.Join(tmpDir, "Win11_English_x64.iso")
+	if err := os.WriteFile(iso, []byte("iso"), 0644); err != nil {
+		t.Fatalf("Failed to write iso: %v", err)
+	}
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.Mkdir(dst, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	info := NewStampInfo(iso, tmpDir, "1.0.2", "FAT")
+	if info.Source != "Win11_English_x64.iso" || info.Version != "1.0.2" || info.Filesystem != "FAT" || info.CreatedAt.IsZero() {
+		t.Errorf("NewStampInfo() = %+v", info)
+	}
+	if sum := sha256.Sum256([]byte("iso")); info.SourceSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("SourceSHA256 = %q", info.SourceSHA256)
+	}
+	if len(info.Editions) != 0 {
+		t.Errorf("Editions = %v, want none without an install image", info.Editions)
+	}
+
+	info.Editions = []string{"Windows 11 Pro"}
+	if err := WriteInfoStamp(dst, info); err != nil {
+		t.Fatalf("WriteInfoStamp() = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, StampFileName))
+	if err != nil {
+		t.Fatalf("Failed to read stamp: %v", err)
+	}
+	var got StampInfo
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Stamp is not valid JSON: %v", err)
+	}
+	if got.Source != info.Source || got.SourceSHA256 != info.SourceSHA256 || !got.CreatedAt.Equal(info.CreatedAt) ||
+		len(got.Editions) != 1 || got.Editions[0] != "Windows 11 Pro" {
+		t.Errorf("stamp round-trip = %+v, want %+v", got, info)
+	}
+
+	if err := WriteInfoStamp(filepath.Join(tmpDir, "missing"), info); err == nil {
+		t.Error("WriteInfoStamp() should fail for a missing target")
+	}
+}
+
+func TestClearDirectory(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "sources", "sxs"), 0755); err != nil {
+		t.Fatalf("Failed to create dirs: %v", err)
+	}
+	for _, name := range []string{"bootmgr", "sources/install.wim", "sources/sxs/a.cab"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := ClearDirectory(tmpDir); err != nil {
+		t.Fatalf("ClearDirectory() = %v", err)
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("directory itself should remain: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ClearDirectory() left %d entries", len(entries))
+	}
+
+	if err := ClearDirectory(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("ClearDirectory() should fail for a missing directory")
+	}
+}
+
+func TestIsTmpfs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tmpfs-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if _, err := IsTmpfs(tmpDir); err != nil {
+		t.Errorf("IsTmpfs() failed: %v", err)
+	}
+	if _, err := IsTmpfs(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("IsTmpfs() should fail for a missing directory")
+	}
+}
+
+func TestCheckStagingSpaceInsufficient(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "staging_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	defer InvalidateSourceCache()
+
+	sourcesDir := filepath.Join(tmpDir, "sources")
+	if err := os.MkdirAll(sourcesDir, 0755); err != nil {
+		t.Fatalf("Failed to create sources dir: %v", err)
+	}
+	wim, err := os.Create(filepath.Join(sourcesDir, "install.wim"))
+	if err != nil {
+		t.Fatalf("Failed to create WIM: %v", err)
+	}
+	// A sparse 4TiB WIM needs more staging space than any test machine has
+	err = wim.Truncate(4 << 40)
+	_ = wim.Close()
+	if err != nil {
+		t.Skipf("Filesystem does not support a sparse 4TiB file: %v", err)
+	}
+
+	opts := DefaultCopyOptions()
+	opts.SplitStrategy = SplitTempStaging
+	opts.StagingDir = tmpDir
+	err = CheckStagingSpace(tmpDir, opts)
+	if !errors.Is(err, ErrStagingSpace) {
+		t.Errorf("CheckStagingSpace() = %v, want ErrStagingSpace", err)
+	}
+}
+
+// fakeWIMTool records WIM operations instead of running a WIM tool
+type fakeWIMTool struct {
+	splits []string
+	images []WIMImage
+	err    error
+}
+
+func (f *fakeWIMTool) Name() string { return "fake" }
+
+func (f *fakeWIMTool) Split(_ context.Context, wimPath, swmPath string, maxSizeMB int) error {
+	f.splits = append(f.splits, fmt.Sprintf("%s -> %s (%d MB)", wimPath, swmPath, maxSizeMB))
+	return f.err
+}
+
+func (f *fakeWIMTool) Info(string) ([]WIMImage, error) { return f.images, f.err }
+
+func (f *fakeWIMTool) Export(string, int, string) error { return f.err }
+
+func (f *fakeWIMTool) Verify(string) error { return f.err }
+
+func TestWIMToolFake(t *testing.T) {
+	oldTool := DefaultWIMTool
+	defer func() { DefaultWIMTool = oldTool }()
+
+	fake := &fakeWIMTool{images: []WIMImage{{Index: 1, Name: "Windows 11 Pro"}}}
+	DefaultWIMTool = fake
+
+	if err := SplitWIM("/src/sources/install.wim", "/dst/sources", 3800); err != nil {
+		t.Fatalf("SplitWIM() failed: %v", err)
+	}
+	want := "/src/sources/install.wim -> /dst/sources/install.swm (3800 MB)"
+	if len(fake.splits) != 1 || fake.splits[0] != want {
+		t.Errorf("Split calls = %v, want [%s]", fake.splits, want)
+	}
+
+	images, err := ListWIMImages("/src/sources/install.wim")
+	if err != nil || len(images) != 1 || images[0].Name != "Windows 11 Pro" {
+		t.Errorf("ListWIMImages() = %v, %v", images, err)
+	}
+
+	fake.err = errors.New("corrupt resource")
+	if err := VerifyWIM("/src/sources/install.wim"); err == nil || !strings.Contains(err.Error(), "corrupt resource") {
+		t.Errorf("VerifyWIM() = %v, want the tool error", err)
+	}
+	if err := ExportWIMImage("/src/sources/install.wim", 1, "/tmp/pro.wim"); err == nil {
+		t.Error("ExportWIMImage() should return the tool error")
+	}
+}
+
+func TestDetectWIMTool(t *testing.T) {
+	oldLookPath := wimLookPath
+	defer func() { wimLookPath = oldLookPath }()
+
+	tests := []struct {
+		available []string
+		want      string
+	}{
+		{[]string{"wimlib-imagex", "wimsplit", "wiminfo"}, "wimlib-imagex"},
+		{[]string{"wimsplit", "wiminfo"}, "wimlib (wimsplit, wiminfo, ...)"},
+		{[]string{"wimsplit"}, "wimlib-imagex"},
+		{nil, "wimlib-imagex"},
+	}
+	for _, tt := range tests {
+		wimLookPath = func(name string) (string, error) {
+			for _, a := range tt.available {
+				if a == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", errors.New("not found")
+		}
+		if got := DetectWIMTool().Name(); got != tt.want {
+			t.Errorf("DetectWIMTool() with %v = %q, want %q", tt.available, got, tt.want)
+		}
+	}
+
+	perCommand := wimlibTool{perCommand: true}.command("split", "a.wim", "a.swm", "100")
+	if got := strings.Join(perCommand.Args, " "); got != "wimsplit a.wim a.swm 100" {
+		t.Errorf("per-command split runs %q", got)
+	}
+	imagex := wimlibTool{}.command("info", "a.wim")
+	if got := strings.Join(imagex.Args, " "); got != "wimlib-imagex info a.wim" {
+		t.Errorf("wimlib-imagex info runs %q", got)
+	}
+}
+
+func TestSourceWatch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "source_watch")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	watch := watchSource(tmpDir)
+	if err := watch.check(os.ErrNotExist); err != nil {
+		t.Errorf("check() with the source present = %v, want nil", err)
+	}
+
+	// Errors from a vanished device are recognised without looking at the root
+	err = watch.check(fmt.Errorf("read error: %w", syscall.ENODEV))
+	if !errors.Is(err, ErrSourceUnavailable) {
+		t.Errorf("check(ENODEV) = %v, want ErrSourceUnavailable", err)
+	}
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if err := watch.check(os.ErrNotExist); !errors.Is(err, ErrSourceUnavailable) {
+		t.Errorf("check() with the source gone = %v, want ErrSourceUnavailable", err)
+	}
+}
+
+func TestCopyAbortsWhenSourceVanishes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	srcDir := filepath.Join(tmpDir, "src")
+	dstDir := filepath.Join(tmpDir, "dst")
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		path := filepath.Join(srcDir, "sources", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	// The source disappears as soon as the first file is about to be copied
+	err = CopyWithProgress(srcDir, dstDir, func(copied, total int64, current string) {
+		_ = os.RemoveAll(srcDir)
+	})
+	if !errors.Is(err, ErrSourceUnavailable) {
+		t.Errorf("CopyWithProgress() = %v, want ErrSourceUnavailable", err)
+	}
+	var copyFailed *CopyFailedError
+	if errors.As(err, &copyFailed) {
+		t.Error("A vanished source must abort the copy, not be reported as failed files")
+	}
+}
+
+func TestSplitMeter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "split_meter_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	outDir := filepath.Join(tmpDir, "out")
+	stageDir := filepath.Join(tmpDir, "stage")
+	for _, dir := range []string{outDir, stageDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meter := newSplitMeter("install", 1000, outDir)
+	if got := meter.written(); got != 0 {
+		t.Errorf("written() = %d before any part, want 0", got)
+	}
+
+	_ = os.WriteFile(filepath.Join(outDir, "install.swm"), make([]byte, 300), 0644)
+	_ = os.WriteFile(filepath.Join(outDir, "install2.swm"), make([]byte, 100), 0644)
+	_ = os.WriteFile(filepath.Join(outDir, "boot.wim"), make([]byte, 500), 0644)
+	if got := meter.written(); got != 400 {
+		t.Errorf("written() = %d, want 400", got)
+	}
+
+	// Staged parts count for half, as they are copied to the target afterwards
+	meter.addDir(stageDir)
+	_ = os.WriteFile(filepath.Join(stageDir, "install.swm"), make([]byte, 1000), 0644)
+	if got := meter.written(); got != 700 {
+		t.Errorf("written() = %d with staging, want 700", got)
+	}
+
+	_ = os.WriteFile(filepath.Join(outDir, "install3.swm"), make([]byte, 2000), 0644)
+	if got := meter.written(); got != 1000 {
+		t.Errorf("written() = %d, want it capped at the WIM size", got)
+	}
+}
+
+func TestCopyWindowsISOWeightsSplitProgress(t *testing.T) {
+	oldTool := DefaultWIMTool
+	defer func() { DefaultWIMTool = oldTool }()
+	DefaultWIMTool = &fakeWIMTool{}
+
+	tmpDir, err := os.MkdirTemp("", "split_progress_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "setup.exe"), make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Sparse, so the test does not need 5GB of disk
+	wimSize := int64(5) << 30
+	f, err := os.Create(filepath.Join(src, "big.wim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(wimSize); err != nil {
+		_ = f.Close()
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	var calls [][2]int64
+	err = CopyWindowsISOWithOptions(src, dst, DefaultCopyOptions(), func(bytesCopied, totalBytes int64, _ string) {
+		calls = append(calls, [2]int64{bytesCopied, totalBytes})
+	})
+	if err != nil {
+		t.Fatalf("CopyWindowsISOWithOptions() failed: %v", err)
+	}
+
+	want := 1024 + wimSize
+	if len(calls) == 0 {
+		t.Fatal("no progress reported")
+	}
+	for _, c := range calls {
+		if c[1] != want {
+			t.Fatalf("progress total = %d, want %d covering the split", c[1], want)
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != want {
+		t.Errorf("final progress = %d, want %d", last[0], want)
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	patterns := []string{"sources/sxs", "support", "*.log"}
+	tests := []struct {
+		relPath string
+		want    bool
+	}{
+		{"sources/sxs", true},
+		{"Sources/SxS/microsoft-windows-netfx3.cab", true},
+		{"support/adprep/adprep.exe", true},
+		{"setup.log", true},
+		{"sources/install.wim", false},
+		{"sources/sxsextra.cab", false},
+		{"supporttools/readme.txt", false},
+	}
+	for _, tt := range tests {
+		if got := isExcluded(tt.relPath, patterns); got != tt.want {
+			t.Errorf("isExcluded(%q) = %v, want %v", tt.relPath, got, tt.want)
+		}
+	}
+	if isExcluded("sources/sxs", nil) {
+		t.Error("nothing is excluded without patterns")
+	}
+}
+
+func TestParseSlimPresets(t *testing.T) {
+	presets, err := ParseSlimPresets("sxs, Support,sxs")
+	if err != nil || len(presets) != 2 || presets[0].Name != "sxs" || presets[1].Name != "support" {
+		t.Errorf("ParseSlimPresets() = %v, %v; want sxs and support", presets, err)
+	}
+
+	presets, err = ParseSlimPresets("all")
+	if err != nil || len(presets) != len(SlimPresets) {
+		t.Errorf("ParseSlimPresets(all) = %v, %v; want every preset", presets, err)
+	}
+
+	for _, value := range []string{"winre", ""} {
+		if _, err := ParseSlimPresets(value); err == nil {
+			t.Errorf("ParseSlimPresets(%q) should fail", value)
+		}
+	}
+
+	// No preset may break installability
+	if err := ValidateExcludes(SlimExcludes(SlimPresets)); err != nil {
+		t.Errorf("presets exclude an essential file: %v", err)
+	}
+	if err := ValidateExcludes([]string{"sources"}); err == nil {
+		t.Error("excluding sources should be refused")
+	}
+	if err := ValidateExcludes([]string{"efi/boot/*.efi"}); err == nil {
+		t.Error("excluding the UEFI bootloader should be refused")
+	}
+}
+
+func TestCopyWindowsISOWithExclude(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "exclude_copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	files := map[string]string{
+		"setup.exe":                    "setup",
+		"sources/boot.wim":             "boot",
+		"sources/sxs/netfx3.cab":       "netfx3",
+		"sources/sxs/nested/extra.cab": "extra",
+		"support/logging/readme.txt":   "support",
+	}
+	for rel, content := range files {
+		p := filepath.Join(src, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := DefaultCopyOptions()
+	opts.Exclude = []string{"sources/sxs"}
+	if err := CopyWindowsISOWithOptions(src, dst, opts, nil); err != nil {
+		t.Fatalf("CopyWindowsISOWithOptions() failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "sources", "sxs")); !os.IsNotExist(err) {
+		t.Errorf("sources/sxs should not be copied, stat err = %v", err)
+	}
+	for _, rel := range []string{"setup.exe", "sources/boot.wim", "support/logging/readme.txt"} {
+		if _, err := os.Stat(filepath.Join(dst, rel)); err != nil {
+			t.Errorf("%s missing on the target: %v", rel, err)
+		}
+	}
+
+	if err := VerifyAllFilesExcluding(src, dst, opts.Exclude); err != nil {
+		t.Errorf("VerifyAllFilesExcluding() = %v", err)
+	}
+	if err := VerifyAllFiles(src, dst); err == nil {
+		t.Error("VerifyAllFiles() should report the excluded files as missing")
+	}
+
+	InvalidateSourceCache()
+	full, err := EstimateCopyFootprintWithOptions(src, "NTFS", DefaultCopyOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slim, err := EstimateCopyFootprintWithOptions(src, "NTFS", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full-slim != int64(len("netfx3")+len("extra")) {
+		t.Errorf("footprint %d with exclusions, %d without; want the SxS bytes subtracted", slim, full)
+	}
+}
+
+func TestCopyWindowsISOWithWIMSplitContextCancel(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cancel_copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.MkdirAll(filepath.Join(src, "sources"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Large enough to be copied in several chunks with progress in between
+	if err := os.WriteFile(filepath.Join(src, "sources", "boot.wim"), make([]byte, 8*ChunkSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel as soon as the large file reports its first chunk
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = CopyWindowsISOWithWIMSplitContext(ctx, src, dst, func(bytesCopied, _ int64, _ string) {
+		if bytesCopied > 0 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CopyWindowsISOWithWIMSplitContext() = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "sources", "boot.wim")); !os.IsNotExist(err) {
+		t.Errorf("partial boot.wim should be removed, stat err = %v", err)
+	}
+
+	// An already cancelled context stops before anything is copied
+	err = CopyWindowsISOWithWIMSplitContext(ctx, src, filepath.Join(tmpDir, "dst2"), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CopyWindowsISOWithWIMSplitContext() with a cancelled context = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "dst2")); !os.IsNotExist(err) {
+		t.Errorf("nothing should be copied with a cancelled context, stat err = %v", err)
+	}
+}
+
+func TestRemoveSWMParts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "swm_remove_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	for _, name := range []string{"install.swm", "install2.swm", "boot.wim"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeSWMParts(tmpDir, "install")
+
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 1 || entries[0].Name() != "boot.wim" {
+		t.Errorf("remaining files = %v, want only boot.wim", entries)
+	}
+}
+
+func TestValidateCopyChecksum(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "checksum_validate_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	files := map[string]string{
+		"setup.exe":           "setup",
+		"sources/boot.wim":    "boot",
+		"sources/install.wim": "install image",
+	}
+	for rel, content := range files {
+		for _, root := range []string{src, dst} {
+			p := filepath.Join(root, rel)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var last [2]int64
+	err = ValidateCopyChecksum(src, dst, func(read, total int64, _ string) {
+		last = [2]int64{read, total}
+	})
+	if err != nil {
+		t.Fatalf("ValidateCopyChecksum() on identical trees = %v", err)
+	}
+	if last[1] == 0 || last[0] != last[1] {
+		t.Errorf("final progress = %d/%d, want complete", last[0], last[1])
+	}
+
+	// Same size, different contents
+	if err := os.WriteFile(filepath.Join(dst, "setup.exe"), []byte("SETUP"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *ChecksumMismatchError
+	err = ValidateCopyChecksum(src, dst, nil)
+	if !errors.As(err, &mismatch) || mismatch.RelPath != "setup.exe" {
+		t.Errorf("ValidateCopyChecksum() = %v, want a mismatch for setup.exe", err)
+	}
+	if err := ValidateCopy(src, dst); err != nil {
+		t.Errorf("ValidateCopy() = %v, size/count check should not notice", err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "setup.exe"), []byte("setup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A split install.wim is checked as an SWM set
+	if err := os.Remove(filepath.Join(dst, "sources", "install.wim")); err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateCopyChecksum(src, dst, nil)
+	if !errors.As(err, &mismatch) || mismatch.RelPath != filepath.Join("sources", "install.wim") {
+		t.Errorf("ValidateCopyChecksum() without install.wim or parts = %v, want a mismatch", err)
+	}
+	for _, part := range []string{"install.swm", "install2.swm"} {
+		if err := os.WriteFile(filepath.Join(dst, "sources", part), []byte("part"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ValidateCopyChecksum(src, dst, nil); err != nil {
+		t.Errorf("ValidateCopyChecksum() with a complete SWM set = %v", err)
+	}
+	if err := os.Remove(filepath.Join(dst, "sources", "install.swm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCopyChecksum(src, dst, nil); !errors.As(err, &mismatch) {
+		t.Errorf("ValidateCopyChecksum() with a gap in the SWM set = %v, want a mismatch", err)
+	}
+}
+
+func TestCopyWithProgressResume(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "resume_copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.MkdirAll(filepath.Join(src, "sources"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for rel, content := range map[string]string{"setup.exe": "setup", "sources/boot.wim": "boot image"} {
+		if err := os.WriteFile(filepath.Join(src, rel), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CopyWithProgress(src, dst, nil); err != nil {
+		t.Fatalf("CopyWithProgress() failed: %v", err)
+	}
+	srcInfo, _ := os.Stat(filepath.Join(src, "setup.exe"))
+	if dstInfo, _ := os.Stat(filepath.Join(dst, "setup.exe")); !alreadyCopied(filepath.Join(dst, "setup.exe"), srcInfo) {
+		t.Errorf("copy mtime %v should match the source's %v", dstInfo.ModTime(), srcInfo.ModTime())
+	}
+
+	// Same size and mtime counts as copied, even with different contents; a truncated
+	// file from an interrupted run is copied again
+	if err := os.WriteFile(filepath.Join(dst, "setup.exe"), []byte("SETUP"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dst, "setup.exe"), srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "sources", "boot.wim"), []byte("boot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var last int64
+	opts := CopyOptions{ResumeMode: true}
+	if err := CopyWithProgressWithOptions(src, dst, opts, func(bytesCopied, _ int64, _ string) { last = bytesCopied }); err != nil {
+		t.Fatalf("CopyWithProgressWithOptions() failed: %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dst, "setup.exe")); string(data) != "SETUP" {
+		t.Errorf("setup.exe = %q, should have been skipped", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "sources", "boot.wim")); string(data) != "boot image" {
+		t.Errorf("boot.wim = %q, should have been copied again", data)
+	}
+	if want := int64(len("setup") + len("boot image")); last != want {
+		t.Errorf("final progress = %d, want %d including skipped files", last, want)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("a zero rate should mean no limiter")
+	}
+	var unlimited *rateLimiter
+	unlimited.wait(1 << 30) // must not panic
+
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	l := newRateLimiter(1000)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.wait(500)
+	}
+	if slept != 2*time.Second {
+		t.Errorf("slept %v for 2000 bytes at 1000 B/s, want 2s", slept)
+	}
+
+	// A long pause elsewhere does not allow a burst afterwards
+	now = now.Add(time.Minute)
+	slept = 0
+	l.wait(500)
+	l.wait(500)
+	if slept < 500*time.Millisecond {
+		t.Errorf("slept %v after a pause, want the rate to apply again", slept)
+	}
+}
+
+func TestCopyWithProgressMaxRate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "max_rate_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	const size = 64 * 1024
+	for _, name := range []string{"a.bin", "b.bin"} {
+		if err := os.WriteFile(filepath.Join(src, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 128KiB at 256KiB/s takes at least half a second
+	opts := CopyOptions{MaxBytesPerSec: 256 * 1024}
+	start := time.Now()
+	if err := CopyWithProgressWithOptions(src, filepath.Join(tmpDir, "dst"), opts, nil); err != nil {
+		t.Fatalf("CopyWithProgressWithOptions() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("copy took %v, want at least 500ms at the limited rate", elapsed)
+	}
+}
+
+func TestCopyPreservesMetadata(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "metadata_copy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	srcFile := filepath.Join(src, "setup.exe")
+	if err := os.WriteFile(srcFile, []byte("setup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2021, 10, 5, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(srcFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// ISO files are read-only; the copy must stay writable
+	if err := os.Chmod(srcFile, 0555); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(tmpDir, "dst")
+	if err := CopyWithProgress(src, dst, nil); err != nil {
+		t.Fatalf("CopyWithProgress() failed: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "setup.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := info.ModTime().Sub(old); diff < -mtimeTolerance || diff > mtimeTolerance {
+		t.Errorf("copy mtime = %v, want %v", info.ModTime(), old)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("copy mode = %v, want 0755 (source bits plus owner write)", info.Mode().Perm())
+	}
+
+	noMeta := filepath.Join(tmpDir, "nometa")
+	opts := DefaultCopyOptions()
+	opts.PreserveMetadata = false
+	if err := CopyWithProgressWithOptions(src, noMeta, opts, nil); err != nil {
+		t.Fatalf("CopyWithProgressWithOptions() failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(noMeta, "setup.exe")); err != nil || info.ModTime().Before(old.Add(time.Hour)) {
+		t.Errorf("without PreserveMetadata the copy should get the current time, got %v, %v", info, err)
+	}
+}
+
+func TestIsSplittableImage(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"sources/install.wim", true},
+		{"sources/install.esd", true},
+		{"SOURCES/INSTALL.ESD", true},
+		{"sources/install.swm", false},
+		{"sources/install.iso", false},
+		{"esd", false},
+	}
+
+	for _, test := range tests {
+		if got := IsSplittableImage(test.path); got != test.expected {
+			t.Errorf("IsSplittableImage(%s) = %v, expected %v", test.path, got, test.expected)
+		}
+	}
+}
+
+func TestCopyWindowsISOSplitsESD(t *testing.T) {
+	oldTool := DefaultWIMTool
+	defer func() { DefaultWIMTool = oldTool }()
+	fake := &fakeWIMTool{}
+	DefaultWIMTool = fake
+
+	tmpDir, err := os.MkdirTemp("", "esd_split_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	src := filepath.Join(tmpDir, "src")
+	dst := filepath.Join(tmpDir, "dst")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Sparse, so the test does not need 5GB of disk
+	f, err := os.Create(filepath.Join(src, "install.esd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(int64(5) << 30); err != nil {
+		_ = f.Close()
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	if err := CheckFATFit(src, DefaultCopyOptions()); err != nil {
+		t.Errorf("CheckFATFit() = %v, an ESD can be split", err)
+	}
+	if err := CopyWindowsISOWithOptions(src, dst, DefaultCopyOptions(), nil); err != nil {
+		t.Fatalf("CopyWindowsISOWithOptions() failed: %v", err)
+	}
+
+	want := filepath.Join(src, "install.esd") + " -> " + filepath.Join(dst, "install.swm") + " (3800 MB)"
+	if len(fake.splits) != 1 || fake.splits[0] != want {
+		t.Errorf("Split calls = %v, want [%s]", fake.splits, want)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "install.esd")); !os.IsNotExist(err) {
+		t.Errorf("install.esd should be split, not copied whole, stat err = %v", err)
+	}
+}
+
+func TestDetailedProgress(t *testing.T) {
+	if DetailedProgress(nil) != nil {
+		t.Error("DetailedProgress(nil) should return nil")
+	}
+
+	now := time.Unix(0, 0)
+	var got []Progress
+	fn := detailedProgress(func(p Progress) { got = append(got, p) }, func() time.Time { return now })
+
+	const mb = 1024 * 1024
+	fn(0, 100*mb, "a")
+	if got[0].BytesPerSec != 0 || got[0].EstimatedRemaining != 0 {
+		t.Errorf("first report = %+v, rate and ETA should be unknown", got[0])
+	}
+
+	// 10 MB/s steady: 50 MB left takes 5s
+	for i := 1; i <= 5; i++ {
+		now = now.Add(time.Second)
+		fn(int64(i)*10*mb, 100*mb, "a")
+	}
+	last := got[len(got)-1]
+	if last.BytesPerSec != 10*mb {
+		t.Errorf("rate = %.0f, want %d", last.BytesPerSec, 10*mb)
+	}
+	if last.EstimatedRemaining != 5*time.Second {
+		t.Errorf("ETA = %v, want 5s", last.EstimatedRemaining)
+	}
+
+	// The average only covers RateWindow, so a slowdown shows up
+	for i := 1; i <= 10; i++ {
+		now = now.Add(time.Second)
+		fn(50*mb+int64(i)*mb, 100*mb, "b")
+	}
+	if last = got[len(got)-1]; last.BytesPerSec != mb {
+		t.Errorf("rate after slowdown = %.0f, want %d", last.BytesPerSec, mb)
+	}
+
+	// A restarted copy starts tracking from scratch
+	now = now.Add(time.Second)
+	fn(0, 100*mb, "a")
+	if last = got[len(got)-1]; last.BytesPerSec != 0 {
+		t.Errorf("rate after restart = %.0f, want unknown", last.BytesPerSec)
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	p := Progress{BytesCopied: 512 * 1024 * 1024, TotalBytes: 1024 * 1024 * 1024}
+	if got := FormatProgress(p); got != "Copying: 50.0% (512.0 MB)" {
+		t.Errorf("FormatProgress() without rate = %q", got)
+	}
+
+	p.BytesPerSec = 16 * 1024 * 1024
+	p.EstimatedRemaining = 3*time.Minute + 12*time.Second
+	if got := FormatProgress(p); got != "Copying: 50.0% (512.0 MB) at 16.0 MB/s, ETA 00:03:12" {
+		t.Errorf("FormatProgress() = %q", got)
+	}
+
+	if got := FormatETA(2*time.Hour + 5*time.Second); got != "02:00:05" {
+		t.Errorf("FormatETA() = %q, want 02:00:05", got)
+	}
+}
@@ -3,6 +3,8 @@ package deps
 import (
 	"fmt"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/mathisen/woeusb-go/internal/distro"
@@ -13,6 +15,7 @@ type MissingDep struct {
 	Binary      string // e.g., "wimlib-imagex"
 	PackageName string // distro-specific package name
 	Required    bool   // true if required, false if optional
+	Purpose     string // what an optional dependency is needed for, e.g. "legacy BIOS boot"
 }
 
 // Dependencies holds paths to all required external tools
@@ -21,13 +24,22 @@ type Dependencies struct {
 	Parted      string
 	Lsblk       string
 	Blockdev    string
+	Sfdisk      string
 	Mount       string
 	Umount      string
 	SevenZip    string
 	MkFat       string
 	MkNTFS      string
+	MkExFAT     string
 	GrubCmd     string
 	WimlibSplit string // wimlib-imagex for splitting WIM files
+	Sbsign      string // only checked with CheckOptions.SecureBoot
+	Mokutil     string // only checked with CheckOptions.SecureBoot
+}
+
+// CheckOptions selects optional dependency groups to check in addition to the defaults
+type CheckOptions struct {
+	SecureBoot bool // also check Secure Boot signing/enrollment tools
 }
 
 // CheckResult contains the result of dependency checking
@@ -60,6 +72,12 @@ func CheckDependencies() (*Dependencies, error) {
 // CheckDependenciesWithDistro verifies all required tools and returns detailed info
 // including distro-specific package names for missing dependencies
 func CheckDependenciesWithDistro() *CheckResult {
+	return CheckDependenciesWithOptions(CheckOptions{})
+}
+
+// CheckDependenciesWithOptions is CheckDependenciesWithDistro plus the optional
+// dependency groups selected in opts
+func CheckDependenciesWithOptions(opts CheckOptions) *CheckResult {
 	result := &CheckResult{
 		Deps:    &Dependencies{},
 		Missing: []MissingDep{},
@@ -82,9 +100,9 @@ func CheckDependenciesWithDistro() *CheckResult {
 		{"parted", &result.Deps.Parted},
 		{"lsblk", &result.Deps.Lsblk},
 		{"blockdev", &result.Deps.Blockdev},
+		{"sfdisk", &result.Deps.Sfdisk},
 		{"mount", &result.Deps.Mount},
 		{"umount", &result.Deps.Umount},
-		{"7z", &result.Deps.SevenZip},
 	}
 
 	for _, tool := range requiredTools {
@@ -99,6 +117,17 @@ func CheckDependenciesWithDistro() *CheckResult {
 		}
 	}
 
+	// Find 7z, 7zz or 7za (or the --7z-path override)
+	if path, err := FindSevenZip(); err != nil {
+		result.Missing = append(result.Missing, MissingDep{
+			Binary:      "7z",
+			PackageName: distro.GetPackageNameWithFallback("7z", distroInfo),
+			Required:    true,
+		})
+	} else {
+		result.Deps.SevenZip = path
+	}
+
 	// Find mkdosfs/mkfs.vfat/mkfs.fat (return first found)
 	fatCmds := []string{"mkdosfs", "mkfs.vfat", "mkfs.fat"}
 	fatFound := false
@@ -117,8 +146,12 @@ func CheckDependenciesWithDistro() *CheckResult {
 		})
 	}
 
-	// Find wimlib-imagex (required for Win10/11)
-	if path, err := exec.LookPath("wimlib-imagex"); err != nil {
+	// Find wimlib-imagex (required for Win10/11); wimlib's per-command frontends also work
+	path, err := exec.LookPath("wimlib-imagex")
+	if err != nil {
+		path, err = exec.LookPath("wimsplit")
+	}
+	if err != nil {
 		result.Missing = append(result.Missing, MissingDep{
 			Binary:      "wimlib-imagex",
 			PackageName: distro.GetPackageNameWithFallback("wimlib-imagex", distroInfo),
@@ -136,6 +169,25 @@ func CheckDependenciesWithDistro() *CheckResult {
 			Binary:      "mkntfs",
 			PackageName: distro.GetPackageNameWithFallback("mkntfs", distroInfo),
 			Required:    false,
+			Purpose:     "NTFS filesystem support",
+		})
+	}
+
+	// Find mkfs.exfat or mkexfatfs (optional - only needed if user forces exFAT)
+	exfatFound := false
+	for _, cmd := range []string{"mkfs.exfat", "mkexfatfs"} {
+		if path, err := exec.LookPath(cmd); err == nil {
+			result.Deps.MkExFAT = path
+			exfatFound = true
+			break
+		}
+	}
+	if !exfatFound {
+		result.Missing = append(result.Missing, MissingDep{
+			Binary:      "mkfs.exfat",
+			PackageName: distro.GetPackageNameWithFallback("mkfs.exfat", distroInfo),
+			Required:    false,
+			Purpose:     "exFAT filesystem support",
 		})
 	}
 
@@ -154,12 +206,61 @@ func CheckDependenciesWithDistro() *CheckResult {
 			Binary:      "grub-install",
 			PackageName: distro.GetPackageNameWithFallback("grub-install", distroInfo),
 			Required:    false,
+			Purpose:     "legacy BIOS boot",
 		})
 	}
 
+	if opts.SecureBoot {
+		secureBootTools := []struct {
+			binary string
+			field  *string
+		}{
+			{"sbsign", &result.Deps.Sbsign},
+			{"mokutil", &result.Deps.Mokutil},
+		}
+
+		for _, tool := range secureBootTools {
+			if path, err := exec.LookPath(tool.binary); err != nil {
+				result.Missing = append(result.Missing, MissingDep{
+					Binary:      tool.binary,
+					PackageName: distro.GetPackageNameWithFallback(tool.binary, distroInfo),
+					Required:    false,
+					Purpose:     "Secure Boot support",
+				})
+			} else {
+				*tool.field = path
+			}
+		}
+	}
+
 	return result
 }
 
+// SevenZipPath overrides the 7z binary lookup when set (--7z-path)
+var SevenZipPath string
+
+// sevenZipLookPath finds 7-Zip binaries; tests replace it
+var sevenZipLookPath = exec.LookPath
+
+// FindSevenZip returns the path of the 7-Zip binary to use: SevenZipPath if set,
+// otherwise the first of distro.SevenZipBinaries found in PATH
+func FindSevenZip() (string, error) {
+	if SevenZipPath != "" {
+		path, err := sevenZipLookPath(SevenZipPath)
+		if err != nil {
+			return "", fmt.Errorf("7z binary %s is not usable: %v", SevenZipPath, err)
+		}
+		return path, nil
+	}
+
+	for _, binary := range distro.SevenZipBinaries {
+		if path, err := sevenZipLookPath(binary); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("7z not found (tried %s)", strings.Join(distro.SevenZipBinaries, ", "))
+}
+
 // BinaryExists checks if a binary exists in PATH
 func BinaryExists(binary string) bool {
 	_, err := exec.LookPath(binary)
@@ -201,3 +302,88 @@ func GetOptionalMissing(missing []MissingDep) []MissingDep {
 	}
 	return optional
 }
+
+// Oldest tool versions known to work; older ones fail mid-run
+var (
+	MinWimlibVersion = "1.6.0" // first release that reads solid (ESD) resources
+	MinPartedVersion = "3.2"   // first release with the esp partition flag
+)
+
+// VersionWarning reports a tool older than its minimum version
+type VersionWarning struct {
+	Binary    string
+	Installed string
+	Minimum   string
+}
+
+// String describes the warning for users
+func (w VersionWarning) String() string {
+	return fmt.Sprintf("%s %s is older than %s and may fail during the write; please update it", w.Binary, w.Installed, w.Minimum)
+}
+
+// versionOutput returns the output of binary --version; tests replace it
+var versionOutput = func(binary string) (string, error) {
+	out, err := exec.Command(binary, "--version").Output()
+	return string(out), err
+}
+
+// CheckVersions returns a warning for each installed tool older than its minimum.
+// Tools that are missing or whose version cannot be parsed are skipped; missing tools
+// are reported by CheckDependencies.
+func CheckVersions() []VersionWarning {
+	checks := []struct {
+		binaries []string
+		minimum  string
+	}{
+		{[]string{"wimlib-imagex", "wimsplit"}, MinWimlibVersion},
+		{[]string{"parted"}, MinPartedVersion},
+	}
+
+	var warnings []VersionWarning
+	for _, c := range checks {
+		for _, binary := range c.binaries {
+			out, err := versionOutput(binary)
+			if err != nil {
+				continue
+			}
+			installed, ok := ParseVersion(out)
+			if ok && CompareVersions(installed, c.minimum) < 0 {
+				warnings = append(warnings, VersionWarning{Binary: binary, Installed: installed, Minimum: c.minimum})
+			}
+			break
+		}
+	}
+	return warnings
+}
+
+var versionPattern = regexp.MustCompile(`\b\d+(\.\d+)+\b`)
+
+// ParseVersion returns the first dotted version number in the first line of a
+// --version output, e.g. "3.6" from "parted (GNU parted) 3.6"
+func ParseVersion(out string) (string, bool) {
+	line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	version := versionPattern.FindString(line)
+	return version, version != ""
+}
+
+// CompareVersions compares dotted versions numerically, returning -1, 0 or 1. Missing
+// components count as 0, so 3.2 equals 3.2.0.
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
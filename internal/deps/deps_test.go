@@ -1,6 +1,8 @@
 package deps
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -133,6 +135,38 @@ func TestGetOptionalMissing(t *testing.T) {
 // For any binary name in the required list, the dependency checker SHALL correctly
 // identify whether the binary exists in PATH.
 // **Validates: Requirements 1.3**
+func TestCheckDependenciesWithOptionsSecureBoot(t *testing.T) {
+	isSecureBootTool := func(binary string) bool {
+		return binary == "sbsign" || binary == "mokutil"
+	}
+
+	// Secure Boot tools are only checked when requested
+	for _, m := range CheckDependenciesWithDistro().Missing {
+		if isSecureBootTool(m.Binary) {
+			t.Errorf("%s reported without CheckOptions.SecureBoot", m.Binary)
+		}
+	}
+
+	result := CheckDependenciesWithOptions(CheckOptions{SecureBoot: true})
+	for _, m := range result.Missing {
+		if !isSecureBootTool(m.Binary) {
+			continue
+		}
+		if m.Required {
+			t.Errorf("%s should be optional", m.Binary)
+		}
+		if m.Purpose != "Secure Boot support" {
+			t.Errorf("%s purpose = %q, want %q", m.Binary, m.Purpose, "Secure Boot support")
+		}
+	}
+	if (result.Deps.Sbsign != "") != BinaryExists("sbsign") {
+		t.Error("Sbsign path does not match BinaryExists")
+	}
+	if (result.Deps.Mokutil != "") != BinaryExists("mokutil") {
+		t.Error("Mokutil path does not match BinaryExists")
+	}
+}
+
 func TestProperty3_DependencyBinaryDetection(t *testing.T) {
 	// This property test verifies that BinaryExists correctly identifies
 	// whether a binary exists in PATH for any given binary name.
@@ -190,7 +224,7 @@ func TestProperty3_DependencyBinaryDetection(t *testing.T) {
 	if result.Deps.Lsblk != "" && !BinaryExists("lsblk") {
 		t.Error("Lsblk path set but BinaryExists returns false")
 	}
-	if result.Deps.SevenZip != "" && !BinaryExists("7z") {
+	if result.Deps.SevenZip != "" && !BinaryExists(result.Deps.SevenZip) {
 		t.Error("7z path set but BinaryExists returns false")
 	}
 	if result.Deps.WimlibSplit != "" && !BinaryExists("wimlib-imagex") {
@@ -219,3 +253,113 @@ func TestProperty3_DependencyBinaryDetection(t *testing.T) {
 		}
 	}
 }
+
+func TestFindSevenZip(t *testing.T) {
+	origLookPath, origOverride := sevenZipLookPath, SevenZipPath
+	defer func() { sevenZipLookPath, SevenZipPath = origLookPath, origOverride }()
+
+	installed := map[string]bool{}
+	sevenZipLookPath = func(file string) (string, error) {
+		if installed[file] && strings.HasPrefix(file, "/") {
+			return file, nil
+		}
+		if installed[file] {
+			return "/usr/bin/" + file, nil
+		}
+		return "", fmt.Errorf("%s: not found", file)
+	}
+
+	if _, err := FindSevenZip(); err == nil {
+		t.Error("expected an error with no 7-Zip binary installed")
+	}
+
+	installed["7za"] = true
+	installed["7zz"] = true
+	if path, err := FindSevenZip(); err != nil || path != "/usr/bin/7zz" {
+		t.Errorf("FindSevenZip() = %q, %v; want 7zz preferred over 7za", path, err)
+	}
+
+	installed["7z"] = true
+	if path, _ := FindSevenZip(); path != "/usr/bin/7z" {
+		t.Errorf("FindSevenZip() = %q; want 7z preferred", path)
+	}
+
+	SevenZipPath = "/opt/7zip/7zz"
+	if _, err := FindSevenZip(); err == nil {
+		t.Error("expected an error for an override that is not usable")
+	}
+	installed["/opt/7zip/7zz"] = true
+	if path, err := FindSevenZip(); err != nil || path != "/opt/7zip/7zz" {
+		t.Errorf("FindSevenZip() = %q, %v; want the override", path, err)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+		ok     bool
+	}{
+		{"wimlib-imagex 1.14.4 (using wimlib 1.14.4)\nCopyright 2012-2023 Eric Biggers\n", "1.14.4", true},
+		{"wimlib-imagex 1.5.3 (using wimlib 1.5.3)\n", "1.5.3", true},
+		{"parted (GNU parted) 3.6\nCopyright (C) 2023 Free Software Foundation, Inc.\n", "3.6", true},
+		{"parted (GNU parted) 2.3\n", "2.3", true},
+		{"no version here\n1.2.3\n", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseVersion(tt.output)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseVersion(%q) = %q, %v; want %q, %v", tt.output, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.14.4", "1.6.0", 1},
+		{"1.5.3", "1.6.0", -1},
+		{"3.2", "3.2.0", 0},
+		{"3.10", "3.2", 1},
+		{"2.3", "3.2", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVersions(t *testing.T) {
+	outputs := map[string]string{
+		"wimlib-imagex": "wimlib-imagex 1.5.3 (using wimlib 1.5.3)\n",
+		"parted":        "parted (GNU parted) 3.6\n",
+	}
+	orig := versionOutput
+	versionOutput = func(binary string) (string, error) {
+		out, ok := outputs[binary]
+		if !ok {
+			return "", fmt.Errorf("%s not found", binary)
+		}
+		return out, nil
+	}
+	defer func() { versionOutput = orig }()
+
+	warnings := CheckVersions()
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", warnings)
+	}
+	want := VersionWarning{Binary: "wimlib-imagex", Installed: "1.5.3", Minimum: MinWimlibVersion}
+	if warnings[0] != want {
+		t.Errorf("Warning = %+v, want %+v", warnings[0], want)
+	}
+
+	// Missing tools are left to CheckDependencies
+	outputs = map[string]string{}
+	if warnings := CheckVersions(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings for missing tools, got %v", warnings)
+	}
+}
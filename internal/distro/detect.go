@@ -2,8 +2,10 @@ package distro
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"strings"
 )
 
@@ -38,6 +40,11 @@ var packageManagers = map[string]string{
 	"suse":                "zypper",
 	"gentoo":              "emerge",
 	"void":                "xbps",
+	"nixos":               "nix",
+	"alpine":              "apk",
+	"solus":               "eopkg",
+	"mageia":              "urpmi",
+	"openmandriva":        "dnf",
 }
 
 // idLikeToPackageManager maps ID_LIKE values to package managers
@@ -50,9 +57,52 @@ var idLikeToPackageManager = map[string]string{
 	"suse":   "zypper",
 }
 
-// Detect reads /etc/os-release and returns distro info
+// osReleasePath is read by Detect; tests replace it
+var osReleasePath = "/etc/os-release"
+
+// lsbRelease returns the output of lsb_release -si; tests replace it
+var lsbRelease = func() (string, error) {
+	out, err := exec.Command("lsb_release", "-si").Output()
+	return string(out), err
+}
+
+// lsbDistributorIDs maps lsb_release distributor IDs (lowercased) to os-release IDs
+// where they differ
+var lsbDistributorIDs = map[string]string{
+	"manjarolinux":      "manjaro",
+	"redhatenterprise":  "rhel",
+	"rockylinux":        "rocky",
+	"openmandrivalinux": "openmandriva",
+	"voidlinux":         "void",
+	"elementaryos":      "elementary",
+}
+
+// Detect reads /etc/os-release and returns distro info. Systems without it are
+// identified by lsb_release -si.
 func Detect() (*Info, error) {
-	return DetectFromFile("/etc/os-release")
+	info, err := DetectFromFile(osReleasePath)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return info, err
+	}
+
+	out, lsbErr := lsbRelease()
+	if lsbErr != nil {
+		return nil, fmt.Errorf("%w (lsb_release also failed: %v)", err, lsbErr)
+	}
+	return InfoFromLSBRelease(out), nil
+}
+
+// InfoFromLSBRelease returns distro info for the distributor ID printed by
+// lsb_release -si, e.g. "Ubuntu" or "ManjaroLinux"
+func InfoFromLSBRelease(distributor string) *Info {
+	distributor = strings.TrimSpace(distributor)
+	id := strings.ToLower(distributor)
+	if mapped, ok := lsbDistributorIDs[id]; ok {
+		id = mapped
+	}
+	info := &Info{ID: id, Name: distributor}
+	info.PackageManager = info.GetPackageManager()
+	return info
 }
 
 // DetectFromFile reads the specified os-release file and returns distro info
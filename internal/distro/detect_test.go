@@ -1,6 +1,7 @@
 package distro
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -213,6 +214,103 @@ VERSION_ID="13"`,
 				PackageManager: "apt",
 			},
 		},
+		{
+			name: "NixOS",
+			content: `ANSI_COLOR="1;34"
+BUILD_ID="25.05.20250601.abcdef0"
+DOCUMENTATION_URL="https://nixos.org/learn.html"
+ID=nixos
+ID_LIKE=""
+NAME=NixOS
+PRETTY_NAME="NixOS 25.05 (Warbler)"
+VERSION="25.05 (Warbler)"
+VERSION_CODENAME=warbler
+VERSION_ID="25.05"`,
+			expected: Info{
+				ID:             "nixos",
+				IDLike:         "",
+				Name:           "NixOS",
+				Version:        "25.05 (Warbler)",
+				PackageManager: "nix",
+			},
+		},
+		{
+			name: "Alpine",
+			content: `NAME="Alpine Linux"
+ID=alpine
+VERSION_ID=3.22.0
+PRETTY_NAME="Alpine Linux v3.22"
+HOME_URL="https://alpinelinux.org/"`,
+			expected: Info{
+				ID:             "alpine",
+				IDLike:         "",
+				Name:           "Alpine Linux",
+				Version:        "3.22.0",
+				PackageManager: "apk",
+			},
+		},
+		{
+			name: "Void musl",
+			content: `NAME="Void"
+ID="void"
+PRETTY_NAME="Void Linux"
+HOME_URL="https://voidlinux.org/"
+DOCUMENTATION_URL="https://docs.voidlinux.org/"`,
+			expected: Info{
+				ID:             "void",
+				IDLike:         "",
+				Name:           "Void",
+				Version:        "",
+				PackageManager: "xbps",
+			},
+		},
+		{
+			name: "Solus",
+			content: `NAME="Solus"
+VERSION="4.7"
+ID="solus"
+VERSION_CODENAME=endurance
+VERSION_ID="4.7"
+PRETTY_NAME="Solus 4.7 Endurance"`,
+			expected: Info{
+				ID:             "solus",
+				IDLike:         "",
+				Name:           "Solus",
+				Version:        "4.7",
+				PackageManager: "eopkg",
+			},
+		},
+		{
+			name: "Mageia",
+			content: `NAME="Mageia"
+VERSION="9"
+ID=mageia
+VERSION_ID=9
+ID_LIKE="mandriva fedora"
+PRETTY_NAME="Mageia 9"`,
+			expected: Info{
+				ID:             "mageia",
+				IDLike:         "mandriva fedora",
+				Name:           "Mageia",
+				Version:        "9",
+				PackageManager: "urpmi",
+			},
+		},
+		{
+			name: "OpenMandriva",
+			content: `NAME="OpenMandriva Lx"
+VERSION="6.0 (Vanadium) Rock"
+ID="openmandriva"
+VERSION_ID="6.0"
+ID_LIKE="mandriva fedora"`,
+			expected: Info{
+				ID:             "openmandriva",
+				IDLike:         "mandriva fedora",
+				Name:           "OpenMandriva Lx",
+				Version:        "6.0 (Vanadium) Rock",
+				PackageManager: "dnf",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -279,3 +377,54 @@ func TestGetPackageManager(t *testing.T) {
 		})
 	}
 }
+
+// TestDetect_LSBReleaseFallback tests that lsb_release is used without os-release
+func TestDetect_LSBReleaseFallback(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "distro-lsb-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	origPath, origLSB := osReleasePath, lsbRelease
+	defer func() { osReleasePath, lsbRelease = origPath, origLSB }()
+	osReleasePath = tmpDir + "/os-release"
+	lsbRelease = func() (string, error) { return "ManjaroLinux\n", nil }
+
+	info, err := Detect()
+	if err != nil {
+		t.Fatalf("Detect failed: %v", err)
+	}
+	if info.ID != "manjaro" || info.Name != "ManjaroLinux" || info.PackageManager != "pacman" {
+		t.Errorf("Detect() = %+v, want manjaro with pacman", info)
+	}
+
+	// Without lsb_release the missing os-release is reported
+	lsbRelease = func() (string, error) { return "", fmt.Errorf("not found") }
+	if _, err := Detect(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+// TestInfoFromLSBRelease tests mapping lsb_release distributor IDs
+func TestInfoFromLSBRelease(t *testing.T) {
+	tests := []struct {
+		distributor string
+		id          string
+		pm          string
+	}{
+		{"Ubuntu", "ubuntu", "apt"},
+		{"Fedora", "fedora", "dnf"},
+		{"Alpine", "alpine", "apk"},
+		{"Solus", "solus", "eopkg"},
+		{"Mageia", "mageia", "urpmi"},
+		{"OpenMandrivaLinux", "openmandriva", "dnf"},
+		{"Haiku", "haiku", ""},
+	}
+	for _, tt := range tests {
+		info := InfoFromLSBRelease(tt.distributor)
+		if info.ID != tt.id || info.PackageManager != tt.pm {
+			t.Errorf("InfoFromLSBRelease(%q) = %+v, want ID %q, package manager %q", tt.distributor, info, tt.id, tt.pm)
+		}
+	}
+}
@@ -10,6 +10,7 @@ var RequiredBinaries = []string{
 	"parted",
 	"lsblk",
 	"blockdev",
+	"sfdisk",
 	"mount",
 	"umount",
 	"7z",
@@ -17,16 +18,33 @@ var RequiredBinaries = []string{
 	"wimlib-imagex",
 }
 
+// SevenZipBinaries lists the binaries that satisfy the "7z" requirement, in order of
+// preference: p7zip's 7z, 7-Zip's own 7zz and p7zip's standalone 7za
+var SevenZipBinaries = []string{
+	"7z",
+	"7zz",
+	"7za",
+}
+
 // OptionalBinaries lists optional binary dependencies
 var OptionalBinaries = []string{
 	"grub-install",
 	"mkntfs",
+	"mkfs.exfat",
+}
+
+// SecureBootBinaries lists optional tools for signing and enrolling Secure Boot keys.
+// They are only checked when Secure Boot support is requested.
+var SecureBootBinaries = []string{
+	"sbsign",
+	"mokutil",
 }
 
 // packageMappings maps binary names to distro-specific package names
 // Supported distros: Ubuntu, Debian, Linux Mint, Pop!_OS, Elementary, Zorin,
 // Fedora, RHEL, CentOS, Rocky, AlmaLinux, Arch, Manjaro, EndeavourOS,
-// openSUSE (Tumbleweed, Leap), Void, Gentoo
+// openSUSE (Tumbleweed, Leap), Void, Gentoo, NixOS, Alpine, Solus, Mageia,
+// OpenMandriva
 var packageMappings = map[string]map[string]string{
 	"wimlib-imagex": {
 		// Debian-based
@@ -52,8 +70,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "wimtools",
 		"suse":                "wimtools",
 		// Other
-		"void":   "wimlib",
-		"gentoo": "app-arch/wimlib",
+		"void":         "wimlib",
+		"gentoo":       "app-arch/wimlib",
+		"nixos":        "wimlib",
+		"alpine":       "wimlib",
+		"solus":        "wimlib",
+		"mageia":       "wimlib",
+		"openmandriva": "wimlib",
 	},
 	"7z": {
 		// Debian-based
@@ -79,8 +102,68 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "p7zip",
 		"suse":                "p7zip",
 		// Other
-		"void":   "p7zip",
-		"gentoo": "app-arch/p7zip",
+		"void":         "p7zip",
+		"gentoo":       "app-arch/p7zip",
+		"nixos":        "p7zip",
+		"alpine":       "p7zip",
+		"solus":        "p7zip",
+		"mageia":       "p7zip",
+		"openmandriva": "p7zip",
+	},
+	"7zz": {
+		// Debian-based
+		"ubuntu":     "7zip",
+		"debian":     "7zip",
+		"linuxmint":  "7zip",
+		"pop":        "7zip",
+		"elementary": "7zip",
+		"zorin":      "7zip",
+		// RHEL-based
+		"fedora": "7zip",
+		// Arch-based
+		"arch":        "7zip",
+		"manjaro":     "7zip",
+		"endeavouros": "7zip",
+		// SUSE-based
+		"opensuse":            "7zip",
+		"opensuse-tumbleweed": "7zip",
+		// Other
+		"void":   "7zip",
+		"gentoo": "app-arch/7zip",
+		"nixos":  "_7zz",
+		"alpine": "7zip",
+	},
+	"7za": {
+		// Debian-based
+		"ubuntu":     "p7zip",
+		"debian":     "p7zip",
+		"linuxmint":  "p7zip",
+		"pop":        "p7zip",
+		"elementary": "p7zip",
+		"zorin":      "p7zip",
+		// RHEL-based
+		"fedora":    "p7zip",
+		"rhel":      "p7zip",
+		"centos":    "p7zip",
+		"rocky":     "p7zip",
+		"almalinux": "p7zip",
+		// Arch-based
+		"arch":        "p7zip",
+		"manjaro":     "p7zip",
+		"endeavouros": "p7zip",
+		// SUSE-based
+		"opensuse":            "p7zip",
+		"opensuse-tumbleweed": "p7zip",
+		"opensuse-leap":       "p7zip",
+		"suse":                "p7zip",
+		// Other
+		"void":         "p7zip",
+		"gentoo":       "app-arch/p7zip",
+		"nixos":        "p7zip",
+		"alpine":       "p7zip",
+		"solus":        "p7zip",
+		"mageia":       "p7zip",
+		"openmandriva": "p7zip",
 	},
 	"mkdosfs": {
 		// Debian-based
@@ -106,8 +189,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "dosfstools",
 		"suse":                "dosfstools",
 		// Other
-		"void":   "dosfstools",
-		"gentoo": "sys-fs/dosfstools",
+		"void":         "dosfstools",
+		"gentoo":       "sys-fs/dosfstools",
+		"nixos":        "dosfstools",
+		"alpine":       "dosfstools",
+		"solus":        "dosfstools",
+		"mageia":       "dosfstools",
+		"openmandriva": "dosfstools",
 	},
 	"parted": {
 		// Debian-based
@@ -133,8 +221,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "parted",
 		"suse":                "parted",
 		// Other
-		"void":   "parted",
-		"gentoo": "sys-block/parted",
+		"void":         "parted",
+		"gentoo":       "sys-block/parted",
+		"nixos":        "parted",
+		"alpine":       "parted",
+		"solus":        "parted",
+		"mageia":       "parted",
+		"openmandriva": "parted",
 	},
 	"wipefs": {
 		// Debian-based
@@ -160,8 +253,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "util-linux",
 		"suse":                "util-linux",
 		// Other
-		"void":   "util-linux",
-		"gentoo": "sys-apps/util-linux",
+		"void":         "util-linux",
+		"gentoo":       "sys-apps/util-linux",
+		"nixos":        "util-linux",
+		"alpine":       "util-linux",
+		"solus":        "util-linux",
+		"mageia":       "util-linux",
+		"openmandriva": "util-linux",
 	},
 	"lsblk": {
 		// Debian-based
@@ -187,8 +285,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "util-linux",
 		"suse":                "util-linux",
 		// Other
-		"void":   "util-linux",
-		"gentoo": "sys-apps/util-linux",
+		"void":         "util-linux",
+		"gentoo":       "sys-apps/util-linux",
+		"nixos":        "util-linux",
+		"alpine":       "util-linux",
+		"solus":        "util-linux",
+		"mageia":       "util-linux",
+		"openmandriva": "util-linux",
 	},
 	"blockdev": {
 		// Debian-based
@@ -214,8 +317,45 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "util-linux",
 		"suse":                "util-linux",
 		// Other
-		"void":   "util-linux",
-		"gentoo": "sys-apps/util-linux",
+		"void":         "util-linux",
+		"gentoo":       "sys-apps/util-linux",
+		"nixos":        "util-linux",
+		"alpine":       "util-linux",
+		"solus":        "util-linux",
+		"mageia":       "util-linux",
+		"openmandriva": "util-linux",
+	},
+	"sfdisk": {
+		// Debian-based (split out of util-linux)
+		"ubuntu":     "fdisk",
+		"debian":     "fdisk",
+		"linuxmint":  "fdisk",
+		"pop":        "fdisk",
+		"elementary": "fdisk",
+		"zorin":      "fdisk",
+		// RHEL-based
+		"fedora":    "util-linux",
+		"rhel":      "util-linux",
+		"centos":    "util-linux",
+		"rocky":     "util-linux",
+		"almalinux": "util-linux",
+		// Arch-based
+		"arch":        "util-linux",
+		"manjaro":     "util-linux",
+		"endeavouros": "util-linux",
+		// SUSE-based
+		"opensuse":            "util-linux",
+		"opensuse-tumbleweed": "util-linux",
+		"opensuse-leap":       "util-linux",
+		"suse":                "util-linux",
+		// Other
+		"void":         "util-linux",
+		"gentoo":       "sys-apps/util-linux",
+		"nixos":        "util-linux",
+		"alpine":       "util-linux",
+		"solus":        "util-linux",
+		"mageia":       "util-linux",
+		"openmandriva": "util-linux",
 	},
 	"mount": {
 		// Debian-based
@@ -241,8 +381,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "util-linux",
 		"suse":                "util-linux",
 		// Other
-		"void":   "util-linux",
-		"gentoo": "sys-apps/util-linux",
+		"void":         "util-linux",
+		"gentoo":       "sys-apps/util-linux",
+		"nixos":        "util-linux",
+		"alpine":       "util-linux",
+		"solus":        "util-linux",
+		"mageia":       "util-linux",
+		"openmandriva": "util-linux",
 	},
 	"umount": {
 		// Debian-based
@@ -268,8 +413,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "util-linux",
 		"suse":                "util-linux",
 		// Other
-		"void":   "util-linux",
-		"gentoo": "sys-apps/util-linux",
+		"void":         "util-linux",
+		"gentoo":       "sys-apps/util-linux",
+		"nixos":        "util-linux",
+		"alpine":       "util-linux",
+		"solus":        "util-linux",
+		"mageia":       "util-linux",
+		"openmandriva": "util-linux",
 	},
 	"grub-install": {
 		// Debian-based (grub-pc for BIOS, grub-efi-amd64 for UEFI)
@@ -295,8 +445,13 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "grub2",
 		"suse":                "grub2",
 		// Other
-		"void":   "grub",
-		"gentoo": "sys-boot/grub",
+		"void":         "grub",
+		"gentoo":       "sys-boot/grub",
+		"nixos":        "grub2",
+		"alpine":       "grub-bios",
+		"solus":        "grub2",
+		"mageia":       "grub2",
+		"openmandriva": "grub2",
 	},
 	"mkntfs": {
 		// Debian-based
@@ -322,8 +477,109 @@ var packageMappings = map[string]map[string]string{
 		"opensuse-leap":       "ntfs-3g",
 		"suse":                "ntfs-3g",
 		// Other
-		"void":   "ntfs-3g",
-		"gentoo": "sys-fs/ntfs3g",
+		"void":         "ntfs-3g",
+		"gentoo":       "sys-fs/ntfs3g",
+		"nixos":        "ntfs3g",
+		"alpine":       "ntfs-3g-progs",
+		"solus":        "ntfs-3g",
+		"mageia":       "ntfs-3g",
+		"openmandriva": "ntfs-3g",
+	},
+	"mkfs.exfat": {
+		// Debian-based
+		"ubuntu":     "exfatprogs",
+		"debian":     "exfatprogs",
+		"linuxmint":  "exfatprogs",
+		"pop":        "exfatprogs",
+		"elementary": "exfatprogs",
+		"zorin":      "exfatprogs",
+		// RHEL-based
+		"fedora":    "exfatprogs",
+		"rhel":      "exfatprogs",
+		"centos":    "exfatprogs",
+		"rocky":     "exfatprogs",
+		"almalinux": "exfatprogs",
+		// Arch-based
+		"arch":        "exfatprogs",
+		"manjaro":     "exfatprogs",
+		"endeavouros": "exfatprogs",
+		// SUSE-based
+		"opensuse":            "exfatprogs",
+		"opensuse-tumbleweed": "exfatprogs",
+		"opensuse-leap":       "exfatprogs",
+		"suse":                "exfatprogs",
+		// Other
+		"void":         "exfatprogs",
+		"gentoo":       "sys-fs/exfatprogs",
+		"nixos":        "exfatprogs",
+		"alpine":       "exfatprogs",
+		"solus":        "exfatprogs",
+		"mageia":       "exfatprogs",
+		"openmandriva": "exfatprogs",
+	},
+	"sbsign": {
+		// Debian-based
+		"ubuntu":     "sbsigntool",
+		"debian":     "sbsigntool",
+		"linuxmint":  "sbsigntool",
+		"pop":        "sbsigntool",
+		"elementary": "sbsigntool",
+		"zorin":      "sbsigntool",
+		// RHEL-based
+		"fedora":    "sbsigntools",
+		"rhel":      "sbsigntools",
+		"centos":    "sbsigntools",
+		"rocky":     "sbsigntools",
+		"almalinux": "sbsigntools",
+		// Arch-based
+		"arch":        "sbsigntools",
+		"manjaro":     "sbsigntools",
+		"endeavouros": "sbsigntools",
+		// SUSE-based
+		"opensuse":            "sbsigntools",
+		"opensuse-tumbleweed": "sbsigntools",
+		"opensuse-leap":       "sbsigntools",
+		"suse":                "sbsigntools",
+		// Other
+		"void":         "sbsigntool",
+		"gentoo":       "app-crypt/sbsigntools",
+		"nixos":        "sbsigntool",
+		"alpine":       "sbsigntool",
+		"solus":        "sbsigntools",
+		"mageia":       "sbsigntools",
+		"openmandriva": "sbsigntools",
+	},
+	"mokutil": {
+		// Debian-based
+		"ubuntu":     "mokutil",
+		"debian":     "mokutil",
+		"linuxmint":  "mokutil",
+		"pop":        "mokutil",
+		"elementary": "mokutil",
+		"zorin":      "mokutil",
+		// RHEL-based
+		"fedora":    "mokutil",
+		"rhel":      "mokutil",
+		"centos":    "mokutil",
+		"rocky":     "mokutil",
+		"almalinux": "mokutil",
+		// Arch-based
+		"arch":        "mokutil",
+		"manjaro":     "mokutil",
+		"endeavouros": "mokutil",
+		// SUSE-based
+		"opensuse":            "mokutil",
+		"opensuse-tumbleweed": "mokutil",
+		"opensuse-leap":       "mokutil",
+		"suse":                "mokutil",
+		// Other
+		"void":         "mokutil",
+		"gentoo":       "sys-boot/mokutil",
+		"nixos":        "mokutil",
+		"alpine":       "mokutil",
+		"solus":        "mokutil",
+		"mageia":       "mokutil",
+		"openmandriva": "mokutil",
 	},
 }
 
@@ -349,6 +605,22 @@ var installCommands = map[string]string{
 	"suse":                "sudo zypper install",
 	"void":                "sudo xbps-install -S",
 	"gentoo":              "sudo emerge",
+	"nixos":               "nix-env -iA",
+	"alpine":              "sudo apk add",
+	"solus":               "sudo eopkg install",
+	"mageia":              "sudo urpmi",
+	"openmandriva":        "sudo dnf install",
+}
+
+// packageAttrPrefixes is prepended to each package in install commands for distros
+// whose package manager takes attribute paths rather than names
+var packageAttrPrefixes = map[string]string{
+	"nixos": "nixos.",
+}
+
+// installNotes are appended to install commands as a shell comment
+var installNotes = map[string]string{
+	"nixos": "or add them to environment.systemPackages in /etc/nixos/configuration.nix",
 }
 
 // idLikeToInstallCommand maps ID_LIKE values to install commands
@@ -408,17 +680,7 @@ func GetInstallCommand(distroID string, packages []string) string {
 		return "# Install packages using your package manager: " + strings.Join(packages, " ")
 	}
 
-	// Deduplicate packages
-	seen := make(map[string]bool)
-	var uniquePackages []string
-	for _, pkg := range packages {
-		if !seen[pkg] {
-			seen[pkg] = true
-			uniquePackages = append(uniquePackages, pkg)
-		}
-	}
-
-	return prefix + " " + strings.Join(uniquePackages, " ")
+	return buildInstallCommand(prefix, distroID, packages)
 }
 
 // GetInstallCommandWithInfo returns the full install command using distro Info
@@ -432,17 +694,41 @@ func GetInstallCommandWithInfo(info *Info, packages []string) string {
 		return "# Install packages using your package manager: " + strings.Join(packages, " ")
 	}
 
-	// Deduplicate packages
+	return buildInstallCommand(prefix, info.ID, packages)
+}
+
+// buildInstallCommand joins prefix and the deduplicated packages, adding distroID's
+// attribute prefix and note if it has them
+func buildInstallCommand(prefix, distroID string, packages []string) string {
 	seen := make(map[string]bool)
 	var uniquePackages []string
 	for _, pkg := range packages {
 		if !seen[pkg] {
 			seen[pkg] = true
-			uniquePackages = append(uniquePackages, pkg)
+			uniquePackages = append(uniquePackages, packageAttrPrefixes[distroID]+pkg)
 		}
 	}
 
-	return prefix + " " + strings.Join(uniquePackages, " ")
+	command := prefix + " " + strings.Join(uniquePackages, " ")
+	if note, ok := installNotes[distroID]; ok {
+		command += " # " + note
+	}
+	return command
+}
+
+// CanInstall reports whether GetInstallCommandWithInfo returns a runnable command for
+// info rather than manual instructions
+func CanInstall(info *Info) bool {
+	return info != nil && getInstallPrefixWithInfo(info) != ""
+}
+
+// CommandForUser adapts an install command for the current user: root runs the
+// package manager directly, without the leading sudo
+func CommandForUser(command string, isRoot bool) string {
+	if isRoot {
+		return strings.TrimPrefix(command, "sudo ")
+	}
+	return command
 }
 
 // getInstallPrefix returns the install command prefix for a distro ID
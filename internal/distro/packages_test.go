@@ -12,6 +12,7 @@ import (
 var SupportedDistros = []string{
 	"ubuntu", "debian", "linuxmint", "fedora", "arch", "manjaro",
 	"opensuse", "opensuse-tumbleweed", "opensuse-leap",
+	"nixos", "alpine", "solus", "mageia", "openmandriva",
 }
 
 // PackageTestInput represents input for package mapping property tests
@@ -183,6 +184,11 @@ func TestGetInstallCommand_AllDistros(t *testing.T) {
 		{"opensuse", "sudo zypper install"},
 		{"opensuse-tumbleweed", "sudo zypper install"},
 		{"opensuse-leap", "sudo zypper install"},
+		{"nixos", "nix-env -iA"},
+		{"alpine", "sudo apk add"},
+		{"solus", "sudo eopkg install"},
+		{"mageia", "sudo urpmi"},
+		{"openmandriva", "sudo dnf install"},
 	}
 
 	for _, tt := range tests {
@@ -308,3 +314,42 @@ func TestGetInstallCommandWithInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestCanInstall(t *testing.T) {
+	tests := []struct {
+		name string
+		info *Info
+		want bool
+	}{
+		{"nil info", nil, false},
+		{"known ID", &Info{ID: "fedora"}, true},
+		{"ID_LIKE fallback", &Info{ID: "kali", IDLike: "debian"}, true},
+		{"unknown", &Info{ID: "haiku"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanInstall(tt.info); got != tt.want {
+				t.Errorf("CanInstall() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandForUser(t *testing.T) {
+	cmd := GetInstallCommand("arch", []string{"parted"})
+	if got := CommandForUser(cmd, false); got != "sudo pacman -S parted" {
+		t.Errorf("CommandForUser(non-root) = %q", got)
+	}
+	if got := CommandForUser(cmd, true); got != "pacman -S parted" {
+		t.Errorf("CommandForUser(root) = %q", got)
+	}
+}
+
+// TestGetInstallCommand_NixOS tests that NixOS packages are installed by attribute path
+func TestGetInstallCommand_NixOS(t *testing.T) {
+	result := GetInstallCommandWithInfo(&Info{ID: "nixos"}, []string{"wimlib", "p7zip", "wimlib"})
+	want := "nix-env -iA nixos.wimlib nixos.p7zip # " + installNotes["nixos"]
+	if result != want {
+		t.Errorf("GetInstallCommandWithInfo(nixos) = %q, want %q", result, want)
+	}
+}
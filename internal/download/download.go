@@ -0,0 +1,177 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mathisen/woeusb-go/internal/fingerprint"
+)
+
+// ProgressFunc is called while downloading; total is -1 if the server did not send a size
+type ProgressFunc func(downloaded, total int64)
+
+// partSuffix marks an incomplete download that File can resume
+const partSuffix = ".part"
+
+// client follows redirects (the default policy) and only times out while connecting
+// or waiting for headers, since the body of an ISO can take a long time to arrive
+var client = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// IsURL reports whether s is an http:// or https:// URL
+func IsURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// CachePath returns where a download of rawURL is stored in the user cache directory.
+// The name is prefixed with a hash of the URL so different sources never collide.
+func CachePath(rawURL string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %v", err)
+	}
+
+	name := "download.iso"
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "/" && base != "." {
+			name = base
+		}
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+
+	return filepath.Join(cacheDir, "woeusb-go", "downloads", hex.EncodeToString(sum[:4])+"-"+name), nil
+}
+
+// File downloads rawURL to dest. An existing dest is reused as is; an existing
+// dest+".part" from an interrupted download is resumed with a Range request.
+func File(rawURL, dest string, progressFn ProgressFunc) error {
+	if info, err := os.Stat(dest); err == nil && info.Mode().IsRegular() {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", dest, err)
+	}
+
+	partPath := dest + partSuffix
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %v", rawURL, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download from %s: %v", rawURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file already holds everything the server has
+		return os.Rename(partPath, dest)
+	case resp.StatusCode == http.StatusOK:
+		// Server ignored the Range header (or there was nothing to resume): start over
+		flags |= os.O_TRUNC
+		offset = 0
+	default:
+		return fmt.Errorf("download failed with status: %s", resp.Status)
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %v", partPath, err)
+	}
+	defer func() { _ = out.Close() }()
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	if err := copyWithProgress(out, resp.Body, offset, total, progressFn); err != nil {
+		return fmt.Errorf("failed to write downloaded data: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write downloaded data: %v", err)
+	}
+
+	return os.Rename(partPath, dest)
+}
+
+// copyWithProgress copies src to dst, reporting the running total starting at offset
+func copyWithProgress(dst io.Writer, src io.Reader, offset, total int64, progressFn ProgressFunc) error {
+	buf := make([]byte, 1024*1024)
+	downloaded := offset
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			downloaded += int64(n)
+			if progressFn != nil {
+				progressFn(downloaded, total)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// VerifySHA256 checks that the file at path has the expected hex SHA-256
+func VerifySHA256(path, expected string) error {
+	fp, err := fingerprint.ComputeWithCache(path, "")
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(fp.SHA256, strings.TrimSpace(expected)) {
+		return fmt.Errorf("SHA-256 mismatch for %s: got %s, expected %s", path, fp.SHA256, expected)
+	}
+	return nil
+}
+
+// Remove deletes a downloaded file and any partial download next to it
+func Remove(dest string) error {
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(dest + partSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsURL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"https://example.com/win11.iso", true},
+		{"http://example.com/win11.iso", true},
+		{"ftp://example.com/win11.iso", false},
+		{"/home/user/win11.iso", false},
+		{"win11.iso", false},
+		{"https://", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsURL(tt.input); got != tt.expected {
+			t.Errorf("IsURL(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestCachePath(t *testing.T) {
+	p1, err := CachePath("https://example.com/a/win11.iso")
+	if err != nil {
+		t.Skipf("No cache directory: %v", err)
+	}
+	p2, _ := CachePath("https://mirror.example.org/win11.iso")
+
+	if !strings.HasSuffix(p1, "-win11.iso") {
+		t.Errorf("CachePath() = %s, want the URL file name", p1)
+	}
+	if p1 == p2 {
+		t.Error("Different URLs with the same file name should not share a cache path")
+	}
+}
+
+func TestFileResumeAndRedirect(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/win.iso", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "win.iso", time.Time{}, strings.NewReader(content))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/win.iso", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "download_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// Simulate an interrupted download holding the first 4000 bytes
+	dest := filepath.Join(tmpDir, "win.iso")
+	if err := os.WriteFile(dest+partSuffix, []byte(content[:4000]), 0644); err != nil {
+		t.Fatalf("Failed to create partial file: %v", err)
+	}
+
+	var lastDownloaded, lastTotal int64
+	err = File(server.URL+"/redirect", dest, func(downloaded, total int64) {
+		lastDownloaded, lastTotal = downloaded, total
+	})
+	if err != nil {
+		t.Fatalf("File() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("Failed to read download: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("Downloaded %d bytes, content mismatch", len(data))
+	}
+	if lastDownloaded != int64(len(content)) || lastTotal != int64(len(content)) {
+		t.Errorf("Last progress = %d/%d, want %d/%d", lastDownloaded, lastTotal, len(content), len(content))
+	}
+	if _, err := os.Stat(dest + partSuffix); !os.IsNotExist(err) {
+		t.Error("Partial file should be gone after a complete download")
+	}
+
+	if err := Remove(dest); err != nil {
+		t.Errorf("Remove() failed: %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("Remove() left the download behind")
+	}
+}
+
+func TestFileHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "download_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := File(server.URL+"/missing.iso", filepath.Join(tmpDir, "missing.iso"), nil); err == nil {
+		t.Error("Expected error for 404 response")
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "verify*.iso")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	_, _ = fmt.Fprint(tmpFile, "abc")
+	_ = tmpFile.Close()
+
+	if err := VerifySHA256(tmpFile.Name(), "BA7816BF8F01CFEA414140DE5DAE2223B00361A396177A9CB410FF61F20015AD"); err != nil {
+		t.Errorf("VerifySHA256() with matching hash = %v", err)
+	}
+	if err := VerifySHA256(tmpFile.Name(), "00"); err == nil {
+		t.Error("Expected error for mismatching hash")
+	}
+}
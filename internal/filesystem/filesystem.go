@@ -1,47 +1,201 @@
 package filesystem
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 const (
 	// FAT32MaxFileSize is the maximum file size supported by FAT32 (4GB - 1 byte)
 	FAT32MaxFileSize = 4*1024*1024*1024 - 1 // 4,294,967,295 bytes
+
+	// FAT32MinVolumeSize is the smallest FAT32 volume: 65525 clusters of 512 bytes
+	FAT32MinVolumeSize = 65525 * 512
+	// FAT32WindowsMaxVolumeSize is the largest FAT32 volume Windows' own tools create (32GB).
+	// Larger ones work, but some firmware and tools do not expect them.
+	FAT32WindowsMaxVolumeSize = 32 * 1024 * 1024 * 1024
+	// FAT32MaxVolumeSize is the largest volume mkdosfs can format: 2^32 sectors of 512 bytes (2TiB)
+	FAT32MaxVolumeSize = 1 << 32 * 512
+
+	// NTFSMinVolumeSize is the smallest volume mkntfs formats (1MiB)
+	NTFSMinVolumeSize = 1024 * 1024
+	// ExFATMinVolumeSize is the smallest volume mkfs.exfat formats (1MiB)
+	ExFATMinVolumeSize = 1024 * 1024
 )
 
 // FormatFAT32 formats a partition with FAT32 filesystem
 func FormatFAT32(partition string) error {
-	cmd := exec.Command("mkdosfs", "-F", "32", partition)
+	return FormatFAT32WithOptions(partition, FATOptions{})
+}
+
+// FATOptions configures FormatFAT32WithOptions
+type FATOptions struct {
+	VolumeID string // Volume serial as 8 hex digits (see NormalizeFATVolumeID); "" lets mkdosfs pick a random one
+}
+
+// FormatFAT32WithOptions formats a partition with FAT32, applying opts
+func FormatFAT32WithOptions(partition string, opts FATOptions) error {
+	args := []string{"-F", "32"}
+	if opts.VolumeID != "" {
+		id, err := NormalizeFATVolumeID(opts.VolumeID)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-i", id)
+	}
+	args = append(args, partition)
+
+	cmd := exec.Command("mkdosfs", args...)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to format %s as FAT32: %v", partition, err)
 	}
 	return nil
 }
 
+// exfatFormatters are the exFAT mkfs commands tried in order: exfatprogs, then exfat-utils
+var exfatFormatters = []string{"mkfs.exfat", "mkexfatfs"}
+
+// FindExFATFormatter returns the first available exFAT mkfs command
+func FindExFATFormatter() (string, error) {
+	for _, name := range exfatFormatters {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no exFAT formatter found (tried %s)", strings.Join(exfatFormatters, ", "))
+}
+
+// FormatExFAT formats a partition with exFAT and sets its label. exFAT has no 4GB file
+// size limit, so large WIMs do not need to be split.
+func FormatExFAT(partition, label string) error {
+	mkfs, err := FindExFATFormatter()
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command(mkfs, partition).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to format %s as exFAT: %v: %s", partition, err, strings.TrimSpace(string(out)))
+	}
+
+	if label != "" {
+		return SetExFATLabel(partition, label)
+	}
+	return nil
+}
+
+// fatVolumeID matches a FAT volume serial, with or without the dash blkid shows
+var fatVolumeID = regexp.MustCompile(`^[0-9A-Fa-f]{4}-?[0-9A-Fa-f]{4}$`)
+
+// NormalizeFATVolumeID checks a FAT volume serial given as 8 hex digits, optionally
+// written as XXXX-XXXX like blkid prints it, and returns it in the form mkdosfs -i takes
+func NormalizeFATVolumeID(id string) (string, error) {
+	if !fatVolumeID.MatchString(id) {
+		return "", fmt.Errorf("invalid FAT volume ID %q: use 8 hex digits, e.g. 1234ABCD or 1234-ABCD", id)
+	}
+	return strings.ToUpper(strings.ReplaceAll(id, "-", "")), nil
+}
+
 // FormatNTFS formats a partition with NTFS filesystem and sets a label
 func FormatNTFS(partition, label string) error {
-	args := []string{"--quick"}
+	return FormatNTFSWithProgress(partition, label, true, nil)
+}
+
+// FormatProgressFunc receives the completion percentage of a running format
+type FormatProgressFunc func(percent int)
+
+// FormatNTFSWithProgress formats a partition with NTFS, forwarding the percentage mkntfs
+// prints while zeroing to progressFn. A quick format prints no progress and just returns.
+func FormatNTFSWithProgress(partition, label string, quick bool, progressFn FormatProgressFunc) error {
+	var args []string
+	if quick {
+		args = append(args, "--quick")
+	}
 	if label != "" {
 		args = append(args, "--label", label)
 	}
 	args = append(args, partition)
 
 	cmd := exec.Command("mkntfs", args...)
-	if err := cmd.Run(); err != nil {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to format %s as NTFS: %v", partition, err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to format %s as NTFS: %v", partition, err)
+	}
+
+	parseMkntfsProgress(stdout, progressFn)
+
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("failed to format %s as NTFS: %v: %s", partition, err, msg)
+		}
 		return fmt.Errorf("failed to format %s as NTFS: %v", partition, err)
 	}
 	return nil
 }
 
+// mkntfsPercent matches the percentage mkntfs prints while zeroing, e.g. " 42%"
+var mkntfsPercent = regexp.MustCompile(`(\d{1,3})(?:\.\d+)?%`)
+
+// parseMkntfsProgress reads mkntfs output and calls progressFn whenever the percentage changes.
+// mkntfs redraws its progress with carriage returns or backspaces rather than newlines.
+func parseMkntfsProgress(r io.Reader, progressFn FormatProgressFunc) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexAny(data, "\r\n\b"); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	last := -1
+	for scanner.Scan() {
+		m := mkntfsPercent.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		percent, err := strconv.Atoi(m[1])
+		if err != nil || percent > 100 || percent == last {
+			continue
+		}
+		last = percent
+		if progressFn != nil {
+			progressFn(percent)
+		}
+	}
+
+	// Drain anything left so mkntfs never blocks on a full pipe
+	_, _ = io.Copy(io.Discard, r)
+}
+
 // FormatPartition formats a partition with the specified filesystem and label
 func FormatPartition(partition, fstype, label string) error {
+	return FormatPartitionWithFATOptions(partition, fstype, label, FATOptions{})
+}
+
+// FormatPartitionWithFATOptions is FormatPartition, applying fatOpts when fstype is FAT
+func FormatPartitionWithFATOptions(partition, fstype, label string, fatOpts FATOptions) error {
 	switch strings.ToUpper(fstype) {
 	case "FAT32", "FAT":
-		if err := FormatFAT32(partition); err != nil {
+		if err := FormatFAT32WithOptions(partition, fatOpts); err != nil {
 			return err
 		}
 		// Set label after formatting if specified
@@ -51,30 +205,153 @@ func FormatPartition(partition, fstype, label string) error {
 		return nil
 	case "NTFS":
 		return FormatNTFS(partition, label)
+	case "EXFAT":
+		return FormatExFAT(partition, label)
 	default:
 		return fmt.Errorf("unsupported filesystem type: %s", fstype)
 	}
 }
 
-// SetFAT32Label sets the label on a FAT32 partition
+// LabelError is returned when a partition was formatted but its label could not be set.
+// The filesystem is usable, so callers may treat it as a warning.
+type LabelError struct {
+	Partition  string
+	Label      string
+	Filesystem string // "FAT32" when empty
+	Err        error
+}
+
+func (e *LabelError) Error() string {
+	fstype := e.Filesystem
+	if fstype == "" {
+		fstype = "FAT32"
+	}
+	return fmt.Sprintf("failed to set %s label '%s' on %s: %v", fstype, e.Label, e.Partition, e.Err)
+}
+
+func (e *LabelError) Unwrap() error {
+	return e.Err
+}
+
+// SetFAT32Label sets the label on a FAT32 partition, trying fatlabel, dosfslabel
+// and mlabel (mtools) in that order
 func SetFAT32Label(partition, label string) error {
-	// Use fatlabel to set the label
-	cmd := exec.Command("fatlabel", partition, label)
-	if err := cmd.Run(); err != nil {
-		// Fallback to dosfslabel if fatlabel is not available
-		cmd = exec.Command("dosfslabel", partition, label)
+	attempts := [][]string{
+		{"fatlabel", partition, label},
+		{"dosfslabel", partition, label},
+		// mlabel only accepts upper-case labels
+		{"mlabel", "-i", partition, "::" + strings.ToUpper(label)},
+	}
+
+	var errs []string
+	for _, args := range attempts {
+		cmd := exec.Command(args[0], args[1:]...)
 		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to set FAT32 label on %s: %v", partition, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", args[0], err))
+			continue
+		}
+		return nil
+	}
+
+	return &LabelError{
+		Partition: partition,
+		Label:     label,
+		Err:       fmt.Errorf("%s", strings.Join(errs, "; ")),
+	}
+}
+
+// SetNTFSLabel sets the label on an NTFS partition with ntfslabel
+func SetNTFSLabel(partition, label string) error {
+	out, err := exec.Command("ntfslabel", partition, label).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = fmt.Errorf("ntfslabel: %v: %s", err, msg)
+		} else {
+			err = fmt.Errorf("ntfslabel: %v", err)
+		}
+		return &LabelError{Partition: partition, Label: label, Filesystem: "NTFS", Err: err}
+	}
+	return nil
+}
+
+// SetExFATLabel sets the label on an exFAT partition with exfatlabel, which both
+// exfatprogs and exfat-utils provide
+func SetExFATLabel(partition, label string) error {
+	out, err := exec.Command("exfatlabel", partition, label).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = fmt.Errorf("exfatlabel: %v: %s", err, msg)
+		} else {
+			err = fmt.Errorf("exfatlabel: %v", err)
 		}
+		return &LabelError{Partition: partition, Label: label, Filesystem: "exFAT", Err: err}
 	}
 	return nil
 }
 
+// SetLabel sets the label of an existing FAT32, NTFS or exFAT partition without reformatting it
+func SetLabel(partition, fstype, label string) error {
+	switch strings.ToUpper(fstype) {
+	case "FAT32", "FAT":
+		return SetFAT32Label(partition, label)
+	case "NTFS":
+		return SetNTFSLabel(partition, label)
+	case "EXFAT":
+		return SetExFATLabel(partition, label)
+	default:
+		return fmt.Errorf("unsupported filesystem type: %s", fstype)
+	}
+}
+
+// DetectFilesystem returns the filesystem on partition as reported by blkid: "FAT" for
+// FAT32, "NTFS", "EXFAT", or blkid's own name (e.g. "vfat (FAT16)", "ext4") for anything else
+func DetectFilesystem(partition string) (string, error) {
+	out, err := exec.Command("blkid", "-p", "-o", "export", partition).Output()
+	if err != nil {
+		return "", fmt.Errorf("blkid found no filesystem on %s: %v", partition, err)
+	}
+	return parseBlkidFilesystem(string(out))
+}
+
+// parseBlkidFilesystem extracts the filesystem from blkid -o export output
+func parseBlkidFilesystem(out string) (string, error) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			values[key] = value
+		}
+	}
+
+	switch fstype := values["TYPE"]; fstype {
+	case "":
+		return "", fmt.Errorf("blkid reported no filesystem type")
+	case "ntfs":
+		return "NTFS", nil
+	case "exfat":
+		return "EXFAT", nil
+	case "vfat":
+		if version := values["VERSION"]; version != "" && version != "FAT32" {
+			return fmt.Sprintf("vfat (%s)", version), nil
+		}
+		return "FAT", nil
+	default:
+		return fstype, nil
+	}
+}
+
 // CheckFAT32Limit walks through all files in the mountpoint and returns true if any file exceeds FAT32 limits
 func CheckFAT32Limit(mountpoint string) (bool, []string, error) {
+	return CheckFAT32LimitContext(context.Background(), mountpoint)
+}
+
+// CheckFAT32LimitContext is CheckFAT32Limit, returning ctx.Err() once ctx is cancelled
+func CheckFAT32LimitContext(ctx context.Context, mountpoint string) (bool, []string, error) {
 	var oversizedFiles []string
 
 	err := filepath.Walk(mountpoint, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			// Skip files we can't access rather than failing completely
 			return nil
@@ -89,6 +366,9 @@ func CheckFAT32Limit(mountpoint string) (bool, []string, error) {
 		return nil
 	})
 
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, nil, ctxErr
+	}
 	if err != nil {
 		return false, nil, fmt.Errorf("failed to walk directory %s: %v", mountpoint, err)
 	}
@@ -98,10 +378,18 @@ func CheckFAT32Limit(mountpoint string) (bool, []string, error) {
 
 // GetLargestFileSize returns the size of the largest file in the mountpoint
 func GetLargestFileSize(mountpoint string) (int64, string, error) {
+	return getLargestFileSize(context.Background(), mountpoint)
+}
+
+// getLargestFileSize implements GetLargestFileSize, returning ctx.Err() once ctx is cancelled
+func getLargestFileSize(ctx context.Context, mountpoint string) (int64, string, error) {
 	var maxSize int64
 	var maxFile string
 
 	err := filepath.Walk(mountpoint, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return nil // Skip files we can't access
 		}
@@ -114,6 +402,9 @@ func GetLargestFileSize(mountpoint string) (int64, string, error) {
 		return nil
 	})
 
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return 0, "", ctxErr
+	}
 	if err != nil {
 		return 0, "", fmt.Errorf("failed to walk directory %s: %v", mountpoint, err)
 	}
@@ -145,13 +436,21 @@ func FormatSizeHuman(bytes int64) string {
 
 // SuggestFilesystem suggests the appropriate filesystem based on content analysis
 func SuggestFilesystem(mountpoint string) (string, string, error) {
-	hasOversized, oversizedFiles, err := CheckFAT32Limit(mountpoint)
+	return SuggestFilesystemContext(context.Background(), mountpoint)
+}
+
+// SuggestFilesystemContext is SuggestFilesystem, returning ctx.Err() once ctx is cancelled
+func SuggestFilesystemContext(ctx context.Context, mountpoint string) (string, string, error) {
+	hasOversized, oversizedFiles, err := CheckFAT32LimitContext(ctx, mountpoint)
 	if err != nil {
 		return "", "", err
 	}
 
 	if hasOversized {
-		maxSize, maxFile, err := GetLargestFileSize(mountpoint)
+		maxSize, maxFile, err := getLargestFileSize(ctx, mountpoint)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", "", ctxErr
+		}
 		if err != nil {
 			return "NTFS", fmt.Sprintf("Files exceed FAT32 4GB limit (%d files)", len(oversizedFiles)), nil
 		}
@@ -167,6 +466,55 @@ func SuggestFilesystem(mountpoint string) (string, string, error) {
 	return "FAT32", "All files are within FAT32 limits", nil
 }
 
+// PartitionSizeWarning is returned by ValidatePartitionSize for a partition size the
+// filesystem supports but that is inadvisable. Callers may treat it as a warning.
+type PartitionSizeWarning struct {
+	Filesystem string
+	Size       int64
+	Reason     string
+}
+
+func (w *PartitionSizeWarning) Error() string {
+	return fmt.Sprintf("%s on a %s partition: %s", w.Filesystem, FormatSizeHuman(w.Size), w.Reason)
+}
+
+// ValidatePartitionSize checks that fstype suits a partition of sizeBytes. Sizes mkfs
+// refuses are errors; FAT32 above FAT32WindowsMaxVolumeSize is a *PartitionSizeWarning.
+func ValidatePartitionSize(fstype string, sizeBytes int64) error {
+	switch strings.ToUpper(fstype) {
+	case "FAT32", "FAT":
+		switch {
+		case sizeBytes < FAT32MinVolumeSize:
+			return fmt.Errorf("a %s partition is too small for FAT32 (at least %s)",
+				FormatSizeHuman(sizeBytes), FormatSizeHuman(FAT32MinVolumeSize))
+		case sizeBytes > FAT32MaxVolumeSize:
+			return fmt.Errorf("a %s partition is larger than mkdosfs can format as FAT32 (%s); use NTFS",
+				FormatSizeHuman(sizeBytes), FormatSizeHuman(FAT32MaxVolumeSize))
+		case sizeBytes > FAT32WindowsMaxVolumeSize:
+			return &PartitionSizeWarning{
+				Filesystem: "FAT32",
+				Size:       sizeBytes,
+				Reason:     "Windows itself does not create FAT32 volumes over 32GB and some firmware may not boot them; consider NTFS",
+			}
+		}
+		return nil
+	case "NTFS":
+		if sizeBytes < NTFSMinVolumeSize {
+			return fmt.Errorf("a %s partition is too small for NTFS (at least %s)",
+				FormatSizeHuman(sizeBytes), FormatSizeHuman(NTFSMinVolumeSize))
+		}
+		return nil
+	case "EXFAT":
+		if sizeBytes < ExFATMinVolumeSize {
+			return fmt.Errorf("a %s partition is too small for exFAT (at least %s)",
+				FormatSizeHuman(sizeBytes), FormatSizeHuman(ExFATMinVolumeSize))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported filesystem type: %s", fstype)
+	}
+}
+
 // ValidateFilesystemChoice validates if the chosen filesystem can handle the content
 func ValidateFilesystemChoice(mountpoint, filesystem string) error {
 	if filesystem == "FAT32" || filesystem == "FAT" {
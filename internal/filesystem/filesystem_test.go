@@ -1,8 +1,11 @@
 package filesystem
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -123,6 +126,13 @@ func TestSuggestFilesystem(t *testing.T) {
 	if reason != "All files are within FAT32 limits" {
 		t.Errorf("Unexpected reason: %s", reason)
 	}
+
+	// A cancelled scan reports the cancellation instead of a suggestion
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := SuggestFilesystemContext(ctx, tmpDir); !errors.Is(err, context.Canceled) {
+		t.Errorf("SuggestFilesystemContext() error = %v, want context.Canceled", err)
+	}
 }
 
 func TestValidateFilesystemChoice(t *testing.T) {
@@ -160,6 +170,36 @@ func TestFormatFAT32(t *testing.T) {
 	}
 }
 
+func TestNormalizeFATVolumeID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{"1234abcd", "1234ABCD", false},
+		{"1234-ABCD", "1234ABCD", false},
+		{"DEADBEEF", "DEADBEEF", false},
+		{"1234abc", "", true},
+		{"1234abcde", "", true},
+		{"12-34ABCD", "", true},
+		{"XYZ12345", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeFATVolumeID(tt.id)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeFATVolumeID(%q) = %q, %v; want %q, error %v", tt.id, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// An invalid ID is rejected before mkdosfs runs
+	err := FormatFAT32WithOptions("/dev/nonexistent", FATOptions{VolumeID: "nothex"})
+	if err == nil || !strings.Contains(err.Error(), "invalid FAT volume ID") {
+		t.Errorf("FormatFAT32WithOptions() with a bad ID = %v", err)
+	}
+}
+
 func TestFormatNTFS(t *testing.T) {
 	// Test with non-existent partition (should fail gracefully)
 	err := FormatNTFS("/dev/nonexistent", "TestLabel")
@@ -194,4 +234,129 @@ func TestSetFAT32Label(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error when setting label on non-existent partition")
 	}
+
+	// The failure is reported as a LabelError so callers can treat it as a warning
+	var labelErr *LabelError
+	if !errors.As(err, &labelErr) {
+		t.Fatalf("Expected *LabelError, got %T: %v", err, err)
+	}
+	if labelErr.Partition != "/dev/nonexistent" || labelErr.Label != "TestLabel" {
+		t.Errorf("Unexpected LabelError fields: %+v", labelErr)
+	}
+}
+
+func TestParseMkntfsProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []int
+	}{
+		{"quick format", "Cluster size has been automatically set to 4096 bytes.\nCreating NTFS volume structures.\nmkntfs completed successfully. Have a nice day.\n", nil},
+		{"carriage returns", "Initializing device with zeroes:   0%\r  1%\r  1%\r 50%\r100%\nCreating NTFS volume structures.\n", []int{0, 1, 50, 100}},
+		{"backspaces", "Initializing device with zeroes:  10%\b\b\b\b 20%\b\b\b\b 30.5%\n", []int{10, 20, 30}},
+	}
+
+	for _, test := range tests {
+		var got []int
+		parseMkntfsProgress(strings.NewReader(test.output), func(percent int) {
+			got = append(got, percent)
+		})
+
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.expected)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: got %v, expected %v", test.name, got, test.expected)
+				break
+			}
+		}
+	}
+
+	// A nil callback must not panic
+	parseMkntfsProgress(strings.NewReader(" 42%\r"), nil)
+}
+
+func TestFormatNTFSWithProgress(t *testing.T) {
+	// Test with non-existent partition (should fail gracefully)
+	if err := FormatNTFSWithProgress("/dev/nonexistent", "TEST", true, nil); err == nil {
+		t.Error("Expected error when formatting non-existent partition")
+	}
+}
+
+func TestParseBlkidFilesystem(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    string
+		wantErr bool
+	}{
+		{"DEVNAME=/dev/sdb1\nLABEL=WIN11\nUUID=1234-ABCD\nVERSION=FAT32\nTYPE=vfat\nUSAGE=filesystem\n", "FAT", false},
+		{"DEVNAME=/dev/sdb1\nUUID=1234-ABCD\nVERSION=FAT16\nTYPE=vfat\n", "vfat (FAT16)", false},
+		{"DEVNAME=/dev/sdb1\nLABEL=WIN11\nUUID=01D9A1B2C3D4E5F6\nTYPE=ntfs\n", "NTFS", false},
+		{"DEVNAME=/dev/sdb1\nLABEL=WIN11\nUUID=1234-ABCD\nTYPE=exfat\n", "EXFAT", false},
+		{"DEVNAME=/dev/sdb1\nUUID=abc\nTYPE=ext4\n", "ext4", false},
+		{"DEVNAME=/dev/sdb1\n", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBlkidFilesystem(tt.out)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBlkidFilesystem(%q) = %q, %v; want %q, error %v", tt.out, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	// Test with non-existent device (should fail)
+	err := SetLabel("/dev/nonexistent", "NTFS", "TEST")
+	var labelErr *LabelError
+	if !errors.As(err, &labelErr) || !strings.Contains(err.Error(), "NTFS label") {
+		t.Errorf("SetLabel() on NTFS = %v, want an NTFS LabelError", err)
+	}
+
+	if err := SetLabel("/dev/nonexistent", "ext4", "TEST"); err == nil || errors.As(err, &labelErr) {
+		t.Errorf("SetLabel() on ext4 = %v, want an unsupported filesystem error", err)
+	}
+
+	if _, err := DetectFilesystem("/dev/nonexistent"); err == nil {
+		t.Error("DetectFilesystem should fail for a non-existent device")
+	}
+}
+
+func TestValidatePartitionSize(t *testing.T) {
+	const gib = int64(1024 * 1024 * 1024)
+	tests := []struct {
+		fstype  string
+		size    int64
+		wantErr bool
+		warning bool
+	}{
+		{"FAT", FAT32MinVolumeSize - 1, true, false},
+		{"FAT", FAT32MinVolumeSize, false, false},
+		{"FAT32", 16 * gib, false, false},
+		{"FAT", FAT32WindowsMaxVolumeSize, false, false},
+		{"FAT", FAT32WindowsMaxVolumeSize + 1, true, true},
+		{"FAT", 256 * gib, true, true},
+		{"FAT", FAT32MaxVolumeSize, true, true},
+		{"FAT", FAT32MaxVolumeSize + 1, true, false},
+		{"NTFS", NTFSMinVolumeSize - 1, true, false},
+		{"NTFS", NTFSMinVolumeSize, false, false},
+		{"ntfs", 4096 * gib, false, false},
+		{"EXFAT", ExFATMinVolumeSize - 1, true, false},
+		{"EXFAT", 512 * gib, false, false},
+		{"ext4", 16 * gib, true, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePartitionSize(tt.fstype, tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePartitionSize(%s, %d) = %v, wantErr %v", tt.fstype, tt.size, err, tt.wantErr)
+			continue
+		}
+		var warning *PartitionSizeWarning
+		if errors.As(err, &warning) != tt.warning {
+			t.Errorf("ValidatePartitionSize(%s, %d) = %v, warning %v", tt.fstype, tt.size, err, tt.warning)
+		}
+	}
 }
@@ -0,0 +1,158 @@
+package fingerprint
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheFileName is the file under the cache directory holding known fingerprints
+const cacheFileName = "fingerprints.json"
+
+// Fingerprint identifies the exact source image that was written
+type Fingerprint struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Cached bool   `json:"-"` // true if the hash came from the cache
+}
+
+// Compute returns the SHA-256 and size of the file at path, using a cache in the
+// user cache directory so repeated runs on an unchanged image do not hash it again
+func Compute(path string) (*Fingerprint, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ComputeWithCache(path, "")
+	}
+	return ComputeWithCache(path, filepath.Join(cacheDir, "woeusb-go"))
+}
+
+// ComputeWithCache is Compute with an explicit cache directory; "" disables caching
+func ComputeWithCache(path, cacheDir string) (*Fingerprint, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", path, err)
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access %s: %v", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	key := cacheKey(absPath, info)
+	cache := loadCache(cacheDir)
+	if sum, ok := cache[key]; ok {
+		return &Fingerprint{Path: absPath, Size: info.Size(), SHA256: sum, Cached: true}, nil
+	}
+
+	sum, err := hashFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheDir != "" {
+		cache[key] = sum
+		// A cache write failure only costs a re-hash next time
+		_ = saveCache(cacheDir, cache)
+	}
+
+	return &Fingerprint{Path: absPath, Size: info.Size(), SHA256: sum}, nil
+}
+
+// cacheKey identifies a file version by path, size and modification time
+func cacheKey(absPath string, info os.FileInfo) string {
+	return fmt.Sprintf("%s|%d|%d", absPath, info.Size(), info.ModTime().UnixNano())
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadCache reads the fingerprint cache; a missing or corrupt cache is treated as empty
+func loadCache(cacheDir string) map[string]string {
+	cache := map[string]string{}
+	if cacheDir == "" {
+		return cache
+	}
+
+	data, err := os.ReadFile(filepath.Join(cacheDir, cacheFileName))
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return map[string]string{}
+	}
+	return cache
+}
+
+// saveCache writes the fingerprint cache
+func saveCache(cacheDir string, cache map[string]string) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fingerprint cache: %v", err)
+	}
+
+	return os.WriteFile(filepath.Join(cacheDir, cacheFileName), data, 0644)
+}
+
+// LoadKnown reads a database of known-good hashes in sha256sum format
+// ("<hex>  <name>" per line, # comments allowed), keyed by lowercase hash
+func LoadKnown(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	known := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		name := ""
+		if len(fields) > 1 {
+			// sha256sum marks binary mode with a leading '*'
+			name = strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		}
+		known[strings.ToLower(fields[0])] = name
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return known, nil
+}
+
+// Match looks up the fingerprint in a known-good database and returns the image name
+func (f *Fingerprint) Match(known map[string]string) (string, bool) {
+	name, ok := known[strings.ToLower(f.SHA256)]
+	return name, ok
+}
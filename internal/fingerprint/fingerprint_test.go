@@ -0,0 +1,96 @@
+package fingerprint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeWithCache(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "fingerprint_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	isoPath := filepath.Join(tmpDir, "test.iso")
+	if err := os.WriteFile(isoPath, []byte("abc"), 0644); err != nil {
+		t.Fatalf("Failed to create test ISO: %v", err)
+	}
+	cacheDir := filepath.Join(tmpDir, "cache")
+
+	// sha256("abc")
+	const abcSum = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+
+	fp, err := ComputeWithCache(isoPath, cacheDir)
+	if err != nil {
+		t.Fatalf("ComputeWithCache failed: %v", err)
+	}
+	if fp.SHA256 != abcSum || fp.Size != 3 || fp.Cached {
+		t.Errorf("First ComputeWithCache() = %+v", fp)
+	}
+
+	fp, err = ComputeWithCache(isoPath, cacheDir)
+	if err != nil {
+		t.Fatalf("ComputeWithCache failed: %v", err)
+	}
+	if !fp.Cached || fp.SHA256 != abcSum {
+		t.Errorf("Second ComputeWithCache() = %+v, want a cache hit", fp)
+	}
+
+	// Changing the file invalidates the cached entry
+	if err := os.WriteFile(isoPath, []byte("abcd"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite test ISO: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(isoPath, later, later); err != nil {
+		t.Fatalf("Failed to touch test ISO: %v", err)
+	}
+	fp, err = ComputeWithCache(isoPath, cacheDir)
+	if err != nil {
+		t.Fatalf("ComputeWithCache failed: %v", err)
+	}
+	if fp.Cached || fp.SHA256 == abcSum || fp.Size != 4 {
+		t.Errorf("ComputeWithCache() after change = %+v", fp)
+	}
+
+	if _, err := ComputeWithCache(tmpDir, ""); err == nil {
+		t.Error("Expected error for a directory")
+	}
+}
+
+func TestLoadKnownAndMatch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "fingerprint_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dbPath := filepath.Join(tmpDir, "SHA256SUMS")
+	db := "# known images\nAAAA  Win11_English_x64.iso\nbbbb *Win10.iso\n\n"
+	if err := os.WriteFile(dbPath, []byte(db), 0644); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+
+	known, err := LoadKnown(dbPath)
+	if err != nil {
+		t.Fatalf("LoadKnown failed: %v", err)
+	}
+
+	tests := []struct {
+		sum      string
+		wantName string
+		wantOK   bool
+	}{
+		{"aaaa", "Win11_English_x64.iso", true},
+		{"BBBB", "Win10.iso", true},
+		{"cccc", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := (&Fingerprint{SHA256: tt.sum}).Match(known)
+		if name != tt.wantName || ok != tt.wantOK {
+			t.Errorf("Match(%s) = (%q, %v), want (%q, %v)", tt.sum, name, ok, tt.wantName, tt.wantOK)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"image/color"
 	"os"
+	"os/exec"
 	"strings"
 
 	"fyne.io/fyne/v2"
@@ -59,6 +60,7 @@ func (a *App) Run() error {
 
 	// Check dependencies (but don't block on root - we'll use pkexec)
 	missing := a.CheckDependencies()
+	outdated := deps.CheckVersions()
 	if len(missing) > 0 {
 		// Only show dialog for required dependencies
 		hasRequired := false
@@ -69,7 +71,7 @@ func (a *App) Run() error {
 			}
 		}
 		if hasRequired {
-			a.showDependencyDialog(missing)
+			a.showDependencyDialog(missing, outdated)
 			return nil // User needs to install dependencies first
 		}
 	}
@@ -77,6 +79,9 @@ func (a *App) Run() error {
 	// Create and show main window
 	a.mainWindow = NewMainWindow(a.fyneApp, a.distroInfo)
 	a.mainWindow.Show()
+	if len(outdated) > 0 {
+		dialog.ShowInformation("Outdated Dependencies", VersionWarningsText(outdated), a.mainWindow.window)
+	}
 
 	// Run the application
 	a.fyneApp.Run()
@@ -101,8 +106,44 @@ func IsRootWithGetter(getUID func() int) bool {
 	return getUID() == 0
 }
 
-// showDependencyDialog displays missing dependencies with install instructions
-func (a *App) showDependencyDialog(missing []deps.MissingDep) {
+// AskpassHelper returns the askpass program named by SUDO_ASKPASS if it is set and
+// executable. sudo -A then asks it for credentials instead of the password dialog.
+func AskpassHelper() (string, bool) {
+	helper := os.Getenv("SUDO_ASKPASS")
+	if helper == "" {
+		return "", false
+	}
+	info, err := os.Stat(helper)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return "", false
+	}
+	return helper, true
+}
+
+// PkexecPath returns the path of pkexec if it is installed. It re-runs the CLI as root
+// after Polkit has authenticated the user, without a password dialog of our own.
+func PkexecPath() (string, bool) {
+	path, err := exec.LookPath("pkexec")
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// VersionWarningsText lists outdated tools for the dependency dialogs
+func VersionWarningsText(warnings []deps.VersionWarning) string {
+	var sb strings.Builder
+	sb.WriteString("The following tools are older than supported:\n\n")
+	for _, w := range warnings {
+		sb.WriteString(fmt.Sprintf("• %s %s (need %s or newer)\n", w.Binary, w.Installed, w.Minimum))
+	}
+	sb.WriteString("\nWrites may fail part way through. Please update them.")
+	return sb.String()
+}
+
+// showDependencyDialog displays missing dependencies with install instructions, and
+// any outdated tools
+func (a *App) showDependencyDialog(missing []deps.MissingDep, outdated []deps.VersionWarning) {
 	win := a.fyneApp.NewWindow("WoeUSB-go - Missing Dependencies")
 	win.Resize(fyne.NewSize(600, 400))
 
@@ -122,6 +163,9 @@ func (a *App) showDependencyDialog(missing []deps.MissingDep) {
 	if installCmd != "" {
 		sb.WriteString(fmt.Sprintf("\nInstall command:\n%s", installCmd))
 	}
+	if len(outdated) > 0 {
+		sb.WriteString("\n\n" + VersionWarningsText(outdated))
+	}
 
 	dialog.ShowInformation("Missing Dependencies", sb.String(), win)
 	win.Show()
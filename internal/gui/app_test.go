@@ -1,7 +1,12 @@
 package gui
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/mathisen/woeusb-go/internal/deps"
 )
 
 // TestProperty2_RootPrivilegeDetection tests Property 2:
@@ -50,3 +55,83 @@ func TestIsRoot_PropertyBased(t *testing.T) {
 		}
 	}
 }
+
+func TestAskpassHelper(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gui_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	helper := filepath.Join(tmpDir, "askpass")
+	if err := os.WriteFile(helper, []byte("#!/bin/sh\necho secret\n"), 0755); err != nil {
+		t.Fatalf("Failed to write helper: %v", err)
+	}
+	notExec := filepath.Join(tmpDir, "not-exec")
+	if err := os.WriteFile(notExec, []byte("secret\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		env  string
+		want bool
+	}{
+		{"", false},
+		{helper, true},
+		{notExec, false},
+		{tmpDir, false},
+		{filepath.Join(tmpDir, "missing"), false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SUDO_ASKPASS", tt.env)
+		got, ok := AskpassHelper()
+		if ok != tt.want || (ok && got != tt.env) {
+			t.Errorf("AskpassHelper() with SUDO_ASKPASS=%q = %q, %v, want %v", tt.env, got, ok, tt.want)
+		}
+	}
+}
+
+func TestPkexecPath(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gui_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	t.Setenv("PATH", tmpDir)
+	if _, ok := PkexecPath(); ok {
+		t.Error("PkexecPath() found pkexec in an empty PATH")
+	}
+
+	pkexec := filepath.Join(tmpDir, "pkexec")
+	if err := os.WriteFile(pkexec, []byte("#!/bin/sh\nexec \"$@\"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write pkexec: %v", err)
+	}
+	if got, ok := PkexecPath(); !ok || got != pkexec {
+		t.Errorf("PkexecPath() = %q, %v, want %q, true", got, ok, pkexec)
+	}
+}
+
+func TestPkexecFailure(t *testing.T) {
+	for _, code := range []int{126, 127} {
+		if _, ok := pkexecFailure(code); !ok {
+			t.Errorf("pkexecFailure(%d) should be an authentication failure", code)
+		}
+	}
+	// Exit codes of the CLI itself are not pkexec failures
+	for _, code := range []int{1, 2, 5, 130} {
+		if msg, ok := pkexecFailure(code); ok {
+			t.Errorf("pkexecFailure(%d) = %q, want no authentication failure", code, msg)
+		}
+	}
+}
+
+func TestVersionWarningsText(t *testing.T) {
+	text := VersionWarningsText([]deps.VersionWarning{
+		{Binary: "parted", Installed: "2.3", Minimum: "3.2"},
+	})
+	if !strings.Contains(text, "parted 2.3 (need 3.2 or newer)") {
+		t.Errorf("Expected the outdated tool in the text, got: %q", text)
+	}
+}
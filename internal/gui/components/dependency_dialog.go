@@ -97,6 +97,8 @@ func (d *DependencyDialog) formatDependency(dep deps.MissingDep) string {
 	reqStr := "[optional]"
 	if dep.Required {
 		reqStr = "[REQUIRED]"
+	} else if dep.Purpose != "" {
+		reqStr = fmt.Sprintf("[optional: %s]", dep.Purpose)
 	}
 	return fmt.Sprintf("• %s (package: %s) %s", dep.Binary, dep.PackageName, reqStr)
 }
@@ -139,6 +141,8 @@ func FormatMissingDeps(missing []deps.MissingDep) string {
 		reqStr := "optional"
 		if dep.Required {
 			reqStr = "REQUIRED"
+		} else if dep.Purpose != "" {
+			reqStr = "optional: " + dep.Purpose
 		}
 		lines = append(lines, fmt.Sprintf("• %s (package: %s) [%s]", dep.Binary, dep.PackageName, reqStr))
 	}
@@ -3,10 +3,12 @@ package components
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -53,7 +55,13 @@ var excludedTransports = map[string]bool{
 
 // GetUSBDevices returns only removable USB devices by parsing lsblk JSON output
 func GetUSBDevices() ([]USBDevice, error) {
-	return GetUSBDevicesWithRunner(defaultCommandRunner{})
+	return GetUSBDevicesWithOptions(false)
+}
+
+// GetUSBDevicesWithOptions returns USB devices, optionally including ones that report RM=0
+// (many USB SSDs and some sticks do). The tran=usb requirement is always kept.
+func GetUSBDevicesWithOptions(includeNonRemovable bool) ([]USBDevice, error) {
+	return getUSBDevices(defaultCommandRunner{}, includeNonRemovable)
 }
 
 // CommandRunner interface for executing commands (allows testing)
@@ -71,31 +79,79 @@ func (d defaultCommandRunner) Run(name string, args ...string) ([]byte, error) {
 
 // GetUSBDevicesWithRunner returns USB devices using a custom command runner
 func GetUSBDevicesWithRunner(runner CommandRunner) ([]USBDevice, error) {
+	return getUSBDevices(runner, false)
+}
+
+// ErrLsblkFailed is wrapped by the errors of GetUSBDevices and friends when lsblk could
+// not be run or parsed. Finding no USB devices is not an error: the list is just empty.
+var ErrLsblkFailed = errors.New("lsblk failed")
+
+// lsblkAttempts is how often getUSBDevices runs lsblk before giving up or accepting
+// an empty list; lsblk can briefly fail or miss a device right after it is plugged in
+const lsblkAttempts = 3
+
+// lsblkRetryDelay is the pause between lsblk attempts; tests shorten it
+var lsblkRetryDelay = 500 * time.Millisecond
+
+// getUSBDevices runs lsblk through runner and filters the result, retrying if lsblk
+// fails or finds no USB devices
+func getUSBDevices(runner CommandRunner, includeNonRemovable bool) ([]USBDevice, error) {
+	var devices []USBDevice
+	var err error
+	for attempt := 1; attempt <= lsblkAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(lsblkRetryDelay)
+		}
+		devices, err = scanUSBDevices(runner, includeNonRemovable)
+		if err == nil && len(devices) > 0 {
+			return devices, nil
+		}
+	}
+	return devices, err
+}
+
+// scanUSBDevices runs lsblk once through runner and filters the result
+func scanUSBDevices(runner CommandRunner, includeNonRemovable bool) ([]USBDevice, error) {
 	output, err := runner.Run("lsblk", "-J", "-o", "NAME,SIZE,TYPE,RM,TRAN,MODEL")
 	if err != nil {
-		return nil, fmt.Errorf("failed to run lsblk: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrLsblkFailed, err)
 	}
 
-	return ParseLsblkOutput(output)
+	devices, err := ParseLsblkOutputWithOptions(output, includeNonRemovable)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLsblkFailed, err)
+	}
+	return devices, nil
 }
 
 // ParseLsblkOutput parses lsblk JSON output and filters for USB devices
 func ParseLsblkOutput(jsonData []byte) ([]USBDevice, error) {
+	return ParseLsblkOutputWithOptions(jsonData, false)
+}
+
+// ParseLsblkOutputWithOptions parses lsblk JSON output, optionally keeping non-removable USB disks
+func ParseLsblkOutputWithOptions(jsonData []byte, includeNonRemovable bool) ([]USBDevice, error) {
 	var lsblkOut LsblkOutput
 	if err := json.Unmarshal(jsonData, &lsblkOut); err != nil {
 		return nil, fmt.Errorf("failed to parse lsblk output: %w", err)
 	}
 
-	return FilterUSBDevices(lsblkOut.Blockdevices), nil
+	return FilterUSBDevicesWithOptions(lsblkOut.Blockdevices, includeNonRemovable), nil
 }
 
 // FilterUSBDevices filters block devices to return only USB devices
 // Criteria: type=disk, removable=true, tran=usb, not in excluded transports
 func FilterUSBDevices(devices []BlockDevice) []USBDevice {
+	return FilterUSBDevicesWithOptions(devices, false)
+}
+
+// FilterUSBDevicesWithOptions filters block devices, relaxing the removable
+// requirement when includeNonRemovable is set
+func FilterUSBDevicesWithOptions(devices []BlockDevice, includeNonRemovable bool) []USBDevice {
 	var usbDevices []USBDevice
 
 	for _, dev := range devices {
-		if IsUSBBlockDevice(dev) {
+		if IsUSBBlockDeviceWithOptions(dev, includeNonRemovable) {
 			usbDevices = append(usbDevices, BlockDeviceToUSBDevice(dev))
 		}
 	}
@@ -110,13 +166,19 @@ func FilterUSBDevices(devices []BlockDevice) []USBDevice {
 // - tran (transport) is "usb"
 // - tran is NOT in excluded transports (sata, nvme, ata)
 func IsUSBBlockDevice(dev BlockDevice) bool {
+	return IsUSBBlockDeviceWithOptions(dev, false)
+}
+
+// IsUSBBlockDeviceWithOptions is IsUSBBlockDevice with an optional relaxed
+// removable check; transport must still be "usb"
+func IsUSBBlockDeviceWithOptions(dev BlockDevice, includeNonRemovable bool) bool {
 	// Must be a disk (not a partition)
 	if dev.Type != "disk" {
 		return false
 	}
 
-	// Must be removable
-	if !dev.IsRemovable() {
+	// Must be removable unless explicitly relaxed
+	if !includeNonRemovable && !dev.IsRemovable() {
 		return false
 	}
 
@@ -206,46 +268,46 @@ func parseSizeToBytes(sizeStr string) int64 {
 
 // FormatDeviceDisplay formats a USB device for display in the UI
 // Returns a string containing device path, size, and model
+// Devices reporting RM=0 are marked "[non-removable]"
 func FormatDeviceDisplay(dev USBDevice) string {
 	name := dev.Name
 	if name == "" {
 		name = "Unknown Device"
 	}
-	return fmt.Sprintf("%s - %s (%s)", dev.Path, dev.SizeHuman, name)
+	display := fmt.Sprintf("%s - %s (%s)", dev.Path, dev.SizeHuman, name)
+	if !dev.Removable {
+		display += " [non-removable]"
+	}
+	return display
 }
 
 // DeviceSelector provides USB device selection as a Fyne widget
 type DeviceSelector struct {
 	widget.BaseWidget
-	devices   []USBDevice
-	selected  string
-	onSelect  func(device string)
-	list      *widget.Select
-	container *fyne.Container
-	noDevices *widget.Label
+	devices             []USBDevice // devices[i] is shown as option i of list
+	selected            *USBDevice
+	includeNonRemovable bool
+	onSelect            func(device *USBDevice)
+	list                *widget.Select
+	container           *fyne.Container
+	noDevices           *widget.Label
 }
 
-// NewDeviceSelector creates a new device selector widget
-func NewDeviceSelector(onSelect func(device string)) *DeviceSelector {
+// noDevicesText is shown when lsblk worked but found no USB devices
+const noDevicesText = "No USB devices detected"
+
+// NewDeviceSelector creates a new device selector widget. onSelect receives the
+// selected device, or nil when the selection is cleared.
+func NewDeviceSelector(onSelect func(device *USBDevice)) *DeviceSelector {
 	ds := &DeviceSelector{
 		onSelect: onSelect,
 	}
 
-	ds.noDevices = widget.NewLabel("No USB devices detected")
+	ds.noDevices = widget.NewLabel(noDevicesText)
 	ds.noDevices.Hide()
 
-	ds.list = widget.NewSelect([]string{}, func(selected string) {
-		// Extract device path from display string
-		if selected != "" {
-			// Format is "/dev/sdX - SIZE (NAME)"
-			parts := strings.Split(selected, " - ")
-			if len(parts) > 0 {
-				ds.selected = parts[0]
-				if ds.onSelect != nil {
-					ds.onSelect(ds.selected)
-				}
-			}
-		}
+	ds.list = widget.NewSelect([]string{}, func(string) {
+		ds.selectIndex(ds.list.SelectedIndex())
 	})
 	ds.list.PlaceHolder = "Select a USB device..."
 
@@ -255,6 +317,20 @@ func NewDeviceSelector(onSelect func(device string)) *DeviceSelector {
 	return ds
 }
 
+// selectIndex selects devices[index] and notifies onSelect. Out-of-range indexes
+// (the select widget reports -1 for no selection) are ignored.
+func (ds *DeviceSelector) selectIndex(index int) {
+	if index < 0 || index >= len(ds.devices) {
+		return
+	}
+
+	dev := ds.devices[index]
+	ds.selected = &dev
+	if ds.onSelect != nil {
+		ds.onSelect(ds.selected)
+	}
+}
+
 // CreateRenderer implements fyne.Widget
 func (ds *DeviceSelector) CreateRenderer() fyne.WidgetRenderer {
 	return widget.NewSimpleRenderer(ds.container)
@@ -267,24 +343,22 @@ func (ds *DeviceSelector) Refresh() {
 
 // RefreshDevices rescans for USB devices
 func (ds *DeviceSelector) RefreshDevices() error {
-	devices, err := GetUSBDevices()
-	if err != nil {
-		return fmt.Errorf("failed to get USB devices: %w", err)
-	}
-
-	ds.devices = devices
-	ds.updateList()
-	return nil
+	return ds.RefreshDevicesWithRunner(defaultCommandRunner{})
 }
 
 // RefreshDevicesWithRunner rescans using a custom command runner (for testing)
 func (ds *DeviceSelector) RefreshDevicesWithRunner(runner CommandRunner) error {
-	devices, err := GetUSBDevicesWithRunner(runner)
+	devices, err := getUSBDevices(runner, ds.includeNonRemovable)
 	if err != nil {
+		// An empty list would claim there are no devices, which lsblk did not say
+		ds.devices = nil
+		ds.noDevices.SetText("Could not list USB devices (lsblk failed)")
+		ds.updateList()
 		return fmt.Errorf("failed to get USB devices: %w", err)
 	}
 
 	ds.devices = devices
+	ds.noDevices.SetText(noDevicesText)
 	ds.updateList()
 	return nil
 }
@@ -294,9 +368,9 @@ func (ds *DeviceSelector) updateList() {
 	if len(ds.devices) == 0 {
 		ds.list.Hide()
 		ds.noDevices.Show()
-		ds.selected = ""
+		ds.selected = nil
 		if ds.onSelect != nil {
-			ds.onSelect("")
+			ds.onSelect(nil)
 		}
 		return
 	}
@@ -309,12 +383,26 @@ func (ds *DeviceSelector) updateList() {
 		options[i] = FormatDeviceDisplay(dev)
 	}
 	ds.list.Options = options
+
+	// Keep the selection only if that device is still plugged in, at its new index
+	if ds.selected != nil {
+		path := ds.selected.Path
+		ds.selected = nil
+		ds.list.ClearSelected()
+		ds.SetSelected(path)
+		if ds.selected == nil && ds.onSelect != nil {
+			ds.onSelect(nil)
+		}
+	}
 	ds.list.Refresh()
 }
 
 // GetSelected returns the currently selected device path
 func (ds *DeviceSelector) GetSelected() string {
-	return ds.selected
+	if ds.selected == nil {
+		return ""
+	}
+	return ds.selected.Path
 }
 
 // GetDevices returns the list of detected USB devices
@@ -322,14 +410,29 @@ func (ds *DeviceSelector) GetDevices() []USBDevice {
 	return ds.devices
 }
 
+// SetIncludeNonRemovable controls whether USB devices reporting RM=0 are listed
+// on the next refresh
+func (ds *DeviceSelector) SetIncludeNonRemovable(include bool) {
+	ds.includeNonRemovable = include
+}
+
+// IncludesNonRemovable reports whether non-removable USB devices are listed
+func (ds *DeviceSelector) IncludesNonRemovable() bool {
+	return ds.includeNonRemovable
+}
+
+// GetSelectedDevice returns the selected device, or nil if nothing is selected
+func (ds *DeviceSelector) GetSelectedDevice() *USBDevice {
+	return ds.selected
+}
+
 // SetSelected sets the selected device programmatically
 func (ds *DeviceSelector) SetSelected(devicePath string) {
-	ds.selected = devicePath
-	// Find and select the matching option
-	for _, dev := range ds.devices {
+	for i, dev := range ds.devices {
 		if dev.Path == devicePath {
-			ds.list.SetSelected(FormatDeviceDisplay(dev))
-			break
+			ds.selected = &dev
+			ds.list.SetSelectedIndex(i)
+			return
 		}
 	}
 }
@@ -1,10 +1,13 @@
 package components
 
 import (
+	"errors"
 	"math/rand"
 	"reflect"
 	"testing"
 	"testing/quick"
+
+	"fyne.io/fyne/v2/test"
 )
 
 // BlockDeviceTestData represents generated block device data for property testing
@@ -177,6 +180,32 @@ func TestFilterUSBDevices_ExcludesNonRemovable(t *testing.T) {
 	}
 }
 
+// TestFilterUSBDevicesWithOptions_IncludesNonRemovable tests the relaxed removable check
+func TestFilterUSBDevicesWithOptions_IncludesNonRemovable(t *testing.T) {
+	devices := []BlockDevice{
+		{Name: "sda", Size: "500G", Type: "disk", Rm: "0", Tran: "usb", Model: "USB SSD"},
+		{Name: "sdb", Size: "16G", Type: "disk", Rm: "1", Tran: "usb", Model: "USB Flash"},
+		{Name: "sdc", Size: "1T", Type: "disk", Rm: "0", Tran: "sata", Model: "SATA Drive"},
+	}
+
+	result := FilterUSBDevicesWithOptions(devices, true)
+
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 USB devices (non-USB still excluded), got %d", len(result))
+	}
+
+	if result[0].Path != "/dev/sda" || result[0].Removable {
+		t.Errorf("Expected /dev/sda marked non-removable, got %+v", result[0])
+	}
+
+	if !IsUSBBlockDeviceWithOptions(devices[0], true) {
+		t.Error("Expected non-removable USB disk to pass relaxed check")
+	}
+	if IsUSBBlockDeviceWithOptions(devices[0], false) {
+		t.Error("Expected non-removable USB disk to fail default check")
+	}
+}
+
 // TestFilterUSBDevices_ExcludesSATA tests that SATA devices are excluded
 func TestFilterUSBDevices_ExcludesSATA(t *testing.T) {
 	devices := []BlockDevice{
@@ -472,3 +501,156 @@ func TestFormatDeviceDisplay_LargeSize(t *testing.T) {
 		t.Errorf("FormatDeviceDisplay() = %q, want %q", result, expected)
 	}
 }
+
+// TestFormatDeviceDisplay_NonRemovable tests that non-removable devices are marked
+func TestFormatDeviceDisplay_NonRemovable(t *testing.T) {
+	dev := USBDevice{
+		Path:      "/dev/sdb",
+		Name:      "Samsung T7",
+		Size:      500 * 1024 * 1024 * 1024,
+		SizeHuman: "500G",
+		Removable: false,
+		Transport: "usb",
+	}
+
+	result := FormatDeviceDisplay(dev)
+	expected := "/dev/sdb - 500G (Samsung T7) [non-removable]"
+
+	if result != expected {
+		t.Errorf("FormatDeviceDisplay() = %q, want %q", result, expected)
+	}
+}
+
+// fakeRunner returns canned lsblk output
+type fakeRunner struct {
+	output string
+}
+
+func (f fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	return []byte(f.output), nil
+}
+
+func TestDeviceSelector_SelectionCarriesDevice(t *testing.T) {
+	// The model name contains " - ", which used to break parsing the display string
+	lsblk := `{"blockdevices": [
+		{"name": "sdb", "size": "16G", "type": "disk", "rm": true, "tran": "usb", "model": "Stick - Blue"},
+		{"name": "sdc", "size": "32G", "type": "disk", "rm": true, "tran": "usb", "model": "Other"}
+	]}`
+
+	test.NewTempApp(t)
+
+	var got []*USBDevice
+	ds := NewDeviceSelector(func(device *USBDevice) {
+		got = append(got, device)
+	})
+	if err := ds.RefreshDevicesWithRunner(fakeRunner{output: lsblk}); err != nil {
+		t.Fatalf("RefreshDevicesWithRunner failed: %v", err)
+	}
+
+	ds.list.SetSelected(ds.list.Options[0])
+	if len(got) != 1 || got[0] == nil || got[0].Path != "/dev/sdb" || got[0].Name != "Stick - Blue" {
+		t.Fatalf("onSelect got %+v, want /dev/sdb", got)
+	}
+	if ds.GetSelected() != "/dev/sdb" {
+		t.Errorf("GetSelected() = %q, want /dev/sdb", ds.GetSelected())
+	}
+
+	ds.SetSelected("/dev/sdc")
+	if dev := ds.GetSelectedDevice(); dev == nil || dev.Path != "/dev/sdc" || dev.SizeHuman == "" {
+		t.Errorf("GetSelectedDevice() = %+v, want /dev/sdc", dev)
+	}
+
+	// Unplugging the selected device clears the selection
+	got = nil
+	lsblk = `{"blockdevices": [
+		{"name": "sdb", "size": "16G", "type": "disk", "rm": true, "tran": "usb", "model": "Stick - Blue"}
+	]}`
+	if err := ds.RefreshDevicesWithRunner(fakeRunner{output: lsblk}); err != nil {
+		t.Fatalf("RefreshDevicesWithRunner failed: %v", err)
+	}
+	if ds.GetSelectedDevice() != nil {
+		t.Errorf("selection should be cleared, got %+v", ds.GetSelectedDevice())
+	}
+	if len(got) != 1 || got[0] != nil {
+		t.Errorf("onSelect should be called with nil, got %+v", got)
+	}
+}
+
+// flakyRunner fails or returns no devices for the first failures calls, then returns output
+type flakyRunner struct {
+	output   string
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyRunner) Run(name string, args ...string) ([]byte, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		if f.err != nil {
+			return nil, f.err
+		}
+		return []byte(`{"blockdevices": []}`), nil
+	}
+	return []byte(f.output), nil
+}
+
+func TestGetUSBDevicesRetries(t *testing.T) {
+	oldDelay := lsblkRetryDelay
+	defer func() { lsblkRetryDelay = oldDelay }()
+	lsblkRetryDelay = 0
+
+	lsblk := `{"blockdevices": [
+		{"name": "sdb", "size": "16G", "type": "disk", "rm": true, "tran": "usb", "model": "Stick"}
+	]}`
+
+	// A device that shows up on the second scan is found
+	runner := &flakyRunner{output: lsblk, failures: 1}
+	devices, err := GetUSBDevicesWithRunner(runner)
+	if err != nil || len(devices) != 1 || runner.calls != 2 {
+		t.Errorf("got %v, %v after %d calls; want /dev/sdb after 2", devices, err, runner.calls)
+	}
+
+	// A transient lsblk failure is retried too
+	runner = &flakyRunner{output: lsblk, failures: 2, err: errors.New("exit status 1")}
+	if devices, err := GetUSBDevicesWithRunner(runner); err != nil || len(devices) != 1 {
+		t.Errorf("got %v, %v; want /dev/sdb after retrying", devices, err)
+	}
+
+	// No devices at all is an empty list, not an error
+	runner = &flakyRunner{output: `{"blockdevices": []}`}
+	devices, err = GetUSBDevicesWithRunner(runner)
+	if err != nil || len(devices) != 0 || runner.calls != lsblkAttempts {
+		t.Errorf("got %v, %v after %d calls; want an empty list after %d", devices, err, runner.calls, lsblkAttempts)
+	}
+
+	// lsblk failing every time is reported as such
+	runner = &flakyRunner{failures: lsblkAttempts, err: errors.New("exit status 1")}
+	if _, err := GetUSBDevicesWithRunner(runner); !errors.Is(err, ErrLsblkFailed) {
+		t.Errorf("err = %v, want ErrLsblkFailed", err)
+	}
+}
+
+func TestDeviceSelector_LsblkFailureMessage(t *testing.T) {
+	oldDelay := lsblkRetryDelay
+	defer func() { lsblkRetryDelay = oldDelay }()
+	lsblkRetryDelay = 0
+
+	test.NewTempApp(t)
+	ds := NewDeviceSelector(nil)
+
+	failing := &flakyRunner{failures: lsblkAttempts, err: errors.New("exit status 1")}
+	if err := ds.RefreshDevicesWithRunner(failing); !errors.Is(err, ErrLsblkFailed) {
+		t.Errorf("err = %v, want ErrLsblkFailed", err)
+	}
+	if ds.noDevices.Text == noDevicesText {
+		t.Error("lsblk failure should not be shown as no devices")
+	}
+
+	if err := ds.RefreshDevicesWithRunner(&flakyRunner{output: `{"blockdevices": []}`}); err != nil {
+		t.Fatalf("RefreshDevicesWithRunner failed: %v", err)
+	}
+	if ds.noDevices.Text != noDevicesText {
+		t.Errorf("message = %q, want %q", ds.noDevices.Text, noDevicesText)
+	}
+}
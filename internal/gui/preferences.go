@@ -0,0 +1,68 @@
+package gui
+
+import (
+	"fyne.io/fyne/v2"
+)
+
+// Preference keys stored through fyne's Preferences API
+const (
+	prefFilesystem   = "filesystem"
+	prefLabel        = "label"
+	prefNonRemovable = "include_non_removable"
+	prefVerify       = "verify"
+)
+
+// Filesystem choices offered in the advanced options
+var filesystemOptions = []string{"FAT", "NTFS", "EXFAT"}
+
+// Preferences are the write options the GUI remembers across sessions
+type Preferences struct {
+	Filesystem   string
+	Label        string
+	NonRemovable bool
+	Verify       bool
+}
+
+// DefaultPreferences returns the options used when nothing has been stored yet. Unlike
+// the CLI, whose --target-filesystem defaults to auto, the GUI defaults to FAT, which
+// boots on every firmware; large WIMs are split to fit.
+func DefaultPreferences() Preferences {
+	return Preferences{
+		Filesystem: "FAT",
+		Label:      "Windows USB",
+	}
+}
+
+// LoadPreferences reads the stored options from store, falling back to
+// DefaultPreferences for missing or unknown values
+func LoadPreferences(store fyne.Preferences) Preferences {
+	defaults := DefaultPreferences()
+	prefs := Preferences{
+		Filesystem:   store.StringWithFallback(prefFilesystem, defaults.Filesystem),
+		Label:        store.StringWithFallback(prefLabel, defaults.Label),
+		NonRemovable: store.BoolWithFallback(prefNonRemovable, defaults.NonRemovable),
+		Verify:       store.BoolWithFallback(prefVerify, defaults.Verify),
+	}
+
+	known := false
+	for _, fs := range filesystemOptions {
+		if prefs.Filesystem == fs {
+			known = true
+		}
+	}
+	if !known {
+		prefs.Filesystem = defaults.Filesystem
+	}
+	if prefs.Label == "" {
+		prefs.Label = defaults.Label
+	}
+	return prefs
+}
+
+// SavePreferences stores prefs in store
+func SavePreferences(store fyne.Preferences, prefs Preferences) {
+	store.SetString(prefFilesystem, prefs.Filesystem)
+	store.SetString(prefLabel, prefs.Label)
+	store.SetBool(prefNonRemovable, prefs.NonRemovable)
+	store.SetBool(prefVerify, prefs.Verify)
+}
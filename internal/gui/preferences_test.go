@@ -0,0 +1,59 @@
+package gui
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2/test"
+)
+
+func TestLoadPreferencesDefaults(t *testing.T) {
+	store := test.NewTempApp(t).Preferences()
+
+	if got := LoadPreferences(store); got != DefaultPreferences() {
+		t.Errorf("LoadPreferences() with nothing stored = %+v, want %+v", got, DefaultPreferences())
+	}
+}
+
+func TestSavePreferencesRoundTrip(t *testing.T) {
+	store := test.NewTempApp(t).Preferences()
+
+	want := Preferences{Filesystem: "NTFS", Label: "WIN11", NonRemovable: true, Verify: true}
+	SavePreferences(store, want)
+
+	if got := LoadPreferences(store); got != want {
+		t.Errorf("LoadPreferences() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadPreferencesInvalid(t *testing.T) {
+	store := test.NewTempApp(t).Preferences()
+	store.SetString(prefFilesystem, "exFAT")
+	store.SetString(prefLabel, "")
+
+	got := LoadPreferences(store)
+	if got.Filesystem != "FAT" {
+		t.Errorf("Unknown filesystem should fall back to FAT, got %q", got.Filesystem)
+	}
+	if got.Label != DefaultPreferences().Label {
+		t.Errorf("Empty label should fall back to the default, got %q", got.Label)
+	}
+}
+
+func TestMainWindowRestoresPreferences(t *testing.T) {
+	app := test.NewTempApp(t)
+	SavePreferences(app.Preferences(), Preferences{Filesystem: "NTFS", Label: "WIN11", NonRemovable: true, Verify: true})
+
+	w := NewMainWindow(app, nil)
+	if w.filesystem.Selected != "NTFS" || w.label.Text != "WIN11" || !w.nonRemovable.Checked || !w.verify.Checked {
+		t.Errorf("Advanced options not restored: %+v", w.currentPreferences())
+	}
+	if !w.deviceSelector.IncludesNonRemovable() {
+		t.Error("Device selector should include non-removable devices")
+	}
+
+	// Changing an option is remembered for the next session
+	w.filesystem.SetSelected("FAT")
+	if got := LoadPreferences(app.Preferences()).Filesystem; got != "FAT" {
+		t.Errorf("Stored filesystem = %q, want FAT", got)
+	}
+}
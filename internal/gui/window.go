@@ -1,12 +1,17 @@
 package gui
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -14,14 +19,12 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 
-	"github.com/mathisen/woeusb-go/internal/bootloader"
 	filecopy "github.com/mathisen/woeusb-go/internal/copy"
-	"github.com/mathisen/woeusb-go/internal/deps"
 	"github.com/mathisen/woeusb-go/internal/distro"
-	"github.com/mathisen/woeusb-go/internal/filesystem"
 	"github.com/mathisen/woeusb-go/internal/gui/components"
-	"github.com/mathisen/woeusb-go/internal/mount"
-	"github.com/mathisen/woeusb-go/internal/partition"
+	"github.com/mathisen/woeusb-go/internal/output"
+	"github.com/mathisen/woeusb-go/internal/validation"
+	"github.com/mathisen/woeusb-go/pkg/woeusb"
 )
 
 // OperationState represents the current state of the write operation
@@ -41,13 +44,36 @@ type MainWindow struct {
 	fileBrowser    *components.FileBrowser
 	progressBar    *components.ProgressBar
 	startButton    *widget.Button
+	cancelButton   *widget.Button
 	refreshButton  *widget.Button
+	nonRemovable   *widget.Check
+	allowLarge     *widget.Check
+	filesystem     *widget.Select
+	label          *widget.Entry
+	verify         *widget.Check
 	statusLabel    *widget.Label
 
+	// prefsStore persists the advanced options; options is their snapshot for the
+	// running write, taken on the UI thread when it starts
+	prefsStore fyne.Preferences
+	options    Preferences
+
 	selectedDevice string
 	selectedISO    string
 	state          OperationState
 	distroInfo     *distro.Info
+
+	// errorLinesMu guards errorLines, the CLI error lines seen during a sudo run, and
+	// cliSummary, the completion summary the CLI printed
+	errorLinesMu sync.Mutex
+	errorLines   []string
+	cliSummary   string
+
+	// cancelMu guards cancel and done, which stop the running write and are closed
+	// once it has cleaned up
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
 }
 
 // NewMainWindow creates the main application window
@@ -56,6 +82,7 @@ func NewMainWindow(app fyne.App, distroInfo *distro.Info) *MainWindow {
 		window:     app.NewWindow("WoeUSB-go"),
 		state:      StateIdle,
 		distroInfo: distroInfo,
+		prefsStore: app.Preferences(),
 	}
 
 	w.buildUI()
@@ -71,8 +98,11 @@ func (w *MainWindow) buildUI() {
 	deviceLabel := widget.NewLabel("Target USB Device:")
 	deviceLabel.TextStyle = fyne.TextStyle{Bold: true}
 
-	w.deviceSelector = components.NewDeviceSelector(func(device string) {
-		w.selectedDevice = device
+	w.deviceSelector = components.NewDeviceSelector(func(device *components.USBDevice) {
+		w.selectedDevice = ""
+		if device != nil {
+			w.selectedDevice = device.Path
+		}
 		w.UpdateState()
 	})
 
@@ -80,10 +110,26 @@ func (w *MainWindow) buildUI() {
 		_ = w.deviceSelector.RefreshDevices()
 	})
 
+	prefs := LoadPreferences(w.prefsStore)
+
+	w.nonRemovable = widget.NewCheck("Show non-removable USB devices", nil)
+	w.nonRemovable.SetChecked(prefs.NonRemovable)
+	w.deviceSelector.SetIncludeNonRemovable(prefs.NonRemovable)
+	w.nonRemovable.OnChanged = func(checked bool) {
+		w.deviceSelector.SetIncludeNonRemovable(checked)
+		_ = w.deviceSelector.RefreshDevices()
+		w.savePreferences()
+	}
+
+	// Not remembered, so each large device has to be allowed again
+	w.allowLarge = widget.NewCheck(fmt.Sprintf("Allow devices larger than %d GB", validation.DefaultMaxDeviceSize>>30), nil)
+
 	deviceSection := container.NewVBox(
 		deviceLabel,
 		w.deviceSelector,
 		w.refreshButton,
+		w.nonRemovable,
+		w.allowLarge,
 	)
 
 	// File browser section
@@ -101,6 +147,29 @@ func (w *MainWindow) buildUI() {
 		w.fileBrowser,
 	)
 
+	// Advanced options, restored from the last session
+	w.filesystem = widget.NewSelect(filesystemOptions, nil)
+	w.filesystem.SetSelected(prefs.Filesystem)
+	w.filesystem.OnChanged = func(string) { w.savePreferences() }
+
+	w.label = widget.NewEntry()
+	w.label.SetText(prefs.Label)
+	w.label.OnChanged = func(string) { w.savePreferences() }
+
+	w.verify = widget.NewCheck("Verify all files after copying (slow)", nil)
+	w.verify.SetChecked(prefs.Verify)
+	w.verify.OnChanged = func(bool) { w.savePreferences() }
+
+	advancedSection := widget.NewAccordion(widget.NewAccordionItem("Advanced options",
+		container.NewVBox(
+			widget.NewForm(
+				widget.NewFormItem("Filesystem", w.filesystem),
+				widget.NewFormItem("Label", w.label),
+			),
+			w.verify,
+		),
+	))
+
 	// Progress section
 	w.progressBar = components.NewProgressBar()
 
@@ -113,16 +182,21 @@ func (w *MainWindow) buildUI() {
 	w.startButton.Importance = widget.HighImportance
 	w.startButton.Disable() // Disabled until selections are made
 
+	// Cancel button, enabled only while writing
+	w.cancelButton = widget.NewButton("Cancel", w.onCancelClicked)
+	w.cancelButton.Disable()
+
 	// Layout
 	content := container.NewVBox(
 		deviceSection,
 		widget.NewSeparator(),
 		isoSection,
+		advancedSection,
 		widget.NewSeparator(),
 		w.progressBar,
 		w.statusLabel,
 		widget.NewSeparator(),
-		w.startButton,
+		container.NewGridWithColumns(2, w.startButton, w.cancelButton),
 	)
 
 	w.window.SetContent(container.NewPadded(content))
@@ -150,14 +224,45 @@ func (w *MainWindow) UpdateState() {
 		w.startButton.Disable()
 	}
 
+	if CanCancel(w.state) {
+		w.cancelButton.Enable()
+	} else {
+		w.cancelButton.Disable()
+	}
+
 	// Disable controls during operation
 	if w.state == StateInProgress {
 		w.refreshButton.Disable()
+		w.nonRemovable.Disable()
+		w.allowLarge.Disable()
+		w.filesystem.Disable()
+		w.label.Disable()
+		w.verify.Disable()
 	} else {
 		w.refreshButton.Enable()
+		w.nonRemovable.Enable()
+		w.allowLarge.Enable()
+		w.filesystem.Enable()
+		w.label.Enable()
+		w.verify.Enable()
 	}
 }
 
+// currentPreferences returns the options currently set in the window
+func (w *MainWindow) currentPreferences() Preferences {
+	return Preferences{
+		Filesystem:   w.filesystem.Selected,
+		Label:        w.label.Text,
+		NonRemovable: w.nonRemovable.Checked,
+		Verify:       w.verify.Checked,
+	}
+}
+
+// savePreferences stores the current options for the next session
+func (w *MainWindow) savePreferences() {
+	SavePreferences(w.prefsStore, w.currentPreferences())
+}
+
 // SetState sets the operation state and updates UI accordingly
 func (w *MainWindow) SetState(state OperationState) {
 	w.state = state
@@ -171,11 +276,24 @@ func (w *MainWindow) GetState() OperationState {
 
 // onStartClicked handles the start button click
 func (w *MainWindow) onStartClicked() {
+	message := "WARNING: All data on " + w.selectedDevice + " will be permanently erased!\n\n"
+	if dev := w.deviceSelector.GetSelectedDevice(); dev != nil {
+		name := dev.Name
+		if name == "" {
+			name = "Unknown Device"
+		}
+		message += fmt.Sprintf("Device: %s\nSize: %s\n\n", name, dev.SizeHuman)
+		if !dev.Removable {
+			message += "This device reports itself as non-removable. Make sure it is really\n" +
+				"the USB drive you intend to overwrite and not an external system disk.\n\n"
+		}
+	}
+	message += "Are you sure you want to continue?"
+
 	// Show confirmation dialog
 	dialog.ShowConfirm(
 		"Confirm Write Operation",
-		"WARNING: All data on "+w.selectedDevice+" will be permanently erased!\n\n"+
-			"Are you sure you want to continue?",
+		message,
 		func(confirmed bool) {
 			if confirmed {
 				w.startWriteOperation()
@@ -187,14 +305,36 @@ func (w *MainWindow) onStartClicked() {
 
 // startWriteOperation begins the USB creation process
 func (w *MainWindow) startWriteOperation() {
+	w.options = w.currentPreferences()
+	if w.options.Label == "" {
+		w.options.Label = DefaultPreferences().Label
+	}
+
 	// Check if we're running as root
 	if IsRoot() {
-		// Already root, proceed directly
+		// Already root, proceed directly. The woeusb package only writes FAT sticks,
+		// so NTFS and exFAT run the CLI like the unprivileged paths do.
 		w.SetState(StateInProgress)
 		w.progressBar.Reset()
-		go w.runWriteOperation("")
+		if strings.EqualFold(w.options.Filesystem, "FAT") {
+			go w.runWriteOperation(w.executeDeviceMode)
+		} else {
+			go w.runWriteOperation(w.executeCLI)
+		}
+	} else if _, ok := AskpassHelper(); ok {
+		// The askpass helper supplies the credentials, so no password dialog
+		w.SetState(StateInProgress)
+		w.progressBar.Reset()
+		go w.runWriteOperation(w.executeWithAskpass)
+	} else if pkexec, ok := PkexecPath(); ok {
+		// Polkit shows the system's own authentication dialog
+		w.SetState(StateInProgress)
+		w.progressBar.Reset()
+		go w.runWriteOperation(func(ctx context.Context) (writeResult, error) {
+			return w.executeWithPkexec(ctx, pkexec)
+		})
 	} else {
-		// Need to elevate - show password dialog
+		// Without pkexec, fall back to sudo with our own password dialog
 		components.ShowPasswordDialogWithInfo(
 			w.window,
 			"WoeUSB-go needs administrator privileges to write to the USB device.",
@@ -217,7 +357,9 @@ func (w *MainWindow) startWriteOperation() {
 						return
 					}
 					// Run the write operation with sudo
-					w.runWriteOperation(result.Password)
+					w.runWriteOperation(func(ctx context.Context) (writeResult, error) {
+						return w.executeWithSudo(ctx, result.Password)
+					})
 				}()
 			},
 		)
@@ -242,104 +384,234 @@ func (w *MainWindow) validateSudoPassword(password string) error {
 	return cmd.Wait()
 }
 
-// runWriteOperation executes the write operation (with or without sudo)
-func (w *MainWindow) runWriteOperation(password string) {
-	var err error
+// writeResult is what a finished write reports back to runWriteOperation
+type writeResult struct {
+	failedFiles []filecopy.FailedFile // files an in-process write could not copy
+	copiedBytes int64                 // size of an in-process write, for the summary
+	summary     string                // completion summary printed by the CLI
+}
 
-	if password != "" {
-		// Cache sudo credentials for subsequent commands
-		w.updateProgress(0.02, "Authenticating...")
-		// Run with sudo using the provided password
-		err = w.executeWithSudo(password)
-	} else {
-		// Already root, run directly
-		err = w.executeDeviceMode()
-	}
+// runWriteOperation runs execute (in-process or via sudo) and shows the outcome.
+// execute is cancelled through cancelOperation.
+func (w *MainWindow) runWriteOperation(execute func(ctx context.Context) (writeResult, error)) {
+	start := time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	w.cancelMu.Lock()
+	w.cancel, w.done = cancel, done
+	w.cancelMu.Unlock()
+	defer func() {
+		cancel()
+		close(done)
+	}()
+
+	result, err := execute(ctx)
 
 	// Update UI on completion (schedule on main thread)
 	time.Sleep(100 * time.Millisecond) // Small delay to ensure UI updates
 
-	if err != nil {
+	if errors.Is(err, context.Canceled) {
+		w.SetState(StateIdle)
+		w.updateProgress(0, "Cancelled")
+		w.updateStatus("Cancelled")
+	} else if err != nil {
 		w.SetState(StateError)
 		w.updateStatus(fmt.Sprintf("Error: %v", err))
 		w.showError(err.Error())
+	} else if len(result.failedFiles) > 0 {
+		w.SetState(StateComplete)
+		w.updateProgress(1.0, "Completed with warnings")
+		w.showCompletedWithWarnings(result.failedFiles)
 	} else {
+		// The CLI prints its own summary; in-process writes build it here
+		summary := result.summary
+		if summary == "" && result.copiedBytes > 0 {
+			summary = filecopy.CompletionSummary(time.Since(start), result.copiedBytes)
+		}
 		w.SetState(StateComplete)
 		w.updateProgress(1.0, "Complete!")
-		w.showSuccess()
+		w.showSuccess(summary)
 	}
 }
 
+// cancelOperation cancels the running write and returns a channel that is closed once
+// it has cleaned up. Without a running write the channel is already closed.
+func (w *MainWindow) cancelOperation() <-chan struct{} {
+	w.cancelMu.Lock()
+	cancel, done := w.cancel, w.done
+	w.cancelMu.Unlock()
+
+	if cancel == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	cancel()
+	return done
+}
+
+// onCancelClicked aborts the running write. The write stops copying, unmounts and
+// leaves the window idle once it has cleaned up.
+func (w *MainWindow) onCancelClicked() {
+	w.cancelButton.Disable()
+	w.updateStatus("Cancelling, cleaning up...")
+	w.cancelOperation()
+}
+
+// executeCLI runs the CLI tool directly, for a GUI that already runs as root
+func (w *MainWindow) executeCLI(ctx context.Context) (writeResult, error) {
+	return w.runCLIElevated(ctx, nil, "")
+}
+
 // executeWithSudo runs the CLI tool with elevated privileges via sudo -S
-func (w *MainWindow) executeWithSudo(password string) error {
-	w.updateProgress(0.02, "Authenticating...")
+func (w *MainWindow) executeWithSudo(ctx context.Context, password string) (writeResult, error) {
+	return w.runCLIElevated(ctx, []string{"sudo", "-S"}, password)
+}
+
+// executeWithAskpass runs the CLI tool via sudo -A, which gets the credentials from
+// the SUDO_ASKPASS helper
+func (w *MainWindow) executeWithAskpass(ctx context.Context) (writeResult, error) {
+	return w.runCLIElevated(ctx, []string{"sudo", "-A"}, "")
+}
+
+// executeWithPkexec runs the CLI tool through pkexec, which asks for credentials with
+// the desktop's Polkit authentication agent
+func (w *MainWindow) executeWithPkexec(ctx context.Context, pkexec string) (writeResult, error) {
+	return w.runCLIElevated(ctx, []string{pkexec}, "")
+}
+
+// runCLIElevated runs the CLI tool through the elevate command (sudo with its
+// authentication flag, or pkexec), piping password to sudo's stdin if it is set.
+// An empty elevate runs the CLI directly.
+// Cancelling ctx sends SIGTERM, which sudo relays to the CLI so it can clean up before
+// exiting. pkexec runs the CLI as root where we cannot signal it, so it is cancelled by
+// closing its stdin instead (--stop-on-stdin-close).
+func (w *MainWindow) runCLIElevated(ctx context.Context, elevate []string, password string) (writeResult, error) {
+	useSudo := len(elevate) > 0 && elevate[0] == "sudo"
+	usePkexec := len(elevate) > 0 && !useSudo
+	if len(elevate) > 0 {
+		w.updateProgress(0.02, "Authenticating...")
+	}
 
 	// Get the path to our own executable
 	executable, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %v", err)
+		return writeResult{}, fmt.Errorf("failed to get executable path: %v", err)
 	}
 
-	// Build the command: sudo -S /path/to/woeusb-go --device <iso> <device>
-	// Use -n after authentication to prevent further password prompts
-	cmd := exec.Command("sudo", "-S", executable, "--device", w.selectedISO, w.selectedDevice)
+	// Build the command: [sudo -S|-A|pkexec] /path/to/woeusb-go --device <iso> <device>
+	args := append(append([]string{}, elevate...), executable, "--device", "--no-color", "--progress-style", "none", "--progress-json",
+		"--target-filesystem", w.options.Filesystem, "--label", w.options.Label)
+	if usePkexec {
+		args = append(args, "--stop-on-stdin-close")
+	}
+	if w.deviceSelector.IncludesNonRemovable() {
+		args = append(args, "--include-non-removable")
+	}
+	if w.allowLarge.Checked {
+		args = append(args, "--allow-large-device")
+	}
+	if w.options.Verify {
+		args = append(args, "--verify")
+	}
+	args = append(args, w.selectedISO, w.selectedDevice)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 
 	// Create pipe for stdin to send password
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %v", err)
+		return writeResult{}, fmt.Errorf("failed to create stdin pipe: %v", err)
 	}
-
-	// Create pipes for stdout/stderr to capture progress
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
+	if usePkexec {
+		cmd.Cancel = stdin.Close
+	} else {
+		cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
 	}
-	stderr, err := cmd.StderrPipe()
+
+	// Send stdout and stderr into one pipe, so lines arrive in the order the CLI wrote
+	// them instead of interleaving partial lines from two streams
+	pipeReader, pipeWriter, err := os.Pipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+		return writeResult{}, fmt.Errorf("failed to create output pipe: %v", err)
 	}
+	defer func() { _ = pipeReader.Close() }()
+	cmd.Stdout = pipeWriter
+	cmd.Stderr = pipeWriter
 
 	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start sudo: %v", err)
+	err = cmd.Start()
+	// The child holds its own copy; closing ours lets the reader see EOF when it exits
+	_ = pipeWriter.Close()
+	if err != nil {
+		return writeResult{}, fmt.Errorf("failed to start %s: %v", filepath.Base(args[0]), err)
 	}
 
-	// Send password to sudo via stdin, then close
-	_, err = stdin.Write([]byte(password + "\n"))
-	if err != nil {
-		return fmt.Errorf("failed to send password: %v", err)
+	// Send password to sudo via stdin, then close. With pkexec stdin stays open until
+	// the write finishes or is cancelled.
+	if password != "" {
+		if _, err := stdin.Write([]byte(password + "\n")); err != nil {
+			return writeResult{}, fmt.Errorf("failed to send password: %v", err)
+		}
+	}
+	if !usePkexec {
+		_ = stdin.Close()
 	}
-	_ = stdin.Close()
 
-	// Read output in goroutines to update progress
-	// Use a WaitGroup to ensure we read all output before Wait() returns
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		w.readOutputWithCR(stdout)
-	}()
-	go func() {
-		defer wg.Done()
-		w.readOutputWithCR(stderr)
-	}()
+	w.errorLinesMu.Lock()
+	w.errorLines = nil
+	w.cliSummary = ""
+	w.errorLinesMu.Unlock()
 
-	// Wait for output readers to finish
-	wg.Wait()
+	// Read all output before Wait() so no progress or error lines are lost
+	w.readOutputWithCR(pipeReader)
 
 	// Wait for command completion
 	if err := cmd.Wait(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return writeResult{}, ctxErr
+		}
+
+		// Prefer the CLI's own error lines (e.g. which files failed to copy)
+		w.errorLinesMu.Lock()
+		errorLines := w.errorLines
+		w.errorLinesMu.Unlock()
+		if len(errorLines) > 0 {
+			return writeResult{}, fmt.Errorf("write operation failed:\n%s", strings.Join(errorLines, "\n"))
+		}
+
 		// Check if it's an authentication failure
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			if exitErr.ExitCode() == 1 {
-				return fmt.Errorf("authentication failed - incorrect password")
+			if usePkexec {
+				if msg, ok := pkexecFailure(exitErr.ExitCode()); ok {
+					return writeResult{}, fmt.Errorf("%s", msg)
+				}
+				return writeResult{}, fmt.Errorf("write operation failed: %v", err)
+			}
+			if useSudo && exitErr.ExitCode() == 1 {
+				if password == "" {
+					return writeResult{}, fmt.Errorf("authentication failed - the askpass helper was rejected or cancelled")
+				}
+				return writeResult{}, fmt.Errorf("authentication failed - incorrect password")
 			}
 		}
-		return fmt.Errorf("write operation failed: %v", err)
+		return writeResult{}, fmt.Errorf("write operation failed: %v", err)
 	}
 
-	return nil
+	w.errorLinesMu.Lock()
+	defer w.errorLinesMu.Unlock()
+	return writeResult{summary: w.cliSummary}, nil
+}
+
+// pkexecFailure describes pkexec's own exit codes, which mean the CLI never ran
+func pkexecFailure(code int) (string, bool) {
+	switch code {
+	case 126:
+		return "authentication failed - the request was dismissed or not authorized", true
+	case 127:
+		return "authentication failed - no Polkit authentication agent is running, or the credentials were rejected", true
+	}
+	return "", false
 }
 
 // readOutputWithCR reads from a pipe handling both \n and \r as line separators
@@ -379,48 +651,29 @@ func (w *MainWindow) parseProgressLine(line string) {
 		return
 	}
 
-	// Try to parse percentage from "Copying: XX.X%" format
-	if strings.Contains(line, "Copying:") && strings.Contains(line, "%") {
-		// Extract percentage from line like "Copying: 45.2% (1.2 GB) - sources/install.wim"
-		var pct float64
-		if _, err := fmt.Sscanf(line, "Copying: %f%%", &pct); err == nil {
-			// Scale copy progress from 0.25 to 0.85
-			progress := 0.25 + (pct/100.0)*0.60
-			w.updateProgress(progress, line)
-			return
-		}
+	// Collect error lines so the failure dialog can show them
+	if msg, ok := strings.CutPrefix(strings.TrimSpace(line), "✗ "); ok {
+		w.errorLinesMu.Lock()
+		w.errorLines = append(w.errorLines, msg)
+		w.errorLinesMu.Unlock()
 	}
 
-	// Try to parse wimlib-imagex split progress
-	if strings.Contains(line, "Writing") && strings.Contains(line, "MiB") {
-		w.updateProgress(0.85, "Splitting WIM file: "+line)
-		return
+	// Progress arrives as JSON events on stdout; text lines only update the status
+	if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "{") {
+		var ev output.ProgressEvent
+		if err := json.Unmarshal([]byte(trimmed), &ev); err == nil {
+			if value, status := progressFromEvent(ev); status != "" {
+				w.updateProgress(value, status)
+			}
+			return
+		}
 	}
 
-	// Map CLI output to progress updates
 	switch {
-	case strings.Contains(line, "Mounting source") || strings.Contains(line, "Mounting ISO"):
-		w.updateProgress(0.05, "Mounting ISO file...")
-	case strings.Contains(line, "Wiping") || strings.Contains(line, "partition table"):
-		w.updateProgress(0.10, "Creating partition table...")
-	case strings.Contains(line, "Formatting"):
-		w.updateProgress(0.15, "Formatting partition...")
-	case strings.Contains(line, "Mounting target"):
-		w.updateProgress(0.20, "Mounting target partition...")
-	case strings.Contains(line, "Will split"):
-		w.updateProgress(0.22, line)
-	case strings.Contains(line, "Copying files"):
-		w.updateProgress(0.25, "Copying files...")
-	case strings.Contains(line, "Splitting"):
-		w.updateProgress(0.85, line)
-	case strings.Contains(line, "Split") && strings.Contains(line, "SWM"):
-		w.updateProgress(0.88, line)
-	case strings.Contains(line, "Installing GRUB") || strings.Contains(line, "GRUB"):
-		w.updateProgress(0.90, "Installing bootloader...")
-	case strings.Contains(line, "Cleaning up"):
-		w.updateProgress(0.95, "Cleaning up...")
-	case strings.Contains(line, "completed successfully"):
-		w.updateProgress(1.0, "Complete!")
+	case strings.Contains(line, "Completed in "):
+		w.errorLinesMu.Lock()
+		w.cliSummary = line[strings.Index(line, "Completed in "):]
+		w.errorLinesMu.Unlock()
 	default:
 		// Show any other meaningful output
 		if len(line) > 5 && !strings.HasPrefix(line, "[sudo]") {
@@ -429,6 +682,48 @@ func (w *MainWindow) parseProgressLine(line string) {
 	}
 }
 
+// phaseProgress maps each CLI phase to the part of the progress bar it fills and the
+// status shown for it
+var phaseProgress = map[string]struct {
+	start, end float64
+	label      string
+}{
+	output.PhaseMount:      {0.02, 0.05, "Mounting ISO file..."},
+	output.PhasePartition:  {0.05, 0.10, "Creating partition table..."},
+	output.PhaseFormat:     {0.10, 0.20, "Formatting partition..."},
+	output.PhaseCopy:       {0.20, 0.85, "Copying files..."},
+	output.PhaseVerify:     {0.85, 0.90, "Verifying files..."},
+	output.PhaseBootloader: {0.90, 0.95, "Installing bootloader..."},
+	output.PhaseCleanup:    {0.95, 1.00, "Cleaning up..."},
+	output.PhaseDone:       {1.00, 1.00, "Complete!"},
+}
+
+// progressFromEvent converts a CLI progress event to a progress bar value and status.
+// The value is -1 when the event only changes the status, and the status is empty for
+// unknown phases.
+func progressFromEvent(ev output.ProgressEvent) (float64, string) {
+	phase, ok := phaseProgress[ev.Phase]
+	if !ok {
+		return -1, ""
+	}
+	if ev.Message != "" {
+		return -1, ev.Message
+	}
+	if ev.Pct < 0 {
+		return -1, phase.label
+	}
+
+	value := phase.start + min(ev.Pct, 1)*(phase.end-phase.start)
+	if ev.Pct == 0 || ev.Phase == output.PhaseDone {
+		return value, phase.label
+	}
+	status := fmt.Sprintf("%s %.1f%%", strings.TrimSuffix(phase.label, "..."), ev.Pct*100)
+	if ev.File != "" {
+		status += " - " + ev.File
+	}
+	return value, status
+}
+
 // updateProgress safely updates progress from any goroutine
 // If value is -1, only updates status text without changing progress bar
 func (w *MainWindow) updateProgress(value float64, status string) {
@@ -451,91 +746,61 @@ func (w *MainWindow) showError(message string) {
 	dialog.ShowError(fmt.Errorf("%s", message), w.window)
 }
 
-// showSuccess displays a success dialog
-func (w *MainWindow) showSuccess() {
-	dialog.ShowInformation("Success",
-		"Bootable USB created successfully!\n\nYou may now safely remove the USB device.",
-		w.window)
-}
-
-// executeDeviceMode performs the actual USB creation
-func (w *MainWindow) executeDeviceMode() error {
-	var srcMount, dstMount string
-	var err error
-
-	// Cleanup function
-	defer func() {
-		if dstMount != "" {
-			_ = mount.CleanupMountpoint(dstMount)
-		}
-		if srcMount != "" {
-			_ = mount.CleanupMountpoint(srcMount)
-		}
-	}()
-
-	// Step 1: Mount source ISO
-	w.updateProgress(0.05, "Mounting ISO file...")
-	srcMount, err = mount.MountISO(w.selectedISO)
-	if err != nil {
-		return fmt.Errorf("failed to mount ISO: %v", err)
-	}
-
-	// Step 2: Create partition table
-	w.updateProgress(0.10, "Creating partition table...")
-	if err := partition.CreateBootablePartition(w.selectedDevice, "FAT"); err != nil {
-		return fmt.Errorf("failed to create partition: %v", err)
-	}
-
-	// Step 3: Get partition path and format
-	mainPartition := partition.GetPartitionPath(w.selectedDevice)
-	w.updateProgress(0.15, "Formatting partition as FAT32...")
-	if err := filesystem.FormatPartition(mainPartition, "FAT", "YOURWINDOWS"); err != nil {
-		return fmt.Errorf("failed to format partition: %v", err)
-	}
-
-	// Step 4: Mount target partition
-	w.updateProgress(0.20, "Mounting target partition...")
-	dstMount, err = mount.MountDevice(mainPartition, "vfat")
-	if err != nil {
-		return fmt.Errorf("failed to mount target: %v", err)
+// showSuccess displays a success dialog with the write's completion summary, if any
+func (w *MainWindow) showSuccess(summary string) {
+	message := "Bootable USB created successfully!"
+	if summary != "" {
+		message += "\n\n" + summary
 	}
+	dialog.ShowInformation("Success", message+"\n\nYou may now safely remove the USB device.", w.window)
+}
 
-	// Step 5: Copy files with progress callback
-	w.updateProgress(0.25, "Copying Windows files (this may take a while)...")
+// showCompletedWithWarnings tells the user the stick was written but some files are missing
+func (w *MainWindow) showCompletedWithWarnings(failed []filecopy.FailedFile) {
+	dialog.ShowInformation("Completed with warnings",
+		fmt.Sprintf("The USB was written, but %d file(s) could not be copied:\n\n%s\n\n"+
+			"The stick may not boot or install correctly. Re-run the write, "+
+			"ideally with a different USB port or stick.", len(failed), formatFailedFiles(failed)),
+		w.window)
+}
 
-	progressCallback := func(current, total int64, filename string) {
-		if total > 0 {
-			// Scale progress from 0.25 to 0.90 during copy
-			copyProgress := float64(current) / float64(total)
-			overallProgress := 0.25 + (copyProgress * 0.65)
-			status := fmt.Sprintf("Copying: %s (%.1f%%)", filename, copyProgress*100)
-			w.updateProgress(overallProgress, status)
-		}
-	}
+// executeDeviceMode performs the actual USB creation with the woeusb package.
+// Cancelling ctx stops the copy; the mounts are cleaned up before it returns.
+func (w *MainWindow) executeDeviceMode(ctx context.Context) (writeResult, error) {
+	writer := woeusb.New(woeusb.Options{
+		Source:           w.selectedISO,
+		Target:           w.selectedDevice,
+		Mode:             woeusb.ModeDevice,
+		Filesystem:       w.options.Filesystem,
+		Label:            w.options.Label,
+		Verify:           w.options.Verify,
+		AllowLargeDevice: w.allowLarge.Checked,
+		ProgressFunc: func(p woeusb.Progress) {
+			ev := output.ProgressEvent{Phase: p.Phase, Pct: p.Pct, File: p.File, Message: p.Message}
+			if value, status := progressFromEvent(ev); status != "" {
+				w.updateProgress(value, status)
+			}
+		},
+	})
 
-	if err := filecopy.CopyWindowsISOWithWIMSplit(srcMount, dstMount, progressCallback); err != nil {
-		return fmt.Errorf("failed to copy files: %v", err)
-	}
+	err := writer.Run(ctx)
+	// Individual file failures still leave a mostly usable stick, so they are
+	// reported on the completion screen instead of failing the write
+	return writeResult{failedFiles: writer.FailedFiles(), copiedBytes: writer.BytesCopied()}, err
+}
 
-	// Step 6: Install GRUB bootloader
-	w.updateProgress(0.92, "Installing GRUB bootloader...")
-	dependencies, _ := deps.CheckDependencies()
-	if dependencies != nil && dependencies.GrubCmd != "" {
-		if err := bootloader.InstallGRUBWithConfig(dstMount, w.selectedDevice, dependencies.GrubCmd); err != nil {
-			// GRUB failure is non-fatal, UEFI boot will still work
-			w.updateProgress(0.95, "GRUB install failed (UEFI boot will work)")
+// formatFailedFiles lists failed files one per line, capped so the dialog stays readable
+func formatFailedFiles(failed []filecopy.FailedFile) string {
+	const maxShown = 10
+	var lines []string
+	for i, f := range failed {
+		if i == maxShown {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(failed)-maxShown))
+			break
 		}
+		lines = append(lines, fmt.Sprintf("%s: %v", f.Path, f.Err))
 	}
-
-	// Step 7: Cleanup
-	w.updateProgress(0.98, "Cleaning up...")
-	_ = mount.CleanupMountpoint(dstMount) // Non-fatal, ignore error
-	dstMount = ""
-
-	_ = mount.CleanupMountpoint(srcMount) // Non-fatal, ignore error
-	srcMount = ""
-
-	return nil
+	return strings.Join(lines, "\n")
 }
 
 // onCloseRequested handles window close requests
@@ -548,8 +813,12 @@ func (w *MainWindow) onCloseRequested() {
 				"Are you sure you want to close?",
 			func(confirmed bool) {
 				if confirmed {
-					// TODO: Cancel operation and cleanup
-					w.window.Close()
+					w.updateStatus("Cancelling, cleaning up...")
+					done := w.cancelOperation()
+					go func() {
+						<-done
+						fyne.Do(w.window.Close)
+					}()
 				}
 			},
 			w.window,
@@ -565,6 +834,11 @@ func CanStart(deviceSelected, isoSelected bool, state OperationState) bool {
 	return deviceSelected && isoSelected && state == StateIdle
 }
 
+// CanCancel returns true if the cancel button should be enabled
+func CanCancel(state OperationState) bool {
+	return state == StateInProgress
+}
+
 // ShouldDisableControls returns true if UI controls should be disabled
 // This is exposed for testing Property 11
 func ShouldDisableControls(state OperationState) bool {
@@ -1,7 +1,10 @@
 package gui
 
 import (
+	"math"
 	"testing"
+
+	"github.com/mathisen/woeusb-go/internal/output"
 )
 
 // TestProperty7_StartButtonState tests Property 7:
@@ -98,3 +101,40 @@ func TestCanStart_AllCombinations(t *testing.T) {
 		}
 	}
 }
+
+func TestProgressFromEvent(t *testing.T) {
+	testCases := []struct {
+		ev     output.ProgressEvent
+		value  float64
+		status string
+	}{
+		{output.ProgressEvent{Phase: output.PhaseCopy, Pct: 0}, 0.20, "Copying files..."},
+		{output.ProgressEvent{Phase: output.PhaseCopy, Pct: 0.5, File: "sources/install.wim"}, 0.525, "Copying files 50.0% - sources/install.wim"},
+		{output.ProgressEvent{Phase: output.PhaseCopy, Pct: -1, Message: "Copying Windows files..."}, -1, "Copying Windows files..."},
+		{output.ProgressEvent{Phase: output.PhaseFormat, Pct: 1}, 0.20, "Formatting partition 100.0%"},
+		{output.ProgressEvent{Phase: output.PhaseDone, Pct: 0}, 1, "Complete!"},
+		{output.ProgressEvent{Phase: "unknown", Pct: 0.5}, -1, ""},
+	}
+
+	for _, tc := range testCases {
+		value, status := progressFromEvent(tc.ev)
+		if math.Abs(value-tc.value) > 1e-9 || status != tc.status {
+			t.Errorf("progressFromEvent(%+v) = %v, %q, want %v, %q", tc.ev, value, status, tc.value, tc.status)
+		}
+	}
+}
+
+// TestCanCancel tests that the cancel button is only enabled while writing
+func TestCanCancel(t *testing.T) {
+	states := map[OperationState]bool{
+		StateIdle:       false,
+		StateInProgress: true,
+		StateComplete:   false,
+		StateError:      false,
+	}
+	for state, want := range states {
+		if got := CanCancel(state); got != want {
+			t.Errorf("CanCancel(%v) = %v, want %v", state, got, want)
+		}
+	}
+}
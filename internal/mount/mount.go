@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -101,6 +103,9 @@ func Mount(source, mountpoint, fstype string, opts []string) error {
 
 // Unmount attempts to unmount a filesystem at the given mountpoint
 func Unmount(mountpoint string) error {
+	// Write out cached data first, whatever the mount options
+	_ = SyncFilesystem(mountpoint)
+
 	// Try syscall first
 	err := syscall.Unmount(mountpoint, 0)
 	if err == nil {
@@ -119,6 +124,16 @@ func Unmount(mountpoint string) error {
 	return nil
 }
 
+// SyncFilesystem writes out all cached data of the filesystem mounted at mountpoint.
+// It uses sync(2), which flushes every filesystem, as syscall has no syncfs.
+func SyncFilesystem(mountpoint string) error {
+	if _, err := os.Stat(mountpoint); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", mountpoint, err)
+	}
+	syscall.Sync()
+	return nil
+}
+
 // CreateTempMountpoint creates a temporary directory for mounting
 func CreateTempMountpoint(prefix string) (string, error) {
 	tmpDir, err := os.MkdirTemp("", prefix)
@@ -150,20 +165,37 @@ func CleanupMountpoint(mountpoint string) error {
 	return nil
 }
 
-// CheckNotBusy checks if a device is mounted and attempts to unmount it
-func CheckNotBusy(devicePath string) error {
+// MountedPaths returns the mount points of a device and its partitions
+func MountedPaths(devicePath string) ([]string, error) {
+	// /proc/mounts lists canonical nodes, so match against the symlink target
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
+
 	mounts, err := GetMountInfo()
 	if err != nil {
-		return fmt.Errorf("failed to get mount info: %v", err)
+		return nil, fmt.Errorf("failed to get mount info: %v", err)
 	}
 
-	// Find all mount points for this device or its partitions
 	var mountedPaths []string
 	for _, mount := range mounts {
 		if mount.Device == devicePath || strings.HasPrefix(mount.Device, devicePath) {
 			mountedPaths = append(mountedPaths, mount.Mountpoint)
 		}
 	}
+	return mountedPaths, nil
+}
+
+// CheckNotBusy checks if a device is mounted and attempts to unmount it
+func CheckNotBusy(devicePath string) error {
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
+
+	mountedPaths, err := MountedPaths(devicePath)
+	if err != nil {
+		return err
+	}
 
 	if len(mountedPaths) == 0 {
 		return nil // Device is not mounted
@@ -172,6 +204,10 @@ func CheckNotBusy(devicePath string) error {
 	// Attempt to unmount all mount points
 	for _, mountpoint := range mountedPaths {
 		if err := Unmount(mountpoint); err != nil {
+			if holders, herr := FindDeviceHolders(devicePath); herr == nil && len(holders) > 0 {
+				return fmt.Errorf("device %s is busy (mounted at %s): held by %s: %v",
+					devicePath, mountpoint, formatHolders(holders), err)
+			}
 			return fmt.Errorf("device %s is busy (mounted at %s) and cannot be unmounted: %v",
 				devicePath, mountpoint, err)
 		}
@@ -180,6 +216,92 @@ func CheckNotBusy(devicePath string) error {
 	return nil
 }
 
+// ProcessInfo identifies a process holding a device or a file on it open
+type ProcessInfo struct {
+	PID  int
+	Name string
+}
+
+func (p ProcessInfo) String() string {
+	return fmt.Sprintf("%s (pid %d)", p.Name, p.PID)
+}
+
+// FindDeviceHolders returns the processes that have the device (or one of its partitions)
+// open, or a file or working directory under one of its mountpoints, by scanning /proc
+func FindDeviceHolders(device string) ([]ProcessInfo, error) {
+	_, mountpoints, err := IsMounted(device)
+	if err != nil {
+		return nil, err
+	}
+	return findHolders("/proc", device, mountpoints)
+}
+
+// findHolders scans procRoot/<pid>/{cwd,fd/*} for links into device or mountpoints
+func findHolders(procRoot, device string, mountpoints []string) ([]ProcessInfo, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", procRoot, err)
+	}
+
+	self := os.Getpid()
+	var holders []ProcessInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		pidDir := filepath.Join(procRoot, entry.Name())
+		if !holdsPath(pidDir, device, mountpoints) {
+			continue
+		}
+
+		name := "unknown"
+		if comm, err := os.ReadFile(filepath.Join(pidDir, "comm")); err == nil {
+			name = strings.TrimSpace(string(comm))
+		}
+		holders = append(holders, ProcessInfo{PID: pid, Name: name})
+	}
+
+	return holders, nil
+}
+
+// holdsPath reports whether the process at pidDir has its cwd or an open fd on the
+// device or under one of the mountpoints. Unreadable processes are skipped.
+func holdsPath(pidDir, device string, mountpoints []string) bool {
+	links := []string{filepath.Join(pidDir, "cwd")}
+	if fds, err := os.ReadDir(filepath.Join(pidDir, "fd")); err == nil {
+		for _, fd := range fds {
+			links = append(links, filepath.Join(pidDir, "fd", fd.Name()))
+		}
+	}
+
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil {
+			continue
+		}
+		if target == device || strings.HasPrefix(target, device) {
+			return true
+		}
+		for _, mp := range mountpoints {
+			if target == mp || strings.HasPrefix(target, mp+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatHolders joins holders as "name (pid N), ..."
+func formatHolders(holders []ProcessInfo) string {
+	parts := make([]string, len(holders))
+	for i, h := range holders {
+		parts[i] = h.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
 // IsMounted checks if a specific device or mountpoint is currently mounted
 func IsMounted(path string) (bool, []string, error) {
 	mounts, err := GetMountInfo()
@@ -197,47 +319,331 @@ func IsMounted(path string) (bool, []string, error) {
 	return len(mountpoints) > 0, mountpoints, nil
 }
 
+// isoMountAttempt is one filesystem/options combination tried by MountISO
+type isoMountAttempt struct {
+	fstype string
+	opts   []string
+}
+
+// isoMountAttempts lists the ways MountISO tries to mount an image, in order.
+// UDF comes first (Windows 10/11 ISOs); iso9660 is mounted with iocharset=utf8 so
+// Joliet/Rock Ridge long names are used instead of uppercased 8.3 names.
+var isoMountAttempts = []isoMountAttempt{
+	{"udf", []string{"ro", "loop", "iocharset=utf8"}},
+	{"udf", []string{"ro", "loop"}},
+	{"iso9660", []string{"ro", "loop", "iocharset=utf8"}},
+	{"iso9660", []string{"ro", "loop"}},
+}
+
+// isoKeyFiles are files whose exact names Windows boot depends on
+var isoKeyFiles = []string{
+	"bootmgr",
+	filepath.Join("sources", "boot.wim"),
+}
+
 // MountISO mounts an ISO file to a temporary mountpoint
 func MountISO(isoPath string) (string, error) {
+	mountpoint, err := mountISOFilesystem(isoPath, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to mount ISO %s: %v", isoPath, err)
+	}
+	return mountpoint, nil
+}
+
+// MountOptical mounts the disc in an optical drive such as /dev/sr0 read-only, trying
+// udf and then iso9660 like MountISO rather than letting "auto" pick a filesystem
+func MountOptical(device string) (string, error) {
+	mountpoint, err := mountISOFilesystem(device, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to mount disc in %s: %v", device, err)
+	}
+	return mountpoint, nil
+}
+
+// sysBlockDir is where the kernel lists block devices; tests point it elsewhere
+var sysBlockDir = "/sys/block"
+
+// IsOpticalDevice reports whether device is an optical drive: sysfs reports SCSI
+// device type 5 (CD/DVD), or the node is an sr* device
+func IsOpticalDevice(device string) bool {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	name := filepath.Base(device)
+
+	if data, err := os.ReadFile(filepath.Join(sysBlockDir, name, "device", "type")); err == nil {
+		return strings.TrimSpace(string(data)) == "5"
+	}
+	return strings.HasPrefix(name, "sr")
+}
+
+// mountISOFilesystem mounts source with each of isoMountAttempts until one exposes
+// the Windows boot files under their exact names. Image files are mounted through a
+// loop device, drives directly.
+func mountISOFilesystem(source string, loop bool) (string, error) {
 	mountpoint, err := CreateTempMountpoint("woeusb-iso-")
 	if err != nil {
 		return "", err
 	}
 
-	// Try UDF first (Windows 10/11 ISOs), then fall back to iso9660
-	// Using "auto" lets the kernel detect the correct filesystem
-	if err := Mount(isoPath, mountpoint, "udf", []string{"ro", "loop"}); err != nil {
-		// Fallback to iso9660 for older ISOs
-		if err := Mount(isoPath, mountpoint, "iso9660", []string{"ro", "loop"}); err != nil {
-			_ = os.RemoveAll(mountpoint)
-			return "", fmt.Errorf("failed to mount ISO %s: %v", isoPath, err)
+	var lastErr error
+	for _, attempt := range isoMountAttempts {
+		opts := attempt.opts
+		if !loop {
+			opts = withoutOption(opts, "loop")
+		}
+		if err := Mount(source, mountpoint, attempt.fstype, opts); err != nil {
+			lastErr = err
+			continue
 		}
+
+		// Reject mounts that expose mangled names and try the next variant
+		if err := CheckISONames(mountpoint); err != nil {
+			lastErr = err
+			_ = Unmount(mountpoint)
+			continue
+		}
+
+		return mountpoint, nil
 	}
 
+	_ = os.RemoveAll(mountpoint)
+	return "", lastErr
+}
+
+// withoutOption returns opts without option
+func withoutOption(opts []string, option string) []string {
+	var out []string
+	for _, opt := range opts {
+		if opt != option {
+			out = append(out, opt)
+		}
+	}
+	return out
+}
+
+// MountISOWithFSType mounts an image file read-only as fstype, skipping the udf/iso9660
+// detection of MountISO. An empty fstype behaves like MountISO.
+func MountISOWithFSType(isoPath, fstype string) (string, error) {
+	if fstype == "" {
+		return MountISO(isoPath)
+	}
+
+	mountpoint, err := CreateTempMountpoint("woeusb-iso-")
+	if err != nil {
+		return "", err
+	}
+	if err := Mount(isoPath, mountpoint, fstype, []string{"ro", "loop"}); err != nil {
+		_ = os.RemoveAll(mountpoint)
+		return "", fmt.Errorf("failed to mount %s as %s: %v", isoPath, fstype, err)
+	}
 	return mountpoint, nil
 }
 
+// procFilesystems lists the filesystems registered with the kernel
+var procFilesystems = "/proc/filesystems"
+
+// fsModuleAvailable reports whether a kernel module for fstype can be loaded on demand
+var fsModuleAvailable = func(fstype string) bool {
+	return exec.Command("modinfo", "fs-"+fstype).Run() == nil
+}
+
+// ValidateFSType checks that the kernel can mount fstype: it is either registered in
+// /proc/filesystems or provided by a module the kernel loads on the first mount
+func ValidateFSType(fstype string) error {
+	data, err := os.ReadFile(procFilesystems)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %v", procFilesystems, err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// Lines are "<name>" or "nodev\t<name>"
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[len(fields)-1] == fstype {
+			return nil
+		}
+	}
+	if fsModuleAvailable(fstype) {
+		return nil
+	}
+	return fmt.Errorf("filesystem type %q is not known to the kernel (see %s)", fstype, procFilesystems)
+}
+
+// CheckISONames verifies that key Windows boot files, if present, appear with
+// their expected names rather than as uppercased or versioned (8.3) variants
+func CheckISONames(mountpoint string) error {
+	for _, rel := range isoKeyFiles {
+		if _, err := os.Stat(filepath.Join(mountpoint, rel)); err == nil {
+			continue
+		}
+
+		if variant, ok := findNameVariant(mountpoint, rel); ok {
+			return fmt.Errorf("found %s instead of %s (ISO names were not mounted correctly)", variant, rel)
+		}
+	}
+	return nil
+}
+
+// findNameVariant looks for rel under root, matching each path component
+// case-insensitively and ignoring ISO9660 ";1" version suffixes
+func findNameVariant(root, rel string) (string, bool) {
+	current := root
+	var found []string
+
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		entries, err := os.ReadDir(current)
+		if err != nil {
+			return "", false
+		}
+
+		match := ""
+		for _, entry := range entries {
+			name := strings.TrimSuffix(entry.Name(), ";1")
+			if strings.EqualFold(name, part) {
+				match = entry.Name()
+				break
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+
+		found = append(found, match)
+		current = filepath.Join(current, match)
+	}
+
+	return filepath.Join(found...), true
+}
+
 // MountDevice mounts a block device to a temporary mountpoint
 func MountDevice(devicePath, fstype string) (string, error) {
+	return mountDevice(devicePath, fstype, []string{}, "")
+}
+
+// MountDeviceWithTuning mounts a block device read-write with the options tuning
+// selects for its filesystem
+func MountDeviceWithTuning(devicePath, fstype string, tuning MountTuning) (string, error) {
+	return mountDevice(devicePath, fstype, []string{}, tuning)
+}
+
+// MountDeviceReadOnly mounts a block device read-only to a temporary mountpoint
+func MountDeviceReadOnly(devicePath, fstype string) (string, error) {
+	return mountDevice(devicePath, fstype, []string{"ro"}, "")
+}
+
+// MountTuning selects the durability/speed trade-off of a read-write target mount
+type MountTuning string
+
+const (
+	// MountTuningSafe writes data out early (flush on vfat, synchronous directory
+	// updates on NTFS), so little is lost if the stick is pulled during the copy
+	MountTuningSafe MountTuning = "safe"
+	// MountTuningFast lets the kernel cache writes; the final sync before unmount
+	// can then take minutes and must be waited for
+	MountTuningFast MountTuning = "fast"
+)
+
+// ParseMountTuning parses a --mount-tuning value
+func ParseMountTuning(value string) (MountTuning, error) {
+	switch tuning := MountTuning(strings.ToLower(value)); tuning {
+	case MountTuningSafe, MountTuningFast:
+		return tuning, nil
+	}
+	return "", fmt.Errorf("unknown mount tuning %q (use %s or %s)", value, MountTuningSafe, MountTuningFast)
+}
+
+// options returns the mount options tuning adds for the normalized fstype
+// ("vfat", "ntfs3", "ntfs-3g" or "exfat")
+func (t MountTuning) options(fstype string) []string {
+	switch t {
+	case MountTuningSafe:
+		switch fstype {
+		case "vfat":
+			return []string{"flush"}
+		case "ntfs3", "exfat":
+			return []string{"dirsync"}
+		case "ntfs-3g":
+			return withoutOption(NTFS3GOptions, "async")
+		}
+	case MountTuningFast:
+		if fstype == "ntfs-3g" {
+			return append(append([]string{}, NTFS3GOptions...), "noatime")
+		}
+		return []string{"noatime"}
+	}
+	if fstype == "ntfs-3g" {
+		return NTFS3GOptions
+	}
+	return nil
+}
+
+// mountDevice mounts a block device to a temporary mountpoint with the given options
+// and those tuning adds for the filesystem
+func mountDevice(devicePath, fstype string, opts []string, tuning MountTuning) (string, error) {
 	mountpoint, err := CreateTempMountpoint("woeusb-dev-")
 	if err != nil {
 		return "", err
 	}
 
 	// Normalize filesystem type
+	isNTFS := false
 	switch strings.ToLower(fstype) {
 	case "fat", "fat32", "vfat":
 		fstype = "vfat"
-	case "ntfs", "ntfs-3g":
+	case "ntfs", "ntfs-3g", "ntfs3":
 		fstype = "ntfs3" // Use kernel ntfs3 driver (faster than ntfs-3g FUSE)
+		isNTFS = true
+	case "exfat":
+		fstype = "exfat" // Kernel driver, or exfat-fuse through mount.exfat
 	}
 
-	opts := []string{}
-
-	if err := Mount(devicePath, mountpoint, fstype, opts); err != nil {
+	err = Mount(devicePath, mountpoint, fstype, append(append([]string{}, opts...), tuning.options(fstype)...))
+	if err != nil && isNTFS {
+		// Kernel without ntfs3: fall back to the FUSE driver, tuned for large writes
+		ntfs3gOpts := append(append([]string{}, opts...), tuning.options("ntfs-3g")...)
+		err = Mount(devicePath, mountpoint, "ntfs-3g", ntfs3gOpts)
+	}
+	if err != nil {
 		_ = os.RemoveAll(mountpoint)
 		return "", fmt.Errorf("failed to mount device %s: %v", devicePath, err)
 	}
 
 	return mountpoint, nil
 }
+
+// NTFS3GOptions are extra mount options used when NTFS falls back to ntfs-3g (FUSE).
+// big_writes and async speed up copying large WIM files; windows_names refuses names
+// Windows cannot open, which never occur in a Windows ISO (see WindowsNameRejected).
+var NTFS3GOptions = []string{"big_writes", "async", "windows_names"}
+
+// windowsReservedNames are DOS device names ntfs-3g windows_names refuses as file names
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// WindowsNameRejected reports whether ntfs-3g's windows_names option would refuse
+// to create a file with this name (forbidden characters, reserved device names,
+// or a trailing dot or space)
+func WindowsNameRejected(name string) bool {
+	if strings.ContainsAny(name, `"*/:<>?\|`) {
+		return true
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return true
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return true
+	}
+
+	stem := strings.ToUpper(name)
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	return windowsReservedNames[stem]
+}
@@ -153,3 +153,253 @@ func TestUnmount(t *testing.T) {
 		t.Error("Expected error when unmounting non-mounted directory")
 	}
 }
+
+func TestCheckISONames(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "iso_names_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// Missing key files are not an error (not every source is a Windows ISO)
+	if err := CheckISONames(tmpDir); err != nil {
+		t.Errorf("CheckISONames failed for empty directory: %v", err)
+	}
+
+	// Uppercased 8.3 variants must be rejected
+	if err := os.MkdirAll(filepath.Join(tmpDir, "SOURCES"), 0755); err != nil {
+		t.Fatalf("Failed to create SOURCES dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "SOURCES", "BOOT.WIM;1"), []byte("wim"), 0644); err != nil {
+		t.Fatalf("Failed to create BOOT.WIM: %v", err)
+	}
+	if err := CheckISONames(tmpDir); err == nil {
+		t.Error("CheckISONames should fail for uppercased names")
+	}
+
+	// Exact names pass
+	_ = os.RemoveAll(filepath.Join(tmpDir, "SOURCES"))
+	if err := os.MkdirAll(filepath.Join(tmpDir, "sources"), 0755); err != nil {
+		t.Fatalf("Failed to create sources dir: %v", err)
+	}
+	for _, name := range []string{"bootmgr", filepath.Join("sources", "boot.wim")} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	if err := CheckISONames(tmpDir); err != nil {
+		t.Errorf("CheckISONames failed for correct names: %v", err)
+	}
+}
+
+func TestFindHolders(t *testing.T) {
+	procRoot, err := os.MkdirTemp("", "proc")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(procRoot) }()
+
+	// Fake /proc entries: each process has a comm file and fd symlinks
+	procs := []struct {
+		pid   string
+		comm  string
+		links map[string]string
+	}{
+		{"100", "gvfsd-metadata", map[string]string{"fd/3": "/dev/sdb1"}},
+		{"200", "bash", map[string]string{"cwd": "/media/usb/sources"}},
+		{"300", "firefox", map[string]string{"fd/4": "/dev/sda", "cwd": "/media/usbstick"}},
+		{"self", "ignored", map[string]string{"fd/1": "/dev/sdb"}},
+	}
+	for _, p := range procs {
+		dir := filepath.Join(procRoot, p.pid)
+		if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "comm"), []byte(p.comm+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write comm: %v", err)
+		}
+		for link, target := range p.links {
+			if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+				t.Fatalf("Failed to create link %s: %v", link, err)
+			}
+		}
+	}
+
+	holders, err := findHolders(procRoot, "/dev/sdb", []string{"/media/usb"})
+	if err != nil {
+		t.Fatalf("findHolders() error = %v", err)
+	}
+
+	want := []ProcessInfo{{PID: 100, Name: "gvfsd-metadata"}, {PID: 200, Name: "bash"}}
+	if len(holders) != len(want) {
+		t.Fatalf("findHolders() = %v, want %v", holders, want)
+	}
+	for i := range want {
+		if holders[i] != want[i] {
+			t.Errorf("holders[%d] = %v, want %v", i, holders[i], want[i])
+		}
+	}
+
+	if got := formatHolders(holders); got != "gvfsd-metadata (pid 100), bash (pid 200)" {
+		t.Errorf("formatHolders() = %q", got)
+	}
+}
+
+func TestWindowsNameRejected(t *testing.T) {
+	// Names found on Windows 10/11 ISOs must all be accepted with windows_names
+	isoNames := []string{
+		"bootmgr", "bootmgr.efi", "autorun.inf", "setup.exe", "boot.wim", "install.wim",
+		"install.esd", "install2.swm", "bootx64.efi", "chs_boot.ttf", "BCD", "ei.cfg",
+		"[BOOT]", "en-us", "Microsoft-Windows-Client-LanguagePack-Package~31bf3856ad364e35~amd64~en-US~.cab",
+		"setupprep.exe", "appraiserres.dll", "x64", "$OEM$",
+	}
+	for _, name := range isoNames {
+		if WindowsNameRejected(name) {
+			t.Errorf("WindowsNameRejected(%q) = true, want false", name)
+		}
+	}
+
+	rejected := []string{"a:b", "what?", "pipe|name", "trailing.", "trailing ", "CON", "nul.txt", "com1.log", "LPT9"}
+	for _, name := range rejected {
+		if !WindowsNameRejected(name) {
+			t.Errorf("WindowsNameRejected(%q) = false, want true", name)
+		}
+	}
+}
+
+func TestValidateFSType(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "fstype-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	path := filepath.Join(tmpDir, "filesystems")
+	content := "nodev\tsysfs\nnodev\ttmpfs\n\text4\n\tvfat\n\tiso9660\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write filesystems: %v", err)
+	}
+
+	oldProc, oldModule := procFilesystems, fsModuleAvailable
+	defer func() { procFilesystems, fsModuleAvailable = oldProc, oldModule }()
+	procFilesystems = path
+	fsModuleAvailable = func(fstype string) bool { return fstype == "udf" }
+
+	for _, fstype := range []string{"vfat", "iso9660", "tmpfs", "udf"} {
+		if err := ValidateFSType(fstype); err != nil {
+			t.Errorf("ValidateFSType(%q) failed: %v", fstype, err)
+		}
+	}
+
+	err = ValidateFSType("bogusfs")
+	if err == nil {
+		t.Fatal("Expected error for unknown filesystem type")
+	}
+	if !strings.Contains(err.Error(), "bogusfs") {
+		t.Errorf("Error should name the type, got: %v", err)
+	}
+
+	procFilesystems = filepath.Join(tmpDir, "missing")
+	if err := ValidateFSType("vfat"); err == nil {
+		t.Error("Expected error when filesystems list is unreadable")
+	}
+}
+
+func TestParseMountTuning(t *testing.T) {
+	for _, value := range []string{"safe", "Fast"} {
+		if _, err := ParseMountTuning(value); err != nil {
+			t.Errorf("ParseMountTuning(%q) failed: %v", value, err)
+		}
+	}
+	if _, err := ParseMountTuning("turbo"); err == nil {
+		t.Error("Expected error for unknown mount tuning")
+	}
+}
+
+func TestMountTuningOptions(t *testing.T) {
+	tests := []struct {
+		tuning MountTuning
+		fstype string
+		want   string
+	}{
+		{MountTuningSafe, "vfat", "flush"},
+		{MountTuningSafe, "ntfs3", "dirsync"},
+		{MountTuningSafe, "ntfs-3g", "big_writes,windows_names"},
+		{MountTuningFast, "vfat", "noatime"},
+		{MountTuningFast, "ntfs3", "noatime"},
+		{MountTuningFast, "ntfs-3g", "big_writes,async,windows_names,noatime"},
+		{"", "vfat", ""},
+		{"", "ntfs-3g", "big_writes,async,windows_names"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tt.tuning.options(tt.fstype), ","); got != tt.want {
+			t.Errorf("%q.options(%s) = %q, want %q", tt.tuning, tt.fstype, got, tt.want)
+		}
+	}
+}
+
+func TestSyncFilesystem(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sync-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := SyncFilesystem(tmpDir); err != nil {
+		t.Errorf("SyncFilesystem failed: %v", err)
+	}
+	if err := SyncFilesystem(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("Expected error for a missing mountpoint")
+	}
+}
+
+func TestIsOpticalDevice(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sysblock-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	oldSysBlock := sysBlockDir
+	defer func() { sysBlockDir = oldSysBlock }()
+	sysBlockDir = tmpDir
+
+	for name, devType := range map[string]string{"sr1": "5\n", "sdb": "0\n", "sdc": "5\n"} {
+		dir := filepath.Join(tmpDir, name, "device")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "type"), []byte(devType), 0644); err != nil {
+			t.Fatalf("Failed to write type: %v", err)
+		}
+	}
+
+	tests := []struct {
+		device string
+		want   bool
+	}{
+		{"/dev/sr1", true},
+		{"/dev/sdb", false},
+		{"/dev/sdc", true},  // USB DVD drive reported through sysfs
+		{"/dev/sr0", true},  // not in sysfs, falls back to the name
+		{"/dev/sdd", false}, // not in sysfs
+	}
+	for _, tt := range tests {
+		if got := IsOpticalDevice(tt.device); got != tt.want {
+			t.Errorf("IsOpticalDevice(%s) = %v, want %v", tt.device, got, tt.want)
+		}
+	}
+}
+
+func TestMountOpticalNonexistent(t *testing.T) {
+	if _, err := MountOptical("/dev/nonexistent-sr"); err == nil {
+		t.Error("Expected error when mounting a missing optical device")
+	}
+}
+
+func TestWithoutOption(t *testing.T) {
+	got := withoutOption([]string{"ro", "loop", "iocharset=utf8"}, "loop")
+	if strings.Join(got, ",") != "ro,iocharset=utf8" {
+		t.Errorf("withoutOption() = %v", got)
+	}
+}
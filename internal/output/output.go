@@ -1,8 +1,13 @@
 package output
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 // ANSI color codes
@@ -19,6 +24,22 @@ const (
 
 var noColor = false
 
+// Hooks observe messages as they are printed, e.g. to build a run report
+var (
+	stepHook    func(msg string)
+	warningHook func(msg string)
+)
+
+// SetStepHook registers fn to be called with the message of every Step; nil removes it
+func SetStepHook(fn func(msg string)) {
+	stepHook = fn
+}
+
+// SetWarningHook registers fn to be called with the message of every Warning; nil removes it
+func SetWarningHook(fn func(msg string)) {
+	warningHook = fn
+}
+
 // SetNoColor disables color output
 func SetNoColor(disabled bool) {
 	noColor = disabled
@@ -31,67 +52,350 @@ func colorize(color, text string) string {
 	return color + text + Reset
 }
 
+// Level is the severity of a message. Messages below the level set with SetLevel are
+// not printed or logged.
+type Level int
+
+// Levels, from most to least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// String returns the level name as accepted by ParseLevel
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel parses debug, info, warn or error (case-insensitive)
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(s)
+	if s == "warning" {
+		s = "warn"
+	}
+	for l, name := range levelNames {
+		if name == s {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+}
+
+var level = LevelInfo
+
+// SetLevel suppresses messages below l
+func SetLevel(l Level) {
+	level = l
+}
+
+// GetLevel returns the current level
+func GetLevel() Level {
+	return level
+}
+
+// enabled reports whether messages at l are printed
+func enabled(l Level) bool {
+	return l >= level
+}
+
+var (
+	logMu   sync.Mutex
+	logFile *os.File
+)
+
+// SetLogFile appends every printed message to path, with a timestamp and level and
+// without colors. An empty path closes the current log file.
+func SetLogFile(path string) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if logFile != nil {
+		_ = logFile.Close()
+		logFile = nil
+	}
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	logFile = f
+	return nil
+}
+
+// writeLog appends msg to the log file, if any
+func writeLog(l Level, msg string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if logFile == nil {
+		return
+	}
+	line := fmt.Sprintf("%s %-5s %s\n", now().Format(time.RFC3339), strings.ToUpper(l.String()), msg)
+	_, _ = logFile.WriteString(line)
+}
+
+// printLevel writes text to stderr and msg to the log file if l is enabled
+func printLevel(l Level, color, text, msg string) {
+	if !enabled(l) {
+		return
+	}
+	fmt.Fprintln(os.Stderr, colorize(color, text))
+	writeLog(l, msg)
+}
+
 // Step prints a step header in cyan
 func Step(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorize(Cyan+Bold, "▶ "+msg))
+	if stepHook != nil {
+		stepHook(msg)
+	}
+	printLevel(LevelInfo, Cyan+Bold, "▶ "+msg, msg)
+	emitProgress(ProgressEvent{Phase: phase, Pct: -1, Message: msg})
 }
 
 // Info prints an info message in green
 func Info(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorize(Green, "  ✓ "+msg))
+	printLevel(LevelInfo, Green, "  ✓ "+msg, msg)
 }
 
 // Warning prints a warning message in yellow
 func Warning(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorize(Yellow, "  ⚠ "+msg))
+	if warningHook != nil {
+		warningHook(msg)
+	}
+	printLevel(LevelWarn, Yellow, "  ⚠ "+msg, msg)
 }
 
 // Error prints an error message in red
 func Error(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorize(Red, "  ✗ "+msg))
+	printLevel(LevelError, Red, "  ✗ "+msg, msg)
 }
 
 // Notice prints a notice in magenta (for long operations)
 func Notice(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorize(Magenta, "  ℹ "+msg))
+	printLevel(LevelInfo, Magenta, "  ℹ "+msg, msg)
 }
 
 // Success prints a success message in bold green
 func Success(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(os.Stderr, colorize(Green+Bold, "✓ "+msg))
+	printLevel(LevelInfo, Green+Bold, "✓ "+msg, msg)
+}
+
+// Progress styles accepted by SetProgressStyle
+const (
+	StyleAuto    = "auto"    // StyleBar on a terminal, StylePercent otherwise
+	StyleBar     = "bar"     // redraw one line with \r, with a bar when the percentage is known
+	StylePercent = "percent" // print a new line every 10% or ProgressLineInterval, for logs
+	StyleDots    = "dots"    // print a dot per second of progress
+	StyleNone    = "none"    // print nothing
+)
+
+// ProgressLineInterval is the longest StylePercent waits between two progress lines
+const ProgressLineInterval = 5 * time.Second
+
+var progressStyle = StyleBar
+
+// progress tracks what StylePercent and StyleDots have printed for the current operation
+var progress struct {
+	active   bool
+	last     time.Time
+	decade   int    // last 10% step printed, -1 if none
+	pending  string // message not printed yet, flushed by ProgressDone
+	printed  bool   // StyleDots printed a dot for the current operation
+	wrote    bool   // StylePercent printed at least one line
+	lastText string
+}
+
+// now is the clock used for progress throttling; tests replace it
+var now = time.Now
+
+// SetProgressStyle selects how Progress and ProgressPercent render
+func SetProgressStyle(style string) error {
+	switch style {
+	case StyleAuto, "":
+		style = StyleBar
+		if !isTerminal(os.Stderr) {
+			style = StylePercent
+		}
+	case StyleBar, StylePercent, StyleDots, StyleNone:
+	default:
+		return fmt.Errorf("unknown progress style %q (use auto, bar, percent, dots or none)", style)
+	}
+	progressStyle = style
+	return nil
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-// Progress prints progress info (overwrites line)
+// Progress prints progress info whose percentage is unknown (overwrites line with StyleBar)
 func Progress(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	if noColor {
-		fmt.Fprintf(os.Stderr, "\r  %s", msg)
-	} else {
-		fmt.Fprintf(os.Stderr, "\r  %s%s%s", Blue, msg, Reset)
+	renderProgress(-1, fmt.Sprintf(format, args...))
+}
+
+// ProgressPercent prints progress info for an operation that is percent (0-100) done
+func ProgressPercent(percent float64, format string, args ...interface{}) {
+	ProgressPercentFile(percent, "", format, args...)
+}
+
+// ProgressPercentFile is ProgressPercent for an operation working on file, which is
+// reported in the JSON progress stream
+func ProgressPercentFile(percent float64, file, format string, args ...interface{}) {
+	percent = min(max(percent, 0), 100)
+	emitProgress(ProgressEvent{Phase: phase, Pct: percent / 100, File: file})
+	renderProgress(percent, fmt.Sprintf(format, args...))
+}
+
+// renderProgress prints msg in the current style; percent is -1 when unknown
+func renderProgress(percent float64, msg string) {
+	if !progress.active {
+		progress.active = true
+		progress.decade = -1
+	}
+
+	switch progressStyle {
+	case StyleNone:
+		return
+	case StyleDots:
+		if t := now(); !progress.printed || t.Sub(progress.last) >= time.Second {
+			progress.last = t
+			progress.printed = true
+			fmt.Fprint(os.Stderr, ".")
+		}
+	case StylePercent:
+		decade := int(percent) / 10
+		t := now()
+		if progress.wrote && t.Sub(progress.last) < ProgressLineInterval && (percent < 0 || decade <= progress.decade) {
+			progress.pending = msg
+			return
+		}
+		progress.last = t
+		progress.decade = decade
+		progress.wrote = true
+		progress.pending = ""
+		progress.lastText = msg
+		fmt.Fprintln(os.Stderr, colorize(Blue, "  "+msg))
+	default:
+		if percent >= 0 {
+			msg = progressBar(percent) + " " + msg
+		}
+		if noColor {
+			fmt.Fprintf(os.Stderr, "\r  %s", msg)
+		} else {
+			fmt.Fprintf(os.Stderr, "\r  %s%s%s", Blue, msg, Reset)
+		}
 	}
 }
 
+// progressBar renders percent as a 20 character bar
+func progressBar(percent float64) string {
+	const width = 20
+	filled := int(percent * width / 100)
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
 // ProgressDone finishes progress line
 func ProgressDone() {
-	fmt.Fprintln(os.Stderr)
+	switch progressStyle {
+	case StyleNone:
+	case StylePercent:
+		// Make sure the final state ends up in the log
+		if progress.pending != "" && progress.pending != progress.lastText {
+			fmt.Fprintln(os.Stderr, colorize(Blue, "  "+progress.pending))
+		}
+	default:
+		fmt.Fprintln(os.Stderr)
+	}
+	progress.active = false
+	progress.printed = false
+	progress.wrote = false
+	progress.pending = ""
+	progress.lastText = ""
+}
+
+// Phases reported in the JSON progress stream
+const (
+	PhaseMount      = "mount"
+	PhasePartition  = "partition"
+	PhaseFormat     = "format"
+	PhaseCopy       = "copy"
+	PhaseVerify     = "verify"
+	PhaseBootloader = "bootloader"
+	PhaseCleanup    = "cleanup"
+	PhaseDone       = "done"
+)
+
+// ProgressEvent is one line of the JSON progress stream. Pct is the fraction (0-1) of
+// the current phase that is done, or -1 when unknown.
+type ProgressEvent struct {
+	Phase   string  `json:"phase"`
+	Pct     float64 `json:"pct"`
+	File    string  `json:"file,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+var (
+	progressJSON io.Writer
+	phase        string
+)
+
+// SetProgressJSON writes progress as newline-delimited ProgressEvent JSON to w; nil disables it
+func SetProgressJSON(w io.Writer) {
+	progressJSON = w
 }
 
-// Verbose prints only if verbose mode is enabled
-var verboseMode = false
+// SetPhase starts phase, which is attached to the following steps and progress events
+func SetPhase(p string) {
+	phase = p
+	emitProgress(ProgressEvent{Phase: p, Pct: 0})
+}
 
+// emitProgress writes ev as one line to the JSON progress stream
+func emitProgress(ev ProgressEvent) {
+	if progressJSON == nil {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	// A single write keeps each event on its own line when the stream is shared
+	_, _ = progressJSON.Write(append(data, '\n'))
+}
+
+// SetVerbose enables Verbose messages by setting the level to LevelDebug, or back to LevelInfo
 func SetVerbose(enabled bool) {
-	verboseMode = enabled
+	if enabled {
+		SetLevel(LevelDebug)
+	} else {
+		SetLevel(LevelInfo)
+	}
 }
 
+// Verbose prints a debug message, shown only at LevelDebug
 func Verbose(format string, args ...interface{}) {
-	if verboseMode {
-		msg := fmt.Sprintf(format, args...)
-		fmt.Fprintln(os.Stderr, colorize(Cyan, "  [verbose] "+msg))
-	}
+	msg := fmt.Sprintf(format, args...)
+	printLevel(LevelDebug, Cyan, "  [verbose] "+msg, msg)
 }
@@ -2,9 +2,11 @@ package output
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSetNoColor(t *testing.T) {
@@ -24,14 +26,14 @@ func TestSetNoColor(t *testing.T) {
 func TestSetVerbose(t *testing.T) {
 	// Test enabling verbose
 	SetVerbose(true)
-	if !verboseMode {
-		t.Error("Expected verboseMode to be true")
+	if level != LevelDebug {
+		t.Errorf("Expected level debug, got %v", level)
 	}
 
 	// Test disabling verbose
 	SetVerbose(false)
-	if verboseMode {
-		t.Error("Expected verboseMode to be false")
+	if level != LevelInfo {
+		t.Errorf("Expected level info, got %v", level)
 	}
 }
 
@@ -226,3 +228,239 @@ func TestColorConstants(t *testing.T) {
 		t.Error("Bold constant should not be empty")
 	}
 }
+
+func TestHooks(t *testing.T) {
+	var steps, warnings []string
+	SetStepHook(func(msg string) { steps = append(steps, msg) })
+	SetWarningHook(func(msg string) { warnings = append(warnings, msg) })
+	defer SetStepHook(nil)
+	defer SetWarningHook(nil)
+
+	oldStderr := os.Stderr
+	_, w, _ := os.Pipe()
+	os.Stderr = w
+	Step("Copying %s", "files")
+	Warning("disk is %d%% full", 90)
+	Info("not hooked")
+	_ = w.Close()
+	os.Stderr = oldStderr
+
+	if len(steps) != 1 || steps[0] != "Copying files" {
+		t.Errorf("Unexpected steps: %v", steps)
+	}
+	if len(warnings) != 1 || warnings[0] != "disk is 90% full" {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+}
+
+func TestProgressStyles(t *testing.T) {
+	SetNoColor(true)
+	defer SetNoColor(false)
+	defer func() { progressStyle = StyleBar; now = time.Now }()
+
+	if err := SetProgressStyle("fancy"); err == nil {
+		t.Error("SetProgressStyle() should reject unknown styles")
+	}
+
+	// Bar: redrawn line with a bar when the percentage is known
+	if err := SetProgressStyle(StyleBar); err != nil {
+		t.Fatalf("SetProgressStyle(bar) failed: %v", err)
+	}
+	output := captureStderr(func() {
+		ProgressPercent(50, "Copying")
+		ProgressDone()
+	})
+	if output != "\r  [##########----------] Copying\n" {
+		t.Errorf("bar output = %q", output)
+	}
+
+	// Percent: one line per 10% step or interval, and the final state on done
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+	if err := SetProgressStyle(StylePercent); err != nil {
+		t.Fatalf("SetProgressStyle(percent) failed: %v", err)
+	}
+	output = captureStderr(func() {
+		ProgressPercent(1, "1%%")
+		ProgressPercent(5, "5%%")
+		ProgressPercent(12, "12%%")
+		ProgressPercent(13, "13%%")
+		clock = clock.Add(ProgressLineInterval)
+		ProgressPercent(14, "14%%")
+		ProgressPercent(15, "15%%")
+		ProgressDone()
+	})
+	if output != "  1%\n  12%\n  14%\n  15%\n" {
+		t.Errorf("percent output = %q", output)
+	}
+	if strings.Contains(output, "\r") {
+		t.Error("percent style must not use carriage returns")
+	}
+
+	// Dots: at most one dot per second
+	clock = time.Unix(0, 0)
+	if err := SetProgressStyle(StyleDots); err != nil {
+		t.Fatalf("SetProgressStyle(dots) failed: %v", err)
+	}
+	output = captureStderr(func() {
+		Progress("a")
+		Progress("b")
+		clock = clock.Add(time.Second)
+		Progress("c")
+		ProgressDone()
+	})
+	if output != "..\n" {
+		t.Errorf("dots output = %q", output)
+	}
+
+	// None: silent, including ProgressDone
+	if err := SetProgressStyle(StyleNone); err != nil {
+		t.Fatalf("SetProgressStyle(none) failed: %v", err)
+	}
+	output = captureStderr(func() {
+		ProgressPercent(50, "Copying")
+		ProgressDone()
+	})
+	if output != "" {
+		t.Errorf("none output = %q", output)
+	}
+
+	// Auto picks newline-based output when stderr is not a terminal
+	var style string
+	captureStderr(func() {
+		_ = SetProgressStyle(StyleAuto)
+		style = progressStyle
+	})
+	if style != StylePercent {
+		t.Errorf("auto style on a pipe = %q, want %q", style, StylePercent)
+	}
+}
+
+func TestProgressJSON(t *testing.T) {
+	var buf bytes.Buffer
+	SetProgressJSON(&buf)
+	defer SetProgressJSON(nil)
+	defer func() { phase = ""; progressStyle = StyleBar }()
+	progressStyle = StyleNone
+
+	captureStderr(func() {
+		SetPhase(PhaseCopy)
+		Step("Copying Windows files...")
+		ProgressPercentFile(45, "sources/install.wim", "Copying")
+		ProgressPercent(150, "Copying")
+	})
+
+	want := []ProgressEvent{
+		{Phase: PhaseCopy, Pct: 0},
+		{Phase: PhaseCopy, Pct: -1, Message: "Copying Windows files..."},
+		{Phase: PhaseCopy, Pct: 0.45, File: "sources/install.wim"},
+		{Phase: PhaseCopy, Pct: 1},
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(want), buf.String())
+	}
+	for i, line := range lines {
+		var ev ProgressEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("line %d is not JSON: %q", i, line)
+		}
+		if ev != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, ev, want[i])
+		}
+	}
+	if lines[2] != `{"phase":"copy","pct":0.45,"file":"sources/install.wim"}` {
+		t.Errorf("unexpected encoding: %s", lines[2])
+	}
+
+	// Disabled: nothing is written
+	buf.Reset()
+	SetProgressJSON(nil)
+	captureStderr(func() { ProgressPercent(10, "Copying") })
+	if buf.Len() != 0 {
+		t.Errorf("disabled stream wrote %q", buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"warn", LevelWarn, false},
+		{"warning", LevelWarn, false},
+		{"error", LevelError, false},
+		{"trace", LevelInfo, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLevel(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSetLevelSuppressesLowerLevels(t *testing.T) {
+	SetNoColor(true)
+	SetLevel(LevelWarn)
+	defer func() {
+		SetLevel(LevelInfo)
+		SetNoColor(false)
+	}()
+
+	out := captureStderr(func() {
+		Info("hidden info")
+		Verbose("hidden debug")
+		Warning("shown warning")
+		Error("shown error")
+	})
+	if strings.Contains(out, "hidden") {
+		t.Errorf("Expected info and debug to be suppressed, got: %q", out)
+	}
+	if !strings.Contains(out, "shown warning") || !strings.Contains(out, "shown error") {
+		t.Errorf("Expected warning and error, got: %q", out)
+	}
+}
+
+func TestSetLogFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "output-log-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	fixed := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	logPath := tmpDir + "/woeusb.log"
+	if err := SetLogFile(logPath); err != nil {
+		t.Fatalf("SetLogFile failed: %v", err)
+	}
+	SetVerbose(false)
+	captureStderr(func() {
+		Step("Formatting")
+		Warning("label too long")
+		Verbose("not logged")
+	})
+	if err := SetLogFile(""); err != nil {
+		t.Fatalf("SetLogFile(\"\") failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	want := "2024-05-01T12:30:00Z INFO  Formatting\n" +
+		"2024-05-01T12:30:00Z WARN  label too long\n"
+	if string(data) != want {
+		t.Errorf("Log file = %q, want %q", data, want)
+	}
+}
@@ -1,65 +1,143 @@
 package partition
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mathisen/woeusb-go/internal/bootloader"
+	filecopy "github.com/mathisen/woeusb-go/internal/copy"
+	"github.com/mathisen/woeusb-go/internal/deps"
+	"github.com/mathisen/woeusb-go/internal/download"
+	"github.com/mathisen/woeusb-go/internal/filesystem"
+	"github.com/mathisen/woeusb-go/internal/mount"
+	"github.com/mathisen/woeusb-go/internal/output"
 )
 
+// UEFINTFSPartitionSize is the size reserved at the end of the device for UEFI:NTFS
+const UEFINTFSPartitionSize = 524288
+
 // CreateUEFINTFSPartition creates a 512KB partition at the end of the device for UEFI:NTFS
 func CreateUEFINTFSPartition(device string) (string, error) {
-	// Get device size to calculate start position
-	size, err := GetDeviceSize(device)
+	startBytes, err := uefiNTFSStartForDevice(device)
 	if err != nil {
-		return "", fmt.Errorf("failed to get device size: %v", err)
+		return "", err
 	}
-	// Start 512KiB from the end
-	startBytes := size - 524288
 
 	// Create a small partition at the end of the device
-	cmd := exec.Command("parted", "-s", "--", device, "mkpart", "primary", "fat32", fmt.Sprintf("%dB", startBytes), "100%")
-	if err := cmd.Run(); err != nil {
+	if err := runParted("-s", "--", device, "mkpart", "primary", "fat32", fmt.Sprintf("%dB", startBytes), "100%"); err != nil {
 		return "", fmt.Errorf("failed to create UEFI:NTFS partition on %s: %v", device, err)
 	}
 
 	// Re-read partition table
-	if err := RereadPartitionTable(device); err != nil {
-		return "", fmt.Errorf("failed to re-read partition table: %v", err)
+	if err := rereadAndWait(device, 2); err != nil {
+		return "", err
 	}
 
 	// Return the partition path (should be partition 2 for UEFI:NTFS)
-	var partitionPath string
-	if strings.Contains(device, "nvme") || strings.Contains(device, "mmcblk") {
-		partitionPath = device + "p2"
-	} else {
-		partitionPath = device + "2"
+	return partitionPath(device, 2), nil
+}
+
+// uefiNTFSImageURL is the UEFI:NTFS image URL (official release)
+const uefiNTFSImageURL = "https://github.com/pbatard/uefi-ntfs/releases/download/v1.4/uefi-ntfs.img"
+
+// uefiNTFSImageSHA256 is the expected SHA-256 of the image at uefiNTFSImageURL; bump it
+// together with the URL. While it is empty the image is never downloaded, and
+// UEFINTFSImage has to supply a trusted copy.
+const uefiNTFSImageSHA256 = ""
+
+// ErrUEFINTFSDownloadUnverified is returned instead of downloading uefi-ntfs.img when no
+// checksum is pinned for it
+var ErrUEFINTFSDownloadUnverified = errors.New("no checksum is pinned for the UEFI:NTFS image download; " +
+	"pass a trusted uefi-ntfs.img with --uefi-ntfs-image or use --ntfs-uefi-source iso")
+
+// downloadFile fetches a URL to a local path; tests replace it to stay offline
+var downloadFile = download.File
+
+// UEFINTFSImage is a local uefi-ntfs.img used instead of downloading one, for machines
+// without network access; empty downloads it from uefiNTFSImageURL
+var UEFINTFSImage string
+
+// CheckUEFINTFSImage checks that path is a non-empty regular file usable as uefi-ntfs.img
+func CheckUEFINTFSImage(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("UEFI:NTFS image %s is not accessible: %v", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("UEFI:NTFS image %s is not a regular file", path)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("UEFI:NTFS image %s is empty", path)
 	}
+	return nil
+}
+
+// CheckUEFINTFSSource checks that the UEFI:NTFS image can be installed, either from
+// UEFINTFSImage or from a download with a pinned checksum, before anything is wiped
+func CheckUEFINTFSSource() error {
+	if UEFINTFSImage == "" && uefiNTFSImageSHA256 == "" {
+		return ErrUEFINTFSDownloadUnverified
+	}
+	return nil
+}
+
+// downloadUEFINTFSImage downloads uefi-ntfs.img to imagePath and checks it against
+// expected, deleting it if it does not match. Without an expected checksum nothing
+// is downloaded.
+func downloadUEFINTFSImage(imagePath, expected string) error {
+	if expected == "" {
+		return ErrUEFINTFSDownloadUnverified
+	}
+	if err := downloadFile(uefiNTFSImageURL, imagePath, nil); err != nil {
+		return err
+	}
+	if err := verifyUEFINTFSImage(imagePath, expected); err != nil {
+		_ = download.Remove(imagePath)
+		return err
+	}
+	return nil
+}
 
-	return partitionPath, nil
+// verifyUEFINTFSImage checks the image at path against the expected hex SHA-256
+func verifyUEFINTFSImage(path, expected string) error {
+	if expected == "" {
+		return fmt.Errorf("no checksum is pinned for the UEFI:NTFS image, refusing to write it unverified; " +
+			"pass a trusted copy with --uefi-ntfs-image")
+	}
+	if err := download.VerifySHA256(path, expected); err != nil {
+		return fmt.Errorf("UEFI:NTFS image failed verification: %v", err)
+	}
+	return nil
 }
 
-// InstallUEFINTFS downloads uefi-ntfs.img and writes it to the partition
+// InstallUEFINTFS downloads uefi-ntfs.img, or uses UEFINTFSImage, and writes it to the partition
 func InstallUEFINTFS(partition, tempDir string) error {
-	// UEFI:NTFS image URL (official release)
-	imageURL := "https://github.com/pbatard/uefi-ntfs/releases/download/v1.4/uefi-ntfs.img"
+	if UEFINTFSImage != "" {
+		if err := CheckUEFINTFSImage(UEFINTFSImage); err != nil {
+			return err
+		}
+		return writeUEFINTFSImage(UEFINTFSImage, partition)
+	}
 
 	// Download the image to temp directory
 	imagePath := filepath.Join(tempDir, "uefi-ntfs.img")
-	if err := downloadFile(imageURL, imagePath); err != nil {
-		// Handle download failure gracefully (warning, not error)
-		fmt.Fprintf(os.Stderr, "Warning: Failed to download UEFI:NTFS image: %v\n", err)
-		fmt.Fprintf(os.Stderr, "UEFI booting may not work properly for NTFS partitions\n")
-		return nil // Return nil to continue without failing
+	if err := downloadUEFINTFSImage(imagePath, uefiNTFSImageSHA256); err != nil {
+		return fmt.Errorf("failed to download UEFI:NTFS image: %w", err)
 	}
 
-	// Write the image to the partition
-	if err := writeImageToPartition(imagePath, partition); err != nil {
-		return fmt.Errorf("failed to write UEFI:NTFS image to partition %s: %v", partition, err)
+	if err := writeUEFINTFSImage(imagePath, partition); err != nil {
+		return err
 	}
 
 	// Clean up downloaded image
@@ -68,42 +146,58 @@ func InstallUEFINTFS(partition, tempDir string) error {
 	return nil
 }
 
-// downloadFile downloads a file from URL to the specified path
-func downloadFile(url, filepath string) error {
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// InstallUEFINTFSToExisting downloads uefi-ntfs.img and installs it on partition 2 of a
+// device already laid out by CreateNTFSWithUEFILayout or CreateNTFSWithUEFIGPTLayout,
+// without touching the partition table, so the step can be retried on its own
+func InstallUEFINTFSToExisting(device string) error {
+	uefiPartition := partitionPath(device, 2)
+	if info, err := os.Stat(uefiPartition); err != nil || info.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("no UEFI:NTFS partition at %s; create the partition layout first", uefiPartition)
 	}
 
-	resp, err := client.Get(url)
+	layout, err := DescribeDevice(device)
 	if err != nil {
-		return fmt.Errorf("failed to download from %s: %v", url, err)
+		return fmt.Errorf("failed to read partition table of %s: %v", device, err)
 	}
-	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status: %s", resp.Status)
+	tempDir, err := os.MkdirTemp("", "woeusb-uefi-ntfs-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
 	}
+	defer func() { _ = os.RemoveAll(tempDir) }()
 
-	// Create the file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %v", filepath, err)
+	// The GPT layout keeps UEFI:NTFS as files on a FAT32 ESP, the MBR layout as a raw image
+	if layout.TableType == "gpt" {
+		return InstallUEFINTFSToESP(uefiPartition, tempDir)
 	}
-	defer func() { _ = out.Close() }()
+	return InstallUEFINTFS(uefiPartition, tempDir)
+}
 
-	// Copy data
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write downloaded data: %v", err)
+// writeUEFINTFSImage writes a downloaded uefi-ntfs.img to partition
+func writeUEFINTFSImage(imagePath, partition string) error {
+	// The UEFI:NTFS image is a FAT filesystem built for 512-byte sectors
+	if sectorSize, err := GetSectorSize(partition); err == nil && sectorSize != 512 {
+		output.Warning("%s uses %d-byte sectors; the UEFI:NTFS image is formatted for 512-byte sectors and may not boot", partition, sectorSize)
 	}
 
+	if err := writeImageToPartition(imagePath, partition); err != nil {
+		return fmt.Errorf("failed to write UEFI:NTFS image to partition %s: %v", partition, err)
+	}
 	return nil
 }
 
 // writeImageToPartition writes an image file to a partition using dd
 func writeImageToPartition(imagePath, partition string) error {
-	cmd := exec.Command("dd", "if="+imagePath, "of="+partition, "bs=1M", "status=progress")
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat image: %v", err)
+	}
+	if partSize, err := GetDeviceSize(partition); err == nil && info.Size() > partSize {
+		return fmt.Errorf("image %s (%d bytes) does not fit in %s (%d bytes)", imagePath, info.Size(), partition, partSize)
+	}
+
+	// conv=fsync makes sure the whole image reached the device, including a short last block
+	cmd := exec.Command("dd", "if="+imagePath, "of="+partition, "bs=1M", "conv=fsync", "status=progress")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to write image with dd: %v", err)
 	}
@@ -112,6 +206,23 @@ func writeImageToPartition(imagePath, partition string) error {
 
 // CreateNTFSWithUEFI creates an NTFS partition setup with UEFI:NTFS support
 func CreateNTFSWithUEFI(device, tempDir string) (string, string, error) {
+	mainPartition, uefiPartition, err := CreateNTFSWithUEFILayout(device)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Install UEFI:NTFS
+	if err := InstallUEFINTFS(uefiPartition, tempDir); err != nil {
+		return "", "", fmt.Errorf("failed to install UEFI:NTFS: %v", err)
+	}
+
+	return mainPartition, uefiPartition, nil
+}
+
+// CreateNTFSWithUEFILayout is the destructive part of CreateNTFSWithUEFI: it wipes the
+// device and creates the main NTFS partition and an empty UEFI:NTFS partition. The
+// UEFI:NTFS image is installed separately with InstallUEFINTFSToExisting.
+func CreateNTFSWithUEFILayout(device string) (string, string, error) {
 	// Wipe the device first
 	if err := Wipe(device); err != nil {
 		return "", "", fmt.Errorf("failed to wipe device: %v", err)
@@ -127,20 +238,223 @@ func CreateNTFSWithUEFI(device, tempDir string) (string, string, error) {
 		return "", "", fmt.Errorf("failed to create main partition: %v", err)
 	}
 
+	if err := SetPartitionType(device, 1, "NTFS"); err != nil {
+		return "", "", fmt.Errorf("failed to set main partition type: %v", err)
+	}
+
 	// Create UEFI:NTFS partition
 	uefiPartition, err := CreateUEFINTFSPartition(device)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to create UEFI:NTFS partition: %v", err)
 	}
 
-	// Install UEFI:NTFS
-	if err := InstallUEFINTFS(uefiPartition, tempDir); err != nil {
+	if err := verifyCreatedLayout(device, "msdos", []ExpectedPartition{{Number: 1, FSType: "NTFS"}, {Number: 2}}); err != nil {
+		return "", "", err
+	}
+
+	return GetPartitionPath(device), uefiPartition, nil
+}
+
+// espSizeMiB returns the size of the EFI system partition for a given logical sector size.
+// FAT32 needs at least 65525 clusters, so 4Kn media need a larger partition.
+func espSizeMiB(sectorSize int) int {
+	if sectorSize >= 4096 {
+		return 260
+	}
+	return 100
+}
+
+// CreateESPOnlyGPT creates a GPT layout with a single EFI System Partition spanning the
+// device, for sticks that only need to boot on UEFI. The ESP is left unformatted.
+func CreateESPOnlyGPT(device string) error {
+	if err := Wipe(device); err != nil {
+		return fmt.Errorf("failed to wipe device: %v", err)
+	}
+
+	if err := CreateGPTTable(device); err != nil {
+		return fmt.Errorf("failed to create GPT table: %v", err)
+	}
+
+	// Leave 1MiB at the end for the backup GPT
+	if err := runParted("-s", "--", device, "mkpart", "ESP", "fat32", "1MiB", "-1MiB"); err != nil {
+		return fmt.Errorf("failed to create ESP on %s: %v", device, err)
+	}
+
+	if err := runParted("-s", device, "set", "1", "esp", "on"); err != nil {
+		return fmt.Errorf("failed to set ESP flag on %s: %v", device, err)
+	}
+
+	if err := rereadAndWait(device, 1); err != nil {
+		return err
+	}
+
+	return verifyCreatedLayout(device, "gpt", []ExpectedPartition{{Number: 1}})
+}
+
+// UEFI:NTFS sources for the GPT layout's ESP (--ntfs-uefi-source)
+const (
+	UEFISourceDownload = "download" // pbatard's uefi-ntfs.img
+	UEFISourceISO      = "iso"      // the Windows ISO's own efi directory
+)
+
+// CreateNTFSWithUEFIGPT creates a GPT layout with a main NTFS partition and a FAT32
+// ESP at the end that holds the UEFI:NTFS bootloader files
+func CreateNTFSWithUEFIGPT(device, tempDir string) (string, string, error) {
+	mainPartition, esp, err := CreateNTFSWithUEFIGPTLayout(device)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := InstallUEFINTFSToESP(esp, tempDir); err != nil {
 		return "", "", fmt.Errorf("failed to install UEFI:NTFS: %v", err)
 	}
 
-	// Return main partition path
-	mainPartition := GetPartitionPath(device)
-	return mainPartition, uefiPartition, nil
+	return mainPartition, esp, nil
+}
+
+// CreateNTFSWithUEFIGPTLayout is the destructive part of CreateNTFSWithUEFIGPT: it
+// wipes the device, creates the GPT layout and formats the ESP, leaving it empty.
+// Populate it with InstallUEFINTFSToESP or InstallISOBootToESP.
+func CreateNTFSWithUEFIGPTLayout(device string) (string, string, error) {
+	sectorSize, err := GetSectorSize(device)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get sector size: %v", err)
+	}
+	// Leave 1MiB at the end for the backup GPT
+	espStart := fmt.Sprintf("-%dMiB", espSizeMiB(sectorSize)+1)
+
+	if err := Wipe(device); err != nil {
+		return "", "", fmt.Errorf("failed to wipe device: %v", err)
+	}
+
+	if err := CreateGPTTable(device); err != nil {
+		return "", "", fmt.Errorf("failed to create GPT table: %v", err)
+	}
+
+	// On GPT the first mkpart argument is the partition name
+	if err := runParted("-s", "--", device, "mkpart", "Windows", "ntfs", "1MiB", espStart); err != nil {
+		return "", "", fmt.Errorf("failed to create main partition on %s: %v", device, err)
+	}
+
+	if err := runParted("-s", "--", device, "mkpart", "UEFI_NTFS", "fat32", espStart, "-1MiB"); err != nil {
+		return "", "", fmt.Errorf("failed to create ESP on %s: %v", device, err)
+	}
+
+	if err := runParted("-s", device, "set", "2", "esp", "on"); err != nil {
+		return "", "", fmt.Errorf("failed to set ESP flag on %s: %v", device, err)
+	}
+
+	if err := rereadAndWait(device, 1, 2); err != nil {
+		return "", "", err
+	}
+
+	if err := SetPartitionType(device, 1, "NTFS"); err != nil {
+		return "", "", fmt.Errorf("failed to set main partition type: %v", err)
+	}
+
+	if err := verifyCreatedLayout(device, "gpt", []ExpectedPartition{{Number: 1, FSType: "NTFS"}, {Number: 2}}); err != nil {
+		return "", "", err
+	}
+
+	esp := partitionPath(device, 2)
+	if err := filesystem.FormatPartition(esp, "FAT", "UEFI_NTFS"); err != nil {
+		return "", "", fmt.Errorf("failed to format ESP: %v", err)
+	}
+
+	return GetPartitionPath(device), esp, nil
+}
+
+// InstallISOBootToESP copies the efi directory of the Windows ISO mounted at srcMount
+// onto a formatted ESP instead of downloading UEFI:NTFS, and checks that the ESP then
+// holds an x64 fallback bootloader (efi/boot/bootx64.efi)
+func InstallISOBootToESP(esp, srcMount string) error {
+	efiDir, err := findDirFold(srcMount, "efi")
+	if err != nil {
+		return fmt.Errorf("no efi directory on the source: %v", err)
+	}
+
+	espMount, err := mount.MountDevice(esp, "vfat")
+	if err != nil {
+		return fmt.Errorf("failed to mount ESP: %v", err)
+	}
+	defer func() { _ = mount.CleanupMountpoint(espMount) }()
+
+	return copyISOBoot(efiDir, espMount)
+}
+
+// copyISOBoot copies efiDir to efi/ under espMount and checks for bootx64.efi
+func copyISOBoot(efiDir, espMount string) error {
+	if err := filecopy.CopyDirectoryQuiet(efiDir, filepath.Join(espMount, "efi")); err != nil {
+		return fmt.Errorf("failed to copy ISO boot files to ESP: %v", err)
+	}
+
+	archs, err := bootloader.CheckUEFIBootloaderArch(espMount)
+	if err != nil {
+		return fmt.Errorf("ESP check failed: %v", err)
+	}
+	if !slices.Contains(archs, bootloader.UEFIArchX64) {
+		return fmt.Errorf("the source has no efi/boot/bootx64.efi, use --ntfs-uefi-source download")
+	}
+	return nil
+}
+
+// findDirFold returns the subdirectory of dir named name, matched case-insensitively
+func findDirFold(dir, name string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.EqualFold(entry.Name(), name) {
+			return filepath.Join(dir, entry.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s", name, dir)
+}
+
+// InstallUEFINTFSToESP downloads uefi-ntfs.img, or uses UEFINTFSImage, and copies its
+// files onto a formatted ESP
+func InstallUEFINTFSToESP(esp, tempDir string) error {
+	imagePath := UEFINTFSImage
+	if imagePath != "" {
+		if err := CheckUEFINTFSImage(imagePath); err != nil {
+			return err
+		}
+	} else {
+		imagePath = filepath.Join(tempDir, "uefi-ntfs.img")
+		if err := downloadUEFINTFSImage(imagePath, uefiNTFSImageSHA256); err != nil {
+			return fmt.Errorf("failed to download UEFI:NTFS image: %w", err)
+		}
+		defer func() { _ = os.Remove(imagePath) }()
+	}
+
+	// Extract the files from the FAT image instead of writing the raw image
+	extractDir := filepath.Join(tempDir, "uefi-ntfs")
+	if err := os.MkdirAll(extractDir, 0755); err != nil {
+		return fmt.Errorf("failed to create extraction directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(extractDir) }()
+
+	sevenZip, err := deps.FindSevenZip()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(sevenZip, "x", "-y", "-o"+extractDir, imagePath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to extract UEFI:NTFS image with 7z: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	espMount, err := mount.MountDevice(esp, "vfat")
+	if err != nil {
+		return fmt.Errorf("failed to mount ESP: %v", err)
+	}
+	defer func() { _ = mount.CleanupMountpoint(espMount) }()
+
+	if err := filecopy.CopyDirectoryQuiet(extractDir, espMount); err != nil {
+		return fmt.Errorf("failed to copy UEFI:NTFS files to ESP: %v", err)
+	}
+
+	return nil
 }
 
 // Wipe removes all filesystem signatures and partition table from a device
@@ -161,138 +475,1050 @@ func Wipe(device string) error {
 
 // CreateMBRTable creates a new MBR (msdos) partition table on the device
 func CreateMBRTable(device string) error {
-	cmd := exec.Command("parted", "-s", device, "mklabel", "msdos")
-	if err := cmd.Run(); err != nil {
+	if err := runParted("-s", device, "mklabel", "msdos"); err != nil {
 		return fmt.Errorf("failed to create MBR table on %s: %v", device, err)
 	}
 	return nil
 }
 
-// CreatePartition creates a partition on the device with the specified filesystem type
-func CreatePartition(device, fstype string) error {
-	var partType string
-	var start, end string
+// CreateGPTTable creates a new GPT partition table on the device
+func CreateGPTTable(device string) error {
+	if err := runParted("-s", device, "mklabel", "gpt"); err != nil {
+		return fmt.Errorf("failed to create GPT table on %s: %v", device, err)
+	}
+	return nil
+}
+
+// DefaultStartOffset is where the main partition starts unless a layout says otherwise
+const DefaultStartOffset = 1024 * 1024
+
+// PartitionLayout controls where the main partition is placed on the device. The zero
+// value gives the default layout: start at 1MiB and use the rest of the device.
+type PartitionLayout struct {
+	StartOffset int64 // Start of the main partition in bytes (0 means DefaultStartOffset)
+	TrailingGap int64 // Bytes left unallocated at the end of the device
+}
+
+// bounds returns the first and last byte (inclusive, as parted expects) of the main
+// partition on a device of size bytes with sectors of align bytes. For NTFS the space
+// for the UEFI:NTFS partition is reserved in front of the trailing gap.
+func (l PartitionLayout) bounds(size int64, align int, fstype string) (int64, int64, error) {
+	if align <= 0 {
+		return 0, 0, fmt.Errorf("invalid sector alignment: %d", align)
+	}
+
+	start := l.StartOffset
+	if start == 0 {
+		start = DefaultStartOffset
+	}
+	if start < 0 || start%int64(align) != 0 {
+		return 0, 0, fmt.Errorf("start offset %d is not a positive multiple of the %d-byte sector size", start, align)
+	}
+	if l.TrailingGap < 0 || l.TrailingGap%int64(align) != 0 {
+		return 0, 0, fmt.Errorf("trailing gap %d is not a multiple of the %d-byte sector size", l.TrailingGap, align)
+	}
 
-	// Determine partition type and layout based on filesystem
+	limit := size - l.TrailingGap
 	switch strings.ToUpper(fstype) {
-	case "FAT32", "FAT":
-		partType = "primary"
-		start = "1MiB"
-		end = "100%"
+	case "FAT32", "FAT", "EXFAT":
 	case "NTFS":
-		// For NTFS, leave space for UEFI:NTFS partition at the end
-		partType = "primary"
-		start = "1MiB"
-		// Calculate end position: total size minus 512KiB
-		size, err := GetDeviceSize(device)
+		uefiStart, err := uefiNTFSStart(limit, align)
 		if err != nil {
-			return fmt.Errorf("failed to get device size: %v", err)
+			return 0, 0, err
 		}
-		// End position in bytes, leaving 512KiB (524288 bytes) at the end
-		endBytes := size - 524288
-		end = fmt.Sprintf("%dB", endBytes)
+		limit = uefiStart
 	default:
-		return fmt.Errorf("unsupported filesystem type: %s", fstype)
+		return 0, 0, fmt.Errorf("unsupported filesystem type: %s", fstype)
 	}
 
-	// Create the partition using -- to separate options from arguments
-	cmd := exec.Command("parted", "-s", "--", device, "mkpart", partType, start, end)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to create partition on %s: %v", device, err)
+	if limit <= start {
+		return 0, 0, fmt.Errorf("layout leaves no room for the partition on a %d-byte device (start %d, trailing gap %d)",
+			size, start, l.TrailingGap)
 	}
+	return start, limit - 1, nil
+}
 
-	return nil
+// CreatePartition creates a partition on the device with the specified filesystem type
+func CreatePartition(device, fstype string) error {
+	return CreatePartitionWithLayout(device, fstype, PartitionLayout{})
 }
 
-// RereadPartitionTable forces the kernel to re-read the partition table
-func RereadPartitionTable(device string) error {
-	// Run blockdev --rereadpt
-	cmd := exec.Command("blockdev", "--rereadpt", device)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to re-read partition table for %s: %v", device, err)
-	}
+// CheckLayout checks that layout fits device and matches its sector alignment, without
+// changing anything. Run it before wiping so a bad layout cannot leave an empty device.
+func CheckLayout(device, fstype string, layout PartitionLayout) error {
+	_, err := PlannedPartitionSize(device, fstype, layout)
+	return err
+}
 
-	// Sleep for 3 seconds to allow the kernel to process the changes
-	time.Sleep(3 * time.Second)
+// PlannedPartitionSize returns the size in bytes of the main partition layout would
+// create on device, checking the layout like CheckLayout
+func PlannedPartitionSize(device, fstype string, layout PartitionLayout) (int64, error) {
+	size, err := GetDeviceSize(device)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get device size: %v", err)
+	}
+	align, err := deviceAlignment(device)
+	if err != nil {
+		return 0, err
+	}
 
-	return nil
+	start, end, err := layout.bounds(size, align, fstype)
+	if err != nil {
+		return 0, fmt.Errorf("invalid partition layout for %s: %v", device, err)
+	}
+	return end - start + 1, nil
 }
 
-// GetPartitionPath returns the path to the first partition of a device
-func GetPartitionPath(device string) string {
-	// Handle different device naming conventions
-	if strings.Contains(device, "nvme") || strings.Contains(device, "mmcblk") {
-		return device + "p1"
+// CreatePartitionWithLayout creates the main partition on the device, placed according
+// to layout. With NTFS, space for the UEFI:NTFS partition is left after it.
+func CreatePartitionWithLayout(device, fstype string, layout PartitionLayout) error {
+	switch strings.ToUpper(fstype) {
+	case "FAT32", "FAT", "NTFS", "EXFAT":
+	default:
+		return fmt.Errorf("unsupported filesystem type: %s", fstype)
 	}
-	return device + "1"
-}
 
-// verifyNoPartitions checks that no partitions exist on the device
-func verifyNoPartitions(device string) error {
-	cmd := exec.Command("lsblk", "-n", "-o", "TYPE", device)
-	output, err := cmd.Output()
+	size, err := GetDeviceSize(device)
 	if err != nil {
-		// If lsblk fails, the device might not exist or be accessible
-		// This could be expected after wiping, so we don't treat it as an error
-		return nil
+		return fmt.Errorf("failed to get device size: %v", err)
+	}
+	align, err := deviceAlignment(device)
+	if err != nil {
+		return err
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "part" {
-			return fmt.Errorf("partitions still exist on device %s", device)
-		}
+	start, end, err := layout.bounds(size, align, fstype)
+	if err != nil {
+		return fmt.Errorf("invalid partition layout for %s: %v", device, err)
+	}
+
+	// Create the partition using -- to separate options from arguments
+	if err := runParted("-s", "--", device, "mkpart", "primary", fmt.Sprintf("%dB", start), fmt.Sprintf("%dB", end)); err != nil {
+		return fmt.Errorf("failed to create partition on %s: %v", device, err)
 	}
 
 	return nil
 }
 
-// CreateBootablePartition creates a bootable partition suitable for Windows USB
-func CreateBootablePartition(device, fstype string) error {
-	// Wipe the device first
-	if err := Wipe(device); err != nil {
-		return fmt.Errorf("failed to wipe device: %v", err)
+// ParseSize parses a size such as "4MiB", "512K" or "1048576" into bytes. Suffixes
+// are binary (K, M and G are the same as KiB, MiB and GiB); no suffix means bytes.
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffixes []string
+		factor   int64
+	}{
+		{[]string{"GiB", "G"}, 1024 * 1024 * 1024},
+		{[]string{"MiB", "M"}, 1024 * 1024},
+		{[]string{"KiB", "K"}, 1024},
+		{[]string{"B"}, 1},
+	} {
+		found := false
+		for _, suffix := range unit.suffixes {
+			if trimmed, ok := strings.CutSuffix(s, suffix); ok {
+				s, multiplier, found = trimmed, unit.factor, true
+				break
+			}
+		}
+		if found {
+			break
+		}
 	}
 
-	// Create MBR partition table
-	if err := CreateMBRTable(device); err != nil {
-		return fmt.Errorf("failed to create MBR table: %v", err)
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 4MiB, 512K or a byte count)", value)
 	}
+	return n * multiplier, nil
+}
 
-	// Create the main partition
-	if err := CreatePartition(device, fstype); err != nil {
-		return fmt.Errorf("failed to create partition: %v", err)
-	}
+// runParted runs parted and only fails on genuine errors. parted may exit non-zero
+// while reporting nothing but recoverable warnings (e.g. partition alignment); those
+// are logged and the operation is treated as successful.
+func runParted(args ...string) error {
+	out, err := exec.Command("parted", args...).CombinedOutput()
 
-	// Re-read partition table
-	if err := RereadPartitionTable(device); err != nil {
-		return fmt.Errorf("failed to re-read partition table: %v", err)
+	warnings, err := partedOutcome(err, string(out))
+	for _, w := range warnings {
+		output.Warning("parted: %s", w)
 	}
-
-	return nil
+	return err
 }
 
-// SetBootFlag sets the boot flag on the specified partition
-func SetBootFlag(device string, partNum int) error {
-	cmd := exec.Command("parted", "-s", device, "set", fmt.Sprintf("%d", partNum), "boot", "on")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to set boot flag on %s partition %d: %v", device, partNum, err)
+// partedOutcome classifies parted output given the command's exit error.
+// Lines starting with "Warning:" or "Information:" are warnings, lines starting
+// with "Error:" are fatal. A non-zero exit is only forgiven if parted reported
+// warnings and no errors.
+func partedOutcome(runErr error, out string) ([]string, error) {
+	var warnings, errs []string
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Error:"):
+			errs = append(errs, strings.TrimSpace(strings.TrimPrefix(line, "Error:")))
+		case strings.HasPrefix(line, "Warning:"):
+			warnings = append(warnings, strings.TrimSpace(strings.TrimPrefix(line, "Warning:")))
+		case strings.HasPrefix(line, "Information:"):
+			warnings = append(warnings, strings.TrimSpace(strings.TrimPrefix(line, "Information:")))
+		}
 	}
-	return nil
-}
 
-// GetDeviceSize returns the size of the device in bytes
-func GetDeviceSize(device string) (int64, error) {
-	cmd := exec.Command("blockdev", "--getsize64", device)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get device size for %s: %v", device, err)
+	if runErr == nil {
+		return warnings, nil
 	}
 
-	var size int64
-	if _, err := fmt.Sscanf(strings.TrimSpace(string(output)), "%d", &size); err != nil {
-		return 0, fmt.Errorf("failed to parse device size: %v", err)
+	if len(errs) > 0 {
+		return warnings, fmt.Errorf("%v: %s", runErr, strings.Join(errs, "; "))
+	}
+
+	if len(warnings) > 0 {
+		return warnings, nil
+	}
+
+	if trimmed := strings.TrimSpace(out); trimmed != "" {
+		return nil, fmt.Errorf("%v: %s", runErr, trimmed)
+	}
+	return nil, runErr
+}
+
+// PartitionWaitTimeout is how long WaitForPartition waits for a partition node to appear
+const PartitionWaitTimeout = 15 * time.Second
+
+// NoUdevWaitTimeout bounds the wait for a partition node when udevadm is not installed.
+// Nothing may create the node then (e.g. a static /dev in a container), so the run goes
+// on after this delay, like the fixed sleep used before polling.
+const NoUdevWaitTimeout = 3 * time.Second
+
+// Hooks for waiting on partition nodes; tests replace them
+var (
+	statPartition         = os.Stat
+	partitionPollInterval = 100 * time.Millisecond
+	udevadmAvailable      = func() bool {
+		_, err := exec.LookPath("udevadm")
+		return err == nil
+	}
+)
+
+// RereadPartitionTable forces the kernel to re-read the partition table and lets udev
+// finish processing the resulting events. Callers that need a partition node should
+// follow up with WaitForPartition.
+func RereadPartitionTable(device string) error {
+	// Run blockdev --rereadpt
+	cmd := exec.Command("blockdev", "--rereadpt", device)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to re-read partition table for %s: %v", device, err)
+	}
+
+	// Best effort: udevadm may be missing (e.g. in containers), WaitForPartition still polls
+	_ = exec.Command("udevadm", "settle", "--timeout=10").Run()
+
+	return nil
+}
+
+// WaitForPartition polls until the partition device node at path exists or timeout
+// expires, returning as soon as the node appears
+func WaitForPartition(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if info, err := statPartition(path); err == nil && info.Mode()&os.ModeDevice != 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("partition %s did not appear within %v", path, timeout)
+		}
+		time.Sleep(partitionPollInterval)
+	}
+}
+
+// rereadAndWait re-reads the partition table of device and waits for the given partitions
+func rereadAndWait(device string, partNums ...int) error {
+	if err := RereadPartitionTable(device); err != nil {
+		return fmt.Errorf("failed to re-read partition table: %v", err)
+	}
+
+	withUdev := udevadmAvailable()
+	for _, n := range partNums {
+		if !withUdev {
+			_ = WaitForPartition(partitionPath(device, n), NoUdevWaitTimeout)
+			continue
+		}
+		if err := WaitForPartition(partitionPath(device, n), PartitionWaitTimeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPartitionPath returns the path to the first partition of a device
+func GetPartitionPath(device string) string {
+	return partitionPath(device, 1)
+}
+
+// partitionPath returns the path to partition number n of a device. Symlinked device
+// paths (e.g. /dev/disk/by-id/usb-*) are resolved to the canonical node first. The
+// partitions the kernel lists for the device are preferred over inferring the name,
+// which device-mapper and other unusual nodes do not follow.
+func partitionPath(device string, n int) string {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+
+	if out, err := lsblkPartitionList(device); err == nil {
+		if path, ok := findPartitionNode(out, n); ok {
+			return path
+		}
+	}
+	return inferPartitionPath(device, n)
+}
+
+// inferPartitionPath derives the path to partition n from the device name
+func inferPartitionPath(device string, n int) string {
+	// Handle different device naming conventions
+	if strings.Contains(device, "nvme") || strings.Contains(device, "mmcblk") || strings.Contains(device, "loop") {
+		return fmt.Sprintf("%sp%d", device, n)
+	}
+	return fmt.Sprintf("%s%d", device, n)
+}
+
+// lsblkPartitionList lists device and its children as "PATH TYPE" lines; tests replace it
+var lsblkPartitionList = func(device string) ([]byte, error) {
+	return exec.Command("lsblk", "-ln", "-p", "-o", "NAME,TYPE", device).Output()
+}
+
+// sysClassBlock is where the kernel publishes block device partition numbers
+var sysClassBlock = "/sys/class/block"
+
+// findPartitionNode returns the node of partition n in lsblk -ln -p -o NAME,TYPE output.
+// The number comes from sysfs; partitions without one (e.g. kpartx mappings) are
+// numbered in listing order.
+func findPartitionNode(out []byte, n int) (string, bool) {
+	index := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != "part" {
+			continue
+		}
+		index++
+
+		number := index
+		data, err := os.ReadFile(filepath.Join(sysClassBlock, filepath.Base(fields[0]), "partition"))
+		if err == nil {
+			if parsed, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				number = parsed
+			}
+		}
+		if number == n {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// verifyNoPartitions checks that no partitions exist on the device
+func verifyNoPartitions(device string) error {
+	cmd := exec.Command("lsblk", "-n", "-o", "TYPE", device)
+	output, err := cmd.Output()
+	if err != nil {
+		// If lsblk fails, the device might not exist or be accessible
+		// This could be expected after wiping, so we don't treat it as an error
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "part" {
+			return fmt.Errorf("partitions still exist on device %s", device)
+		}
+	}
+
+	return nil
+}
+
+// CreateBootablePartition creates a bootable partition suitable for Windows USB
+func CreateBootablePartition(device, fstype string) error {
+	return CreateBootablePartitionWithLayout(device, fstype, PartitionLayout{})
+}
+
+// CreateBootablePartitionWithLayout is CreateBootablePartition with the main partition
+// placed according to layout. It is still partition 1, so GetPartitionPath finds it.
+func CreateBootablePartitionWithLayout(device, fstype string, layout PartitionLayout) error {
+	// Wipe the device first
+	if err := Wipe(device); err != nil {
+		return fmt.Errorf("failed to wipe device: %v", err)
+	}
+
+	// Create MBR partition table
+	if err := CreateMBRTable(device); err != nil {
+		return fmt.Errorf("failed to create MBR table: %v", err)
+	}
+
+	// Create the main partition
+	if err := CreatePartitionWithLayout(device, fstype, layout); err != nil {
+		return fmt.Errorf("failed to create partition: %v", err)
+	}
+
+	// Use the partition type Windows expects rather than parted's default
+	if err := SetPartitionType(device, 1, fstype); err != nil {
+		return fmt.Errorf("failed to set partition type: %v", err)
+	}
+
+	// Re-read partition table
+	if err := rereadAndWait(device, 1); err != nil {
+		return err
+	}
+
+	return verifyCreatedLayout(device, "msdos", []ExpectedPartition{{Number: 1, FSType: fstype}})
+}
+
+// MicrosoftBasicDataGUID is the GPT partition type used by Windows for FAT and NTFS data partitions
+const MicrosoftBasicDataGUID = "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"
+
+// PartitionTypeID returns the partition type Windows expects for fstype on the given
+// partition table: 0x0c (FAT32 LBA) or 0x07 (NTFS/exFAT) on MBR, Microsoft basic data on GPT
+func PartitionTypeID(tableType, fstype string) (string, error) {
+	if tableType == "gpt" {
+		switch strings.ToUpper(fstype) {
+		case "FAT", "FAT32", "NTFS", "EXFAT":
+			return MicrosoftBasicDataGUID, nil
+		}
+		return "", fmt.Errorf("unsupported filesystem type: %s", fstype)
+	}
+
+	switch strings.ToUpper(fstype) {
+	case "FAT", "FAT32":
+		return "0c", nil
+	case "NTFS", "EXFAT":
+		return "07", nil
+	}
+	return "", fmt.Errorf("unsupported filesystem type: %s", fstype)
+}
+
+// SetPartitionType sets the MBR type byte or GPT type GUID of a partition to match fstype
+func SetPartitionType(device string, partNum int, fstype string) error {
+	layout, err := DescribeDevice(device)
+	if err != nil {
+		return err
+	}
+
+	typeID, err := PartitionTypeID(layout.TableType, fstype)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("sfdisk", "--part-type", device, strconv.Itoa(partNum), typeID)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set type %s on %s partition %d: %v: %s",
+			typeID, device, partNum, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// SetBootFlag sets the boot flag on the specified partition
+func SetBootFlag(device string, partNum int) error {
+	if err := runParted("-s", device, "set", fmt.Sprintf("%d", partNum), "boot", "on"); err != nil {
+		return fmt.Errorf("failed to set boot flag on %s partition %d: %v", device, partNum, err)
+	}
+	return nil
+}
+
+// GetSectorSize returns the logical sector size of the device in bytes
+func GetSectorSize(device string) (int, error) {
+	return blockdevInt(device, "--getss")
+}
+
+// getPhysicalSectorSize returns the physical sector size of the device in bytes
+func getPhysicalSectorSize(device string) (int, error) {
+	return blockdevInt(device, "--getpbsz")
+}
+
+// blockdevInt runs "blockdev <flag> <device>" and parses the integer it prints
+func blockdevInt(device, flag string) (int, error) {
+	out, err := exec.Command("blockdev", flag, device).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run blockdev %s on %s: %v", flag, device, err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("unexpected blockdev %s output for %s: %q", flag, device, strings.TrimSpace(string(out)))
+	}
+	return n, nil
+}
+
+// uefiNTFSStartForDevice returns the byte offset of the UEFI:NTFS partition on device,
+// aligned to the device's logical and physical sector size
+func uefiNTFSStartForDevice(device string) (int64, error) {
+	size, err := GetDeviceSize(device)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get device size: %v", err)
+	}
+
+	align, err := deviceAlignment(device)
+	if err != nil {
+		return 0, err
+	}
+
+	return uefiNTFSStart(size, align)
+}
+
+// deviceAlignment returns the larger of the device's logical and physical sector size
+func deviceAlignment(device string) (int, error) {
+	sectorSize, err := GetSectorSize(device)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sector size: %v", err)
+	}
+
+	align := sectorSize
+	if physical, err := getPhysicalSectorSize(device); err == nil && physical > align {
+		align = physical
+	}
+	return align, nil
+}
+
+// uefiNTFSStart returns the start offset of a UEFINTFSPartitionSize partition at the
+// end of a device of size bytes, rounded down to a multiple of align
+func uefiNTFSStart(size int64, align int) (int64, error) {
+	if align <= 0 {
+		return 0, fmt.Errorf("invalid sector alignment: %d", align)
+	}
+
+	start := size - UEFINTFSPartitionSize
+	start -= start % int64(align)
+	if start <= 0 {
+		return 0, fmt.Errorf("device too small for a UEFI:NTFS partition: %d bytes", size)
+	}
+	return start, nil
+}
+
+// GetDeviceSize returns the size of the device in bytes
+func GetDeviceSize(device string) (int64, error) {
+	cmd := exec.Command("blockdev", "--getsize64", device)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get device size for %s: %v", device, err)
+	}
+
+	var size int64
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(output)), "%d", &size); err != nil {
+		return 0, fmt.Errorf("failed to parse device size: %v", err)
 	}
 
 	return size, nil
 }
+
+// PartitionInfo describes a single partition on a device
+type PartitionInfo struct {
+	Path       string `json:"path"`
+	Number     int    `json:"number"`
+	Start      int64  `json:"start"` // Start offset in bytes
+	Size       int64  `json:"size"`  // Size in bytes
+	Filesystem string `json:"filesystem"`
+	Label      string `json:"label,omitempty"`
+	UUID       string `json:"uuid,omitempty"`
+	Boot       bool   `json:"boot"`
+	TypeID     string `json:"type_id,omitempty"` // MBR type byte (e.g. "0x7") or GPT type GUID, as reported by lsblk
+}
+
+// DeviceLayout describes the partition layout of a device
+type DeviceLayout struct {
+	Device     string          `json:"device"`
+	TableType  string          `json:"table_type"` // "msdos", "gpt", ...
+	Size       int64           `json:"size"`
+	Partitions []PartitionInfo `json:"partitions"`
+	FreeBytes  int64           `json:"free_bytes"` // Bytes not covered by any partition
+}
+
+// DescribeDevice returns the partition table, partitions and free space of a device
+// by combining parted and lsblk output
+func DescribeDevice(device string) (*DeviceLayout, error) {
+	cmd := exec.Command("parted", "-s", "-m", device, "unit", "B", "print")
+	partedOut, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partition table of %s: %v", device, err)
+	}
+
+	layout, err := parsePartedMachineOutput(device, string(partedOut))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd = exec.Command("lsblk", "-J", "-o", "PATH,FSTYPE,LABEL,UUID,PARTTYPE", device)
+	lsblkOut, err := cmd.Output()
+	if err == nil {
+		_ = applyLsblkDetails(layout, lsblkOut)
+	}
+
+	return layout, nil
+}
+
+// parsePartedMachineOutput parses the output of "parted -m <device> unit B print"
+func parsePartedMachineOutput(device, out string) (*DeviceLayout, error) {
+	layout := &DeviceLayout{Device: device}
+	sawDisk := false
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ";")
+		if line == "" || line == "BYT" {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if !sawDisk {
+			// path:size:transport:logical-sector:physical-sector:table:model:flags
+			if len(fields) < 6 {
+				return nil, fmt.Errorf("unexpected parted output: %s", line)
+			}
+			layout.Size = parseBytes(fields[1])
+			layout.TableType = fields[5]
+			sawDisk = true
+			continue
+		}
+
+		// number:start:end:size:filesystem:name:flags
+		if len(fields) < 7 {
+			continue
+		}
+		num, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		part := PartitionInfo{
+			Path:       partitionPath(device, num),
+			Number:     num,
+			Start:      parseBytes(fields[1]),
+			Size:       parseBytes(fields[3]),
+			Filesystem: fields[4],
+		}
+		for _, flag := range strings.Split(fields[6], ",") {
+			if strings.TrimSpace(flag) == "boot" {
+				part.Boot = true
+			}
+		}
+		layout.Partitions = append(layout.Partitions, part)
+	}
+
+	if !sawDisk {
+		return nil, fmt.Errorf("no disk information in parted output for %s", device)
+	}
+
+	layout.FreeBytes = layout.Size
+	for _, p := range layout.Partitions {
+		layout.FreeBytes -= p.Size
+	}
+	if layout.FreeBytes < 0 {
+		layout.FreeBytes = 0
+	}
+
+	return layout, nil
+}
+
+// parseBytes parses a parted byte value such as "1048576B"
+func parseBytes(value string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSuffix(value, "B"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// lsblkNode is a device entry from "lsblk -J -o PATH,FSTYPE,LABEL,UUID,PARTTYPE"
+type lsblkNode struct {
+	Path     string      `json:"path"`
+	FSType   string      `json:"fstype"`
+	Label    string      `json:"label"`
+	UUID     string      `json:"uuid"`
+	PartType string      `json:"parttype"`
+	Children []lsblkNode `json:"children,omitempty"`
+}
+
+// lsblkChildren returns the partitions listed in lsblk JSON output, by path
+func lsblkChildren(jsonData []byte) (map[string]lsblkNode, error) {
+	var out struct {
+		Blockdevices []lsblkNode `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(jsonData, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse lsblk output: %v", err)
+	}
+
+	nodes := make(map[string]lsblkNode)
+	for _, dev := range out.Blockdevices {
+		for _, child := range dev.Children {
+			nodes[child.Path] = child
+		}
+	}
+	return nodes, nil
+}
+
+// applyLsblkDetails fills filesystem, label and UUID of partitions from lsblk JSON output
+func applyLsblkDetails(layout *DeviceLayout, jsonData []byte) error {
+	nodes, err := lsblkChildren(jsonData)
+	if err != nil {
+		return err
+	}
+
+	for i := range layout.Partitions {
+		node, ok := nodes[layout.Partitions[i].Path]
+		if !ok {
+			continue
+		}
+		if node.FSType != "" {
+			layout.Partitions[i].Filesystem = node.FSType
+		}
+		layout.Partitions[i].Label = node.Label
+		layout.Partitions[i].UUID = node.UUID
+		layout.Partitions[i].TypeID = node.PartType
+	}
+
+	return nil
+}
+
+// Summary returns human-readable lines describing the layout
+func (l *DeviceLayout) Summary() []string {
+	lines := []string{
+		fmt.Sprintf("%s: %s partition table, %s", l.Device, l.TableType, filesystem.FormatSizeHuman(l.Size)),
+	}
+	for _, p := range l.Partitions {
+		line := fmt.Sprintf("%s: %s, %s", p.Path, p.Filesystem, filesystem.FormatSizeHuman(p.Size))
+		if p.Label != "" {
+			line += fmt.Sprintf(", label '%s'", p.Label)
+		}
+		if p.UUID != "" {
+			line += ", UUID " + p.UUID
+		}
+		if p.TypeID != "" {
+			line += ", type " + p.TypeID
+		}
+		if p.Boot {
+			line += ", boot"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("Unpartitioned space: %s", filesystem.FormatSizeHuman(l.FreeBytes)))
+	return lines
+}
+
+// VerifyPartitionType checks that partition partNum has the type Windows expects for fstype
+func (l *DeviceLayout) VerifyPartitionType(partNum int, fstype string) error {
+	want, err := PartitionTypeID(l.TableType, fstype)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range l.Partitions {
+		if p.Number != partNum {
+			continue
+		}
+		if p.TypeID == "" {
+			return fmt.Errorf("partition type of %s is unknown", p.Path)
+		}
+		if !samePartitionType(p.TypeID, want) {
+			return fmt.Errorf("partition %s has type %s, expected %s", p.Path, p.TypeID, want)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("partition %d not found on %s", partNum, l.Device)
+}
+
+// ExpectedPartition is a partition VerifyLayout expects. An empty FSType skips the
+// partition type check.
+type ExpectedPartition struct {
+	Number int
+	FSType string
+}
+
+// VerifyLayout checks that the layout has a tableType partition table holding exactly
+// the expected partitions, each with the type Windows expects for its filesystem.
+// Partitions whose type lsblk did not report are not type-checked.
+func (l *DeviceLayout) VerifyLayout(tableType string, want []ExpectedPartition) error {
+	if l.TableType != tableType {
+		return fmt.Errorf("%s has a %s partition table, expected %s", l.Device, l.TableType, tableType)
+	}
+	if len(l.Partitions) != len(want) {
+		return fmt.Errorf("%s has %d partition(s), expected %d", l.Device, len(l.Partitions), len(want))
+	}
+
+	for i, w := range want {
+		p := l.Partitions[i]
+		if p.Number != w.Number {
+			return fmt.Errorf("%s has partition %d where partition %d was expected", l.Device, p.Number, w.Number)
+		}
+		if w.FSType == "" || p.TypeID == "" {
+			continue
+		}
+		if err := l.VerifyPartitionType(w.Number, w.FSType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyCreatedLayout checks a freshly partitioned device against the expected layout
+// and that the kernel lists every expected partition, so formatting never targets a
+// partition path that does not match what was created
+func verifyCreatedLayout(device, tableType string, want []ExpectedPartition) error {
+	layout, err := DescribeDevice(device)
+	if err != nil {
+		return fmt.Errorf("failed to verify new partition layout: %v", err)
+	}
+	if err := layout.VerifyLayout(tableType, want); err != nil {
+		return fmt.Errorf("unexpected partition layout after partitioning: %v", err)
+	}
+
+	out, err := exec.Command("lsblk", "-J", "-o", "PATH,FSTYPE,LABEL,UUID,PARTTYPE", device).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %v", device, err)
+	}
+	children, err := lsblkChildren(out)
+	if err != nil {
+		return err
+	}
+	for _, w := range want {
+		if path := partitionPath(device, w.Number); children[path].Path == "" {
+			return fmt.Errorf("unexpected partition layout after partitioning: the kernel does not list %s", path)
+		}
+	}
+	return nil
+}
+
+// samePartitionType compares type IDs, treating "0x7", "07" and "7" as equal
+func samePartitionType(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+
+	parse := func(id string) (uint64, bool) {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(id), "0x"), 16, 8)
+		return n, err == nil
+	}
+	na, okA := parse(a)
+	nb, okB := parse(b)
+	return okA && okB && na == nb
+}
+
+// cloneChunkSize is the block size used when cloning devices (4MiB)
+const cloneChunkSize = 4 * 1024 * 1024
+
+// CloneDevice copies the partition table and all partitions of src to dst. Only the
+// bytes up to the end of the last partition are copied; dst must be at least that large.
+// On GPT the backup header is moved to the end of dst afterwards.
+func CloneDevice(src, dst string, progressFn filecopy.ProgressFunc) error {
+	if src == dst {
+		return fmt.Errorf("source and destination are the same device: %s", src)
+	}
+
+	layout, err := DescribeDevice(src)
+	if err != nil {
+		return fmt.Errorf("failed to read source layout: %v", err)
+	}
+
+	extent, err := cloneExtent(layout)
+	if err != nil {
+		return err
+	}
+
+	dstSize, err := GetDeviceSize(dst)
+	if err != nil {
+		return fmt.Errorf("failed to get destination size: %v", err)
+	}
+	if dstSize < extent {
+		return fmt.Errorf("destination %s (%s) is smaller than the used space of %s (%s)",
+			dst, filesystem.FormatSizeHuman(dstSize), src, filesystem.FormatSizeHuman(extent))
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source %s: %v", src, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open destination %s: %v", dst, err)
+	}
+	defer func() { _ = out.Close() }()
+
+	if err := copyRaw(in, out, extent, progressFn); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", src, dst, err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %v", dst, err)
+	}
+
+	if layout.TableType == "gpt" {
+		cmd := exec.Command("sfdisk", "--relocate", "gpt-bak-std", dst)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to relocate backup GPT on %s: %v: %s", dst, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	var partNums []int
+	for _, p := range layout.Partitions {
+		partNums = append(partNums, p.Number)
+	}
+	return rereadAndWait(dst, partNums...)
+}
+
+// cloneExtent returns the number of bytes from the start of the device to the end of its last partition
+func cloneExtent(layout *DeviceLayout) (int64, error) {
+	var extent int64
+	for _, p := range layout.Partitions {
+		if end := p.Start + p.Size; end > extent {
+			extent = end
+		}
+	}
+	if extent == 0 {
+		return 0, fmt.Errorf("%s has no partitions to clone", layout.Device)
+	}
+	return extent, nil
+}
+
+// copyRaw copies exactly n bytes from in to out, reporting progress after every chunk
+func copyRaw(in io.Reader, out io.Writer, n int64, progressFn filecopy.ProgressFunc) error {
+	buf := make([]byte, cloneChunkSize)
+	var copied int64
+
+	for copied < n {
+		chunk := buf
+		if remaining := n - copied; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		read, err := io.ReadFull(in, chunk)
+		if err != nil {
+			return fmt.Errorf("read failed at offset %d: %v", copied, err)
+		}
+		if _, err := out.Write(chunk[:read]); err != nil {
+			return fmt.Errorf("write failed at offset %d: %v", copied, err)
+		}
+
+		copied += int64(read)
+		if progressFn != nil {
+			progressFn(copied, n, "")
+		}
+	}
+
+	return nil
+}
+
+// MediaError is returned when data read back from a device differs from what was written,
+// which usually means the device reports more capacity than it really has
+type MediaError struct {
+	Device string
+	Offset int64 // First offset where the data did not match
+	Size   int64 // Reported device size
+}
+
+func (e *MediaError) Error() string {
+	return fmt.Sprintf("data written to %s did not read back correctly at offset %d (%s of %s reported): "+
+		"the device is failing or has fake capacity", e.Device, e.Offset,
+		filesystem.FormatSizeHuman(e.Offset), filesystem.FormatSizeHuman(e.Size))
+}
+
+// TestMediaIntegrity writes a position-dependent pattern across the whole device and
+// reads it back, detecting counterfeit sticks that silently drop or wrap writes beyond
+// their real capacity. This DESTROYS all data on the device. Progress covers both passes.
+func TestMediaIntegrity(device string, progressFn filecopy.ProgressFunc) error {
+	size, err := GetDeviceSize(device)
+	if err != nil {
+		return fmt.Errorf("failed to get device size: %v", err)
+	}
+
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", device, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// Flush and drop the page cache so the read pass hits the actual medium
+	flush := func() error {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return exec.Command("blockdev", "--flushbufs", device).Run()
+	}
+
+	if err := verifyPattern(f, size, flush, progressFn); err != nil {
+		if mediaErr, ok := err.(*MediaError); ok {
+			mediaErr.Device = device
+		}
+		return err
+	}
+
+	return nil
+}
+
+// verifyPattern writes the test pattern over size bytes of dev, calls flush, then reads
+// everything back and returns a *MediaError at the first mismatch
+func verifyPattern(dev interface {
+	io.ReaderAt
+	io.WriterAt
+}, size int64, flush func() error, progressFn filecopy.ProgressFunc) error {
+	want := make([]byte, cloneChunkSize)
+	got := make([]byte, cloneChunkSize)
+	total := 2 * size
+
+	for offset := int64(0); offset < size; offset += int64(len(want)) {
+		chunk := want[:min(int64(len(want)), size-offset)]
+		fillPattern(chunk, offset)
+		if _, err := dev.WriteAt(chunk, offset); err != nil {
+			return fmt.Errorf("write failed at offset %d: %v", offset, err)
+		}
+		if progressFn != nil {
+			progressFn(offset+int64(len(chunk)), total, "writing")
+		}
+	}
+
+	if flush != nil {
+		if err := flush(); err != nil {
+			return fmt.Errorf("failed to flush device: %v", err)
+		}
+	}
+
+	for offset := int64(0); offset < size; offset += int64(len(got)) {
+		n := min(int64(len(got)), size-offset)
+		if _, err := dev.ReadAt(got[:n], offset); err != nil {
+			return fmt.Errorf("read failed at offset %d: %v", offset, err)
+		}
+		fillPattern(want[:n], offset)
+		if !bytes.Equal(got[:n], want[:n]) {
+			for i := int64(0); i < n; i++ {
+				if got[i] != want[i] {
+					return &MediaError{Offset: offset + i, Size: size}
+				}
+			}
+		}
+		if progressFn != nil {
+			progressFn(size+offset+n, total, "verifying")
+		}
+	}
+
+	return nil
+}
+
+// fillPattern fills buf with data that depends on its absolute offset, so that blocks
+// silently mapped onto other blocks by fake-capacity firmware are detected.
+// offset must be a multiple of 8.
+func fillPattern(buf []byte, offset int64) {
+	for i := 0; i < len(buf); i += 8 {
+		word := mix64((uint64(offset) + uint64(i)) / 8)
+		if i+8 <= len(buf) {
+			binary.LittleEndian.PutUint64(buf[i:], word)
+			continue
+		}
+		for j := i; j < len(buf); j++ {
+			buf[j] = byte(word >> (8 * uint(j-i)))
+		}
+	}
+}
+
+// mix64 is the splitmix64 finalizer, spreading every bit of x over the whole word
+func mix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
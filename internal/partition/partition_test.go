@@ -1,8 +1,16 @@
 package partition
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mathisen/woeusb-go/internal/download"
 )
 
 func TestGetPartitionPath(t *testing.T) {
@@ -16,6 +24,7 @@ func TestGetPartitionPath(t *testing.T) {
 		{"/dev/nvme1n1", "/dev/nvme1n1p1"},
 		{"/dev/mmcblk0", "/dev/mmcblk0p1"},
 		{"/dev/mmcblk1", "/dev/mmcblk1p1"},
+		{"/dev/loop0", "/dev/loop0p1"},
 	}
 
 	for _, test := range tests {
@@ -110,11 +119,125 @@ func TestInstallUEFINTFS(t *testing.T) {
 	}
 	defer func() { _ = os.RemoveAll(tmpDir) }()
 
-	// Test with non-existent partition (should handle gracefully)
+	origDownload := downloadFile
+	defer func() { downloadFile = origDownload }()
+	downloadFile = func(string, string, download.ProgressFunc) error {
+		return errors.New("network unreachable")
+	}
+
+	// A failed download is an error, not a warning that leaves the stick unbootable
 	err = InstallUEFINTFS("/dev/nonexistent", tmpDir)
-	// This should not fail because download failure is handled gracefully
+	if err == nil {
+		t.Error("InstallUEFINTFS() should fail when the image cannot be downloaded")
+	}
+}
+
+func TestDownloadUEFINTFSImage(t *testing.T) {
+	tmpDir := t.TempDir()
+	imagePath := filepath.Join(tmpDir, "uefi-ntfs.img")
+
+	origDownload := downloadFile
+	defer func() { downloadFile = origDownload }()
+	downloads := 0
+	downloadFile = func(_, dest string, _ download.ProgressFunc) error {
+		downloads++
+		return os.WriteFile(dest, []byte("abc"), 0644)
+	}
+
+	// Without a pinned checksum nothing is downloaded
+	err := downloadUEFINTFSImage(imagePath, "")
+	if !errors.Is(err, ErrUEFINTFSDownloadUnverified) {
+		t.Errorf("downloadUEFINTFSImage() without a checksum error = %v, want ErrUEFINTFSDownloadUnverified", err)
+	}
+	if downloads != 0 {
+		t.Errorf("downloadUEFINTFSImage() downloaded %d times without a checksum", downloads)
+	}
+
+	const abcSum = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if err := downloadUEFINTFSImage(imagePath, abcSum); err != nil {
+		t.Errorf("downloadUEFINTFSImage() with matching checksum error = %v", err)
+	}
+	_ = os.Remove(imagePath)
+
+	// A mismatching download is deleted
+	if err := downloadUEFINTFSImage(imagePath, strings.Repeat("0", 64)); err == nil {
+		t.Error("downloadUEFINTFSImage() should reject a mismatching checksum")
+	}
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Errorf("mismatching download was not removed: %v", err)
+	}
+}
+
+func TestCheckUEFINTFSSource(t *testing.T) {
+	defer func() { UEFINTFSImage = "" }()
+	UEFINTFSImage = ""
+	err := CheckUEFINTFSSource()
+	if uefiNTFSImageSHA256 == "" && !errors.Is(err, ErrUEFINTFSDownloadUnverified) {
+		t.Errorf("CheckUEFINTFSSource() without a pin or local image error = %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "--uefi-ntfs-image") {
+		t.Errorf("CheckUEFINTFSSource() error should point to --uefi-ntfs-image, got: %v", err)
+	}
+
+	UEFINTFSImage = "/path/to/uefi-ntfs.img"
+	if err := CheckUEFINTFSSource(); err != nil {
+		t.Errorf("CheckUEFINTFSSource() with a local image error = %v", err)
+	}
+}
+
+func TestVerifyUEFINTFSImage(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "uefi-ntfs-*.img")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	_, _ = tmpFile.WriteString("abc")
+	_ = tmpFile.Close()
+
+	const abcSum = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if err := verifyUEFINTFSImage(tmpFile.Name(), abcSum); err != nil {
+		t.Errorf("verifyUEFINTFSImage() with matching checksum error = %v", err)
+	}
+	if err := verifyUEFINTFSImage(tmpFile.Name(), strings.Repeat("0", 64)); err == nil {
+		t.Error("verifyUEFINTFSImage() should reject a mismatching checksum")
+	}
+	if err := verifyUEFINTFSImage(tmpFile.Name(), ""); err == nil {
+		t.Error("verifyUEFINTFSImage() should reject an image without a pinned checksum")
+	}
+}
+
+func TestLocalUEFINTFSImage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "uefi_ntfs_image")
 	if err != nil {
-		t.Logf("InstallUEFINTFS returned error (may be expected): %v", err)
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	empty := filepath.Join(tmpDir, "empty.img")
+	image := filepath.Join(tmpDir, "uefi-ntfs.img")
+	_ = os.WriteFile(empty, nil, 0644)
+	_ = os.WriteFile(image, []byte("FAT image"), 0644)
+
+	if err := CheckUEFINTFSImage(image); err != nil {
+		t.Errorf("CheckUEFINTFSImage(%s) error = %v", image, err)
+	}
+	for _, path := range []string{empty, tmpDir, filepath.Join(tmpDir, "missing.img")} {
+		if err := CheckUEFINTFSImage(path); err == nil {
+			t.Errorf("CheckUEFINTFSImage(%s) should fail", path)
+		}
+	}
+
+	// A missing local image is an error instead of a skipped download, and the
+	// user's file is never deleted
+	defer func() { UEFINTFSImage = "" }()
+	UEFINTFSImage = filepath.Join(tmpDir, "missing.img")
+	if err := InstallUEFINTFS("/dev/nonexistent", tmpDir); err == nil {
+		t.Error("InstallUEFINTFS() should fail for a missing local image")
+	}
+	UEFINTFSImage = image
+	_ = InstallUEFINTFS(filepath.Join(tmpDir, "partition"), tmpDir)
+	if _, err := os.Stat(image); err != nil {
+		t.Errorf("local image was removed: %v", err)
 	}
 }
 
@@ -132,3 +255,713 @@ func TestCreateNTFSWithUEFI(t *testing.T) {
 		t.Error("Expected error when creating NTFS with UEFI on non-existent device")
 	}
 }
+
+func TestInstallUEFINTFSToExisting(t *testing.T) {
+	// Without an existing layout it fails before downloading anything
+	err := InstallUEFINTFSToExisting("/dev/nonexistent")
+	if err == nil {
+		t.Fatal("Expected error when the UEFI:NTFS partition does not exist")
+	}
+	if !strings.Contains(err.Error(), "partition layout") {
+		t.Errorf("Expected a missing layout error, got: %v", err)
+	}
+}
+
+func TestParsePartedMachineOutput(t *testing.T) {
+	out := `BYT;
+/dev/sdb:16008609792B:scsi:512:512:msdos:SanDisk Cruzer:;
+1:1048576B:16008085503B:16007036928B:fat32::boot, lba;
+2:16008085504B:16008609791B:524288B:fat16::lba;
+`
+	layout, err := parsePartedMachineOutput("/dev/sdb", out)
+	if err != nil {
+		t.Fatalf("parsePartedMachineOutput failed: %v", err)
+	}
+
+	if layout.TableType != "msdos" {
+		t.Errorf("Expected msdos table, got %s", layout.TableType)
+	}
+	if layout.Size != 16008609792 {
+		t.Errorf("Expected size 16008609792, got %d", layout.Size)
+	}
+	if len(layout.Partitions) != 2 {
+		t.Fatalf("Expected 2 partitions, got %d", len(layout.Partitions))
+	}
+
+	first := layout.Partitions[0]
+	if first.Path != "/dev/sdb1" || !first.Boot || first.Start != 1048576 {
+		t.Errorf("Unexpected first partition: %+v", first)
+	}
+	if layout.Partitions[1].Boot {
+		t.Error("Second partition should not have boot flag")
+	}
+
+	expectedFree := int64(16008609792 - 16007036928 - 524288)
+	if layout.FreeBytes != expectedFree {
+		t.Errorf("Expected %d free bytes, got %d", expectedFree, layout.FreeBytes)
+	}
+
+	if _, err := parsePartedMachineOutput("/dev/sdb", "BYT;\n"); err == nil {
+		t.Error("Expected error for output without disk line")
+	}
+}
+
+func TestApplyLsblkDetails(t *testing.T) {
+	layout := &DeviceLayout{
+		Device:     "/dev/nvme0n1",
+		Partitions: []PartitionInfo{{Path: "/dev/nvme0n1p1", Number: 1, Filesystem: "fat32"}},
+	}
+
+	jsonData := []byte(`{"blockdevices": [
+		{"path": "/dev/nvme0n1", "fstype": null, "label": null, "uuid": null, "children": [
+			{"path": "/dev/nvme0n1p1", "fstype": "vfat", "label": "WINDOWS", "uuid": "1234-ABCD", "parttype": "0xc"}
+		]}
+	]}`)
+
+	if err := applyLsblkDetails(layout, jsonData); err != nil {
+		t.Fatalf("applyLsblkDetails failed: %v", err)
+	}
+
+	part := layout.Partitions[0]
+	if part.Filesystem != "vfat" || part.Label != "WINDOWS" || part.UUID != "1234-ABCD" || part.TypeID != "0xc" {
+		t.Errorf("Unexpected partition details: %+v", part)
+	}
+
+	if len(layout.Summary()) != 3 {
+		t.Errorf("Expected 3 summary lines, got %v", layout.Summary())
+	}
+}
+
+func TestDescribeDevice(t *testing.T) {
+	// Test with non-existent device (should fail gracefully)
+	_, err := DescribeDevice("/dev/nonexistent")
+	if err == nil {
+		t.Error("Expected error when describing non-existent device")
+	}
+}
+
+func TestPartedOutcome(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	tests := []struct {
+		name         string
+		runErr       error
+		out          string
+		wantErr      bool
+		wantWarnings int
+	}{
+		{"clean success", nil, "", false, 0},
+		{"success with warning", nil, "Warning: The resulting partition is not properly aligned for best performance.", false, 1},
+		{"non-zero with only warnings", exitErr, "Warning: The resulting partition is not properly aligned for best performance.\nInformation: You may need to update /etc/fstab.", false, 2},
+		{"non-zero with error", exitErr, "Warning: something\nError: Could not stat device /dev/nonexistent - No such file or directory.", true, 1},
+		{"non-zero without output", exitErr, "", true, 0},
+		{"non-zero with other output", exitErr, "unexpected failure", true, 0},
+	}
+
+	for _, test := range tests {
+		warnings, err := partedOutcome(test.runErr, test.out)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+		}
+		if len(warnings) != test.wantWarnings {
+			t.Errorf("%s: got %d warnings, expected %d", test.name, len(warnings), test.wantWarnings)
+		}
+	}
+}
+
+func TestPartitionTypeID(t *testing.T) {
+	tests := []struct {
+		table   string
+		fstype  string
+		want    string
+		wantErr bool
+	}{
+		{"msdos", "FAT", "0c", false},
+		{"msdos", "FAT32", "0c", false},
+		{"msdos", "NTFS", "07", false},
+		{"msdos", "exFAT", "07", false},
+		{"gpt", "NTFS", MicrosoftBasicDataGUID, false},
+		{"msdos", "ext4", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := PartitionTypeID(test.table, test.fstype)
+		if (err != nil) != test.wantErr {
+			t.Errorf("PartitionTypeID(%s, %s) error = %v, wantErr %v", test.table, test.fstype, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("PartitionTypeID(%s, %s) = %q, expected %q", test.table, test.fstype, got, test.want)
+		}
+	}
+}
+
+func TestSetPartitionType(t *testing.T) {
+	// Test with non-existent device (should fail gracefully)
+	if err := SetPartitionType("/dev/nonexistent", 1, "FAT"); err == nil {
+		t.Error("Expected error when setting partition type on non-existent device")
+	}
+}
+
+func TestVerifyPartitionType(t *testing.T) {
+	layout := &DeviceLayout{
+		Device:    "/dev/sdb",
+		TableType: "msdos",
+		Partitions: []PartitionInfo{
+			{Path: "/dev/sdb1", Number: 1, TypeID: "0xc"},
+			{Path: "/dev/sdb2", Number: 2, TypeID: "0x83"},
+			{Path: "/dev/sdb3", Number: 3},
+		},
+	}
+
+	tests := []struct {
+		partNum int
+		fstype  string
+		wantErr bool
+	}{
+		{1, "FAT", false},
+		{1, "NTFS", true},
+		{2, "NTFS", true},
+		{3, "FAT", true},
+		{4, "FAT", true},
+	}
+
+	for _, test := range tests {
+		err := layout.VerifyPartitionType(test.partNum, test.fstype)
+		if (err != nil) != test.wantErr {
+			t.Errorf("VerifyPartitionType(%d, %s) error = %v, wantErr %v", test.partNum, test.fstype, err, test.wantErr)
+		}
+	}
+
+	gpt := &DeviceLayout{
+		Device:     "/dev/sdc",
+		TableType:  "gpt",
+		Partitions: []PartitionInfo{{Path: "/dev/sdc1", Number: 1, TypeID: strings.ToLower(MicrosoftBasicDataGUID)}},
+	}
+	if err := gpt.VerifyPartitionType(1, "NTFS"); err != nil {
+		t.Errorf("VerifyPartitionType on GPT failed: %v", err)
+	}
+}
+
+func TestVerifyLayout(t *testing.T) {
+	layout := &DeviceLayout{
+		Device:    "/dev/sdb",
+		TableType: "msdos",
+		Partitions: []PartitionInfo{
+			{Path: "/dev/sdb1", Number: 1, TypeID: "0x7"},
+			{Path: "/dev/sdb2", Number: 2, TypeID: "0xc"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		tableType string
+		want      []ExpectedPartition
+		wantErr   bool
+	}{
+		{"match", "msdos", []ExpectedPartition{{1, "NTFS"}, {2, ""}}, false},
+		{"wrong table", "gpt", []ExpectedPartition{{1, "NTFS"}, {2, ""}}, true},
+		{"extra partition", "msdos", []ExpectedPartition{{1, "NTFS"}}, true},
+		{"missing partition", "msdos", []ExpectedPartition{{1, "NTFS"}, {2, ""}, {3, ""}}, true},
+		{"wrong type", "msdos", []ExpectedPartition{{1, "FAT"}, {2, ""}}, true},
+		{"wrong number", "msdos", []ExpectedPartition{{1, "NTFS"}, {3, ""}}, true},
+	}
+	for _, tt := range tests {
+		err := layout.VerifyLayout(tt.tableType, tt.want)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifyLayout() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Types lsblk did not report are not checked
+	unknown := &DeviceLayout{Device: "/dev/sdc", TableType: "msdos", Partitions: []PartitionInfo{{Path: "/dev/sdc1", Number: 1}}}
+	if err := unknown.VerifyLayout("msdos", []ExpectedPartition{{1, "FAT"}}); err != nil {
+		t.Errorf("VerifyLayout() with unknown type = %v, want nil", err)
+	}
+}
+
+func TestLsblkChildren(t *testing.T) {
+	children, err := lsblkChildren([]byte(`{"blockdevices": [
+		{"path": "/dev/sdb", "children": [{"path": "/dev/sdb1"}, {"path": "/dev/sdb2"}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("lsblkChildren failed: %v", err)
+	}
+	if len(children) != 2 || children["/dev/sdb2"].Path != "/dev/sdb2" {
+		t.Errorf("Unexpected children: %v", children)
+	}
+
+	if _, err := lsblkChildren([]byte("not json")); err == nil {
+		t.Error("Expected error for invalid lsblk output")
+	}
+}
+
+func TestGetSectorSize(t *testing.T) {
+	// Test with non-existent device (should fail gracefully)
+	if _, err := GetSectorSize("/dev/nonexistent"); err == nil {
+		t.Error("Expected error when getting sector size of non-existent device")
+	}
+}
+
+func TestUEFINTFSStart(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	tests := []struct {
+		name    string
+		size    int64
+		align   int
+		want    int64
+		wantErr bool
+	}{
+		{"512-byte sectors", 16 * gib, 512, 16*gib - UEFINTFSPartitionSize, false},
+		{"4Kn", 16 * gib, 4096, 16*gib - UEFINTFSPartitionSize, false},
+		// A size that is not a multiple of the alignment rounds the start down
+		{"4Kn odd size", 16*gib + 2048, 4096, 16*gib - UEFINTFSPartitionSize, false},
+		{"too small", UEFINTFSPartitionSize, 512, 0, true},
+		{"invalid alignment", 16 * gib, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := uefiNTFSStart(test.size, test.align)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %d, expected %d", test.name, got, test.want)
+		}
+		if !test.wantErr && got%int64(test.align) != 0 {
+			t.Errorf("%s: start %d is not aligned to %d", test.name, got, test.align)
+		}
+	}
+}
+
+func TestCreateGPTTable(t *testing.T) {
+	// Test with non-existent device (should fail gracefully)
+	if err := CreateGPTTable("/dev/nonexistent"); err == nil {
+		t.Error("Expected error when creating GPT table on non-existent device")
+	}
+}
+
+func TestCreateNTFSWithUEFIGPT(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "uefi-gpt-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// Test with non-existent device (should fail gracefully)
+	if _, _, err := CreateNTFSWithUEFIGPT("/dev/nonexistent", tmpDir); err == nil {
+		t.Error("Expected error when creating GPT layout on non-existent device")
+	}
+}
+
+func TestESPSizeMiB(t *testing.T) {
+	// FAT32 needs at least 65525 clusters; clusters are at least one sector
+	for _, sectorSize := range []int{512, 4096} {
+		size := int64(espSizeMiB(sectorSize)) * 1024 * 1024
+		if clusters := size / int64(sectorSize); clusters < 65525 {
+			t.Errorf("ESP of %d MiB has only %d clusters with %d-byte sectors", espSizeMiB(sectorSize), clusters, sectorSize)
+		}
+	}
+}
+
+func TestCloneExtent(t *testing.T) {
+	layout := &DeviceLayout{
+		Device: "/dev/sdb",
+		Partitions: []PartitionInfo{
+			{Number: 1, Start: 1048576, Size: 8 * 1048576},
+			{Number: 2, Start: 9 * 1048576, Size: 524288},
+		},
+	}
+	if extent, err := cloneExtent(layout); err != nil || extent != 9*1048576+524288 {
+		t.Errorf("cloneExtent() = %d, %v; expected %d", extent, err, 9*1048576+524288)
+	}
+
+	if _, err := cloneExtent(&DeviceLayout{Device: "/dev/sdc"}); err == nil {
+		t.Error("Expected error for a device without partitions")
+	}
+}
+
+func TestCopyRaw(t *testing.T) {
+	data := make([]byte, cloneChunkSize+1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	// Copy less than the source holds: only the used extent is cloned
+	n := int64(cloneChunkSize + 500)
+	var out bytes.Buffer
+	var lastCopied, calls int64
+	err := copyRaw(bytes.NewReader(data), &out, n, func(copied, total int64, _ string) {
+		lastCopied = copied
+		calls++
+		if total != n {
+			t.Errorf("progress total = %d, expected %d", total, n)
+		}
+	})
+	if err != nil {
+		t.Fatalf("copyRaw failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data[:n]) {
+		t.Error("copied data does not match source")
+	}
+	if lastCopied != n || calls != 2 {
+		t.Errorf("Expected 2 progress calls ending at %d, got %d calls ending at %d", n, calls, lastCopied)
+	}
+
+	// A short source is an error, not a silent partial clone
+	if err := copyRaw(bytes.NewReader(data[:100]), &out, 200, nil); err == nil {
+		t.Error("Expected error when the source is shorter than the extent")
+	}
+}
+
+func TestCloneDevice(t *testing.T) {
+	if err := CloneDevice("/dev/sdx", "/dev/sdx", nil); err == nil {
+		t.Error("Expected error when cloning a device onto itself")
+	}
+
+	// Test with non-existent device (should fail gracefully)
+	if err := CloneDevice("/dev/nonexistent", "/dev/nonexistent2", nil); err == nil {
+		t.Error("Expected error when cloning a non-existent device")
+	}
+}
+
+// memDevice is an in-memory device; with realSize smaller than its reported size it
+// behaves like a fake-capacity stick that wraps writes around its real storage
+type memDevice struct {
+	data []byte
+}
+
+func (d *memDevice) WriteAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		d.data[(off+int64(i))%int64(len(d.data))] = p[i]
+	}
+	return len(p), nil
+}
+
+func (d *memDevice) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = d.data[(off+int64(i))%int64(len(d.data))]
+	}
+	return len(p), nil
+}
+
+func TestVerifyPattern(t *testing.T) {
+	size := int64(2*cloneChunkSize + 4096)
+
+	// A genuine device passes
+	flushed := false
+	var lastDone int64
+	dev := &memDevice{data: make([]byte, size)}
+	err := verifyPattern(dev, size, func() error { flushed = true; return nil }, func(done, total int64, _ string) {
+		lastDone = done
+		if total != 2*size {
+			t.Errorf("progress total = %d, expected %d", total, 2*size)
+		}
+	})
+	if err != nil {
+		t.Fatalf("verifyPattern failed on a genuine device: %v", err)
+	}
+	if !flushed {
+		t.Error("flush was not called between write and read passes")
+	}
+	if lastDone != 2*size {
+		t.Errorf("progress ended at %d, expected %d", lastDone, 2*size)
+	}
+
+	// A device that really holds only one chunk wraps writes and must be detected
+	fake := &memDevice{data: make([]byte, cloneChunkSize)}
+	err = verifyPattern(fake, size, nil, nil)
+	var mediaErr *MediaError
+	if !errors.As(err, &mediaErr) {
+		t.Fatalf("Expected *MediaError for fake-capacity device, got %v", err)
+	}
+	// The first chunk was overwritten by the wrapped writes
+	if mediaErr.Offset >= cloneChunkSize {
+		t.Errorf("Expected mismatch within the first chunk, got offset %d", mediaErr.Offset)
+	}
+}
+
+func TestFillPattern(t *testing.T) {
+	a := make([]byte, 64)
+	b := make([]byte, 64)
+	fillPattern(a, 0)
+	fillPattern(b, 1<<30)
+	if bytes.Equal(a, b) {
+		t.Error("pattern should differ between offsets")
+	}
+
+	// Filling in pieces gives the same data as filling at once
+	whole := make([]byte, 100)
+	fillPattern(whole, 8)
+	part := make([]byte, 100)
+	fillPattern(part[:48], 8)
+	fillPattern(part[48:], 56)
+	if !bytes.Equal(whole, part) {
+		t.Error("pattern depends on how the buffer is split")
+	}
+}
+
+func TestTestMediaIntegrity(t *testing.T) {
+	// Test with non-existent device (should fail gracefully)
+	if err := TestMediaIntegrity("/dev/nonexistent", nil); err == nil {
+		t.Error("Expected error when testing non-existent device")
+	}
+}
+
+func TestWaitForPartition(t *testing.T) {
+	// An existing device node is found immediately
+	if err := WaitForPartition("/dev/null", time.Second); err != nil {
+		t.Errorf("WaitForPartition(/dev/null) error = %v", err)
+	}
+
+	start := time.Now()
+	if err := WaitForPartition("/dev/nonexistent1", 300*time.Millisecond); err == nil {
+		t.Error("Expected error for partition that never appears")
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("WaitForPartition returned after %v, before the timeout", elapsed)
+	}
+}
+
+func TestWaitForPartitionReturnsWhenNodeAppears(t *testing.T) {
+	oldStat, oldInterval := statPartition, partitionPollInterval
+	defer func() { statPartition, partitionPollInterval = oldStat, oldInterval }()
+
+	calls := 0
+	statPartition = func(path string) (os.FileInfo, error) {
+		calls++
+		if calls < 3 {
+			return nil, os.ErrNotExist
+		}
+		return os.Stat("/dev/null")
+	}
+	partitionPollInterval = time.Millisecond
+
+	start := time.Now()
+	if err := WaitForPartition("/dev/sdb1", PartitionWaitTimeout); err != nil {
+		t.Fatalf("WaitForPartition() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("stat called %d times, want 3", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitForPartition took %v after the node appeared", elapsed)
+	}
+}
+
+func TestPartitionPathSymlink(t *testing.T) {
+	devDir, err := os.MkdirTemp("", "dev")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(devDir) }()
+
+	node := filepath.Join(devDir, "nvme0n1")
+	if err := os.WriteFile(node, nil, 0644); err != nil {
+		t.Fatalf("Failed to create fake node: %v", err)
+	}
+	link := filepath.Join(devDir, "nvme-Samsung_SSD_970_S4EWNX0N123456")
+	if err := os.Symlink("nvme0n1", link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	if got, want := GetPartitionPath(link), node+"p1"; got != want {
+		t.Errorf("GetPartitionPath(%s) = %s, want %s", link, got, want)
+	}
+}
+
+func TestPartitionLayoutBounds(t *testing.T) {
+	const mib = 1024 * 1024
+	const size = 16 * 1024 * mib
+
+	tests := []struct {
+		name      string
+		layout    PartitionLayout
+		align     int
+		fstype    string
+		wantStart int64
+		wantEnd   int64
+		wantErr   bool
+	}{
+		{"default FAT", PartitionLayout{}, 512, "FAT", mib, size - 1, false},
+		{"default NTFS", PartitionLayout{}, 512, "NTFS", mib, size - UEFINTFSPartitionSize - 1, false},
+		{"4MiB start", PartitionLayout{StartOffset: 4 * mib}, 4096, "FAT32", 4 * mib, size - 1, false},
+		{"trailing gap FAT", PartitionLayout{TrailingGap: 1024 * mib}, 512, "FAT", mib, size - 1024*mib - 1, false},
+		{"trailing gap NTFS", PartitionLayout{TrailingGap: 1024 * mib}, 512, "NTFS", mib, size - 1024*mib - UEFINTFSPartitionSize - 1, false},
+		{"unaligned start", PartitionLayout{StartOffset: mib + 512}, 4096, "FAT", 0, 0, true},
+		{"negative start", PartitionLayout{StartOffset: -mib}, 512, "FAT", 0, 0, true},
+		{"unaligned gap", PartitionLayout{TrailingGap: 100}, 512, "FAT", 0, 0, true},
+		{"gap fills device", PartitionLayout{TrailingGap: size - mib}, 512, "FAT", 0, 0, true},
+		{"start past end", PartitionLayout{StartOffset: size}, 512, "FAT", 0, 0, true},
+		{"unsupported filesystem", PartitionLayout{}, 512, "ext4", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		start, end, err := test.layout.bounds(size, test.align, test.fstype)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if start != test.wantStart || end != test.wantEnd {
+			t.Errorf("%s: got %d-%d, expected %d-%d", test.name, start, end, test.wantStart, test.wantEnd)
+		}
+	}
+}
+
+func TestPartitionLayoutPath(t *testing.T) {
+	// A custom start offset still makes the main partition number 1
+	start, end, err := PartitionLayout{StartOffset: 4 * 1024 * 1024, TrailingGap: 1024 * 1024 * 1024}.bounds(16008609792, 512, "FAT")
+	if err != nil {
+		t.Fatalf("bounds failed: %v", err)
+	}
+	out := fmt.Sprintf("BYT;\n/dev/sdb:16008609792B:scsi:512:512:msdos:SanDisk Cruzer:;\n1:%dB:%dB:%dB:fat32::lba;\n", start, end, end-start+1)
+	layout, err := parsePartedMachineOutput("/dev/sdb", out)
+	if err != nil {
+		t.Fatalf("parsePartedMachineOutput failed: %v", err)
+	}
+
+	first := layout.Partitions[0]
+	if first.Path != GetPartitionPath("/dev/sdb") || first.Start != start {
+		t.Errorf("Unexpected main partition: %+v", first)
+	}
+	if want := start + 1024*1024*1024; layout.FreeBytes != want {
+		t.Errorf("Expected %d free bytes, got %d", want, layout.FreeBytes)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"1048576", 1048576, false},
+		{"4MiB", 4 * 1024 * 1024, false},
+		{"4M", 4 * 1024 * 1024, false},
+		{"512K", 512 * 1024, false},
+		{"2GiB", 2 * 1024 * 1024 * 1024, false},
+		{"4096B", 4096, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"4TB", 0, true},
+		{"-1M", 0, true},
+		{"MiB", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSize(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseSize(%q) = %d, expected %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	// Test with non-existent device (should fail before anything is written)
+	if err := CheckLayout("/dev/nonexistent", "FAT", PartitionLayout{}); err == nil {
+		t.Error("CheckLayout should fail for a non-existent device")
+	}
+}
+
+func TestCopyISOBoot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "iso_boot_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// ISO9660 media often use upper-case names
+	src := filepath.Join(tmpDir, "iso")
+	if err := os.MkdirAll(filepath.Join(src, "EFI", "BOOT"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(src, "EFI", "MICROSOFT", "BOOT"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "EFI", "MICROSOFT", "BOOT", "BCD"), []byte("bcd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	efiDir, err := findDirFold(src, "efi")
+	if err != nil {
+		t.Fatalf("findDirFold() error: %v", err)
+	}
+
+	// Without bootx64.efi the ESP would not boot
+	if err := copyISOBoot(efiDir, filepath.Join(tmpDir, "esp-missing")); err == nil {
+		t.Error("expected an error when the source has no bootx64.efi")
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "EFI", "BOOT", "BOOTX64.EFI"), []byte("efi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	esp := filepath.Join(tmpDir, "esp")
+	if err := copyISOBoot(efiDir, esp); err != nil {
+		t.Fatalf("copyISOBoot() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(esp, "efi", "MICROSOFT", "BOOT", "BCD")); err != nil {
+		t.Errorf("BCD not copied to the ESP: %v", err)
+	}
+
+	if _, err := findDirFold(tmpDir, "sources"); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestFindPartitionNode(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "sysblock_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	oldSys := sysClassBlock
+	defer func() { sysClassBlock = oldSys }()
+	sysClassBlock = tmpDir
+
+	// A loop device with partitions 1 and 3, numbered through sysfs
+	for name, number := range map[string]string{"loop0p1": "1", "loop0p3": "3"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name, "partition"), []byte(number+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := []byte("/dev/loop0 loop\n/dev/loop0p1 part\n/dev/loop0p3 part\n")
+	if path, ok := findPartitionNode(out, 3); !ok || path != "/dev/loop0p3" {
+		t.Errorf("findPartitionNode(3) = %q, %v; want /dev/loop0p3", path, ok)
+	}
+	if _, ok := findPartitionNode(out, 2); ok {
+		t.Error("partition 2 does not exist")
+	}
+
+	// Device-mapper partitions have no sysfs number and follow no naming rule
+	out = []byte("/dev/mapper/usb disk\n/dev/mapper/usb-part1 part\n/dev/mapper/usb-part2 part\n")
+	if path, ok := findPartitionNode(out, 2); !ok || path != "/dev/mapper/usb-part2" {
+		t.Errorf("findPartitionNode(2) = %q, %v; want /dev/mapper/usb-part2", path, ok)
+	}
+}
+
+func TestPartitionPathFallsBackToInference(t *testing.T) {
+	oldList, oldSys := lsblkPartitionList, sysClassBlock
+	defer func() { lsblkPartitionList, sysClassBlock = oldList, oldSys }()
+	sysClassBlock = "/nonexistent"
+
+	lsblkPartitionList = func(string) ([]byte, error) { return nil, errors.New("not found") }
+	if got := partitionPath("/dev/loop7", 1); got != "/dev/loop7p1" {
+		t.Errorf("partitionPath() = %q, want /dev/loop7p1", got)
+	}
+
+	lsblkPartitionList = func(string) ([]byte, error) {
+		return []byte("/dev/dm-3 dm\n/dev/dm-4 part\n"), nil
+	}
+	if got := partitionPath("/dev/dm-3", 1); got != "/dev/dm-4" {
+		t.Errorf("partitionPath() = %q, want the node lsblk lists", got)
+	}
+}
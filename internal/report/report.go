@@ -0,0 +1,164 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mathisen/woeusb-go/internal/partition"
+)
+
+// Phase is a named step of a run and how long it took
+type Phase struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Report describes the outcome of a run, for automation and CI
+type Report struct {
+	Version     string                  `json:"version"`
+	Mode        string                  `json:"mode"`
+	Source      string                  `json:"source"`
+	SourceSize  int64                   `json:"source_size,omitempty"`
+	SourceHash  string                  `json:"source_sha256,omitempty"`
+	Target      string                  `json:"target"`
+	Filesystem  string                  `json:"filesystem"`
+	Label       string                  `json:"label"`
+	Success     bool                    `json:"success"`
+	Error       string                  `json:"error,omitempty"`
+	StartedAt   time.Time               `json:"started_at"`
+	FinishedAt  time.Time               `json:"finished_at"`
+	DurationMs  int64                   `json:"duration_ms"`
+	Phases      []Phase                 `json:"phases"`
+	BytesCopied int64                   `json:"bytes_copied"`
+	SplitFiles  []string                `json:"split_files"`
+	Layout      *partition.DeviceLayout `json:"layout,omitempty"`
+	Warnings    []string                `json:"warnings"`
+
+	mu         sync.Mutex
+	phaseName  string
+	phaseStart time.Time
+	now        func() time.Time
+}
+
+// New creates a report for a run starting now
+func New(version, mode, source, target string) *Report {
+	r := &Report{
+		Version:    version,
+		Mode:       mode,
+		Source:     source,
+		Target:     target,
+		Phases:     []Phase{},
+		SplitFiles: []string{},
+		Warnings:   []string{},
+		now:        time.Now,
+	}
+	r.StartedAt = r.now()
+	return r
+}
+
+// BeginPhase ends the current phase, if any, and starts timing a new one
+func (r *Report) BeginPhase(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.endPhase(now)
+	r.phaseName = name
+	r.phaseStart = now
+}
+
+// endPhase records the running phase; the caller must hold r.mu
+func (r *Report) endPhase(now time.Time) {
+	if r.phaseName == "" {
+		return
+	}
+	r.Phases = append(r.Phases, Phase{
+		Name:       r.phaseName,
+		DurationMs: now.Sub(r.phaseStart).Milliseconds(),
+	})
+	r.phaseName = ""
+}
+
+// AddWarning records a warning shown during the run
+func (r *Report) AddWarning(msg string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// SetBytesCopied records the number of bytes copied so far
+func (r *Report) SetBytesCopied(n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.BytesCopied = n
+}
+
+// SetSourceFingerprint records the size and SHA-256 of the source image
+func (r *Report) SetSourceFingerprint(size int64, sha256 string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.SourceSize = size
+	r.SourceHash = sha256
+}
+
+// SetSplitFiles records the files produced by splitting large WIM images
+func (r *Report) SetSplitFiles(files []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.SplitFiles = append([]string{}, files...)
+}
+
+// Finish closes the last phase and records the outcome; err is nil on success
+func (r *Report) Finish(err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.FinishedAt = r.now()
+	r.endPhase(r.FinishedAt)
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// WriteFile writes the report as indented JSON to path
+func (r *Report) WriteFile(path string) error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report to %s: %v", path, err)
+	}
+
+	return nil
+}
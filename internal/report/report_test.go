@@ -0,0 +1,110 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a clock that advances by step on every call
+func fakeClock(step time.Duration) func() time.Time {
+	t := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		t = t.Add(step)
+		return t
+	}
+}
+
+func TestReportPhases(t *testing.T) {
+	r := New("1.0.0", "device", "win.iso", "/dev/sdb")
+	r.now = fakeClock(time.Second)
+	r.StartedAt = r.now()
+
+	r.BeginPhase("Mounting source ISO...")
+	r.BeginPhase("Copying files...")
+	r.AddWarning("something odd")
+	r.Finish(nil)
+
+	if !r.Success || r.Error != "" {
+		t.Errorf("Expected success without error, got success=%v error=%q", r.Success, r.Error)
+	}
+	if len(r.Phases) != 2 {
+		t.Fatalf("Expected 2 phases, got %d", len(r.Phases))
+	}
+	for _, p := range r.Phases {
+		if p.DurationMs != 1000 {
+			t.Errorf("Phase %q: expected 1000ms, got %d", p.Name, p.DurationMs)
+		}
+	}
+	if r.DurationMs != 3000 {
+		t.Errorf("Expected total 3000ms, got %d", r.DurationMs)
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("Expected 1 warning, got %v", r.Warnings)
+	}
+}
+
+func TestReportWriteFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "report_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	r := New("1.0.0", "partition", "win.iso", "/dev/sdb1")
+	r.BeginPhase("Copying files...")
+	r.SetBytesCopied(1234)
+	r.SetSplitFiles([]string{"sources/install.swm", "sources/install2.swm"})
+	r.Finish(errors.New("copy failed"))
+
+	path := filepath.Join(tmpDir, "report.json")
+	if err := r.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Report is not valid JSON: %v", err)
+	}
+	if decoded["success"] != false || decoded["error"] != "copy failed" {
+		t.Errorf("Unexpected outcome in report: success=%v error=%v", decoded["success"], decoded["error"])
+	}
+	if decoded["bytes_copied"] != float64(1234) {
+		t.Errorf("Unexpected bytes_copied: %v", decoded["bytes_copied"])
+	}
+	if files, ok := decoded["split_files"].([]interface{}); !ok || len(files) != 2 {
+		t.Errorf("Unexpected split_files: %v", decoded["split_files"])
+	}
+	if phases, ok := decoded["phases"].([]interface{}); !ok || len(phases) != 1 {
+		t.Errorf("Unexpected phases: %v", decoded["phases"])
+	}
+}
+
+func TestReportNil(t *testing.T) {
+	// A nil report is a no-op so callers don't need to check whether --report was given
+	var r *Report
+	r.BeginPhase("step")
+	r.AddWarning("warning")
+	r.SetBytesCopied(1)
+	r.SetSplitFiles([]string{"a"})
+	r.Finish(nil)
+}
+
+func TestSetSourceFingerprint(t *testing.T) {
+	var nilReport *Report
+	nilReport.SetSourceFingerprint(1, "ignored")
+
+	r := New("1.0", "device", "win.iso", "/dev/sdb")
+	r.SetSourceFingerprint(3, "ba7816bf")
+	if r.SourceSize != 3 || r.SourceHash != "ba7816bf" {
+		t.Errorf("SetSourceFingerprint() recorded size %d, hash %q", r.SourceSize, r.SourceHash)
+	}
+}
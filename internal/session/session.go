@@ -1,10 +1,18 @@
 package session
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/mathisen/woeusb-go/internal/download"
 )
 
 type Session struct {
@@ -18,22 +26,28 @@ type Session struct {
 	SourceMount     string
 	TargetMount     string
 	TempDir         string
+	StagingDir      string // local directory SWM parts are staged in, removed by Cleanup
+	DownloadPath    string // downloaded source removed by Cleanup (unset with --keep-download)
 	SkipGRUB        bool
 	SetBootFlag     bool
 	Verbose         bool
 	NoColor         bool
+	StartTime       time.Time // when the run started, for the completion summary
+	BytesCopied     int64     // bytes written to the target, for the completion summary
+	WipeDone        bool      // the target has been wiped in this run; see WipeTarget
+	UEFINTFSDone    bool      // the UEFI:NTFS image has been written; see InstallUEFINTFS
+	DryRun          bool      // only plan the write; WipeTarget refuses to run
+
+	mu         sync.Mutex
+	cancelScan context.CancelFunc // set while an interrupt should only cancel the scan
+	lock       *DeviceLock
 }
 
 func (s *Session) Cleanup() error {
 	var errs []error
 
-	if s.SourceMount != "" {
-		if err := syscall.Unmount(s.SourceMount, 0); err != nil {
-			errs = append(errs, fmt.Errorf("unmount source: %w", err))
-		} else {
-			_ = os.Remove(s.SourceMount)
-			s.SourceMount = ""
-		}
+	if err := s.ReleaseSource(); err != nil {
+		errs = append(errs, err)
 	}
 
 	if s.TargetMount != "" {
@@ -52,20 +66,236 @@ func (s *Session) Cleanup() error {
 		s.TempDir = ""
 	}
 
+	if s.StagingDir != "" {
+		if err := os.RemoveAll(s.StagingDir); err != nil {
+			errs = append(errs, fmt.Errorf("remove staging dir: %w", err))
+		}
+		s.StagingDir = ""
+	}
+
+	if s.DownloadPath != "" {
+		if err := download.Remove(s.DownloadPath); err != nil {
+			errs = append(errs, fmt.Errorf("remove download: %w", err))
+		}
+		s.DownloadPath = ""
+	}
+
+	// Released last so no other process starts writing while we still clean up
+	if s.lock != nil {
+		if err := s.lock.Release(); err != nil {
+			errs = append(errs, fmt.Errorf("release device lock: %w", err))
+		}
+		s.lock = nil
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("cleanup errors: %v", errs)
 	}
 	return nil
 }
 
+// LockTarget takes the per-device lock for device, which Cleanup releases.
+// Locking a device the session already holds is a no-op.
+func (s *Session) LockTarget(device string) error {
+	if s.lock != nil {
+		return nil
+	}
+
+	lock, err := AcquireDeviceLock(device)
+	if err != nil {
+		return err
+	}
+	s.lock = lock
+	return nil
+}
+
+// MountSource mounts the source with mountFn the first time it is called and returns
+// the existing mountpoint on later calls, so retries within a run reuse one mount
+func (s *Session) MountSource(mountFn func(source string) (string, error)) (string, error) {
+	if s.SourceMount != "" {
+		return s.SourceMount, nil
+	}
+
+	mountpoint, err := mountFn(s.Source)
+	if err != nil {
+		return "", err
+	}
+	s.SourceMount = mountpoint
+	return mountpoint, nil
+}
+
+// ErrAlreadyWiped is returned by WipeTarget when the target was already wiped in this run
+var ErrAlreadyWiped = errors.New("target was already wiped in this run")
+
+// ErrDryRun is returned by WipeTarget for a dry run
+var ErrDryRun = errors.New("not wiping the target in a dry run")
+
+// WipeTarget runs wipeFn (wipe and repartition) against Target once per run. Later calls
+// return ErrAlreadyWiped unless force is set, so retry and fallback paths cannot wipe a
+// stick that already holds this run's layout. WipeDone is set as soon as wipeFn runs,
+// since a failed wipeFn may still have destroyed the old layout.
+func (s *Session) WipeTarget(wipeFn func(device string) error, force bool) error {
+	if s.DryRun {
+		return fmt.Errorf("%s: %w", s.Target, ErrDryRun)
+	}
+	if s.WipeDone && !force {
+		return fmt.Errorf("%s: %w", s.Target, ErrAlreadyWiped)
+	}
+
+	s.WipeDone = true
+	return wipeFn(s.Target)
+}
+
+// InstallUEFINTFS runs installFn (download and write the UEFI:NTFS image) against
+// Target until it succeeds once. Unlike WipeTarget a failure leaves the step pending,
+// so it can be retried against the existing partitions; later calls after a success
+// are no-ops.
+func (s *Session) InstallUEFINTFS(installFn func(device string) error) error {
+	if s.UEFINTFSDone {
+		return nil
+	}
+
+	if err := installFn(s.Target); err != nil {
+		return err
+	}
+	s.UEFINTFSDone = true
+	return nil
+}
+
+// ReleaseSource unmounts the source if it is mounted; further calls are no-ops
+func (s *Session) ReleaseSource() error {
+	if s.SourceMount == "" {
+		return nil
+	}
+
+	if err := syscall.Unmount(s.SourceMount, 0); err != nil {
+		return fmt.Errorf("unmount source: %w", err)
+	}
+	_ = os.Remove(s.SourceMount)
+	s.SourceMount = ""
+	return nil
+}
+
 func (s *Session) SetupSignalHandler() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		<-c
-		fmt.Fprintln(os.Stderr, "\nInterrupted, cleaning up...")
-		_ = s.Cleanup()
-		os.Exit(1)
+		for range c {
+			if s.interruptScan() {
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "\nInterrupted, cleaning up...")
+			_ = s.Cleanup()
+			// 128+SIGINT, as shells report an interrupted command
+			os.Exit(130)
+		}
 	}()
 }
+
+// ScanContext starts an interruptible phase: until done is called, an interrupt
+// cancels the returned context instead of exiting, so the pre-destruction scan can
+// abort cleanly. A second interrupt, or one after done, exits as usual.
+func (s *Session) ScanContext() (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.cancelScan = cancel
+	s.mu.Unlock()
+
+	return ctx, func() {
+		s.mu.Lock()
+		s.cancelScan = nil
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// interruptScan cancels the running scan, if any, and reports whether it did
+func (s *Session) interruptScan() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancelScan == nil {
+		return false
+	}
+	s.cancelScan()
+	s.cancelScan = nil
+	return true
+}
+
+// LockDir is the directory per-device lock files are created in
+var LockDir = "/run/lock"
+
+// sysBlockDir is where the kernel lists block devices; tests point it elsewhere
+var sysBlockDir = "/sys/class/block"
+
+// ErrDeviceLocked is returned by AcquireDeviceLock when another process holds the lock
+var ErrDeviceLocked = errors.New("device is already being written by another woeusb-go process")
+
+// DeviceLock is an exclusive flock on the lock file of one disk
+type DeviceLock struct {
+	file *os.File
+}
+
+// AcquireDeviceLock takes an exclusive lock for the disk device belongs to, failing
+// immediately with ErrDeviceLocked if another process holds it. Partitions lock their
+// whole disk, so device and partition mode runs on the same stick exclude each other.
+func AcquireDeviceLock(device string) (*DeviceLock, error) {
+	if err := os.MkdirAll(LockDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory %s: %v", LockDir, err)
+	}
+
+	path := lockPath(device)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s: %w", device, ErrDeviceLocked)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+
+	// The PID is only informational; the flock is what excludes other processes
+	_ = file.Truncate(0)
+	_, _ = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	return &DeviceLock{file: file}, nil
+}
+
+// Release drops the lock. The lock file is left in place: removing it could let two
+// processes lock different inodes for the same device.
+func (l *DeviceLock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// lockPath returns the lock file used for the disk device belongs to
+func lockPath(device string) string {
+	name := filepath.Base(device)
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		name = filepath.Base(resolved)
+	}
+	return filepath.Join(LockDir, "woeusb-go-"+diskName(name)+".lock")
+}
+
+// diskName returns the whole-disk name for a partition name such as sdb1 or nvme0n1p2,
+// using sysfs. Names sysfs does not know as partitions are returned unchanged.
+func diskName(name string) string {
+	if _, err := os.Stat(filepath.Join(sysBlockDir, name, "partition")); err != nil {
+		return name
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(sysBlockDir, name))
+	if err != nil {
+		return name
+	}
+	return filepath.Base(filepath.Dir(resolved))
+}
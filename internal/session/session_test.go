@@ -1,6 +1,7 @@
 package session
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -55,6 +56,29 @@ func TestSessionCleanupWithTempDir(t *testing.T) {
 	}
 }
 
+func TestSessionCleanupWithStagingDir(t *testing.T) {
+	session := &Session{}
+
+	stagingDir, err := os.MkdirTemp("", "session-staging-*")
+	if err != nil {
+		t.Fatalf("Failed to create staging directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stagingDir, "install.swm"), []byte("part"), 0644); err != nil {
+		t.Fatalf("Failed to create staged part: %v", err)
+	}
+	session.StagingDir = stagingDir
+
+	if err := session.Cleanup(); err != nil {
+		t.Errorf("Unexpected error during cleanup: %v", err)
+	}
+	if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+		t.Error("Staging directory should be removed after cleanup")
+	}
+	if session.StagingDir != "" {
+		t.Error("Session StagingDir should be cleared after cleanup")
+	}
+}
+
 func TestSessionCleanupWithMountpoints(t *testing.T) {
 	session := &Session{}
 
@@ -82,6 +106,90 @@ func TestSessionSetupSignalHandler(t *testing.T) {
 	// but we can verify the function doesn't crash
 }
 
+func TestScanContext(t *testing.T) {
+	session := &Session{}
+
+	if session.interruptScan() {
+		t.Error("interruptScan() outside a scan should report false")
+	}
+
+	ctx, done := session.ScanContext()
+	if !session.interruptScan() {
+		t.Error("interruptScan() during a scan should report true")
+	}
+	if ctx.Err() == nil {
+		t.Error("scan context should be cancelled by an interrupt")
+	}
+	if session.interruptScan() {
+		t.Error("a second interrupt should no longer be absorbed by the scan")
+	}
+	done()
+
+	_, done = session.ScanContext()
+	done()
+	if session.interruptScan() {
+		t.Error("interruptScan() after done should report false")
+	}
+}
+
+func TestDeviceLock(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "session-lock-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// Fake sysfs: sdb1 is a partition of sdb
+	devices := filepath.Join(tmpDir, "devices")
+	block := filepath.Join(tmpDir, "block")
+	for _, dir := range []string{filepath.Join(devices, "sdb", "sdb1"), block} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(devices, "sdb", "sdb1", "partition"), []byte("1\n"), 0644); err != nil {
+		t.Fatalf("Failed to create partition file: %v", err)
+	}
+	for link, target := range map[string]string{"sdb": "../devices/sdb", "sdb1": "../devices/sdb/sdb1"} {
+		if err := os.Symlink(target, filepath.Join(block, link)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	oldLockDir, oldSysBlock := LockDir, sysBlockDir
+	LockDir, sysBlockDir = tmpDir, block
+	defer func() { LockDir, sysBlockDir = oldLockDir, oldSysBlock }()
+
+	if got := diskName("sdb1"); got != "sdb" {
+		t.Errorf("diskName(sdb1) = %q, want sdb", got)
+	}
+	if got := diskName("sdb"); got != "sdb" {
+		t.Errorf("diskName(sdb) = %q, want sdb", got)
+	}
+
+	first := &Session{}
+	if err := first.LockTarget("/dev/sdb"); err != nil {
+		t.Fatalf("LockTarget() failed: %v", err)
+	}
+	if err := first.LockTarget("/dev/sdb"); err != nil {
+		t.Errorf("LockTarget() twice in one session = %v, want nil", err)
+	}
+
+	// A partition of the locked disk is refused as well
+	second := &Session{}
+	if err := second.LockTarget("/dev/sdb1"); !errors.Is(err, ErrDeviceLocked) {
+		t.Errorf("LockTarget() on a locked device = %v, want ErrDeviceLocked", err)
+	}
+
+	if err := first.Cleanup(); err != nil {
+		t.Errorf("Cleanup() failed: %v", err)
+	}
+	if err := second.LockTarget("/dev/sdb1"); err != nil {
+		t.Errorf("LockTarget() after release = %v, want nil", err)
+	}
+	_ = second.Cleanup()
+}
+
 func TestMultipleCleanups(t *testing.T) {
 	session := &Session{}
 
@@ -166,3 +274,184 @@ func TestSessionFields(t *testing.T) {
 		t.Error("Expected SetBootFlag to be true")
 	}
 }
+
+func TestMountSourceReuse(t *testing.T) {
+	session := &Session{Source: "windows.iso"}
+
+	calls := 0
+	mountFn := func(source string) (string, error) {
+		calls++
+		if source != "windows.iso" {
+			t.Errorf("mountFn called with %q, expected windows.iso", source)
+		}
+		return "/tmp/woeusb-iso-test", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		mp, err := session.MountSource(mountFn)
+		if err != nil {
+			t.Fatalf("MountSource failed: %v", err)
+		}
+		if mp != "/tmp/woeusb-iso-test" {
+			t.Errorf("Expected /tmp/woeusb-iso-test, got %s", mp)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected source to be mounted once, got %d mounts", calls)
+	}
+}
+
+func TestMountSourceError(t *testing.T) {
+	session := &Session{Source: "windows.iso"}
+
+	_, err := session.MountSource(func(string) (string, error) {
+		return "", errors.New("no loop device")
+	})
+	if err == nil {
+		t.Error("Expected mount error to be returned")
+	}
+	if session.SourceMount != "" {
+		t.Errorf("SourceMount should stay empty after a failed mount, got %s", session.SourceMount)
+	}
+}
+
+func TestReleaseSourceNoop(t *testing.T) {
+	session := &Session{}
+
+	// Releasing without a mounted source is a no-op
+	if err := session.ReleaseSource(); err != nil {
+		t.Errorf("ReleaseSource without mount failed: %v", err)
+	}
+}
+
+func TestCleanupRemovesDownload(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "session_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	dest := filepath.Join(tmpDir, "win.iso")
+	for _, p := range []string{dest, dest + ".part"} {
+		if err := os.WriteFile(p, []byte("iso"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	s := &Session{DownloadPath: dest}
+	if err := s.Cleanup(); err != nil {
+		t.Fatalf("Cleanup() failed: %v", err)
+	}
+	for _, p := range []string{dest, dest + ".part"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", p)
+		}
+	}
+	if s.DownloadPath != "" {
+		t.Error("DownloadPath should be cleared after Cleanup")
+	}
+}
+
+func TestWipeTargetOnce(t *testing.T) {
+	session := &Session{Target: "/dev/sdz"}
+
+	wipes := 0
+	wipeFn := func(device string) error {
+		wipes++
+		if device != "/dev/sdz" {
+			t.Errorf("wipeFn called with %q, expected /dev/sdz", device)
+		}
+		return nil
+	}
+
+	if err := session.WipeTarget(wipeFn, false); err != nil {
+		t.Fatalf("First WipeTarget failed: %v", err)
+	}
+	if !session.WipeDone {
+		t.Error("WipeDone should be set after the first wipe")
+	}
+
+	// A retry re-entering the device flow must not wipe again
+	err := session.WipeTarget(wipeFn, false)
+	if !errors.Is(err, ErrAlreadyWiped) {
+		t.Errorf("Expected ErrAlreadyWiped on retry, got %v", err)
+	}
+	if wipes != 1 {
+		t.Errorf("Expected the device to be wiped once, got %d wipes", wipes)
+	}
+
+	if err := session.WipeTarget(wipeFn, true); err != nil {
+		t.Fatalf("Forced WipeTarget failed: %v", err)
+	}
+	if wipes != 2 {
+		t.Errorf("Expected a forced wipe to run, got %d wipes", wipes)
+	}
+}
+
+func TestWipeTargetDryRun(t *testing.T) {
+	session := &Session{Target: "/dev/sdz", DryRun: true}
+
+	err := session.WipeTarget(func(string) error {
+		t.Error("wipeFn must not run in a dry run")
+		return nil
+	}, true)
+	if !errors.Is(err, ErrDryRun) {
+		t.Errorf("Expected ErrDryRun, got %v", err)
+	}
+	if session.WipeDone {
+		t.Error("WipeDone should stay unset in a dry run")
+	}
+}
+
+func TestWipeTargetFailure(t *testing.T) {
+	session := &Session{Target: "/dev/sdz"}
+
+	err := session.WipeTarget(func(string) error {
+		return errors.New("parted failed")
+	}, false)
+	if err == nil {
+		t.Error("Expected wipe error to be returned")
+	}
+
+	// The old layout may already be gone, so a failed wipe still counts
+	if !session.WipeDone {
+		t.Error("WipeDone should be set even when wipeFn fails")
+	}
+}
+
+func TestInstallUEFINTFSRetry(t *testing.T) {
+	session := &Session{Target: "/dev/sdz", WipeDone: true}
+
+	attempts := 0
+	installFn := func(device string) error {
+		attempts++
+		if device != "/dev/sdz" {
+			t.Errorf("installFn called with %q, expected /dev/sdz", device)
+		}
+		if attempts == 1 {
+			return errors.New("download failed")
+		}
+		return nil
+	}
+
+	if err := session.InstallUEFINTFS(installFn); err == nil {
+		t.Fatal("Expected the first attempt to fail")
+	}
+	if session.UEFINTFSDone {
+		t.Error("UEFINTFSDone should stay unset after a failed install")
+	}
+
+	// The retry installs again without re-wiping; a later call is a no-op
+	for i := 0; i < 2; i++ {
+		if err := session.InstallUEFINTFS(installFn); err != nil {
+			t.Fatalf("InstallUEFINTFS retry failed: %v", err)
+		}
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 install attempts, got %d", attempts)
+	}
+	if !session.UEFINTFSDone {
+		t.Error("UEFINTFSDone should be set after a successful install")
+	}
+}
@@ -5,9 +5,43 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall"
+
+	"github.com/mathisen/woeusb-go/internal/filesystem"
+	"github.com/mathisen/woeusb-go/internal/partition"
 )
 
+// CheckPrivileges returns an error if the process is not running as root
+func CheckPrivileges() error {
+	return CheckPrivilegesWithGetter(os.Geteuid)
+}
+
+// CheckPrivilegesWithGetter checks root using a custom UID getter (for testing)
+func CheckPrivilegesWithGetter(getUID func() int) error {
+	if getUID() != 0 {
+		return fmt.Errorf("root privileges are required to partition, format and mount devices")
+	}
+	return nil
+}
+
+// SuggestSudoCommand returns the command line args prefixed with sudo, quoted for a POSIX shell
+func SuggestSudoCommand(args []string) string {
+	quoted := []string{"sudo"}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes arg for a POSIX shell if it contains anything but safe characters
+func shellQuote(arg string) string {
+	if arg != "" && regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`).MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
 // ValidateSource checks if the source path exists and is either a file or block device
 func ValidateSource(path string) error {
 	info, err := os.Stat(path)
@@ -31,6 +65,66 @@ func ValidateSource(path string) error {
 	return fmt.Errorf("source must be a regular file or block device: %s", path)
 }
 
+// windowsInstallerFiles are the paths that mark a mounted source as a Windows installer.
+// Each entry is satisfied by any one of its alternatives.
+var windowsInstallerFiles = [][]string{
+	{"sources/install.wim", "sources/install.esd", "sources/install.swm"},
+	{"bootmgr", "setup.exe"},
+}
+
+// ValidateWindowsISO checks that the source mounted at mountpoint is a Windows installer
+// and not, for example, a Linux ISO, so the target is not wiped for a source that cannot
+// produce a working stick. File names are matched case-insensitively.
+func ValidateWindowsISO(mountpoint string) error {
+	var missing []string
+	for _, alternatives := range windowsInstallerFiles {
+		found := false
+		for _, rel := range alternatives {
+			if existsFold(mountpoint, rel) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, strings.Join(alternatives, " or "))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("source does not look like a Windows installer, missing %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// existsFold reports whether the slash-separated path rel exists as a regular file
+// under root, ignoring case in each path component
+func existsFold(root, rel string) bool {
+	dir := root
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return false
+		}
+		next := ""
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), part) {
+				next = filepath.Join(dir, entry.Name())
+				break
+			}
+		}
+		if next == "" {
+			return false
+		}
+		if i == len(parts)-1 {
+			info, err := os.Stat(next)
+			return err == nil && info.Mode().IsRegular()
+		}
+		dir = next
+	}
+	return false
+}
+
 // ValidateTarget checks if the target is a valid block device based on the mode
 func ValidateTarget(path, mode string) error {
 	info, err := os.Stat(path)
@@ -64,10 +158,44 @@ func ValidateTarget(path, mode string) error {
 	return nil
 }
 
+// DefaultMaxDeviceSize is the largest target device mode accepts without
+// --allow-large-device. Windows install sticks are rarely over 64GB, so a much larger
+// "USB device" is more likely an external system or backup disk.
+const DefaultMaxDeviceSize int64 = 128 << 30
+
+// CheckDeviceSize returns an error if device is larger than maxSize bytes
+func CheckDeviceSize(device string, maxSize int64) error {
+	return CheckDeviceSizeWithGetter(device, maxSize, partition.GetDeviceSize)
+}
+
+// CheckDeviceSizeWithGetter checks the device size using a custom size getter (for testing)
+func CheckDeviceSizeWithGetter(device string, maxSize int64, getSize func(string) (int64, error)) error {
+	size, err := getSize(device)
+	if err != nil {
+		return err
+	}
+	if size > maxSize {
+		return fmt.Errorf("%s is %s, larger than the %s expected of a USB stick; "+
+			"pass --allow-large-device if it is really the drive you want to overwrite",
+			device, filesystem.FormatSizeHuman(size), filesystem.FormatSizeHuman(maxSize))
+	}
+	return nil
+}
+
+// ResolveDevicePath follows symlinks such as /dev/disk/by-id/usb-* to the canonical
+// device node (e.g. /dev/sdb). The path is returned unchanged if it cannot be resolved.
+func ResolveDevicePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return resolved
+}
+
 // isWholeDevice determines if the path refers to a whole device or a partition
-// Handles both /dev/sdX and /dev/nvme0n1 naming patterns
+// Handles both /dev/sdX and /dev/nvme0n1 naming patterns, and symlinks to them
 func isWholeDevice(path string) bool {
-	base := filepath.Base(path)
+	base := filepath.Base(ResolveDevicePath(path))
 
 	// Standard SCSI/SATA devices: /dev/sda, /dev/sdb, etc.
 	if matched, _ := regexp.MatchString(`^sd[a-z]$`, base); matched {
@@ -1,7 +1,9 @@
 package validation
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -145,3 +147,140 @@ func TestIsWholeDevice(t *testing.T) {
 		}
 	}
 }
+
+func TestIsWholeDeviceSymlink(t *testing.T) {
+	devDir, err := os.MkdirTemp("", "dev")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(devDir) }()
+
+	// Fake device nodes with by-id style symlinks pointing at them
+	byID := filepath.Join(devDir, "disk", "by-id")
+	if err := os.MkdirAll(byID, 0755); err != nil {
+		t.Fatalf("Failed to create by-id dir: %v", err)
+	}
+	for _, node := range []string{"sdb", "sdb1"} {
+		if err := os.WriteFile(filepath.Join(devDir, node), nil, 0644); err != nil {
+			t.Fatalf("Failed to create fake node %s: %v", node, err)
+		}
+	}
+
+	tests := []struct {
+		link     string
+		target   string
+		expected bool
+	}{
+		// The by-id names end in digits, so the base name alone would misclassify them
+		{"usb-SanDisk_Cruzer_4C530001", "sdb", true},
+		{"usb-SanDisk_Cruzer_4C530001-part1", "sdb1", false},
+	}
+
+	for _, tt := range tests {
+		link := filepath.Join(byID, tt.link)
+		if err := os.Symlink(filepath.Join("..", "..", tt.target), link); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+
+		if got := isWholeDevice(link); got != tt.expected {
+			t.Errorf("isWholeDevice(%s) = %v, want %v", tt.link, got, tt.expected)
+		}
+		if got := ResolveDevicePath(link); got != filepath.Join(devDir, tt.target) {
+			t.Errorf("ResolveDevicePath(%s) = %s, want %s", tt.link, got, filepath.Join(devDir, tt.target))
+		}
+	}
+
+	if got := ResolveDevicePath("/dev/nonexistent"); got != "/dev/nonexistent" {
+		t.Errorf("ResolveDevicePath() of missing path = %s", got)
+	}
+}
+
+func TestCheckPrivilegesWithGetter(t *testing.T) {
+	if err := CheckPrivilegesWithGetter(func() int { return 0 }); err != nil {
+		t.Errorf("Expected no error for root, got %v", err)
+	}
+	if err := CheckPrivilegesWithGetter(func() int { return 1000 }); err == nil {
+		t.Error("Expected error for non-root user")
+	}
+}
+
+func TestSuggestSudoCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"woeusb-go", "--device", "win.iso", "/dev/sdb"}, "sudo woeusb-go --device win.iso /dev/sdb"},
+		{[]string{"woeusb-go", "--label", "Windows USB", "my iso's.iso"}, `sudo woeusb-go --label 'Windows USB' 'my iso'\''s.iso'`},
+		{[]string{"woeusb-go", ""}, "sudo woeusb-go ''"},
+	}
+
+	for _, test := range tests {
+		if got := SuggestSudoCommand(test.args); got != test.expected {
+			t.Errorf("SuggestSudoCommand(%q) = %s, expected %s", test.args, got, test.expected)
+		}
+	}
+}
+
+func TestCheckDeviceSizeWithGetter(t *testing.T) {
+	sizeOf := func(size int64) func(string) (int64, error) {
+		return func(string) (int64, error) { return size, nil }
+	}
+
+	if err := CheckDeviceSizeWithGetter("/dev/sdb", DefaultMaxDeviceSize, sizeOf(32<<30)); err != nil {
+		t.Errorf("32GB device should pass, got %v", err)
+	}
+	if err := CheckDeviceSizeWithGetter("/dev/sdb", DefaultMaxDeviceSize, sizeOf(DefaultMaxDeviceSize)); err != nil {
+		t.Errorf("device exactly at the limit should pass, got %v", err)
+	}
+
+	err := CheckDeviceSizeWithGetter("/dev/sdb", DefaultMaxDeviceSize, sizeOf(2<<40))
+	if err == nil || !strings.Contains(err.Error(), "--allow-large-device") {
+		t.Errorf("2TB device should be refused with a hint, got %v", err)
+	}
+
+	failing := func(string) (int64, error) { return 0, fmt.Errorf("blockdev failed") }
+	if err := CheckDeviceSizeWithGetter("/dev/sdb", DefaultMaxDeviceSize, failing); err == nil {
+		t.Error("size lookup failure should be returned")
+	}
+}
+
+func TestValidateWindowsISO(t *testing.T) {
+	testCases := []struct {
+		name    string
+		files   []string
+		wantErr bool
+	}{
+		{"install.wim and bootmgr", []string{"bootmgr", "sources/install.wim"}, false},
+		{"install.esd and setup.exe", []string{"setup.exe", "sources/install.esd"}, false},
+		{"upper-case names", []string{"BOOTMGR", "SOURCES/INSTALL.WIM"}, false},
+		{"linux ISO", []string{"casper/vmlinuz", "boot/grub/grub.cfg"}, true},
+		{"no install image", []string{"bootmgr", "setup.exe", "sources/boot.wim"}, true},
+		{"no boot manager or setup", []string{"sources/install.wim"}, true},
+		{"empty", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := os.MkdirTemp("", "windows_iso")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer func() { _ = os.RemoveAll(root) }()
+
+			for _, rel := range tc.files {
+				path := filepath.Join(root, rel)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("Failed to create dir: %v", err)
+				}
+				if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+					t.Fatalf("Failed to create %s: %v", rel, err)
+				}
+			}
+
+			err = ValidateWindowsISO(root)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("ValidateWindowsISO() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+// Package woeusb writes bootable Windows USB sticks from a Windows ISO. It runs the
+// same mount, partition, format, copy and bootloader steps as the woeusb-go tool, for
+// programs that embed it. Writing needs root privileges.
+//
+// The GUI writes FAT sticks through this package. The woeusb-go CLI still has its own
+// pipeline, since many of its options have no counterpart here yet.
+package woeusb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mathisen/woeusb-go/internal/bootloader"
+	filecopy "github.com/mathisen/woeusb-go/internal/copy"
+	"github.com/mathisen/woeusb-go/internal/deps"
+	"github.com/mathisen/woeusb-go/internal/filesystem"
+	"github.com/mathisen/woeusb-go/internal/mount"
+	"github.com/mathisen/woeusb-go/internal/output"
+	"github.com/mathisen/woeusb-go/internal/partition"
+	"github.com/mathisen/woeusb-go/internal/session"
+	"github.com/mathisen/woeusb-go/internal/validation"
+)
+
+// Write modes
+const (
+	ModeDevice    = "device"    // wipe the whole device and create a new partition table
+	ModePartition = "partition" // format an existing partition
+)
+
+// Phases reported to ProgressFunc, in order
+const (
+	PhaseMount      = output.PhaseMount
+	PhasePartition  = output.PhasePartition
+	PhaseFormat     = output.PhaseFormat
+	PhaseCopy       = output.PhaseCopy
+	PhaseVerify     = output.PhaseVerify
+	PhaseBootloader = output.PhaseBootloader
+	PhaseCleanup    = output.PhaseCleanup
+)
+
+// Progress describes how far a write is. Pct is the fraction (0-1) of Phase that is
+// done, or -1 when unknown.
+type Progress struct {
+	Phase   string
+	Pct     float64
+	File    string
+	Message string
+}
+
+// ProgressFunc receives progress updates from Run
+type ProgressFunc func(p Progress)
+
+// FailedFile is a source file that could not be copied
+type FailedFile = filecopy.FailedFile
+
+// Options configures a Writer
+type Options struct {
+	Source       string // Windows ISO file or block device
+	Target       string // whole device for ModeDevice, partition for ModePartition
+	Mode         string // ModeDevice (default) or ModePartition
+	Filesystem   string // FAT (default); NTFS or EXFAT only with ModePartition
+	Label        string // filesystem label, "Windows USB" by default
+	SkipGRUB     bool   // do not install GRUB for legacy BIOS boot (ModeDevice only)
+	SetBootFlag  bool   // mark the partition active for picky BIOSes (ModeDevice only)
+	Verify       bool   // hash every copied file against the source
+	ProgressFunc ProgressFunc
+
+	// ModeDevice refuses targets larger than MaxDeviceSize bytes (by default
+	// validation.DefaultMaxDeviceSize), which are more likely system or backup disks,
+	// unless AllowLargeDevice is set
+	MaxDeviceSize    int64
+	AllowLargeDevice bool
+}
+
+// Writer writes one bootable stick. Create it with New.
+type Writer struct {
+	opts        Options
+	failedFiles []FailedFile
+	bytesCopied int64
+}
+
+// New returns a Writer for opts, filling in defaults for empty fields
+func New(opts Options) *Writer {
+	if opts.Mode == "" {
+		opts.Mode = ModeDevice
+	}
+	if opts.Filesystem == "" {
+		opts.Filesystem = "FAT"
+	}
+	opts.Filesystem = strings.ToUpper(opts.Filesystem)
+	if opts.Label == "" {
+		opts.Label = "Windows USB"
+	}
+	if opts.MaxDeviceSize == 0 {
+		opts.MaxDeviceSize = validation.DefaultMaxDeviceSize
+	}
+	return &Writer{opts: opts}
+}
+
+// FailedFiles returns the files the last Run could not copy. Run still succeeds when
+// only some files fail, since the stick is mostly usable.
+func (w *Writer) FailedFiles() []FailedFile {
+	return w.failedFiles
+}
+
+// BytesCopied returns the size of the source written by the last Run
+func (w *Writer) BytesCopied() int64 {
+	return w.bytesCopied
+}
+
+// Run writes Source to Target. Everything that can fail without touching the target
+// (options, source, capacity) is checked before it is modified. Like the CLI, Run holds
+// the per-device lock while writing and wipes the target at most once. Cancelling ctx
+// stops the copy and returns ctx's error.
+func (w *Writer) Run(ctx context.Context) error {
+	opts := w.opts
+	w.failedFiles = nil
+	w.bytesCopied = 0
+
+	if err := w.validate(); err != nil {
+		return err
+	}
+
+	// Cleanup releases the device lock after the deferred unmounts below have run
+	sess := &session.Session{
+		Source:     opts.Source,
+		Target:     opts.Target,
+		Mode:       opts.Mode,
+		Filesystem: opts.Filesystem,
+		Label:      opts.Label,
+	}
+	if err := sess.LockTarget(opts.Target); err != nil {
+		return err
+	}
+	defer func() { _ = sess.Cleanup() }()
+
+	w.progress(PhaseMount, 0, "", "Mounting source...")
+	srcMount, err := mount.MountISO(opts.Source)
+	if err != nil {
+		return fmt.Errorf("failed to mount source: %v", err)
+	}
+	defer func() { _ = mount.CleanupMountpoint(srcMount) }()
+	if err := validation.ValidateWindowsISO(srcMount); err != nil {
+		return err
+	}
+
+	copyOpts := filecopy.DefaultCopyOptions()
+	copyOpts.Filesystem = opts.Filesystem
+	copyOpts.Context = ctx
+	if err := w.checkCapacity(srcMount, copyOpts); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	mainPartition := opts.Target
+	if opts.Mode == ModeDevice {
+		w.progress(PhasePartition, 0, "", "Creating partition table...")
+		err := sess.WipeTarget(func(device string) error {
+			return partition.CreateBootablePartition(device, opts.Filesystem)
+		}, false)
+		if err != nil {
+			return fmt.Errorf("failed to create partition: %v", err)
+		}
+		mainPartition = partition.GetPartitionPath(opts.Target)
+	}
+
+	w.progress(PhaseFormat, 0, "", fmt.Sprintf("Formatting %s as %s...", mainPartition, opts.Filesystem))
+	if err := filesystem.FormatPartition(mainPartition, opts.Filesystem, opts.Label); err != nil {
+		// A missing label is cosmetic, the stick still boots
+		var labelErr *filesystem.LabelError
+		if !errors.As(err, &labelErr) {
+			return fmt.Errorf("failed to format partition: %v", err)
+		}
+	}
+
+	dstMount, err := mount.MountDevice(mainPartition, opts.Filesystem)
+	if err != nil {
+		return fmt.Errorf("failed to mount target: %v", err)
+	}
+	defer func() {
+		if dstMount != "" {
+			_ = mount.CleanupMountpoint(dstMount)
+		}
+	}()
+
+	w.progress(PhaseCopy, 0, "", "Copying Windows files...")
+	err = filecopy.CopyWindowsISOWithOptions(srcMount, dstMount, copyOpts, func(copied, total int64, file string) {
+		if total > 0 {
+			w.progress(PhaseCopy, float64(copied)/float64(total), file, "")
+		}
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		var copyFailed *filecopy.CopyFailedError
+		if !errors.As(err, &copyFailed) {
+			return fmt.Errorf("failed to copy files: %v", err)
+		}
+		w.failedFiles = copyFailed.Failed
+	}
+	if stats, _, err := filecopy.ScanSource(srcMount); err == nil {
+		w.bytesCopied = stats.TotalBytes
+	}
+
+	if err := w.verify(srcMount, dstMount); err != nil {
+		return err
+	}
+
+	if opts.Mode == ModeDevice {
+		if err := w.installBootloader(dstMount); err != nil {
+			return err
+		}
+	}
+
+	w.progress(PhaseCleanup, 0, "", "Cleaning up...")
+	if err := mount.SyncFilesystem(dstMount); err != nil {
+		return fmt.Errorf("failed to flush target: %v", err)
+	}
+	if err := mount.CleanupMountpoint(dstMount); err != nil {
+		return fmt.Errorf("failed to unmount target: %v", err)
+	}
+	dstMount = ""
+	return nil
+}
+
+// validate checks the options, the source and the target
+func (w *Writer) validate() error {
+	opts := w.opts
+	switch opts.Mode {
+	case ModeDevice, ModePartition:
+	default:
+		return fmt.Errorf("unknown mode %q (use %s or %s)", opts.Mode, ModeDevice, ModePartition)
+	}
+	switch opts.Filesystem {
+	case "FAT", "NTFS", "EXFAT":
+	default:
+		return fmt.Errorf("unsupported filesystem %q (use FAT, NTFS or EXFAT)", opts.Filesystem)
+	}
+	// UEFI firmware cannot read NTFS or exFAT, so those sticks need a UEFI:NTFS
+	// partition next to the main one, which ModeDevice does not create
+	if opts.Mode == ModeDevice && opts.Filesystem != "FAT" {
+		return fmt.Errorf("%s is not supported in %s mode: the stick would not boot on UEFI; use FAT", opts.Filesystem, ModeDevice)
+	}
+
+	if err := validation.ValidateSource(opts.Source); err != nil {
+		return err
+	}
+	if err := validation.ValidateTarget(opts.Target, opts.Mode); err != nil {
+		return err
+	}
+	if err := mount.CheckNotBusy(opts.Target); err != nil {
+		return fmt.Errorf("target busy check failed: %v", err)
+	}
+	return w.checkDeviceSize()
+}
+
+// checkDeviceSize refuses ModeDevice targets larger than MaxDeviceSize unless
+// AllowLargeDevice is set
+func (w *Writer) checkDeviceSize() error {
+	if w.opts.Mode != ModeDevice || w.opts.AllowLargeDevice {
+		return nil
+	}
+	return validation.CheckDeviceSizeWithGetter(w.opts.Target, w.opts.MaxDeviceSize, deviceSize)
+}
+
+// deviceSize returns the size of a target device in bytes; tests replace it
+var deviceSize = partition.GetDeviceSize
+
+// checkCapacity returns an error if the source does not fit on the target. An unknown
+// target size skips the check.
+func (w *Writer) checkCapacity(srcMount string, copyOpts filecopy.CopyOptions) error {
+	capacity, err := deviceSize(w.opts.Target)
+	if err != nil {
+		return nil
+	}
+	if err := filecopy.CheckTargetCapacity(srcMount, w.opts.Filesystem, copyOpts, capacity); err != nil {
+		return fmt.Errorf("capacity check failed: %v", err)
+	}
+	return nil
+}
+
+// verify checks the boot-critical files, and every file with Options.Verify
+func (w *Writer) verify(srcMount, dstMount string) error {
+	w.progress(PhaseVerify, 0, "", "Verifying boot-critical files...")
+	if err := filecopy.VerifyCriticalFiles(srcMount, dstMount); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	if err := filecopy.CheckWindowsMediaComplete(dstMount); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	if !w.opts.Verify {
+		return nil
+	}
+
+	w.progress(PhaseVerify, -1, "", "Verifying all files...")
+	err := filecopy.ValidateCopyChecksum(srcMount, dstMount, func(read, total int64, _ string) {
+		if total > 0 {
+			w.progress(PhaseVerify, float64(read)/float64(total), "", "")
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// installBootloader sets the boot flag and installs GRUB for legacy BIOS boot as the
+// options ask. A failed GRUB install is not an error, UEFI boot still works.
+func (w *Writer) installBootloader(dstMount string) error {
+	w.progress(PhaseBootloader, 0, "", "Installing bootloader...")
+	if w.opts.SetBootFlag {
+		if err := partition.SetBootFlag(w.opts.Target, 1); err != nil {
+			return fmt.Errorf("failed to set boot flag: %v", err)
+		}
+	}
+	if w.opts.SkipGRUB {
+		return nil
+	}
+
+	dependencies, _ := deps.CheckDependencies()
+	if dependencies == nil || dependencies.GrubCmd == "" {
+		w.progress(PhaseBootloader, -1, "", "GRUB not found, skipping legacy BIOS boot support")
+		return nil
+	}
+	grubOpts := bootloader.GRUBConfigOptionsForFilesystem(w.opts.Filesystem)
+	if err := bootloader.InstallGRUBWithOptions(dstMount, w.opts.Target, dependencies.GrubCmd, grubOpts); err != nil {
+		w.progress(PhaseBootloader, -1, "", fmt.Sprintf("GRUB installation failed (UEFI boot will still work): %v", err))
+	}
+	return nil
+}
+
+// progress reports p to the ProgressFunc, if any
+func (w *Writer) progress(phase string, pct float64, file, message string) {
+	if w.opts.ProgressFunc != nil {
+		w.opts.ProgressFunc(Progress{Phase: phase, Pct: pct, File: file, Message: message})
+	}
+}
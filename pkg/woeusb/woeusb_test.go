@@ -0,0 +1,120 @@
+package woeusb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	filecopy "github.com/mathisen/woeusb-go/internal/copy"
+)
+
+func TestNewDefaults(t *testing.T) {
+	w := New(Options{Source: "windows.iso", Target: "/dev/sdz", Filesystem: "ntfs"})
+	if w.opts.Mode != ModeDevice {
+		t.Errorf("Mode = %q, want %q", w.opts.Mode, ModeDevice)
+	}
+	if w.opts.Filesystem != "NTFS" {
+		t.Errorf("Filesystem = %q, want NTFS", w.opts.Filesystem)
+	}
+	if w.opts.Label != "Windows USB" {
+		t.Errorf("Label = %q, want the default label", w.opts.Label)
+	}
+}
+
+func TestRunRejectsInvalidOptions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "woeusb_options")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	iso := filepath.Join(tmpDir, "windows.iso")
+	if err := os.WriteFile(iso, []byte("iso"), 0644); err != nil {
+		t.Fatalf("Failed to create ISO: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"unknown mode", Options{Source: iso, Target: "/dev/sdz", Mode: "clone"}},
+		{"unknown filesystem", Options{Source: iso, Target: "/dev/sdz", Filesystem: "ext4"}},
+		{"NTFS in device mode", Options{Source: iso, Target: "/dev/sdz", Filesystem: "NTFS"}},
+		{"exFAT in device mode", Options{Source: iso, Target: "/dev/sdz", Filesystem: "EXFAT"}},
+		{"missing source", Options{Source: filepath.Join(tmpDir, "missing.iso"), Target: "/dev/sdz"}},
+		{"target is not a device", Options{Source: iso, Target: iso}},
+	}
+	for _, tc := range testCases {
+		var phases []string
+		tc.opts.ProgressFunc = func(p Progress) { phases = append(phases, p.Phase) }
+		if err := New(tc.opts).Run(context.Background()); err == nil {
+			t.Errorf("%s: Run() should fail", tc.name)
+		}
+		if len(phases) != 0 {
+			t.Errorf("%s: Run() reported progress %v before validating", tc.name, phases)
+		}
+	}
+}
+
+func TestCheckDeviceCapacity(t *testing.T) {
+	srcMount, err := os.MkdirTemp("", "woeusb_capacity")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(srcMount) }()
+
+	// A 100MB sparse source
+	const sourceSize = 100 * 1024 * 1024
+	f, err := os.Create(filepath.Join(srcMount, "setup.exe"))
+	if err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	if err := f.Truncate(sourceSize); err != nil {
+		_ = f.Close()
+		t.Skipf("Sparse files not supported: %v", err)
+	}
+	_ = f.Close()
+
+	oldSize := deviceSize
+	defer func() { deviceSize = oldSize }()
+	opts := filecopy.DefaultCopyOptions()
+
+	testCases := []struct {
+		name    string
+		size    int64
+		sizeErr error
+		wantErr bool
+	}{
+		{"fits", 1024 * 1024 * 1024, nil, false},
+		{"too small", 64 * 1024 * 1024, nil, true},
+		{"no room for headroom", sourceSize + 1024, nil, true},
+		{"unknown size", 0, errors.New("no such device"), false},
+	}
+	for _, tc := range testCases {
+		deviceSize = func(string) (int64, error) { return tc.size, tc.sizeErr }
+		err := New(Options{Target: "/dev/sdz", Filesystem: "NTFS"}).checkCapacity(srcMount, opts)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: checkCapacity() error = %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestCheckDeviceSize(t *testing.T) {
+	oldSize := deviceSize
+	defer func() { deviceSize = oldSize }()
+	deviceSize = func(string) (int64, error) { return 1 << 40, nil }
+
+	if err := New(Options{Target: "/dev/sdz"}).checkDeviceSize(); err == nil {
+		t.Error("Expected a 1TB device to be refused by default")
+	}
+	if err := New(Options{Target: "/dev/sdz", AllowLargeDevice: true}).checkDeviceSize(); err != nil {
+		t.Errorf("AllowLargeDevice should accept the device: %v", err)
+	}
+	if err := New(Options{Target: "/dev/sdz", MaxDeviceSize: 2 << 40}).checkDeviceSize(); err != nil {
+		t.Errorf("A larger MaxDeviceSize should accept the device: %v", err)
+	}
+	if err := New(Options{Target: "/dev/sdz1", Mode: ModePartition}).checkDeviceSize(); err != nil {
+		t.Errorf("Partition mode should not check the device size: %v", err)
+	}
+}